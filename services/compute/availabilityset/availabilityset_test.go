@@ -103,3 +103,19 @@ func Test_getRpcWssdVirtualMachineReference(t *testing.T) {
 	assert.Equal(t, 1, len(b))
 	assert.Nil(t, b[0])
 }
+
+func Test_addRemoveMember(t *testing.T) {
+	vm := &compute.VirtualMachine{VirtualMachineProperties: &compute.VirtualMachineProperties{}}
+
+	assert.Error(t, removeMember(vm, name))
+	assert.NoError(t, addMember(vm, group, name))
+	assert.Equal(t, name, *vm.AvailabilitySetProfile.Name)
+	assert.Equal(t, group, *vm.AvailabilitySetProfile.GroupName)
+	assert.Error(t, addMember(vm, group, name))
+	assert.Error(t, addMember(vm, group, "avset2"))
+	assert.Error(t, removeMember(vm, "avset2"))
+	assert.NoError(t, removeMember(vm, name))
+	assert.Nil(t, vm.AvailabilitySetProfile)
+
+	assert.Error(t, addMember(&compute.VirtualMachine{}, group, name))
+}
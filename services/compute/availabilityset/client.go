@@ -5,8 +5,10 @@ package availabilityset
 
 import (
 	"context"
+	"sync"
 
 	"github.com/microsoft/moc-sdk-for-go/services/compute"
+	"github.com/microsoft/moc-sdk-for-go/services/compute/virtualmachine"
 	"github.com/microsoft/moc/pkg/auth"
 )
 
@@ -19,7 +21,12 @@ type Service interface {
 
 type AvailabilitySetClient struct {
 	compute.BaseClient
-	internal Service
+	internal   Service
+	cloudFQDN  string
+	authorizer auth.Authorizer
+	// vmclient is created on first use by getVMClient
+	vmclient     *virtualmachine.VirtualMachineClient
+	vmclientLock sync.Mutex
 }
 
 func NewAvailabilitySetClient(cloudFQDN string, authorizer auth.Authorizer) (*AvailabilitySetClient, error) {
@@ -28,7 +35,21 @@ func NewAvailabilitySetClient(cloudFQDN string, authorizer auth.Authorizer) (*Av
 		return nil, err
 	}
 
-	return &AvailabilitySetClient{internal: c}, nil
+	return &AvailabilitySetClient{internal: c, cloudFQDN: cloudFQDN, authorizer: authorizer}, nil
+}
+
+// getVMClient returns the virtual machine client used to manage the members, which is created on first use
+func (c *AvailabilitySetClient) getVMClient() (*virtualmachine.VirtualMachineClient, error) {
+	c.vmclientLock.Lock()
+	defer c.vmclientLock.Unlock()
+	if c.vmclient == nil {
+		vmclient, err := virtualmachine.NewVirtualMachineClient(c.cloudFQDN, c.authorizer)
+		if err != nil {
+			return nil, err
+		}
+		c.vmclient = vmclient
+	}
+	return c.vmclient, nil
 }
 
 // Get methods invokes the client Get method
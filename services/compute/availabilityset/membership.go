@@ -0,0 +1,106 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the Apache v2.0 License.
+
+package availabilityset
+
+import (
+	"context"
+
+	"github.com/microsoft/moc-sdk-for-go/services/compute"
+	"github.com/microsoft/moc-sdk-for-go/services/compute/virtualmachine"
+	"github.com/microsoft/moc/pkg/errors"
+)
+
+// AddVirtualMachine adds the VM to the availability set and returns the updated membership
+func (c *AvailabilitySetClient) AddVirtualMachine(ctx context.Context, group, name, vmName string) (*compute.Membership, error) {
+	if _, err := c.getAvailabilitySet(ctx, group, name); err != nil {
+		return nil, err
+	}
+
+	vmclient, err := c.getVMClient()
+	if err != nil {
+		return nil, err
+	}
+	err = vmclient.Update(ctx, group, vmName, virtualmachine.UpdateFunc(func(ctx context.Context, vm *compute.VirtualMachine) (*compute.VirtualMachine, error) {
+		return vm, addMember(vm, group, name)
+	}))
+	if err != nil {
+		return nil, err
+	}
+	return c.ListMembers(ctx, group, name)
+}
+
+// RemoveVirtualMachine removes the VM from the availability set and returns the updated membership
+func (c *AvailabilitySetClient) RemoveVirtualMachine(ctx context.Context, group, name, vmName string) (*compute.Membership, error) {
+	if _, err := c.getAvailabilitySet(ctx, group, name); err != nil {
+		return nil, err
+	}
+
+	vmclient, err := c.getVMClient()
+	if err != nil {
+		return nil, err
+	}
+	err = vmclient.Update(ctx, group, vmName, virtualmachine.UpdateFunc(func(ctx context.Context, vm *compute.VirtualMachine) (*compute.VirtualMachine, error) {
+		return vm, removeMember(vm, name)
+	}))
+	if err != nil {
+		return nil, err
+	}
+	return c.ListMembers(ctx, group, name)
+}
+
+// ListMembers returns the VMs of the availability set and how they are spread across host nodes. The cloud agent
+// does not report the fault domain of a VM, so the host node distribution is the only one available.
+func (c *AvailabilitySetClient) ListMembers(ctx context.Context, group, name string) (*compute.Membership, error) {
+	avset, err := c.getAvailabilitySet(ctx, group, name)
+	if err != nil {
+		return nil, err
+	}
+
+	vmclient, err := c.getVMClient()
+	if err != nil {
+		return nil, err
+	}
+	distribution, err := vmclient.GetHostNodeDistribution(ctx, group, avset.VirtualMachines)
+	if err != nil {
+		return nil, err
+	}
+	return &compute.Membership{
+		VirtualMachines: avset.VirtualMachines,
+		HostNodes:       distribution,
+	}, nil
+}
+
+func (c *AvailabilitySetClient) getAvailabilitySet(ctx context.Context, group, name string) (*compute.AvailabilitySet, error) {
+	avsets, err := c.Get(ctx, group, name)
+	if err != nil {
+		return nil, err
+	}
+	if avsets == nil || len(*avsets) == 0 {
+		return nil, errors.Wrapf(errors.NotFound, "Availability set [%s] not found", name)
+	}
+	return &(*avsets)[0], nil
+}
+
+func addMember(vm *compute.VirtualMachine, group, name string) error {
+	if vm.VirtualMachineProperties == nil {
+		return errors.Wrapf(errors.InvalidInput, "Virtual Machine has no properties")
+	}
+	if current := vm.AvailabilitySetProfile; current != nil && current.Name != nil && len(*current.Name) > 0 {
+		if *current.Name == name {
+			return errors.Wrapf(errors.AlreadyExists, "Virtual Machine is already a member of availability set [%s]", name)
+		}
+		return errors.Wrapf(errors.InvalidInput, "Virtual Machine is a member of availability set [%s], remove it first", *current.Name)
+	}
+	avsetName, avsetGroup := name, group
+	vm.AvailabilitySetProfile = &compute.AvailabilitySetReference{Name: &avsetName, GroupName: &avsetGroup}
+	return nil
+}
+
+func removeMember(vm *compute.VirtualMachine, name string) error {
+	if vm.VirtualMachineProperties == nil || vm.AvailabilitySetProfile == nil || vm.AvailabilitySetProfile.Name == nil || *vm.AvailabilitySetProfile.Name != name {
+		return errors.Wrapf(errors.NotFound, "Virtual Machine is not a member of availability set [%s]", name)
+	}
+	vm.AvailabilitySetProfile = nil
+	return nil
+}
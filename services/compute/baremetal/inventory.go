@@ -0,0 +1,291 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the Apache v2.0 License.
+
+package baremetal
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/microsoft/moc-sdk-for-go/services/compute"
+	"github.com/microsoft/moc/pkg/errors"
+)
+
+// HostCapabilities summarizes the hardware inventory of a bare metal host
+type HostCapabilities struct {
+	// Name
+	Name string
+	// CPUCount
+	CPUCount uint32
+	// GPUCount
+	GPUCount uint32
+	// MemoryMB
+	MemoryMB uint32
+	// NetworkInterfaceCount
+	NetworkInterfaceCount int
+	// DiskCount
+	DiskCount int
+	// TotalDiskSizeGB
+	TotalDiskSizeGB uint64
+	// LargestDiskSizeGB
+	LargestDiskSizeGB uint32
+	// Free - The host has a hardware inventory and no bare metal machine
+	Free bool
+}
+
+// MachineRequirements is the minimum hardware a bare metal machine needs. Zero values are not checked.
+type MachineRequirements struct {
+	// MinCPUCount
+	MinCPUCount uint32
+	// MinGPUCount
+	MinGPUCount uint32
+	// MinMemoryMB
+	MinMemoryMB uint32
+	// MinNetworkInterfaces
+	MinNetworkInterfaces int
+	// MinDiskSizeGB - Size of the largest single disk
+	MinDiskSizeGB uint32
+	// MinTotalDiskSizeGB - Combined size of all disks
+	MinTotalDiskSizeGB uint64
+}
+
+// CapabilityClass groups the hosts that share the same CPU, GPU, memory, NIC and disk configuration
+type CapabilityClass struct {
+	// Capabilities - Shared capabilities; Name and Free are not set
+	Capabilities HostCapabilities
+	// Hosts - All hosts of the class
+	Hosts []string
+	// FreeHosts - Hosts of the class without a bare metal machine
+	FreeHosts []string
+}
+
+// Inventory is the hardware inventory of the bare metal hosts of a location
+type Inventory struct {
+	// Hosts - Sorted by name
+	Hosts []HostCapabilities
+}
+
+// GetHostCapabilities summarizes the hardware profile, storage profile and network profile of the host
+func GetHostCapabilities(host *compute.BareMetalHost) HostCapabilities {
+	capabilities := HostCapabilities{}
+	if host == nil {
+		return capabilities
+	}
+	if host.Name != nil {
+		capabilities.Name = *host.Name
+	}
+	if host.BareMetalHostProperties == nil {
+		return capabilities
+	}
+
+	if host.HardwareProfile != nil && host.HardwareProfile.MachineSize != nil {
+		size := host.HardwareProfile.MachineSize
+		if size.CpuCount != nil {
+			capabilities.CPUCount = *size.CpuCount
+		}
+		if size.GpuCount != nil {
+			capabilities.GPUCount = *size.GpuCount
+		}
+		if size.MemoryMB != nil {
+			capabilities.MemoryMB = *size.MemoryMB
+		}
+	}
+	if host.NetworkProfile != nil && host.NetworkProfile.NetworkInterfaces != nil {
+		capabilities.NetworkInterfaceCount = len(*host.NetworkProfile.NetworkInterfaces)
+	}
+	if host.StorageProfile != nil && host.StorageProfile.Disks != nil {
+		for _, disk := range *host.StorageProfile.Disks {
+			capabilities.DiskCount++
+			if disk.DiskSizeGB == nil {
+				continue
+			}
+			capabilities.TotalDiskSizeGB += uint64(*disk.DiskSizeGB)
+			if *disk.DiskSizeGB > capabilities.LargestDiskSizeGB {
+				capabilities.LargestDiskSizeGB = *disk.DiskSizeGB
+			}
+		}
+	}
+	return capabilities
+}
+
+// Check returns the reasons the capabilities do not meet the requirements, or nothing if they do
+func (r MachineRequirements) Check(capabilities HostCapabilities) []string {
+	reasons := []string{}
+	if capabilities.CPUCount < r.MinCPUCount {
+		reasons = append(reasons, fmt.Sprintf("%d CPUs < %d", capabilities.CPUCount, r.MinCPUCount))
+	}
+	if capabilities.GPUCount < r.MinGPUCount {
+		reasons = append(reasons, fmt.Sprintf("%d GPUs < %d", capabilities.GPUCount, r.MinGPUCount))
+	}
+	if capabilities.MemoryMB < r.MinMemoryMB {
+		reasons = append(reasons, fmt.Sprintf("%d MB memory < %d MB", capabilities.MemoryMB, r.MinMemoryMB))
+	}
+	if capabilities.NetworkInterfaceCount < r.MinNetworkInterfaces {
+		reasons = append(reasons, fmt.Sprintf("%d NICs < %d", capabilities.NetworkInterfaceCount, r.MinNetworkInterfaces))
+	}
+	if capabilities.LargestDiskSizeGB < r.MinDiskSizeGB {
+		reasons = append(reasons, fmt.Sprintf("largest disk %d GB < %d GB", capabilities.LargestDiskSizeGB, r.MinDiskSizeGB))
+	}
+	if capabilities.TotalDiskSizeGB < r.MinTotalDiskSizeGB {
+		reasons = append(reasons, fmt.Sprintf("total disk %d GB < %d GB", capabilities.TotalDiskSizeGB, r.MinTotalDiskSizeGB))
+	}
+	return reasons
+}
+
+// NewInventory builds the inventory of the hosts. Hosts with a bare metal machine of the same name are not free.
+func NewInventory(hosts []compute.BareMetalHost, machines []compute.BareMetalMachine) *Inventory {
+	used := map[string]bool{}
+	for _, machine := range machines {
+		if machine.Name != nil {
+			used[*machine.Name] = true
+		}
+	}
+
+	inventory := &Inventory{Hosts: []HostCapabilities{}}
+	for i := range hosts {
+		capabilities := GetHostCapabilities(&hosts[i])
+		capabilities.Free = HasHardwareInventory(&hosts[i]) && !used[capabilities.Name] &&
+			GetProvisioningState(&hosts[i], nil) == StateAvailable
+		inventory.Hosts = append(inventory.Hosts, capabilities)
+	}
+	sort.Slice(inventory.Hosts, func(i, j int) bool {
+		return inventory.Hosts[i].Name < inventory.Hosts[j].Name
+	})
+	return inventory
+}
+
+// Classes aggregates the hosts by capability, largest hosts first
+func (i *Inventory) Classes() []CapabilityClass {
+	classes := []CapabilityClass{}
+	index := map[HostCapabilities]int{}
+	for _, host := range i.Hosts {
+		key := host
+		key.Name = ""
+		key.Free = false
+
+		n, ok := index[key]
+		if !ok {
+			n = len(classes)
+			index[key] = n
+			classes = append(classes, CapabilityClass{Capabilities: key, Hosts: []string{}, FreeHosts: []string{}})
+		}
+		classes[n].Hosts = append(classes[n].Hosts, host.Name)
+		if host.Free {
+			classes[n].FreeHosts = append(classes[n].FreeHosts, host.Name)
+		}
+	}
+	sort.SliceStable(classes, func(a, b int) bool {
+		return lessCapabilities(classes[b].Capabilities, classes[a].Capabilities)
+	})
+	return classes
+}
+
+// Match returns the free hosts that meet the requirements, smallest first so that larger hosts stay
+// available for larger requests
+func (i *Inventory) Match(requirements MachineRequirements) []HostCapabilities {
+	matches := []HostCapabilities{}
+	for _, host := range i.Hosts {
+		if host.Free && len(requirements.Check(host)) == 0 {
+			matches = append(matches, host)
+		}
+	}
+	sort.SliceStable(matches, func(a, b int) bool {
+		return lessCapabilities(matches[a], matches[b])
+	})
+	return matches
+}
+
+// explain describes why no free host meets the requirements
+func (i *Inventory) explain(requirements MachineRequirements) string {
+	reasons := []string{}
+	for _, host := range i.Hosts {
+		if !host.Free {
+			reasons = append(reasons, fmt.Sprintf("%s: not free", host.Name))
+			continue
+		}
+		reasons = append(reasons, fmt.Sprintf("%s: %s", host.Name, strings.Join(requirements.Check(host), ", ")))
+	}
+	if len(reasons) == 0 {
+		return "no bare metal hosts"
+	}
+	return strings.Join(reasons, "; ")
+}
+
+func lessCapabilities(a, b HostCapabilities) bool {
+	if a.CPUCount != b.CPUCount {
+		return a.CPUCount < b.CPUCount
+	}
+	if a.MemoryMB != b.MemoryMB {
+		return a.MemoryMB < b.MemoryMB
+	}
+	if a.GPUCount != b.GPUCount {
+		return a.GPUCount < b.GPUCount
+	}
+	if a.TotalDiskSizeGB != b.TotalDiskSizeGB {
+		return a.TotalDiskSizeGB < b.TotalDiskSizeGB
+	}
+	if a.NetworkInterfaceCount != b.NetworkInterfaceCount {
+		return a.NetworkInterfaceCount < b.NetworkInterfaceCount
+	}
+	return a.Name < b.Name
+}
+
+// GetInventory returns the hardware inventory of the hosts in the location. Bare metal machines are listed
+// by group, so the machines of every group of the location are listed to mark their hosts as used.
+func (p *Provisioner) GetInventory(ctx context.Context, location string) (*Inventory, error) {
+	hosts, err := p.hosts.Get(ctx, location, "")
+	if err != nil {
+		return nil, err
+	}
+	if hosts == nil {
+		hosts = &[]compute.BareMetalHost{}
+	}
+	groups, err := p.groups.Get(ctx, location, "")
+	if err != nil && !errors.IsNotFound(err) {
+		return nil, err
+	}
+	machines := []compute.BareMetalMachine{}
+	if groups != nil {
+		for _, g := range *groups {
+			if g.Name == nil {
+				continue
+			}
+			groupMachines, err := p.machines.Get(ctx, *g.Name, "")
+			if err != nil && !errors.IsNotFound(err) {
+				return nil, err
+			}
+			if groupMachines != nil {
+				machines = append(machines, *groupMachines...)
+			}
+		}
+	}
+	return NewInventory(*hosts, machines), nil
+}
+
+// SelectHost returns the smallest free host in the location that meets the requirements
+func (p *Provisioner) SelectHost(ctx context.Context, location string, requirements MachineRequirements) (*compute.BareMetalHost, error) {
+	inventory, err := p.GetInventory(ctx, location)
+	if err != nil {
+		return nil, err
+	}
+	matches := inventory.Match(requirements)
+	if len(matches) == 0 {
+		return nil, errors.Wrapf(errors.NotFound, "No free bare metal host meets the requirements: %s", inventory.explain(requirements))
+	}
+	return p.getHost(ctx, location, matches[0].Name)
+}
+
+// ProvisionMatching selects a free host that meets the requirements and provisions a bare metal machine on it.
+// Concurrent callers may select the same host; the loser fails the provisioning state check.
+func (p *Provisioner) ProvisionMatching(ctx context.Context, location string, requirements MachineRequirements, deployment MachineDeployment) (*compute.BareMetalMachine, error) {
+	if err := validateDeployment(deployment); err != nil {
+		return nil, err
+	}
+	host, err := p.SelectHost(ctx, location, requirements)
+	if err != nil {
+		return nil, err
+	}
+	return p.Provision(ctx, location, *host.Name, deployment)
+}
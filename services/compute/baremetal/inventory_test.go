@@ -0,0 +1,146 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the Apache v2.0 License.
+
+package baremetal
+
+import (
+	"context"
+	"testing"
+
+	"github.com/microsoft/moc-sdk-for-go/services/cloud"
+	"github.com/microsoft/moc-sdk-for-go/services/compute"
+	"github.com/microsoft/moc/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newInventoryHost(name string, cpu, memory uint32, nics int, disks ...uint32) compute.BareMetalHost {
+	host := *newHost("CREATED", cpu, memory)
+	host.Name = &name
+	interfaces := make([]compute.BareMetalHostNetworkInterface, nics)
+	host.NetworkProfile = &compute.BareMetalHostNetworkProfile{NetworkInterfaces: &interfaces}
+	hostDisks := []compute.BareMetalHostDisk{}
+	for i := range disks {
+		hostDisks = append(hostDisks, compute.BareMetalHostDisk{DiskSizeGB: &disks[i]})
+	}
+	host.StorageProfile = &compute.BareMetalHostStorageProfile{Disks: &hostDisks}
+	return host
+}
+
+func Test_GetHostCapabilities(t *testing.T) {
+	host := newInventoryHost("host1", 32, 131072, 2, 512, 1024)
+	capabilities := GetHostCapabilities(&host)
+	assert.Equal(t, "host1", capabilities.Name)
+	assert.Equal(t, uint32(32), capabilities.CPUCount)
+	assert.Equal(t, 2, capabilities.NetworkInterfaceCount)
+	assert.Equal(t, 2, capabilities.DiskCount)
+	assert.Equal(t, uint64(1536), capabilities.TotalDiskSizeGB)
+	assert.Equal(t, uint32(1024), capabilities.LargestDiskSizeGB)
+
+	assert.Equal(t, HostCapabilities{}, GetHostCapabilities(nil))
+}
+
+func Test_InventoryMatch(t *testing.T) {
+	used := "host3"
+	hosts := []compute.BareMetalHost{
+		newInventoryHost("host1", 64, 262144, 2, 2048),
+		newInventoryHost("host2", 32, 131072, 2, 1024),
+		newInventoryHost("host3", 32, 131072, 2, 1024),
+		newInventoryHost("host4", 16, 65536, 1, 512),
+	}
+	machines := []compute.BareMetalMachine{{Name: &used}}
+	inventory := NewInventory(hosts, machines)
+
+	requirements := MachineRequirements{MinCPUCount: 32, MinNetworkInterfaces: 2, MinDiskSizeGB: 1000}
+	matches := inventory.Match(requirements)
+	assert.Len(t, matches, 2)
+	assert.Equal(t, "host2", matches[0].Name)
+	assert.Equal(t, "host1", matches[1].Name)
+
+	assert.Empty(t, inventory.Match(MachineRequirements{MinCPUCount: 128}))
+	assert.Len(t, requirements.Check(inventory.Hosts[3]), 3)
+
+	classes := inventory.Classes()
+	assert.Len(t, classes, 3)
+	assert.Equal(t, []string{"host1"}, classes[0].Hosts)
+	assert.Equal(t, []string{"host2", "host3"}, classes[1].Hosts)
+	assert.Equal(t, []string{"host2"}, classes[1].FreeHosts)
+}
+
+type fakeHosts struct {
+	hosts []compute.BareMetalHost
+}
+
+func (f *fakeHosts) Get(ctx context.Context, location, name string) (*[]compute.BareMetalHost, error) {
+	hosts := []compute.BareMetalHost{}
+	for _, host := range f.hosts {
+		if len(name) == 0 || *host.Name == name {
+			hosts = append(hosts, host)
+		}
+	}
+	return &hosts, nil
+}
+
+func (f *fakeHosts) CreateOrUpdate(ctx context.Context, location, name string, host *compute.BareMetalHost) (*compute.BareMetalHost, error) {
+	return host, nil
+}
+
+// fakeMachines stores the bare metal machines of each group
+type fakeMachines struct {
+	machines map[string][]compute.BareMetalMachine
+}
+
+func (f *fakeMachines) Get(ctx context.Context, group, name string) (*[]compute.BareMetalMachine, error) {
+	machines, ok := f.machines[group]
+	if !ok {
+		return nil, errors.Wrapf(errors.NotFound, "Group [%s] has no bare metal machines", group)
+	}
+	return &machines, nil
+}
+
+func (f *fakeMachines) CreateOrUpdate(ctx context.Context, group, name string, machine *compute.BareMetalMachine) (*compute.BareMetalMachine, error) {
+	return machine, nil
+}
+
+func (f *fakeMachines) Delete(ctx context.Context, group, name string) error {
+	return nil
+}
+
+type fakeGroups struct {
+	names []string
+}
+
+func (f *fakeGroups) Get(ctx context.Context, location, name string) (*[]cloud.Group, error) {
+	groups := []cloud.Group{}
+	for i := range f.names {
+		groups = append(groups, cloud.Group{Name: &f.names[i]})
+	}
+	return &groups, nil
+}
+
+func Test_GetInventory(t *testing.T) {
+	occupied := "host1"
+	p := &Provisioner{
+		hosts: &fakeHosts{hosts: []compute.BareMetalHost{
+			newInventoryHost("host1", 32, 131072, 2, 1024),
+			newInventoryHost("host2", 32, 131072, 2, 1024),
+		}},
+		machines: &fakeMachines{machines: map[string][]compute.BareMetalMachine{
+			"group1": {},
+			"group2": {{Name: &occupied}},
+		}},
+		groups: &fakeGroups{names: []string{"group1", "group2", "group3"}},
+	}
+
+	// host1 runs a machine of group2 and its own state is Available, yet it is not free
+	assert.Equal(t, StateAvailable, GetProvisioningState(&p.hosts.(*fakeHosts).hosts[0], nil))
+	inventory, err := p.GetInventory(context.Background(), "location")
+	require.NoError(t, err)
+	require.Len(t, inventory.Hosts, 2)
+	assert.False(t, inventory.Hosts[0].Free)
+	assert.True(t, inventory.Hosts[1].Free)
+
+	host, err := p.SelectHost(context.Background(), "location", MachineRequirements{MinCPUCount: 16})
+	require.NoError(t, err)
+	assert.Equal(t, "host2", *host.Name)
+}
@@ -0,0 +1,424 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the Apache v2.0 License.
+
+package baremetal
+
+import (
+	"context"
+	"time"
+
+	"github.com/microsoft/moc-sdk-for-go/services/cloud"
+	"github.com/microsoft/moc-sdk-for-go/services/cloud/group"
+	"github.com/microsoft/moc-sdk-for-go/services/compute"
+	"github.com/microsoft/moc-sdk-for-go/services/compute/baremetalhost"
+	"github.com/microsoft/moc-sdk-for-go/services/compute/baremetalmachine"
+	"github.com/microsoft/moc/pkg/auth"
+	"github.com/microsoft/moc/pkg/errors"
+)
+
+const (
+	defaultPollInterval = 10 * time.Second
+	defaultStepTimeout  = 30 * time.Minute
+)
+
+// HostRegistration describes how the cloud agent reaches the node agent of a physical node
+type HostRegistration struct {
+	// Name - Name of the bare metal host, also used for its bare metal machine
+	Name string
+	// FQDN
+	FQDN string
+	// Port
+	Port uint32
+	// AuthorizerPort
+	AuthorizerPort uint32
+	// Certificate
+	Certificate string
+	// Tags
+	Tags map[string]*string
+}
+
+// MachineDeployment describes the operating system deployed to a registered host
+type MachineDeployment struct {
+	// Group - Group of the bare metal machine
+	Group string
+	// Image - Image the operating system is deployed from
+	Image compute.BareMetalMachineImageReference
+	// OsProfile
+	OsProfile *compute.BareMetalMachineOSProfile
+	// SecurityProfile
+	SecurityProfile *compute.SecurityProfile
+	// Tags
+	Tags map[string]*string
+}
+
+// ProvisionerOptions tunes the waits and retries of the provisioner
+type ProvisionerOptions struct {
+	// PollInterval - How often states are polled. Defaults to 10 seconds.
+	PollInterval time.Duration
+	// StepTimeout - Maximum time spent waiting for a single transition. Defaults to 30 minutes.
+	StepTimeout time.Duration
+	// MaxRetries - How many times a failed registration or deployment is retried
+	MaxRetries int
+	// OnTransition - Called whenever a node is observed moving to a new state
+	OnTransition func(name string, from, to ProvisioningState)
+}
+
+// Provisioner drives physical nodes from host registration through machine deployment
+type Provisioner struct {
+	hosts    hostService
+	machines machineService
+	groups   groupService
+	options  ProvisionerOptions
+}
+
+// hostService is the part of the bare metal host client used by the provisioner
+type hostService interface {
+	Get(context.Context, string, string) (*[]compute.BareMetalHost, error)
+	CreateOrUpdate(context.Context, string, string, *compute.BareMetalHost) (*compute.BareMetalHost, error)
+}
+
+// machineService is the part of the bare metal machine client used by the provisioner
+type machineService interface {
+	Get(context.Context, string, string) (*[]compute.BareMetalMachine, error)
+	CreateOrUpdate(context.Context, string, string, *compute.BareMetalMachine) (*compute.BareMetalMachine, error)
+	Delete(context.Context, string, string) error
+}
+
+// groupService lists the groups whose bare metal machines occupy the hosts of a location
+type groupService interface {
+	Get(context.Context, string, string) (*[]cloud.Group, error)
+}
+
+// NewProvisioner returns a provisioner for the cloud
+func NewProvisioner(cloudFQDN string, authorizer auth.Authorizer, options ProvisionerOptions) (*Provisioner, error) {
+	if options.PollInterval < 0 || options.StepTimeout < 0 || options.MaxRetries < 0 {
+		return nil, errors.Wrapf(errors.InvalidInput, "Provisioner options cannot be negative")
+	}
+	if options.PollInterval == 0 {
+		options.PollInterval = defaultPollInterval
+	}
+	if options.StepTimeout == 0 {
+		options.StepTimeout = defaultStepTimeout
+	}
+
+	hosts, err := baremetalhost.NewBareMetalHostClient(cloudFQDN, authorizer)
+	if err != nil {
+		return nil, err
+	}
+	machines, err := baremetalmachine.NewBareMetalMachineClient(cloudFQDN, authorizer)
+	if err != nil {
+		return nil, err
+	}
+	groups, err := group.NewGroupClient(cloudFQDN, authorizer)
+	if err != nil {
+		return nil, err
+	}
+	return &Provisioner{hosts: hosts, machines: machines, groups: groups, options: options}, nil
+}
+
+// GetState returns the provisioning state of the node. group may be empty to ignore machines.
+func (p *Provisioner) GetState(ctx context.Context, location, group, name string) (ProvisioningState, error) {
+	host, machine, err := p.get(ctx, location, group, name)
+	if err != nil {
+		return "", err
+	}
+	return GetProvisioningState(host, machine), nil
+}
+
+// Register registers the host and waits until its hardware inventory is available.
+// Registering a host that is already registered only waits for the inspection to finish.
+func (p *Provisioner) Register(ctx context.Context, location string, registration HostRegistration) (*compute.BareMetalHost, error) {
+	if err := validateRegistration(registration); err != nil {
+		return nil, err
+	}
+	name := registration.Name
+
+	for attempt := 0; ; attempt++ {
+		state, err := p.GetState(ctx, location, "", name)
+		if err != nil {
+			return nil, err
+		}
+
+		switch state {
+		case StateUnregistered, StateFailed:
+			if _, err = p.hosts.CreateOrUpdate(ctx, location, name, getBareMetalHost(location, registration)); err != nil {
+				return nil, errors.Wrapf(err, "Failed to register bare metal host [%s]", name)
+			}
+			p.notify(name, state, StateRegistered)
+		case StateRegistered, StateInspecting:
+		case StateAvailable:
+			return p.getHost(ctx, location, name)
+		default:
+			return nil, errors.Wrapf(errors.InvalidInput, "Bare metal host [%s] is already [%s]", name, state)
+		}
+
+		_, err = p.waitForState(ctx, location, "", name, StateAvailable, StateRegistered, StateInspecting)
+		if err == nil {
+			return p.getHost(ctx, location, name)
+		}
+		if attempt >= p.options.MaxRetries || ctx.Err() != nil {
+			return nil, errors.Wrapf(err, "Registration of bare metal host [%s] failed after %d attempt(s)", name, attempt+1)
+		}
+	}
+}
+
+// Provision deploys a bare metal machine to an available host and waits until it is provisioned.
+// A failed deployment is removed and retried up to MaxRetries times.
+func (p *Provisioner) Provision(ctx context.Context, location, name string, deployment MachineDeployment) (*compute.BareMetalMachine, error) {
+	if err := validateDeployment(deployment); err != nil {
+		return nil, err
+	}
+	group := deployment.Group
+
+	host, machine, err := p.get(ctx, location, group, name)
+	if err != nil {
+		return nil, err
+	}
+	if !HasHardwareInventory(host) {
+		return nil, errors.Wrapf(errors.InvalidInput, "Bare metal host [%s] is not registered or its inspection has not finished", name)
+	}
+	state := GetProvisioningState(host, machine)
+	if err := ValidateTransition(state, StateProvisioning); err != nil {
+		return nil, errors.Wrapf(err, "Bare metal host [%s] cannot be provisioned", name)
+	}
+	if state == StateFailed && machine != nil {
+		if err := p.removeMachine(ctx, location, group, name); err != nil {
+			return nil, err
+		}
+	}
+
+	for attempt := 0; ; attempt++ {
+		_, err = p.machines.CreateOrUpdate(ctx, group, name, getBareMetalMachine(location, name, deployment))
+		if err == nil {
+			p.notify(name, StateAvailable, StateProvisioning)
+			_, err = p.waitForState(ctx, location, group, name, StateProvisioned, StateProvisioning)
+		}
+		if err == nil {
+			return p.getMachine(ctx, group, name)
+		}
+		if attempt >= p.options.MaxRetries || ctx.Err() != nil {
+			return nil, errors.Wrapf(err, "Provisioning of bare metal machine [%s] failed after %d attempt(s)", name, attempt+1)
+		}
+
+		// Clean up the failed deployment before retrying
+		if err := p.removeMachine(ctx, location, group, name); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// Deprovision removes the bare metal machine from the host and waits until the host is available again
+func (p *Provisioner) Deprovision(ctx context.Context, location, group, name string) error {
+	if len(group) == 0 {
+		return errors.Wrapf(errors.InvalidGroup, "Group not specified")
+	}
+	state, err := p.GetState(ctx, location, group, name)
+	if err != nil {
+		return err
+	}
+	if state == StateAvailable {
+		return nil
+	}
+	if err := ValidateTransition(state, StateDeprovisioning); err != nil {
+		return errors.Wrapf(err, "Bare metal machine [%s] cannot be deprovisioned", name)
+	}
+	return p.removeMachine(ctx, location, group, name)
+}
+
+// removeMachine deletes the bare metal machine, if any, and waits for the host to become available
+func (p *Provisioner) removeMachine(ctx context.Context, location, group, name string) error {
+	machine, err := p.findMachine(ctx, group, name)
+	if err != nil {
+		return err
+	}
+	if machine != nil {
+		if err := p.machines.Delete(ctx, group, name); err != nil {
+			return errors.Wrapf(err, "Failed to delete bare metal machine [%s]", name)
+		}
+		p.notify(name, GetProvisioningState(nil, machine), StateDeprovisioning)
+	}
+	// the machine may still report its previous state until the agent starts deleting it
+	_, err = p.waitForState(ctx, location, group, name, StateAvailable, StateDeprovisioning, StateProvisioning, StateProvisioned, StateFailed)
+	return err
+}
+
+// waitForState polls the node until it reaches target. The states in passing are expected on the
+// way there; Failed or any other state ends the wait with an error.
+func (p *Provisioner) waitForState(ctx context.Context, location, group, name string, target ProvisioningState, passing ...ProvisioningState) (ProvisioningState, error) {
+	ctx, cancel := context.WithTimeout(ctx, p.options.StepTimeout)
+	defer cancel()
+	ticker := time.NewTicker(p.options.PollInterval)
+	defer ticker.Stop()
+
+	last := ProvisioningState("")
+	for {
+		state, err := p.GetState(ctx, location, group, name)
+		if err != nil {
+			return last, err
+		}
+		if len(last) > 0 {
+			p.notify(name, last, state)
+		}
+		last = state
+
+		if state == target {
+			return state, nil
+		}
+		if !containsState(passing, state) {
+			if state == StateFailed {
+				return state, errors.Wrapf(errors.Failed, "Bare metal host [%s] failed while waiting for [%s]", name, target)
+			}
+			return state, errors.Wrapf(errors.Failed, "Bare metal host [%s] is unexpectedly [%s] while waiting for [%s]", name, state, target)
+		}
+
+		select {
+		case <-ctx.Done():
+			return state, errors.Wrapf(ctx.Err(), "Bare metal host [%s] did not reach [%s], last observed [%s]", name, target, state)
+		case <-ticker.C:
+		}
+	}
+}
+
+func (p *Provisioner) notify(name string, from, to ProvisioningState) {
+	if p.options.OnTransition != nil && from != to {
+		p.options.OnTransition(name, from, to)
+	}
+}
+
+func (p *Provisioner) get(ctx context.Context, location, group, name string) (*compute.BareMetalHost, *compute.BareMetalMachine, error) {
+	host, err := p.findHost(ctx, location, name)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(group) == 0 {
+		return host, nil, nil
+	}
+	machine, err := p.findMachine(ctx, group, name)
+	if err != nil {
+		return nil, nil, err
+	}
+	return host, machine, nil
+}
+
+// findHost returns the host, or nil if it does not exist
+func (p *Provisioner) findHost(ctx context.Context, location, name string) (*compute.BareMetalHost, error) {
+	hosts, err := p.hosts.Get(ctx, location, name)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if hosts == nil || len(*hosts) == 0 {
+		return nil, nil
+	}
+	return &(*hosts)[0], nil
+}
+
+// findMachine returns the machine, or nil if it does not exist
+func (p *Provisioner) findMachine(ctx context.Context, group, name string) (*compute.BareMetalMachine, error) {
+	machines, err := p.machines.Get(ctx, group, name)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if machines == nil || len(*machines) == 0 {
+		return nil, nil
+	}
+	return &(*machines)[0], nil
+}
+
+func (p *Provisioner) getHost(ctx context.Context, location, name string) (*compute.BareMetalHost, error) {
+	host, err := p.findHost(ctx, location, name)
+	if err != nil {
+		return nil, err
+	}
+	if host == nil {
+		return nil, errors.Wrapf(errors.NotFound, "Bare metal host [%s] not found", name)
+	}
+	return host, nil
+}
+
+func (p *Provisioner) getMachine(ctx context.Context, group, name string) (*compute.BareMetalMachine, error) {
+	machine, err := p.findMachine(ctx, group, name)
+	if err != nil {
+		return nil, err
+	}
+	if machine == nil {
+		return nil, errors.Wrapf(errors.NotFound, "Bare metal machine [%s] not found", name)
+	}
+	return machine, nil
+}
+
+func containsState(states []ProvisioningState, state ProvisioningState) bool {
+	for _, s := range states {
+		if s == state {
+			return true
+		}
+	}
+	return false
+}
+
+func validateRegistration(registration HostRegistration) error {
+	if len(registration.Name) == 0 {
+		return errors.Wrapf(errors.InvalidInput, "Bare metal host name is missing")
+	}
+	if len(registration.FQDN) == 0 {
+		return errors.Wrapf(errors.InvalidInput, "FQDN of bare metal host [%s] is missing", registration.Name)
+	}
+	if registration.Port == 0 || registration.AuthorizerPort == 0 {
+		return errors.Wrapf(errors.InvalidInput, "Port and AuthorizerPort of bare metal host [%s] are required", registration.Name)
+	}
+	if registration.Port > 65535 || registration.AuthorizerPort > 65535 {
+		return errors.Wrapf(errors.InvalidInput, "Port and AuthorizerPort of bare metal host [%s] must be at most 65535", registration.Name)
+	}
+	return nil
+}
+
+func validateDeployment(deployment MachineDeployment) error {
+	if len(deployment.Group) == 0 {
+		return errors.Wrapf(errors.InvalidGroup, "Group not specified")
+	}
+	if (deployment.Image.Name == nil || len(*deployment.Image.Name) == 0) && (deployment.Image.ID == nil || len(*deployment.Image.ID) == 0) {
+		return errors.Wrapf(errors.InvalidInput, "Bare metal machine image reference is missing")
+	}
+	return nil
+}
+
+func getBareMetalHost(location string, registration HostRegistration) *compute.BareMetalHost {
+	name := registration.Name
+	fqdn := registration.FQDN
+	port := registration.Port
+	authorizerPort := registration.AuthorizerPort
+	host := &compute.BareMetalHost{
+		Name:     &name,
+		Location: &location,
+		Tags:     registration.Tags,
+		BareMetalHostProperties: &compute.BareMetalHostProperties{
+			FQDN:           &fqdn,
+			Port:           &port,
+			AuthorizerPort: &authorizerPort,
+		},
+	}
+	if len(registration.Certificate) > 0 {
+		certificate := registration.Certificate
+		host.Certificate = &certificate
+	}
+	return host
+}
+
+func getBareMetalMachine(location, name string, deployment MachineDeployment) *compute.BareMetalMachine {
+	image := deployment.Image
+	return &compute.BareMetalMachine{
+		Name:     &name,
+		Location: &location,
+		Tags:     deployment.Tags,
+		BareMetalMachineProperties: &compute.BareMetalMachineProperties{
+			StorageProfile:  &compute.BareMetalMachineStorageProfile{ImageReference: &image},
+			OsProfile:       deployment.OsProfile,
+			SecurityProfile: deployment.SecurityProfile,
+		},
+	}
+}
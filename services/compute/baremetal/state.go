@@ -0,0 +1,127 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the Apache v2.0 License.
+
+package baremetal
+
+import (
+	"strings"
+
+	"github.com/microsoft/moc-sdk-for-go/services/compute"
+	"github.com/microsoft/moc/pkg/errors"
+)
+
+// ProvisioningState is the state of a physical node in the provisioning workflow
+type ProvisioningState string
+
+const (
+	// StateUnregistered - No bare metal host exists for the node
+	StateUnregistered ProvisioningState = "Unregistered"
+	// StateRegistered - The host is registered and waiting to be accepted by the cloud agent
+	StateRegistered ProvisioningState = "Registered"
+	// StateInspecting - The host is accepted and its hardware inventory is being collected
+	StateInspecting ProvisioningState = "Inspecting"
+	// StateAvailable - The host has a hardware inventory and no bare metal machine
+	StateAvailable ProvisioningState = "Available"
+	// StateProvisioning - A bare metal machine is being deployed to the host
+	StateProvisioning ProvisioningState = "Provisioning"
+	// StateProvisioned - The bare metal machine is deployed
+	StateProvisioned ProvisioningState = "Provisioned"
+	// StateDeprovisioning - The bare metal machine is being removed
+	StateDeprovisioning ProvisioningState = "Deprovisioning"
+	// StateFailed - The host or machine reported a failed provisioning state
+	StateFailed ProvisioningState = "Failed"
+)
+
+// provisioningTransitions lists the states each state can move to
+var provisioningTransitions = map[ProvisioningState][]ProvisioningState{
+	StateUnregistered:   {StateRegistered},
+	StateRegistered:     {StateInspecting, StateAvailable, StateFailed, StateUnregistered},
+	StateInspecting:     {StateAvailable, StateFailed, StateUnregistered},
+	StateAvailable:      {StateProvisioning, StateUnregistered},
+	StateProvisioning:   {StateProvisioned, StateFailed, StateDeprovisioning},
+	StateProvisioned:    {StateDeprovisioning},
+	StateDeprovisioning: {StateAvailable, StateFailed},
+	StateFailed:         {StateRegistered, StateDeprovisioning, StateAvailable, StateProvisioning, StateUnregistered},
+}
+
+// ValidateTransition returns an error if a node cannot move from one state to the other
+func ValidateTransition(from, to ProvisioningState) error {
+	if from == to {
+		return nil
+	}
+	for _, allowed := range provisioningTransitions[from] {
+		if allowed == to {
+			return nil
+		}
+	}
+	return errors.Wrapf(errors.InvalidInput, "Invalid provisioning transition from [%s] to [%s]", from, to)
+}
+
+// GetProvisioningState derives the workflow state of a node from its bare metal host and machine.
+// Either may be nil when it does not exist.
+func GetProvisioningState(host *compute.BareMetalHost, machine *compute.BareMetalMachine) ProvisioningState {
+	if machine != nil {
+		var state *string
+		if machine.BareMetalMachineProperties != nil {
+			state = machine.ProvisioningState
+		}
+		switch {
+		case isDeleting(state):
+			return StateDeprovisioning
+		case isFailed(state):
+			return StateFailed
+		case isCreated(state):
+			return StateProvisioned
+		default:
+			return StateProvisioning
+		}
+	}
+
+	if host == nil {
+		return StateUnregistered
+	}
+	var state *string
+	if host.BareMetalHostProperties != nil {
+		state = host.ProvisioningState
+	}
+	switch {
+	case isFailed(state):
+		return StateFailed
+	case HasHardwareInventory(host):
+		return StateAvailable
+	case isCreated(state):
+		return StateInspecting
+	default:
+		return StateRegistered
+	}
+}
+
+// HasHardwareInventory reports whether the cloud agent has reported the CPU and memory of the host
+func HasHardwareInventory(host *compute.BareMetalHost) bool {
+	if host == nil || host.BareMetalHostProperties == nil || host.HardwareProfile == nil || host.HardwareProfile.MachineSize == nil {
+		return false
+	}
+	size := host.HardwareProfile.MachineSize
+	return size.CpuCount != nil && *size.CpuCount > 0 && size.MemoryMB != nil && *size.MemoryMB > 0
+}
+
+func normalizeState(state *string) string {
+	if state == nil {
+		return ""
+	}
+	return strings.ToUpper(*state)
+}
+
+func isFailed(state *string) bool {
+	s := normalizeState(state)
+	return s == "FAILED" || strings.HasSuffix(s, "_FAILED")
+}
+
+func isDeleting(state *string) bool {
+	return normalizeState(state) == "DELETING"
+}
+
+func isCreated(state *string) bool {
+	s := normalizeState(state)
+	return s == "CREATED" || s == "SUCCEEDED"
+}
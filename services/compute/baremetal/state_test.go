@@ -0,0 +1,69 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the Apache v2.0 License.
+
+package baremetal
+
+import (
+	"testing"
+
+	"github.com/microsoft/moc-sdk-for-go/services/compute"
+	"github.com/stretchr/testify/assert"
+)
+
+func newHost(state string, cpu, memory uint32) *compute.BareMetalHost {
+	return &compute.BareMetalHost{
+		BareMetalHostProperties: &compute.BareMetalHostProperties{
+			ProvisioningState: &state,
+			HardwareProfile: &compute.BareMetalHostHardwareProfile{
+				MachineSize: &compute.BareMetalHostSize{CpuCount: &cpu, MemoryMB: &memory},
+			},
+		},
+	}
+}
+
+func newMachine(state string) *compute.BareMetalMachine {
+	return &compute.BareMetalMachine{
+		BareMetalMachineProperties: &compute.BareMetalMachineProperties{ProvisioningState: &state},
+	}
+}
+
+func Test_GetProvisioningState(t *testing.T) {
+	assert.Equal(t, StateUnregistered, GetProvisioningState(nil, nil))
+	assert.Equal(t, StateRegistered, GetProvisioningState(newHost("CREATING", 0, 0), nil))
+	assert.Equal(t, StateInspecting, GetProvisioningState(newHost("CREATED", 0, 0), nil))
+	assert.Equal(t, StateAvailable, GetProvisioningState(newHost("CREATED", 16, 65536), nil))
+	assert.Equal(t, StateFailed, GetProvisioningState(newHost("CREATE_FAILED", 16, 65536), nil))
+
+	host := newHost("CREATED", 16, 65536)
+	assert.Equal(t, StateProvisioning, GetProvisioningState(host, newMachine("CREATING")))
+	assert.Equal(t, StateProvisioned, GetProvisioningState(host, newMachine("CREATED")))
+	assert.Equal(t, StateDeprovisioning, GetProvisioningState(host, newMachine("DELETING")))
+	assert.Equal(t, StateFailed, GetProvisioningState(host, newMachine("CREATE_FAILED")))
+	assert.Equal(t, StateProvisioning, GetProvisioningState(host, &compute.BareMetalMachine{}))
+}
+
+func Test_ValidateTransition(t *testing.T) {
+	assert.NoError(t, ValidateTransition(StateAvailable, StateProvisioning))
+	assert.NoError(t, ValidateTransition(StateProvisioned, StateDeprovisioning))
+	assert.NoError(t, ValidateTransition(StateFailed, StateProvisioning))
+	assert.NoError(t, ValidateTransition(StateProvisioning, StateProvisioning))
+	assert.Error(t, ValidateTransition(StateProvisioned, StateProvisioning))
+	assert.Error(t, ValidateTransition(StateInspecting, StateProvisioning))
+	assert.Error(t, ValidateTransition(StateAvailable, StateDeprovisioning))
+}
+
+func Test_validateRegistrationAndDeployment(t *testing.T) {
+	registration := HostRegistration{Name: "node1", FQDN: "node1.contoso.com", Port: 45000, AuthorizerPort: 45001}
+	assert.NoError(t, validateRegistration(registration))
+
+	registration.FQDN = ""
+	assert.Error(t, validateRegistration(registration))
+	registration.FQDN = "node1.contoso.com"
+	registration.Port = 70000
+	assert.Error(t, validateRegistration(registration))
+
+	image := "ubuntu"
+	assert.NoError(t, validateDeployment(MachineDeployment{Group: "group", Image: compute.BareMetalMachineImageReference{Name: &image}}))
+	assert.Error(t, validateDeployment(MachineDeployment{Group: "group"}))
+	assert.Error(t, validateDeployment(MachineDeployment{Image: compute.BareMetalMachineImageReference{Name: &image}}))
+}
@@ -6,6 +6,8 @@
 package compute
 
 import (
+	"time"
+
 	"github.com/Azure/go-autorest/autorest"
 	"github.com/Azure/go-autorest/autorest/date"
 	"github.com/microsoft/moc/rpc/common"
@@ -863,6 +865,27 @@ type AzureBlobImageProperties struct {
 	Endpoint    string `json:"endpoint,omitempty"`
 }
 
+// OCIImageProperties describes an image stored as a VHD or VHDX layer of an OCI artifact
+type OCIImageProperties struct {
+	// Registry - Host, and optionally port, of the registry
+	Registry string `json:"registry,omitempty"`
+	// Repository - Repository of the artifact within the registry
+	Repository string `json:"repository,omitempty"`
+	// Reference - Tag or digest of the manifest
+	Reference string `json:"reference,omitempty"`
+	// ManifestDigest - Expected digest of the manifest, e.g. sha256:<hex>. Required unless Reference is a digest.
+	ManifestDigest string `json:"manifestDigest,omitempty"`
+	// Username - Username for basic or token authentication
+	Username string `json:"username,omitempty"`
+	// Password - Password or access token of Username
+	Password string `json:"password,omitempty"`
+	// PlainHTTP - Connect to the registry over http instead of https. Credentials are not sent over http.
+	PlainHTTP bool `json:"plainHTTP,omitempty"`
+	// StagingDirectory - Where the layer is staged before it is uploaded. Required. The cloud agent reads the
+	// staged layer from this path, so it must be readable by the agent at the same path.
+	StagingDirectory string `json:"stagingDirectory,omitempty"`
+}
+
 // Azure GalleryImage properties
 type AzureGalleryImageProperties struct {
 	// SasURL - Specifies the SAS URI for the image
@@ -903,6 +926,18 @@ type GalleryImageProperties struct {
 	SourceType common.ImageSource `json:"sourceType,omitempty"`
 	// CloudInitDataSource - READ-ONLY; The cloud init data source to be used with the image. [NoCloud, Azure]. Default Value – NoCloud. For marketplace images it will be Azure.
 	CloudInitDataSource common.CloudInitDataSource `json:"cloudInitDataSource,omitempty"`
+	// TransferStatus - READ-ONLY; Progress of the agent copying the image from its source
+	TransferStatus *GalleryImageTransferStatus `json:"transferStatus,omitempty"`
+}
+
+// GalleryImageTransferStatus describes how much of a gallery image the agent has copied from its source
+type GalleryImageTransferStatus struct {
+	// ProgressPercentage - READ-ONLY; Percentage of the image copied
+	ProgressPercentage *int64 `json:"progressPercentage,omitempty"`
+	// TransferredBytes - READ-ONLY; Number of bytes copied
+	TransferredBytes *int64 `json:"transferredBytes,omitempty"`
+	// TotalBytes - READ-ONLY; Size of the image in bytes, zero if unknown
+	TotalBytes *int64 `json:"totalBytes,omitempty"`
 }
 
 // GalleryImage specifies information about the gallery Image Definition that you want to create or update.
@@ -1287,6 +1322,15 @@ type PlacementGroupProperties struct {
 	VirtualMachines []*VirtualMachineReference
 }
 
+// Membership describes the member VMs of an availability set or placement group and how they are spread
+// across host nodes. Fault domains are not reported by the cloud agent.
+type Membership struct {
+	// VirtualMachines
+	VirtualMachines []*VirtualMachineReference `json:"virtualMachines,omitempty"`
+	// HostNodes - Host node name to the names of the members running on it. Members not placed on a node yet are under the empty key.
+	HostNodes map[string][]string `json:"hostNodes,omitempty"`
+}
+
 // PlacementGroupReference describes a resoruce reference setting for an Placement Group
 type PlacementGroupReference struct {
 	// Name
@@ -1336,3 +1380,68 @@ type VirtualMachineHostNodeName struct {
 type VirtualMachineHostNodeIpAddress struct {
 	HostNodeIpAddress *string `json:"hostNodeIpAddress ,omitempty"`
 }
+
+// VirtualMachinePowerState enumerates the power states of a virtual machine.
+type VirtualMachinePowerState string
+
+const (
+	PowerStateUnknown  VirtualMachinePowerState = "Unknown"
+	PowerStateRunning  VirtualMachinePowerState = "Running"
+	PowerStateStopped  VirtualMachinePowerState = "Stopped"
+	PowerStatePaused   VirtualMachinePowerState = "Paused"
+	PowerStateSaved    VirtualMachinePowerState = "Saved"
+	PowerStateStarting VirtualMachinePowerState = "Starting"
+	PowerStateStopping VirtualMachinePowerState = "Stopping"
+)
+
+// GuestAgentHealthState summarizes the statuses reported by the guest agent.
+type GuestAgentHealthState string
+
+const (
+	GuestAgentHealthUnknown   GuestAgentHealthState = "Unknown"
+	GuestAgentHealthHealthy   GuestAgentHealthState = "Healthy"
+	GuestAgentHealthDegraded  GuestAgentHealthState = "Degraded"
+	GuestAgentHealthUnhealthy GuestAgentHealthState = "Unhealthy"
+)
+
+// DiskInstanceView describes the runtime state of a disk attached to a virtual machine
+type DiskInstanceView struct {
+	// Name - Name of the virtual hard disk
+	Name *string `json:"name,omitempty"`
+	// ProvisioningState - READ-ONLY; The provisioning state of the disk
+	ProvisioningState *string `json:"provisioningState,omitempty"`
+	// Statuses - READ-ONLY; The statuses reported for the disk
+	Statuses map[string]*string `json:"statuses"`
+}
+
+// NetworkInterfaceInstanceView describes the runtime state of a network interface attached to a virtual machine
+type NetworkInterfaceInstanceView struct {
+	// Name - Name of the network interface
+	Name *string `json:"name,omitempty"`
+	// ProvisioningState - READ-ONLY; The provisioning state of the network interface
+	ProvisioningState *string `json:"provisioningState,omitempty"`
+	// PrivateIPAddresses - READ-ONLY; The private IP addresses assigned to the network interface
+	PrivateIPAddresses []string `json:"privateIPAddresses,omitempty"`
+	// Statuses - READ-ONLY; The statuses reported for the network interface
+	Statuses map[string]*string `json:"statuses"`
+}
+
+// VirtualMachineInstanceView describes the runtime state of a virtual machine
+type VirtualMachineInstanceView struct {
+	// PowerState - READ-ONLY; The power state of the virtual machine
+	PowerState VirtualMachinePowerState `json:"powerState,omitempty"`
+	// ProvisioningState - READ-ONLY; The provisioning state of the virtual machine
+	ProvisioningState *string `json:"provisioningState,omitempty"`
+	// HostNodeName - READ-ONLY; The node hosting the virtual machine
+	HostNodeName *string `json:"hostNodeName,omitempty"`
+	// Uptime - READ-ONLY; Approximate time since the guest agent first reported, only set while running
+	Uptime *time.Duration `json:"uptime,omitempty"`
+	// GuestAgentHealth - READ-ONLY; Summary of the guest agent statuses
+	GuestAgentHealth GuestAgentHealthState `json:"guestAgentHealth,omitempty"`
+	// GuestAgentInstanceView - READ-ONLY; The info of the Agent running on the virtual machine
+	GuestAgentInstanceView *GuestAgentInstanceView `json:"guestAgentInstanceView,omitempty"`
+	// Disks - READ-ONLY; The state of the OS disk and data disks
+	Disks []DiskInstanceView `json:"disks,omitempty"`
+	// NetworkInterfaces - READ-ONLY; The state of the network interfaces
+	NetworkInterfaces []NetworkInterfaceInstanceView `json:"networkInterfaces,omitempty"`
+}
@@ -6,7 +6,10 @@ package galleryimage
 import (
 	"context"
 	"encoding/json"
+	"sync"
 
+	"github.com/microsoft/moc-sdk-for-go/services/cloud"
+	"github.com/microsoft/moc-sdk-for-go/services/cloud/group"
 	"github.com/microsoft/moc-sdk-for-go/services/compute"
 	"github.com/microsoft/moc/pkg/auth"
 	"github.com/microsoft/moc/rpc/common"
@@ -23,7 +26,18 @@ type Service interface {
 // Client structure
 type GalleryImageClient struct {
 	compute.BaseClient
-	internal Service
+	internal   Service
+	cloudFQDN  string
+	authorizer auth.Authorizer
+	// groups is created on first use by getGroups
+	groups     groupService
+	groupsLock sync.Mutex
+}
+
+// groupService is the part of the group client used to keep the deprecation state of image versions
+type groupService interface {
+	Get(context.Context, string, string) (*[]cloud.Group, error)
+	CreateOrUpdate(context.Context, string, string, *cloud.Group) (*cloud.Group, error)
 }
 
 // NewClient method returns new client
@@ -33,7 +47,21 @@ func NewGalleryImageClient(cloudFQDN string, authorizer auth.Authorizer) (*Galle
 		return nil, err
 	}
 
-	return &GalleryImageClient{internal: c}, nil
+	return &GalleryImageClient{internal: c, cloudFQDN: cloudFQDN, authorizer: authorizer}, nil
+}
+
+// getGroups returns the group client, which is created on first use
+func (c *GalleryImageClient) getGroups() (groupService, error) {
+	c.groupsLock.Lock()
+	defer c.groupsLock.Unlock()
+	if c.groups == nil {
+		groups, err := group.NewGroupClient(c.cloudFQDN, c.authorizer)
+		if err != nil {
+			return nil, err
+		}
+		c.groups = groups
+	}
+	return c.groups, nil
 }
 
 // Get methods invokes the client Get method
@@ -70,39 +98,38 @@ func (c *GalleryImageClient) UploadImageFromLocal(ctx context.Context, location,
 
 // UploadImageFromSFS   methods invokes  UploadImageFromSFS  on the client
 func (c *GalleryImageClient) UploadImageFromSFS(ctx context.Context, location, name string, galImage *compute.GalleryImage, sfsImg *compute.SFSImageProperties) (*compute.GalleryImage, error) {
-	// convert sfsImg struct to json string and use it as image-path
-	data, err := json.Marshal(sfsImg)
+	imagePath, err := getSourcePath(galImage, common.ImageSource_SFS_SOURCE, sfsImg)
 	if err != nil {
 		return nil, err
 	}
-	// update galImage with SourceType
-	if galImage != nil && galImage.GalleryImageProperties != nil {
-		galImage.SourceType = common.ImageSource_SFS_SOURCE
-	}
-
-	return c.internal.CreateOrUpdate(ctx, location, string(data), name, galImage)
+	return c.internal.CreateOrUpdate(ctx, location, imagePath, name, galImage)
 }
 
 func (c *GalleryImageClient) UploadImageFromHttp(ctx context.Context, location, name string, galImage *compute.GalleryImage, azHttpImg *compute.AzureGalleryImageProperties) (*compute.GalleryImage, error) {
-	// convert httpImg struct to json string and use it as image-path
-	data, err := json.Marshal(azHttpImg)
+	imagePath, err := getSourcePath(galImage, common.ImageSource_HTTP_SOURCE, azHttpImg)
 	if err != nil {
 		return nil, err
 	}
-	if galImage != nil && galImage.GalleryImageProperties != nil {
-		galImage.SourceType = common.ImageSource_HTTP_SOURCE
-	}
-	return c.internal.CreateOrUpdate(ctx, location, string(data), name, galImage)
+	return c.internal.CreateOrUpdate(ctx, location, imagePath, name, galImage)
 }
 
 // UploadImageFromAzureStorageBlob provisions an image via Azure Storage Blob download
 func (c *GalleryImageClient) UploadImageFromAzureStorageBlob(ctx context.Context, location, name string, galImage *compute.GalleryImage, blobImg *compute.AzureBlobImageProperties) (*compute.GalleryImage, error) {
-	data, err := json.Marshal(blobImg)
+	imagePath, err := getSourcePath(galImage, common.ImageSource_AZURESTORAGEBLOB_SOURCE, blobImg)
 	if err != nil {
 		return nil, err
 	}
+	return c.internal.CreateOrUpdate(ctx, location, imagePath, name, galImage)
+}
+
+// getSourcePath converts the source struct to the json string used as image-path and sets the source type of galImage
+func getSourcePath(galImage *compute.GalleryImage, sourceType common.ImageSource, source interface{}) (string, error) {
+	data, err := json.Marshal(source)
+	if err != nil {
+		return "", err
+	}
 	if galImage != nil && galImage.GalleryImageProperties != nil {
-		galImage.SourceType = common.ImageSource_AZURESTORAGEBLOB_SOURCE
+		galImage.SourceType = sourceType
 	}
-	return c.internal.CreateOrUpdate(ctx, location, string(data), name, galImage)
+	return string(data), nil
 }
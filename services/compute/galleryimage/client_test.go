@@ -25,12 +25,13 @@ type fakeInternal struct {
 
 	createOrUpdateResp *compute.GalleryImage
 	createOrUpdateErr  error
+	getResp            *[]compute.GalleryImage
 }
 
 var _ Service = (*fakeInternal)(nil)
 
 func (f *fakeInternal) Get(ctx context.Context, location, name string) (*[]compute.GalleryImage, error) {
-	return nil, nil
+	return f.getResp, nil
 }
 
 func (f *fakeInternal) CreateOrUpdate(ctx context.Context, location, imagePath, name string, img *compute.GalleryImage) (*compute.GalleryImage, error) {
@@ -59,12 +59,20 @@ func getGalleryImage(c *wssdcloudcompute.GalleryImage, location string) *compute
 		ID:      &c.Id,
 		Version: &c.Status.Version.Number,
 		GalleryImageProperties: &compute.GalleryImageProperties{
-			Statuses:         status.GetStatuses(c.GetStatus()),
-			ContainerName:    &c.ContainerName,
-			HyperVGeneration: c.HyperVGeneration,
+			Statuses:            status.GetStatuses(c.GetStatus()),
+			ContainerName:       &c.ContainerName,
+			HyperVGeneration:    c.HyperVGeneration,
+			CloudInitDataSource: c.CloudInitDataSource,
 		},
 		Tags: tags.ProtoToMap(c.Tags),
 	}
+	if download := c.GetStatus().GetDownloadStatus(); download != nil {
+		galleryImg.TransferStatus = &compute.GalleryImageTransferStatus{
+			ProgressPercentage: &download.ProgressPercentage,
+			TransferredBytes:   &download.DownloadSizeInBytes,
+			TotalBytes:         &download.FileSize,
+		}
+	}
 	switch c.ImageOSType {
 	case wssdcloudcompute.GalleryImageOSType_WINDOWS:
 		galleryImg.OsType = compute.Windows
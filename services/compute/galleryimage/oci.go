@@ -0,0 +1,377 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the Apache v2.0 License.
+
+package galleryimage
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/microsoft/moc-sdk-for-go/services/compute"
+	"github.com/microsoft/moc/pkg/errors"
+)
+
+const (
+	ociManifestMediaType    = "application/vnd.oci.image.manifest.v1+json"
+	dockerManifestMediaType = "application/vnd.docker.distribution.manifest.v2+json"
+	ociTitleAnnotation      = "org.opencontainers.image.title"
+	// Manifests are small; anything larger is not an image manifest
+	maxManifestSize = 4 * 1024 * 1024
+)
+
+type ociDescriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+type ociManifest struct {
+	SchemaVersion int             `json:"schemaVersion"`
+	MediaType     string          `json:"mediaType,omitempty"`
+	Layers        []ociDescriptor `json:"layers"`
+}
+
+// ociRegistry pulls from the OCI distribution API of a registry
+type ociRegistry struct {
+	client     *http.Client
+	baseURL    string
+	repository string
+	username   string
+	password   string
+	token      string
+	// basic is set once the registry answered with a Basic challenge
+	basic bool
+}
+
+// ociHTTPClient pulls the artifacts of UploadImageFromOCI
+var ociHTTPClient = http.DefaultClient
+
+// UploadImageFromOCI pulls the VHD or VHDX layer of an OCI artifact, verifies the manifest and layer
+// digests, stages the layer in StagingDirectory and uploads it with UploadImageFromLocal. The cloud agent
+// reads the staged layer from its path, so StagingDirectory must be readable by the agent at the same path.
+func (c *GalleryImageClient) UploadImageFromOCI(ctx context.Context, location, name string, galImage *compute.GalleryImage, ociImg *compute.OCIImageProperties) (*compute.GalleryImage, error) {
+	imagePath, err := pullOCIImage(ctx, ociHTTPClient, ociImg)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(imagePath)
+
+	return c.UploadImageFromLocal(ctx, location, imagePath, name, galImage)
+}
+
+// pullOCIImage stages the image layer of the artifact and returns its path
+func pullOCIImage(ctx context.Context, client *http.Client, ociImg *compute.OCIImageProperties) (string, error) {
+	if err := validateOCIImage(ociImg); err != nil {
+		return "", err
+	}
+	scheme := "https"
+	if ociImg.PlainHTTP {
+		scheme = "http"
+	}
+	registry := &ociRegistry{
+		client:     client,
+		baseURL:    fmt.Sprintf("%s://%s/v2/%s", scheme, ociImg.Registry, ociImg.Repository),
+		repository: ociImg.Repository,
+		username:   ociImg.Username,
+		password:   ociImg.Password,
+	}
+
+	expected := ociImg.ManifestDigest
+	if isDigest(ociImg.Reference) {
+		if len(expected) > 0 && expected != ociImg.Reference {
+			return "", errors.Wrapf(errors.InvalidInput, "Manifest digest [%s] does not match reference [%s]", expected, ociImg.Reference)
+		}
+		expected = ociImg.Reference
+	}
+	manifest, err := registry.getManifest(ctx, ociImg.Reference, expected)
+	if err != nil {
+		return "", err
+	}
+	layer, err := getImageLayer(manifest)
+	if err != nil {
+		return "", err
+	}
+
+	return registry.stageBlob(ctx, layer, ociImg.StagingDirectory)
+}
+
+func validateOCIImage(ociImg *compute.OCIImageProperties) error {
+	if ociImg == nil {
+		return errors.Wrapf(errors.InvalidInput, "OCI image not specified")
+	}
+	if len(ociImg.Registry) == 0 {
+		return errors.Wrapf(errors.InvalidInput, "OCI registry not specified")
+	}
+	if len(ociImg.Repository) == 0 {
+		return errors.Wrapf(errors.InvalidInput, "OCI repository not specified")
+	}
+	if len(ociImg.Reference) == 0 {
+		return errors.Wrapf(errors.InvalidInput, "OCI reference not specified")
+	}
+	if !isDigest(ociImg.Reference) && len(ociImg.ManifestDigest) == 0 {
+		return errors.Wrapf(errors.InvalidInput, "Manifest digest is required to pull tag [%s]", ociImg.Reference)
+	}
+	if len(ociImg.ManifestDigest) > 0 && !isDigest(ociImg.ManifestDigest) {
+		return errors.Wrapf(errors.InvalidInput, "Manifest digest [%s] is not a sha256 digest", ociImg.ManifestDigest)
+	}
+	if len(ociImg.StagingDirectory) == 0 {
+		return errors.Wrapf(errors.InvalidInput, "OCI staging directory not specified")
+	}
+	if ociImg.PlainHTTP && len(ociImg.Username) > 0 {
+		return errors.Wrapf(errors.InvalidInput, "Credentials cannot be sent to registry [%s] over plain HTTP", ociImg.Registry)
+	}
+	return nil
+}
+
+// getManifest fetches the manifest and verifies it against the expected digest
+func (r *ociRegistry) getManifest(ctx context.Context, reference, expected string) (*ociManifest, error) {
+	response, err := r.get(ctx, r.baseURL+"/manifests/"+url.PathEscape(reference), ociManifestMediaType+", "+dockerManifestMediaType)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(response.Body, maxManifestSize+1))
+	if err != nil {
+		return nil, errors.Wrapf(errors.Failed, "Unable to read manifest [%s]: %v", reference, err)
+	}
+	if len(data) > maxManifestSize {
+		return nil, errors.Wrapf(errors.InvalidInput, "Manifest [%s] is larger than %d bytes", reference, maxManifestSize)
+	}
+	actual := getDigest(data)
+	if actual != expected {
+		return nil, errors.Wrapf(errors.InvalidInput, "Digest of manifest [%s] is %s, expected %s", reference, actual, expected)
+	}
+
+	manifest := &ociManifest{}
+	if err := json.Unmarshal(data, manifest); err != nil {
+		return nil, errors.Wrapf(errors.InvalidInput, "Unable to parse manifest [%s]: %v", reference, err)
+	}
+	return manifest, nil
+}
+
+// stageBlob downloads the blob to directory, verifying its size and digest
+func (r *ociRegistry) stageBlob(ctx context.Context, layer *ociDescriptor, directory string) (string, error) {
+	response, err := r.get(ctx, r.baseURL+"/blobs/"+layer.Digest, "")
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+
+	file, err := os.CreateTemp(directory, "oci-*"+getLayerExtension(layer))
+	if err != nil {
+		return "", errors.Wrapf(errors.Failed, "Unable to stage layer [%s]: %v", layer.Digest, err)
+	}
+	path := file.Name()
+
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(file, hash), io.LimitReader(response.Body, layer.Size+1))
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && size != layer.Size {
+		err = errors.Wrapf(errors.InvalidInput, "Layer [%s] is %d bytes, expected %d", layer.Digest, size, layer.Size)
+	}
+	if actual := "sha256:" + hex.EncodeToString(hash.Sum(nil)); err == nil && actual != layer.Digest {
+		err = errors.Wrapf(errors.InvalidInput, "Digest of layer is %s, expected %s", actual, layer.Digest)
+	}
+	if err != nil {
+		os.Remove(path)
+		return "", err
+	}
+	return path, nil
+}
+
+// get issues the request, authenticating with a bearer token or basic credentials when the registry asks for it
+func (r *ociRegistry) get(ctx context.Context, target, accept string) (*http.Response, error) {
+	response, err := r.do(ctx, target, accept)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode == http.StatusUnauthorized && len(r.token) == 0 && !r.basic {
+		challenge := response.Header.Get("WWW-Authenticate")
+		response.Body.Close()
+		if err := r.authenticate(ctx, challenge); err != nil {
+			return nil, err
+		}
+		if response, err = r.do(ctx, target, accept); err != nil {
+			return nil, err
+		}
+	}
+	if response.StatusCode != http.StatusOK {
+		response.Body.Close()
+		if response.StatusCode == http.StatusNotFound {
+			return nil, errors.Wrapf(errors.NotFound, "[%s] not found in repository [%s]", target, r.repository)
+		}
+		return nil, errors.Wrapf(errors.Failed, "Registry returned %s for [%s]", response.Status, target)
+	}
+	return response, nil
+}
+
+func (r *ociRegistry) do(ctx context.Context, target, accept string) (*http.Response, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(accept) > 0 {
+		request.Header.Set("Accept", accept)
+	}
+	if len(r.token) > 0 {
+		request.Header.Set("Authorization", "Bearer "+r.token)
+	} else if r.basic {
+		request.SetBasicAuth(r.username, r.password)
+	}
+	return r.client.Do(request)
+}
+
+// authenticate answers the challenge of the registry. A Basic challenge has the credentials sent with the
+// next requests, and a Bearer challenge obtains a token from the realm, with the credentials if any.
+func (r *ociRegistry) authenticate(ctx context.Context, challenge string) error {
+	scheme, params := parseChallenge(challenge)
+	if strings.EqualFold(scheme, "Basic") {
+		if len(r.username) == 0 {
+			return errors.Wrapf(errors.Failed, "Registry requires credentials for repository [%s]", r.repository)
+		}
+		r.basic = true
+		return nil
+	}
+	if !strings.EqualFold(scheme, "Bearer") || len(params["realm"]) == 0 {
+		return errors.Wrapf(errors.Failed, "Unsupported authentication challenge [%s] for repository [%s]", challenge, r.repository)
+	}
+
+	realm, err := url.Parse(params["realm"])
+	if err != nil {
+		return errors.Wrapf(errors.Failed, "Invalid token realm [%s]: %v", params["realm"], err)
+	}
+	if len(r.username) > 0 && !strings.EqualFold(realm.Scheme, "https") {
+		return errors.Wrapf(errors.Failed, "Credentials cannot be sent to token realm [%s] over plain HTTP", realm.Redacted())
+	}
+	query := realm.Query()
+	if service := params["service"]; len(service) > 0 {
+		query.Set("service", service)
+	}
+	scope := params["scope"]
+	if len(scope) == 0 {
+		scope = "repository:" + r.repository + ":pull"
+	}
+	query.Set("scope", scope)
+	realm.RawQuery = query.Encode()
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, realm.String(), nil)
+	if err != nil {
+		return err
+	}
+	if len(r.username) > 0 {
+		request.SetBasicAuth(r.username, r.password)
+	}
+	response, err := r.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return errors.Wrapf(errors.Failed, "Token service returned %s for repository [%s]", response.Status, r.repository)
+	}
+
+	token := struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}{}
+	if err := json.NewDecoder(response.Body).Decode(&token); err != nil {
+		return errors.Wrapf(errors.Failed, "Unable to parse token response: %v", err)
+	}
+	r.token = token.Token
+	if len(r.token) == 0 {
+		r.token = token.AccessToken
+	}
+	if len(r.token) == 0 {
+		return errors.Wrapf(errors.Failed, "Token service returned no token for repository [%s]", r.repository)
+	}
+	return nil
+}
+
+// parseChallenge splits a WWW-Authenticate header such as Bearer realm="...",service="..." into its scheme and parameters
+func parseChallenge(challenge string) (string, map[string]string) {
+	params := map[string]string{}
+	scheme, rest, _ := strings.Cut(strings.TrimSpace(challenge), " ")
+	for len(rest) > 0 {
+		rest = strings.TrimLeft(rest, " ,")
+		key, value, found := strings.Cut(rest, "=")
+		if !found {
+			break
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		if strings.HasPrefix(value, `"`) {
+			end := strings.Index(value[1:], `"`)
+			if end < 0 {
+				params[key] = value[1:]
+				break
+			}
+			params[key] = value[1 : end+1]
+			rest = value[end+2:]
+		} else {
+			params[key], rest, _ = strings.Cut(value, ",")
+		}
+	}
+	return scheme, params
+}
+
+// getImageLayer returns the VHD or VHDX layer of the manifest, or its only layer
+func getImageLayer(manifest *ociManifest) (*ociDescriptor, error) {
+	candidates := []*ociDescriptor{}
+	for i := range manifest.Layers {
+		if len(getLayerExtension(&manifest.Layers[i])) > 0 {
+			candidates = append(candidates, &manifest.Layers[i])
+		}
+	}
+	if len(candidates) == 0 && len(manifest.Layers) == 1 {
+		candidates = append(candidates, &manifest.Layers[0])
+	}
+	if len(candidates) != 1 {
+		return nil, errors.Wrapf(errors.InvalidInput, "Manifest has %d VHD or VHDX layers, expected 1", len(candidates))
+	}
+	if !isDigest(candidates[0].Digest) || candidates[0].Size <= 0 {
+		return nil, errors.Wrapf(errors.InvalidInput, "Layer [%s] has no valid sha256 digest or size", candidates[0].Digest)
+	}
+	return candidates[0], nil
+}
+
+// getLayerExtension returns .vhd or .vhdx if the title or media type of the layer names a virtual hard disk
+func getLayerExtension(layer *ociDescriptor) string {
+	extension := strings.ToLower(filepath.Ext(layer.Annotations[ociTitleAnnotation]))
+	if extension == ".vhd" || extension == ".vhdx" {
+		return extension
+	}
+	mediaType := strings.ToLower(layer.MediaType)
+	switch {
+	case strings.Contains(mediaType, "vhdx"):
+		return ".vhdx"
+	case strings.Contains(mediaType, "vhd"):
+		return ".vhd"
+	}
+	return ""
+}
+
+func getDigest(data []byte) string {
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+func isDigest(reference string) bool {
+	value, found := strings.CutPrefix(reference, "sha256:")
+	if !found || len(value) != sha256.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(value)
+	return err == nil
+}
@@ -0,0 +1,220 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the Apache v2.0 License.
+
+package galleryimage
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/microsoft/moc-sdk-for-go/services/compute"
+	"github.com/microsoft/moc/pkg/errors"
+	"github.com/microsoft/moc/rpc/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testRegistry serves a single artifact with a VHDX layer behind bearer token authentication
+type testRegistry struct {
+	server         *httptest.Server
+	manifest       []byte
+	manifestDigest string
+	layer          []byte
+}
+
+func newTestRegistry(t *testing.T, layer []byte) *testRegistry {
+	r := &testRegistry{layer: layer}
+	manifest := ociManifest{
+		SchemaVersion: 2,
+		MediaType:     ociManifestMediaType,
+		Layers: []ociDescriptor{
+			{MediaType: "application/vnd.oci.image.config.v1+json", Digest: getDigest([]byte("{}")), Size: 2},
+			{
+				MediaType:   "application/octet-stream",
+				Digest:      getDigest(layer),
+				Size:        int64(len(layer)),
+				Annotations: map[string]string{ociTitleAnnotation: "disk.vhdx"},
+			},
+		},
+	}
+	var err error
+	r.manifest, err = json.Marshal(manifest)
+	require.NoError(t, err)
+	r.manifestDigest = getDigest(r.manifest)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", func(w http.ResponseWriter, req *http.Request) {
+		user, password, ok := req.BasicAuth()
+		if !ok || user != "user" || password != "secret" || req.URL.Query().Get("scope") != "repository:images/ubuntu:pull" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"token": "pull-token"})
+	})
+	mux.HandleFunc("/v2/images/ubuntu/", func(w http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("Authorization") != "Bearer pull-token" {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="`+r.server.URL+`/token",service="test",scope="repository:images/ubuntu:pull"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch {
+		case req.URL.Path == "/v2/images/ubuntu/manifests/22.04" || req.URL.Path == "/v2/images/ubuntu/manifests/"+r.manifestDigest:
+			w.Header().Set("Content-Type", ociManifestMediaType)
+			w.Write(r.manifest)
+		case req.URL.Path == "/v2/images/ubuntu/blobs/"+getDigest(r.layer):
+			w.Write(r.layer)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+	r.server = httptest.NewTLSServer(mux)
+	t.Cleanup(r.server.Close)
+	return r
+}
+
+func (r *testRegistry) image(reference, digest string) *compute.OCIImageProperties {
+	return &compute.OCIImageProperties{
+		Registry:         strings.TrimPrefix(r.server.URL, "https://"),
+		Repository:       "images/ubuntu",
+		Reference:        reference,
+		ManifestDigest:   digest,
+		Username:         "user",
+		Password:         "secret",
+		StagingDirectory: os.TempDir(),
+	}
+}
+
+func Test_PullOCIImage(t *testing.T) {
+	registry := newTestRegistry(t, []byte("vhdx contents"))
+
+	path, err := pullOCIImage(context.Background(), registry.server.Client(), registry.image("22.04", registry.manifestDigest))
+	require.NoError(t, err)
+	defer os.Remove(path)
+	assert.True(t, strings.HasSuffix(path, ".vhdx"))
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "vhdx contents", string(data))
+
+	path, err = pullOCIImage(context.Background(), registry.server.Client(), registry.image(registry.manifestDigest, ""))
+	require.NoError(t, err)
+	os.Remove(path)
+}
+
+func Test_PullOCIImage_Rejected(t *testing.T) {
+	registry := newTestRegistry(t, []byte("vhdx contents"))
+	client := registry.server.Client()
+
+	// tags need a digest to verify against
+	_, err := pullOCIImage(context.Background(), client, registry.image("22.04", ""))
+	assert.Error(t, err)
+
+	_, err = pullOCIImage(context.Background(), client, registry.image("22.04", getDigest([]byte("other"))))
+	assert.Error(t, err)
+
+	image := registry.image("22.04", registry.manifestDigest)
+	image.Password = "wrong"
+	_, err = pullOCIImage(context.Background(), client, image)
+	assert.Error(t, err)
+
+	_, err = pullOCIImage(context.Background(), client, registry.image("missing", registry.manifestDigest))
+	assert.Error(t, err)
+
+	// credentials are never sent over plain HTTP
+	image = registry.image("22.04", registry.manifestDigest)
+	image.PlainHTTP = true
+	_, err = pullOCIImage(context.Background(), client, image)
+	assert.ErrorIs(t, err, errors.InvalidInput)
+
+	// the agent reads the staged layer, so the staging directory is not guessed
+	image = registry.image("22.04", registry.manifestDigest)
+	image.StagingDirectory = ""
+	_, err = pullOCIImage(context.Background(), client, image)
+	assert.ErrorIs(t, err, errors.InvalidInput)
+}
+
+func Test_PullOCIImage_Basic(t *testing.T) {
+	layer := []byte("vhdx contents")
+	manifest, err := json.Marshal(ociManifest{
+		SchemaVersion: 2,
+		MediaType:     ociManifestMediaType,
+		Layers:        []ociDescriptor{{MediaType: "application/octet-stream", Digest: getDigest(layer), Size: int64(len(layer))}},
+	})
+	require.NoError(t, err)
+	unauthenticated := 0
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		user, password, ok := req.BasicAuth()
+		if !ok {
+			unauthenticated++
+			w.Header().Set("WWW-Authenticate", `Basic realm="registry"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if user != "user" || password != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch req.URL.Path {
+		case "/v2/images/ubuntu/manifests/" + getDigest(manifest):
+			w.Write(manifest)
+		case "/v2/images/ubuntu/blobs/" + getDigest(layer):
+			w.Write(layer)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	image := &compute.OCIImageProperties{
+		Registry:         strings.TrimPrefix(server.URL, "https://"),
+		Repository:       "images/ubuntu",
+		Reference:        getDigest(manifest),
+		Username:         "user",
+		Password:         "secret",
+		StagingDirectory: os.TempDir(),
+	}
+
+	// the credentials are only sent once the registry asks for them
+	path, err := pullOCIImage(context.Background(), server.Client(), image)
+	require.NoError(t, err)
+	os.Remove(path)
+	assert.Equal(t, 1, unauthenticated)
+
+	image.Password = "wrong"
+	_, err = pullOCIImage(context.Background(), server.Client(), image)
+	assert.Error(t, err)
+
+	image.Username = ""
+	_, err = pullOCIImage(context.Background(), server.Client(), image)
+	assert.ErrorIs(t, err, errors.Failed)
+}
+
+func TestUploadImageFromOCI_UploadsStagedLayer(t *testing.T) {
+	registry := newTestRegistry(t, []byte("vhdx contents"))
+	fake := &fakeInternal{}
+	c := &GalleryImageClient{internal: fake}
+	galImage := &compute.GalleryImage{GalleryImageProperties: &compute.GalleryImageProperties{}}
+	ociHTTPClient = registry.server.Client()
+	defer func() { ociHTTPClient = http.DefaultClient }()
+
+	_, err := c.UploadImageFromOCI(context.Background(), "loc-1", "img-1", galImage, registry.image("22.04", registry.manifestDigest))
+	require.NoError(t, err)
+	require.Equal(t, 1, fake.callCount)
+	assert.Equal(t, common.ImageSource_LOCAL_SOURCE, fake.gotImage.SourceType)
+	assert.True(t, strings.HasSuffix(fake.gotImagePath, ".vhdx"))
+
+	// the staged layer is removed once uploaded
+	_, err = os.Stat(fake.gotImagePath)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func Test_ParseChallenge(t *testing.T) {
+	scheme, params := parseChallenge(`Bearer realm="https://auth.example.com/token",service="registry.example.com",scope="repository:a/b:pull"`)
+	assert.Equal(t, "Bearer", scheme)
+	assert.Equal(t, "https://auth.example.com/token", params["realm"])
+	assert.Equal(t, "registry.example.com", params["service"])
+	assert.Equal(t, "repository:a/b:pull", params["scope"])
+}
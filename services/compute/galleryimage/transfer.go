@@ -0,0 +1,353 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the Apache v2.0 License.
+
+package galleryimage
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/microsoft/moc-sdk-for-go/services/compute"
+	"github.com/microsoft/moc/pkg/errors"
+	"github.com/microsoft/moc/rpc/common"
+	"google.golang.org/grpc/codes"
+	grpcstatus "google.golang.org/grpc/status"
+)
+
+const (
+	defaultTransferPollInterval = 5 * time.Second
+	defaultMaxResumes           = 3
+)
+
+// TransferDirection tells which way the image bytes move
+type TransferDirection string
+
+const (
+	// TransferUpload - The image is copied from a path local to the agent
+	TransferUpload TransferDirection = "Upload"
+	// TransferDownload - The agent downloads the image from SFS, HTTP or Azure Storage Blob
+	TransferDownload TransferDirection = "Download"
+)
+
+// TransferOptions tunes the progress polling, resumption and verification of an image transfer
+type TransferOptions struct {
+	// PollInterval - How often the agent status is polled for progress. Defaults to 5 seconds.
+	PollInterval time.Duration
+	// MaxResumes - How many times the transfer is resumed after the agent becomes unavailable. Defaults to 3.
+	MaxResumes int
+	// DisableResume - Fail the transfer the first time the agent becomes unavailable
+	DisableResume bool
+	// SHA256 - Hex encoded digest the image must match. Only local images staged by the SDK can be verified.
+	SHA256 string
+	// StagedBySDK - The local image was written by the SDK host to a path the agent reads, such as a share or
+	// the node the SDK runs on, so the SDK can hash the file the agent uploads. Required with SHA256.
+	StagedBySDK bool
+	// OnProgress - Called whenever new progress is observed
+	OnProgress func(TransferProgress)
+}
+
+// TransferProgress is the last progress of an image transfer reported by the agent
+type TransferProgress struct {
+	// Direction
+	Direction TransferDirection
+	// TransferredBytes
+	TransferredBytes int64
+	// TotalBytes - Zero until the agent knows the size of the image
+	TotalBytes int64
+	// Percentage
+	Percentage int64
+	// ETA - Estimated time left, zero until it can be estimated
+	ETA time.Duration
+	// Resumes - Number of times the transfer was resumed after the agent became unavailable
+	Resumes int
+}
+
+// TransferHandle tracks an image transfer running in the background
+type TransferHandle struct {
+	client    *GalleryImageClient
+	location  string
+	imagePath string
+	name      string
+	image     *compute.GalleryImage
+	options   TransferOptions
+	cancel    context.CancelFunc
+	done      chan struct{}
+
+	mu        sync.Mutex
+	progress  TransferProgress
+	firstSeen time.Time
+	firstSize int64
+	result    *compute.GalleryImage
+	err       error
+}
+
+// BeginUploadImageFromLocal starts UploadImageFromLocal and returns a handle to track it. The agent reads
+// imagePath on the node, so the image is only verified against options.SHA256 when options.StagedBySDK
+// states that the SDK host reads the same file.
+func (c *GalleryImageClient) BeginUploadImageFromLocal(ctx context.Context, location, imagePath, name string, galImage *compute.GalleryImage, options TransferOptions) (*TransferHandle, error) {
+	if len(options.SHA256) > 0 {
+		if !options.StagedBySDK {
+			return nil, errors.Wrapf(errors.NotSupported, "SHA-256 verification is only supported for local images staged by the SDK")
+		}
+		if err := verifySHA256(imagePath, options.SHA256); err != nil {
+			return nil, err
+		}
+	}
+	if galImage != nil && galImage.GalleryImageProperties != nil {
+		galImage.SourceType = common.ImageSource_LOCAL_SOURCE
+	}
+	return c.beginTransfer(ctx, TransferUpload, location, imagePath, name, galImage, options)
+}
+
+// BeginUploadImageFromSFS starts UploadImageFromSFS and returns a handle to track it
+func (c *GalleryImageClient) BeginUploadImageFromSFS(ctx context.Context, location, name string, galImage *compute.GalleryImage, sfsImg *compute.SFSImageProperties, options TransferOptions) (*TransferHandle, error) {
+	return c.beginDownload(ctx, location, name, galImage, common.ImageSource_SFS_SOURCE, sfsImg, options)
+}
+
+// BeginUploadImageFromHttp starts UploadImageFromHttp and returns a handle to track it
+func (c *GalleryImageClient) BeginUploadImageFromHttp(ctx context.Context, location, name string, galImage *compute.GalleryImage, azHttpImg *compute.AzureGalleryImageProperties, options TransferOptions) (*TransferHandle, error) {
+	return c.beginDownload(ctx, location, name, galImage, common.ImageSource_HTTP_SOURCE, azHttpImg, options)
+}
+
+// BeginUploadImageFromAzureStorageBlob starts UploadImageFromAzureStorageBlob and returns a handle to track it
+func (c *GalleryImageClient) BeginUploadImageFromAzureStorageBlob(ctx context.Context, location, name string, galImage *compute.GalleryImage, blobImg *compute.AzureBlobImageProperties, options TransferOptions) (*TransferHandle, error) {
+	return c.beginDownload(ctx, location, name, galImage, common.ImageSource_AZURESTORAGEBLOB_SOURCE, blobImg, options)
+}
+
+func (c *GalleryImageClient) beginDownload(ctx context.Context, location, name string, galImage *compute.GalleryImage, sourceType common.ImageSource, source interface{}, options TransferOptions) (*TransferHandle, error) {
+	// The agent does not report the digest of what it downloaded
+	if len(options.SHA256) > 0 {
+		return nil, errors.Wrapf(errors.NotSupported, "SHA-256 verification is only supported for local images staged by the SDK")
+	}
+	imagePath, err := getSourcePath(galImage, sourceType, source)
+	if err != nil {
+		return nil, err
+	}
+	return c.beginTransfer(ctx, TransferDownload, location, imagePath, name, galImage, options)
+}
+
+func (c *GalleryImageClient) beginTransfer(ctx context.Context, direction TransferDirection, location, imagePath, name string, galImage *compute.GalleryImage, options TransferOptions) (*TransferHandle, error) {
+	if len(location) == 0 {
+		return nil, errors.Wrapf(errors.InvalidInput, "Location not specified")
+	}
+	if len(name) == 0 {
+		return nil, errors.Wrapf(errors.InvalidInput, "Gallery image name not specified")
+	}
+	if options.PollInterval < 0 || options.MaxResumes < 0 {
+		return nil, errors.Wrapf(errors.InvalidInput, "Transfer options cannot be negative")
+	}
+	if options.PollInterval == 0 {
+		options.PollInterval = defaultTransferPollInterval
+	}
+	if options.MaxResumes == 0 {
+		options.MaxResumes = defaultMaxResumes
+	}
+	if options.DisableResume {
+		options.MaxResumes = 0
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	h := &TransferHandle{
+		client:    c,
+		location:  location,
+		imagePath: imagePath,
+		name:      name,
+		image:     galImage,
+		options:   options,
+		cancel:    cancel,
+		done:      make(chan struct{}),
+		progress:  TransferProgress{Direction: direction},
+	}
+	go h.poll(ctx)
+	go h.run(ctx)
+	return h, nil
+}
+
+// Progress returns the last observed progress of the transfer
+func (h *TransferHandle) Progress() TransferProgress {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.progress
+}
+
+// Done is closed once the transfer has finished
+func (h *TransferHandle) Done() <-chan struct{} {
+	return h.done
+}
+
+// Wait blocks until the transfer has finished or ctx is done, and returns the gallery image
+func (h *TransferHandle) Wait(ctx context.Context) (*compute.GalleryImage, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-h.done:
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.result, h.err
+}
+
+// Cancel stops tracking and resuming the transfer. The agent may finish a request already in flight.
+func (h *TransferHandle) Cancel() {
+	h.cancel()
+}
+
+// run issues the request, and issues it again each time it fails because the agent became unavailable,
+// for example while the agent restarts
+func (h *TransferHandle) run(ctx context.Context) {
+	defer h.cancel()
+	for {
+		image, err := h.client.internal.CreateOrUpdate(ctx, h.location, h.imagePath, h.name, h.image)
+		if err == nil {
+			h.finish(image, nil)
+			return
+		}
+		resumes := h.Progress().Resumes
+		if !isAgentUnavailable(err) || resumes >= h.options.MaxResumes || ctx.Err() != nil {
+			h.finish(nil, errors.Wrapf(err, "Transfer of gallery image [%s] failed after %d resume(s)", h.name, resumes))
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			h.finish(nil, ctx.Err())
+			return
+		case <-time.After(h.options.PollInterval):
+		}
+
+		// The agent may have completed the transfer before it became unavailable
+		if image, err := h.find(ctx); err == nil && isTransferred(image) {
+			h.finish(image, nil)
+			return
+		}
+		h.mu.Lock()
+		h.progress.Resumes++
+		h.mu.Unlock()
+	}
+}
+
+// poll reads the progress of the transfer from the agent status until the transfer is done
+func (h *TransferHandle) poll(ctx context.Context) {
+	ticker := time.NewTicker(h.options.PollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		// errors are expected while the image is not created yet or the agent is restarting
+		image, err := h.find(ctx)
+		if err != nil || image == nil || image.GalleryImageProperties == nil || image.TransferStatus == nil {
+			continue
+		}
+		h.observe(image.TransferStatus, time.Now())
+	}
+}
+
+func (h *TransferHandle) find(ctx context.Context) (*compute.GalleryImage, error) {
+	images, err := h.client.internal.Get(ctx, h.location, h.name)
+	if err != nil {
+		return nil, err
+	}
+	if images == nil || len(*images) == 0 {
+		return nil, errors.Wrapf(errors.NotFound, "Gallery image [%s] not found", h.name)
+	}
+	return &(*images)[0], nil
+}
+
+// observe records the transfer status and estimates the time left from the average rate since it was first seen
+func (h *TransferHandle) observe(status *compute.GalleryImageTransferStatus, now time.Time) {
+	h.mu.Lock()
+	progress := h.progress
+	if status.ProgressPercentage != nil {
+		progress.Percentage = *status.ProgressPercentage
+	}
+	if status.TotalBytes != nil {
+		progress.TotalBytes = *status.TotalBytes
+	}
+	if status.TransferredBytes != nil {
+		progress.TransferredBytes = *status.TransferredBytes
+	}
+	if progress.TransferredBytes == 0 && progress.TotalBytes > 0 {
+		progress.TransferredBytes = progress.TotalBytes * progress.Percentage / 100
+	}
+
+	if h.firstSeen.IsZero() || progress.TransferredBytes < h.firstSize {
+		h.firstSeen = now
+		h.firstSize = progress.TransferredBytes
+	}
+	progress.ETA = estimateETA(progress.TransferredBytes-h.firstSize, progress.TotalBytes-progress.TransferredBytes, now.Sub(h.firstSeen))
+
+	changed := progress != h.progress
+	h.progress = progress
+	h.mu.Unlock()
+
+	if changed && h.options.OnProgress != nil {
+		h.options.OnProgress(progress)
+	}
+}
+
+func (h *TransferHandle) finish(image *compute.GalleryImage, err error) {
+	h.mu.Lock()
+	h.result = image
+	h.err = err
+	if err == nil {
+		if h.progress.TotalBytes > 0 {
+			h.progress.TransferredBytes = h.progress.TotalBytes
+		}
+		h.progress.Percentage = 100
+		h.progress.ETA = 0
+	}
+	h.mu.Unlock()
+	close(h.done)
+}
+
+// estimateETA returns the time needed for remaining bytes at the rate transferred bytes took over elapsed,
+// or zero if there is not enough data yet
+func estimateETA(transferred, remaining int64, elapsed time.Duration) time.Duration {
+	if transferred <= 0 || remaining <= 0 || elapsed <= 0 {
+		return 0
+	}
+	return time.Duration(float64(elapsed) * float64(remaining) / float64(transferred))
+}
+
+// isTransferred reports whether the agent has finished creating the gallery image
+func isTransferred(image *compute.GalleryImage) bool {
+	if image == nil || image.GalleryImageProperties == nil {
+		return false
+	}
+	state, ok := image.Statuses["ProvisionState"]
+	return ok && state != nil && strings.EqualFold(strings.TrimSpace(*state), "CREATED")
+}
+
+// isAgentUnavailable reports whether the request failed because the agent could not be reached
+func isAgentUnavailable(err error) bool {
+	s, ok := grpcstatus.FromError(err)
+	return ok && s.Code() == codes.Unavailable
+}
+
+// verifySHA256 compares the SHA-256 digest of the file at path with the hex encoded expected digest
+func verifySHA256(path, expected string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return errors.Wrapf(errors.InvalidInput, "Unable to open image [%s]: %v", path, err)
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return errors.Wrapf(errors.Failed, "Unable to read image [%s]: %v", path, err)
+	}
+	actual := hex.EncodeToString(hash.Sum(nil))
+	if !strings.EqualFold(actual, strings.TrimSpace(expected)) {
+		return errors.Wrapf(errors.InvalidInput, "SHA-256 of image [%s] is %s, expected %s", path, actual, expected)
+	}
+	return nil
+}
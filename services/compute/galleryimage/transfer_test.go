@@ -0,0 +1,89 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the Apache v2.0 License.
+
+package galleryimage
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/microsoft/moc-sdk-for-go/services/compute"
+	"github.com/microsoft/moc/pkg/errors"
+	"github.com/microsoft/moc/rpc/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_EstimateETA(t *testing.T) {
+	assert.Equal(t, time.Duration(0), estimateETA(0, 100, time.Minute))
+	assert.Equal(t, time.Duration(0), estimateETA(100, 0, time.Minute))
+	assert.Equal(t, 3*time.Minute, estimateETA(100, 300, time.Minute))
+}
+
+func Test_Observe(t *testing.T) {
+	h := &TransferHandle{}
+	start := time.Now()
+	transferred, total := int64(100), int64(1000)
+	h.observe(&compute.GalleryImageTransferStatus{TransferredBytes: &transferred, TotalBytes: &total}, start)
+	assert.Equal(t, time.Duration(0), h.Progress().ETA)
+
+	transferred = 400
+	h.observe(&compute.GalleryImageTransferStatus{TransferredBytes: &transferred, TotalBytes: &total}, start.Add(time.Minute))
+	assert.Equal(t, int64(400), h.Progress().TransferredBytes)
+	assert.Equal(t, 2*time.Minute, h.Progress().ETA)
+}
+
+func Test_VerifySHA256(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "image.vhdx")
+	require.NoError(t, os.WriteFile(path, []byte("image"), 0600))
+
+	// sha256("image")
+	digest := "6105d6cc76af400325e94d588ce511be5bfdbb73b437dc51eca43917d7a43e3d"
+	assert.NoError(t, verifySHA256(path, digest))
+	assert.Error(t, verifySHA256(path, "00"+digest[2:]))
+	assert.Error(t, verifySHA256(filepath.Join(t.TempDir(), "missing"), digest))
+}
+
+func TestBeginUploadImageFromLocal_SHA256(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "image.vhdx")
+	require.NoError(t, os.WriteFile(path, []byte("image"), 0600))
+	digest := "6105d6cc76af400325e94d588ce511be5bfdbb73b437dc51eca43917d7a43e3d"
+	name := "img-1"
+	fake := &fakeInternal{createOrUpdateResp: &compute.GalleryImage{Name: &name}}
+	c := &GalleryImageClient{internal: fake}
+	galImage := &compute.GalleryImage{GalleryImageProperties: &compute.GalleryImageProperties{}}
+
+	// The agent reads the path on the node, so only images staged by the SDK are verified
+	_, err := c.BeginUploadImageFromLocal(context.Background(), "loc-1", path, name, galImage, TransferOptions{SHA256: digest})
+	assert.ErrorIs(t, err, errors.NotSupported)
+	_, err = c.BeginUploadImageFromLocal(context.Background(), "loc-1", path, name, galImage, TransferOptions{SHA256: "00" + digest[2:], StagedBySDK: true})
+	assert.ErrorIs(t, err, errors.InvalidInput)
+
+	h, err := c.BeginUploadImageFromLocal(context.Background(), "loc-1", path, name, galImage, TransferOptions{SHA256: digest, StagedBySDK: true})
+	require.NoError(t, err)
+	_, err = h.Wait(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, common.ImageSource_LOCAL_SOURCE, fake.gotImage.SourceType)
+}
+
+func TestBeginUploadImageFromAzureStorageBlob_WaitReturnsImage(t *testing.T) {
+	name := "img-1"
+	fake := &fakeInternal{createOrUpdateResp: &compute.GalleryImage{Name: &name}}
+	c := &GalleryImageClient{internal: fake}
+	galImage := &compute.GalleryImage{GalleryImageProperties: &compute.GalleryImageProperties{}}
+
+	h, err := c.BeginUploadImageFromAzureStorageBlob(context.Background(), "loc-1", name, galImage, &compute.AzureBlobImageProperties{}, TransferOptions{})
+	require.NoError(t, err)
+	image, err := h.Wait(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, name, *image.Name)
+	assert.Equal(t, int64(100), h.Progress().Percentage)
+	assert.Equal(t, TransferDownload, h.Progress().Direction)
+	assert.Equal(t, common.ImageSource_AZURESTORAGEBLOB_SOURCE, fake.gotImage.SourceType)
+
+	_, err = c.BeginUploadImageFromAzureStorageBlob(context.Background(), "loc-1", name, galImage, &compute.AzureBlobImageProperties{}, TransferOptions{SHA256: "00"})
+	assert.Error(t, err)
+}
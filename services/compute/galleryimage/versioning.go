@@ -0,0 +1,468 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the Apache v2.0 License.
+
+package galleryimage
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/microsoft/moc-sdk-for-go/services/cloud"
+	"github.com/microsoft/moc-sdk-for-go/services/compute"
+	"github.com/microsoft/moc/pkg/errors"
+)
+
+// Image versions are regular gallery images named <definition>-<version> and tagged with their definition
+// and version, since the agent has no notion of image definitions. Their deprecation state is kept in the
+// tags of LifecycleGroup, since the agent has no update of a stored gallery image that leaves its data alone.
+const (
+	// DefinitionTag - Tag holding the image definition of a version
+	DefinitionTag = "moc-image-definition"
+	// VersionTag - Tag holding the Major.Minor.Build version
+	VersionTag = "moc-image-version"
+	// DeprecatedTag - Tag set to "true" for deprecated versions
+	DeprecatedTag = "moc-image-deprecated"
+	// EndOfLifeTag - Tag holding the RFC 3339 end of life date of a version
+	EndOfLifeTag = "moc-image-end-of-life"
+	// LifecycleGroup - Resource group of each location holding the deprecation state of its versions, in
+	// DeprecatedTag/<name> and EndOfLifeTag/<name> tags
+	LifecycleGroup = "moc-image-lifecycle"
+
+	// LatestVersion - Image reference version resolving to the newest usable version
+	LatestVersion = "latest"
+)
+
+// ImageVersion is a published version of an image definition
+type ImageVersion struct {
+	// Definition
+	Definition string
+	// Version - Major.Minor.Build
+	Version string
+	// Name - Name of the gallery image holding the version
+	Name string
+	// Deprecated - New deployments should not use the version
+	Deprecated bool
+	// EndOfLife - The version cannot be deployed after this date
+	EndOfLife *time.Time
+	// Image
+	Image *compute.GalleryImage
+}
+
+// RetentionPolicy selects the versions of a definition that are garbage collected
+type RetentionPolicy struct {
+	// KeepLatest - Number of newest versions kept, older versions are deleted. Defaults to 3.
+	KeepLatest int
+	// DeleteDeprecated - Also delete deprecated versions among the newest
+	DeleteDeprecated bool
+	// DeleteExpired - Also delete versions past their end of life among the newest
+	DeleteExpired bool
+	// DryRun - Return the versions that would be deleted without deleting them
+	DryRun bool
+}
+
+const defaultKeepLatest = 3
+
+// maxLifecycleUpdateAttempts bounds the read-modify-write cycles of LifecycleGroup when the cloud agent
+// keeps reporting version conflicts
+const maxLifecycleUpdateAttempts = 10
+
+// lifecycleRetryDelay is the pause between attempts after a version conflict
+var lifecycleRetryDelay = 100 * time.Millisecond
+
+// GetImageVersionName returns the name of the gallery image holding the version of the definition
+func GetImageVersionName(definition, version string) string {
+	return definition + "-" + version
+}
+
+// ParseVersion parses a Major.Minor.Build version
+func ParseVersion(version string) ([3]int, error) {
+	parsed := [3]int{}
+	parts := strings.Split(version, ".")
+	if len(parts) != 3 {
+		return parsed, errors.Wrapf(errors.InvalidInput, "Version [%s] is not Major.Minor.Build", version)
+	}
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return parsed, errors.Wrapf(errors.InvalidInput, "Version [%s] is not Major.Minor.Build", version)
+		}
+		parsed[i] = n
+	}
+	return parsed, nil
+}
+
+// CompareVersions returns -1, 0 or 1 as a is older than, equal to or newer than b. Both must be valid.
+func CompareVersions(a, b string) int {
+	pa, _ := ParseVersion(a)
+	pb, _ := ParseVersion(b)
+	for i := range pa {
+		if pa[i] < pb[i] {
+			return -1
+		}
+		if pa[i] > pb[i] {
+			return 1
+		}
+	}
+	return 0
+}
+
+// GetImageVersion returns the version held by the gallery image, or false if the image is not a version
+func GetImageVersion(image *compute.GalleryImage) (*ImageVersion, bool) {
+	if image == nil || image.Tags == nil {
+		return nil, false
+	}
+	definition, version := image.Tags[DefinitionTag], image.Tags[VersionTag]
+	if definition == nil || version == nil {
+		return nil, false
+	}
+	if _, err := ParseVersion(*version); err != nil {
+		return nil, false
+	}
+
+	v := &ImageVersion{Definition: *definition, Version: *version, Image: image}
+	if image.Name != nil {
+		v.Name = *image.Name
+	}
+	if deprecated := image.Tags[DeprecatedTag]; deprecated != nil {
+		v.Deprecated, _ = strconv.ParseBool(*deprecated)
+	}
+	if eol := image.Tags[EndOfLifeTag]; eol != nil {
+		if t, err := time.Parse(time.RFC3339, *eol); err == nil {
+			v.EndOfLife = &t
+		}
+	}
+	return v, true
+}
+
+// IsExpired reports whether the version is past its end of life at now
+func (v *ImageVersion) IsExpired(now time.Time) bool {
+	return v.EndOfLife != nil && !now.Before(*v.EndOfLife)
+}
+
+// IsUsable reports whether new deployments may use the version at now
+func (v *ImageVersion) IsUsable(now time.Time) bool {
+	return !v.Deprecated && !v.IsExpired(now)
+}
+
+// PrepareImageVersion names and tags galImage as the version of the definition. It must then be uploaded
+// with any of the Upload methods, using the returned name.
+func PrepareImageVersion(definition, version string, galImage *compute.GalleryImage) (string, error) {
+	if len(definition) == 0 {
+		return "", errors.Wrapf(errors.InvalidInput, "Image definition not specified")
+	}
+	if strings.ContainsAny(definition, " /") {
+		return "", errors.Wrapf(errors.InvalidInput, "Image definition [%s] cannot contain spaces or slashes", definition)
+	}
+	if _, err := ParseVersion(version); err != nil {
+		return "", err
+	}
+	if galImage == nil {
+		return "", errors.Wrapf(errors.InvalidInput, "Gallery image not specified")
+	}
+
+	name := GetImageVersionName(definition, version)
+	galImage.Name = &name
+	if galImage.Tags == nil {
+		galImage.Tags = map[string]*string{}
+	}
+	galImage.Tags[DefinitionTag] = &definition
+	galImage.Tags[VersionTag] = &version
+	delete(galImage.Tags, DeprecatedTag)
+	delete(galImage.Tags, EndOfLifeTag)
+	return name, nil
+}
+
+// PublishVersion uploads the local image as a new version of the definition
+func (c *GalleryImageClient) PublishVersion(ctx context.Context, location, imagePath, definition, version string, galImage *compute.GalleryImage) (*compute.GalleryImage, error) {
+	name, err := PrepareImageVersion(definition, version, galImage)
+	if err != nil {
+		return nil, err
+	}
+	existing, err := c.findVersion(ctx, location, definition, version)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		return nil, errors.Wrapf(errors.AlreadyExists, "Version [%s] of image definition [%s] already exists", version, definition)
+	}
+	// A deleted version of the same name may have left its deprecation state behind
+	if err := c.updateLifecycle(ctx, location, func(lifecycle map[string]*string) bool {
+		return clearLifecycle(lifecycle, name)
+	}); err != nil {
+		return nil, err
+	}
+	return c.UploadImageFromLocal(ctx, location, imagePath, name, galImage)
+}
+
+// ListVersions returns the versions of the definition, newest first
+func (c *GalleryImageClient) ListVersions(ctx context.Context, location, definition string) ([]ImageVersion, error) {
+	images, err := c.Get(ctx, location, "")
+	if err != nil {
+		return nil, err
+	}
+	versions := []ImageVersion{}
+	if images == nil {
+		return versions, nil
+	}
+	lifecycle, err := c.getLifecycle(ctx, location)
+	if err != nil {
+		return nil, err
+	}
+	for i := range *images {
+		if v, ok := GetImageVersion(&(*images)[i]); ok && v.Definition == definition {
+			applyLifecycle(v, lifecycle)
+			versions = append(versions, *v)
+		}
+	}
+	sortVersions(versions)
+	return versions, nil
+}
+
+// GetLatestVersion returns the newest version of the definition that is neither deprecated nor expired
+func (c *GalleryImageClient) GetLatestVersion(ctx context.Context, location, definition string) (*ImageVersion, error) {
+	versions, err := c.ListVersions(ctx, location, definition)
+	if err != nil {
+		return nil, err
+	}
+	return getLatestVersion(definition, versions, time.Now())
+}
+
+// DeprecateVersion marks the version deprecated and returns it. endOfLife, if set, is when it stops being
+// deployable. The state is written to the tags of LifecycleGroup, so the stored image is left untouched.
+func (c *GalleryImageClient) DeprecateVersion(ctx context.Context, location, definition, version string, endOfLife *time.Time) (*ImageVersion, error) {
+	v, err := c.findVersion(ctx, location, definition, version)
+	if err != nil {
+		return nil, err
+	}
+	if v == nil {
+		return nil, errors.Wrapf(errors.NotFound, "Version [%s] of image definition [%s] not found", version, definition)
+	}
+
+	deprecated := "true"
+	var eol *string
+	if endOfLife != nil {
+		formatted := endOfLife.UTC().Format(time.RFC3339)
+		eol = &formatted
+	}
+	if err := c.updateLifecycle(ctx, location, func(lifecycle map[string]*string) bool {
+		clearLifecycle(lifecycle, v.Name)
+		lifecycle[DeprecatedTag+"/"+v.Name] = &deprecated
+		if eol != nil {
+			lifecycle[EndOfLifeTag+"/"+v.Name] = eol
+		}
+		return true
+	}); err != nil {
+		return nil, err
+	}
+
+	v.Deprecated = true
+	if endOfLife != nil {
+		t := endOfLife.UTC().Truncate(time.Second)
+		v.EndOfLife = &t
+	}
+	return v, nil
+}
+
+// getLifecycle returns the tags of the LifecycleGroup of the location, or nil if it does not exist
+func (c *GalleryImageClient) getLifecycle(ctx context.Context, location string) (map[string]*string, error) {
+	groups, err := c.getGroups()
+	if err != nil {
+		return nil, err
+	}
+	lifecycle, err := groups.Get(ctx, location, LifecycleGroup)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if lifecycle == nil || len(*lifecycle) == 0 {
+		return nil, nil
+	}
+	return (*lifecycle)[0].Tags, nil
+}
+
+// updateLifecycle applies modify to the tags of the LifecycleGroup of the location, creating the group on
+// first use. modify returns false to skip the update. The group is read again and modify reapplied when the
+// update fails on a version conflict.
+func (c *GalleryImageClient) updateLifecycle(ctx context.Context, location string, modify func(map[string]*string) bool) error {
+	groups, err := c.getGroups()
+	if err != nil {
+		return err
+	}
+	name := LifecycleGroup
+	for attempt := 1; ; attempt++ {
+		lifecycle := &cloud.Group{Name: &name, Location: &location}
+		existing, err := groups.Get(ctx, location, LifecycleGroup)
+		if err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+		if err == nil && existing != nil && len(*existing) > 0 {
+			lifecycle = &(*existing)[0]
+		}
+		if lifecycle.Tags == nil {
+			lifecycle.Tags = map[string]*string{}
+		}
+		if !modify(lifecycle.Tags) {
+			return nil
+		}
+
+		_, err = groups.CreateOrUpdate(ctx, location, LifecycleGroup, lifecycle)
+		if err == nil {
+			return nil
+		}
+		if !errors.IsInvalidVersion(err) || attempt >= maxLifecycleUpdateAttempts {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(lifecycleRetryDelay):
+		}
+	}
+}
+
+// clearLifecycle removes the deprecation state of the named version and reports whether there was any
+func clearLifecycle(lifecycle map[string]*string, name string) bool {
+	found := false
+	for _, key := range []string{DeprecatedTag + "/" + name, EndOfLifeTag + "/" + name} {
+		if _, ok := lifecycle[key]; ok {
+			delete(lifecycle, key)
+			found = true
+		}
+	}
+	return found
+}
+
+// applyLifecycle sets the deprecation state of the version from the tags of LifecycleGroup
+func applyLifecycle(v *ImageVersion, lifecycle map[string]*string) {
+	if deprecated := lifecycle[DeprecatedTag+"/"+v.Name]; deprecated != nil {
+		v.Deprecated, _ = strconv.ParseBool(*deprecated)
+	}
+	if eol := lifecycle[EndOfLifeTag+"/"+v.Name]; eol != nil {
+		if t, err := time.Parse(time.RFC3339, *eol); err == nil {
+			v.EndOfLife = &t
+		}
+	}
+}
+
+// ResolveImageReference points the reference at a concrete version when its Name is an image definition.
+// A nil, empty or "latest" Version resolves to the latest usable version. References to plain gallery
+// images are left untouched.
+func (c *GalleryImageClient) ResolveImageReference(ctx context.Context, location string, reference *compute.ImageReference) error {
+	if reference == nil || reference.Name == nil {
+		return errors.Wrapf(errors.InvalidInput, "Invalid Image Reference Name")
+	}
+	definition := *reference.Name
+	versions, err := c.ListVersions(ctx, location, definition)
+	if err != nil {
+		return err
+	}
+	if len(versions) == 0 {
+		return nil
+	}
+	v, err := resolveVersion(definition, reference.Version, versions, time.Now())
+	if err != nil {
+		return err
+	}
+	reference.Name = &v.Name
+	reference.Version = &v.Version
+	return nil
+}
+
+// ApplyRetentionPolicy deletes the versions of the definition selected by the policy and returns their names
+func (c *GalleryImageClient) ApplyRetentionPolicy(ctx context.Context, location, definition string, policy RetentionPolicy) ([]string, error) {
+	if policy.KeepLatest < 0 {
+		return nil, errors.Wrapf(errors.InvalidInput, "KeepLatest cannot be negative")
+	}
+	versions, err := c.ListVersions(ctx, location, definition)
+	if err != nil {
+		return nil, err
+	}
+
+	deleted := []string{}
+	for _, v := range selectForDeletion(versions, policy, time.Now()) {
+		if !policy.DryRun {
+			if err := c.Delete(ctx, location, v.Name); err != nil {
+				return deleted, errors.Wrapf(err, "Failed to delete version [%s] of image definition [%s]", v.Version, definition)
+			}
+		}
+		deleted = append(deleted, v.Name)
+	}
+	return deleted, nil
+}
+
+func (c *GalleryImageClient) findVersion(ctx context.Context, location, definition, version string) (*ImageVersion, error) {
+	versions, err := c.ListVersions(ctx, location, definition)
+	if err != nil {
+		return nil, err
+	}
+	for i := range versions {
+		if CompareVersions(versions[i].Version, version) == 0 {
+			return &versions[i], nil
+		}
+	}
+	return nil, nil
+}
+
+func sortVersions(versions []ImageVersion) {
+	sort.SliceStable(versions, func(i, j int) bool {
+		return CompareVersions(versions[i].Version, versions[j].Version) > 0
+	})
+}
+
+// getLatestVersion returns the first usable version of versions sorted newest first
+func getLatestVersion(definition string, versions []ImageVersion, now time.Time) (*ImageVersion, error) {
+	for i := range versions {
+		if versions[i].IsUsable(now) {
+			return &versions[i], nil
+		}
+	}
+	return nil, errors.Wrapf(errors.NotFound, "Image definition [%s] has no usable version", definition)
+}
+
+// resolveVersion returns the requested version of versions sorted newest first. Deprecated versions may still
+// be requested explicitly; expired versions may not.
+func resolveVersion(definition string, version *string, versions []ImageVersion, now time.Time) (*ImageVersion, error) {
+	if version == nil || len(*version) == 0 || strings.EqualFold(*version, LatestVersion) {
+		return getLatestVersion(definition, versions, now)
+	}
+	if _, err := ParseVersion(*version); err != nil {
+		return nil, err
+	}
+	for i := range versions {
+		if CompareVersions(versions[i].Version, *version) != 0 {
+			continue
+		}
+		if versions[i].IsExpired(now) {
+			return nil, errors.Wrapf(errors.InvalidInput, "Version [%s] of image definition [%s] reached its end of life on %s",
+				*version, definition, versions[i].EndOfLife.Format(time.RFC3339))
+		}
+		return &versions[i], nil
+	}
+	return nil, errors.Wrapf(errors.NotFound, "Version [%s] of image definition [%s] not found", *version, definition)
+}
+
+// selectForDeletion returns the versions, sorted newest first, that the policy removes. The latest usable
+// version is never removed so that "latest" keeps resolving.
+func selectForDeletion(versions []ImageVersion, policy RetentionPolicy, now time.Time) []ImageVersion {
+	keep := policy.KeepLatest
+	if keep == 0 {
+		keep = defaultKeepLatest
+	}
+	latest, _ := getLatestVersion("", versions, now)
+
+	selected := []ImageVersion{}
+	for i, v := range versions {
+		if latest != nil && v.Name == latest.Name {
+			continue
+		}
+		if i >= keep || (policy.DeleteExpired && v.IsExpired(now)) || (policy.DeleteDeprecated && v.Deprecated) {
+			selected = append(selected, v)
+		}
+	}
+	return selected
+}
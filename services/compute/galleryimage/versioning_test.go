@@ -0,0 +1,187 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the Apache v2.0 License.
+
+package galleryimage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/microsoft/moc-sdk-for-go/services/cloud"
+	"github.com/microsoft/moc-sdk-for-go/services/compute"
+	"github.com/microsoft/moc/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newVersion(version string, deprecated bool, endOfLife *time.Time) ImageVersion {
+	return ImageVersion{
+		Definition: "ubuntu",
+		Version:    version,
+		Name:       GetImageVersionName("ubuntu", version),
+		Deprecated: deprecated,
+		EndOfLife:  endOfLife,
+	}
+}
+
+func Test_CompareVersions(t *testing.T) {
+	assert.Equal(t, 1, CompareVersions("1.10.0", "1.9.0"))
+	assert.Equal(t, -1, CompareVersions("1.0.0", "2.0.0"))
+	assert.Equal(t, 0, CompareVersions("1.2.3", "1.2.3"))
+
+	_, err := ParseVersion("1.2")
+	assert.Error(t, err)
+	_, err = ParseVersion("1.a.3")
+	assert.Error(t, err)
+}
+
+func Test_PrepareImageVersion(t *testing.T) {
+	galImage := &compute.GalleryImage{}
+	name, err := PrepareImageVersion("ubuntu", "1.2.3", galImage)
+	require.NoError(t, err)
+	assert.Equal(t, "ubuntu-1.2.3", name)
+	// Version is the resource version of the agent, not the image version
+	assert.Nil(t, galImage.Version)
+
+	v, ok := GetImageVersion(galImage)
+	require.True(t, ok)
+	assert.Equal(t, "ubuntu", v.Definition)
+	assert.Equal(t, "1.2.3", v.Version)
+	assert.False(t, v.Deprecated)
+
+	_, err = PrepareImageVersion("ubuntu", "latest", galImage)
+	assert.Error(t, err)
+	_, ok = GetImageVersion(&compute.GalleryImage{})
+	assert.False(t, ok)
+}
+
+func Test_ResolveVersion(t *testing.T) {
+	now := time.Now()
+	past := now.Add(-time.Hour)
+	versions := []ImageVersion{
+		newVersion("1.3.0", true, nil),
+		newVersion("1.2.0", false, nil),
+		newVersion("1.1.0", true, &past),
+	}
+
+	v, err := resolveVersion("ubuntu", nil, versions, now)
+	require.NoError(t, err)
+	assert.Equal(t, "1.2.0", v.Version)
+
+	latest := LatestVersion
+	v, err = resolveVersion("ubuntu", &latest, versions, now)
+	require.NoError(t, err)
+	assert.Equal(t, "1.2.0", v.Version)
+
+	deprecated := "1.3.0"
+	v, err = resolveVersion("ubuntu", &deprecated, versions, now)
+	require.NoError(t, err)
+	assert.Equal(t, "ubuntu-1.3.0", v.Name)
+
+	expired := "1.1.0"
+	_, err = resolveVersion("ubuntu", &expired, versions, now)
+	assert.Error(t, err)
+
+	missing := "2.0.0"
+	_, err = resolveVersion("ubuntu", &missing, versions, now)
+	assert.Error(t, err)
+}
+
+func Test_SelectForDeletion(t *testing.T) {
+	now := time.Now()
+	past := now.Add(-time.Hour)
+	versions := []ImageVersion{
+		newVersion("1.4.0", true, &past),
+		newVersion("1.3.0", false, nil),
+		newVersion("1.2.0", true, nil),
+		newVersion("1.1.0", false, nil),
+	}
+
+	names := func(versions []ImageVersion) []string {
+		result := []string{}
+		for _, v := range versions {
+			result = append(result, v.Version)
+		}
+		return result
+	}
+
+	assert.Equal(t, []string{"1.1.0"}, names(selectForDeletion(versions, RetentionPolicy{}, now)))
+	assert.Equal(t, []string{"1.4.0", "1.1.0"}, names(selectForDeletion(versions, RetentionPolicy{DeleteExpired: true}, now)))
+	assert.Equal(t, []string{"1.4.0", "1.2.0", "1.1.0"}, names(selectForDeletion(versions, RetentionPolicy{DeleteDeprecated: true}, now)))
+
+	// the latest usable version is kept even beyond KeepLatest
+	assert.Equal(t, []string{"1.2.0", "1.1.0"}, names(selectForDeletion(versions, RetentionPolicy{KeepLatest: 1}, now)))
+}
+
+// fakeGroups stores the lifecycle group and fails the first conflicts updates with a version conflict
+type fakeGroups struct {
+	stored    *cloud.Group
+	conflicts int
+	updates   int
+}
+
+func (f *fakeGroups) Get(ctx context.Context, location, name string) (*[]cloud.Group, error) {
+	if f.stored == nil {
+		return nil, errors.Wrapf(errors.NotFound, "Group [%s] not found", name)
+	}
+	stored := *f.stored
+	stored.Tags = map[string]*string{}
+	for key, value := range f.stored.Tags {
+		stored.Tags[key] = value
+	}
+	return &[]cloud.Group{stored}, nil
+}
+
+func (f *fakeGroups) CreateOrUpdate(ctx context.Context, location, name string, g *cloud.Group) (*cloud.Group, error) {
+	f.updates++
+	if f.conflicts > 0 {
+		f.conflicts--
+		return nil, errors.Wrapf(errors.InvalidVersion, "Group [%s] changed", name)
+	}
+	f.stored = g
+	return g, nil
+}
+
+func Test_DeprecateVersion(t *testing.T) {
+	image := &compute.GalleryImage{GalleryImageProperties: &compute.GalleryImageProperties{}}
+	name, err := PrepareImageVersion("ubuntu", "1.2.3", image)
+	require.NoError(t, err)
+	fake := &fakeInternal{getResp: &[]compute.GalleryImage{*image}}
+	groups := &fakeGroups{conflicts: 1}
+	c := &GalleryImageClient{internal: fake, groups: groups}
+	lifecycleRetryDelay = 0
+
+	endOfLife := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+	v, err := c.DeprecateVersion(context.Background(), "location", "ubuntu", "1.2.3", &endOfLife)
+	require.NoError(t, err)
+	assert.True(t, v.Deprecated)
+	assert.Equal(t, endOfLife, *v.EndOfLife)
+
+	// the stored image is not posted again, the state goes to the lifecycle group after the conflict
+	assert.Equal(t, 0, fake.callCount)
+	assert.Equal(t, 2, groups.updates)
+	require.NotNil(t, groups.stored)
+	assert.Equal(t, LifecycleGroup, *groups.stored.Name)
+	assert.Equal(t, "location", *groups.stored.Location)
+	assert.Equal(t, "true", *groups.stored.Tags[DeprecatedTag+"/"+name])
+	assert.Equal(t, "2030-01-02T03:04:05Z", *groups.stored.Tags[EndOfLifeTag+"/"+name])
+
+	versions, err := c.ListVersions(context.Background(), "location", "ubuntu")
+	require.NoError(t, err)
+	require.Len(t, versions, 1)
+	assert.True(t, versions[0].Deprecated)
+	assert.Equal(t, endOfLife, *versions[0].EndOfLife)
+
+	_, err = c.DeprecateVersion(context.Background(), "location", "ubuntu", "9.9.9", nil)
+	assert.ErrorIs(t, err, errors.NotFound)
+
+	// publishing the version again after it was deleted starts without the stale state
+	fake.getResp = &[]compute.GalleryImage{}
+	_, err = c.PublishVersion(context.Background(), "location", "/images/ubuntu.vhdx", "ubuntu", "1.2.3", &compute.GalleryImage{GalleryImageProperties: &compute.GalleryImageProperties{}})
+	require.NoError(t, err)
+	assert.Nil(t, groups.stored.Tags[DeprecatedTag+"/"+name])
+	assert.Nil(t, groups.stored.Tags[EndOfLifeTag+"/"+name])
+}
+
+func strPtr(s string) *string { return &s }
@@ -0,0 +1,392 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the Apache v2.0 License.
+
+package placement
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/microsoft/moc-sdk-for-go/services/compute"
+)
+
+const (
+	ConstraintCapacity        = "Capacity"
+	ConstraintZone            = "Zone"
+	ConstraintPlacementGroup  = "PlacementGroup"
+	ConstraintAvailabilitySet = "AvailabilitySet"
+	ConstraintReference       = "Reference"
+	ConstraintPrecheck        = "Precheck"
+)
+
+// Node describes a host node and the capacity still available on it
+type Node struct {
+	// Name
+	Name string
+	// Zone - Zone the node belongs to, empty if the node is not zoned
+	Zone string
+	// CPUCount - Available virtual processors
+	CPUCount int32
+	// MemoryMB - Available memory
+	MemoryMB int64
+}
+
+// VirtualMachineRequest is a VM to place. CPUCount and MemoryMB default to the VM's size in the size
+// catalog, or its custom size; when neither is known, capacity is not checked for the VM.
+type VirtualMachineRequest struct {
+	// VirtualMachine
+	VirtualMachine *compute.VirtualMachine
+	// CPUCount
+	CPUCount int32
+	// MemoryMB
+	MemoryMB int64
+}
+
+// Request is the input of the planner
+type Request struct {
+	// VirtualMachines - Placed in order
+	VirtualMachines []VirtualMachineRequest
+	// AvailabilitySets - Availability sets referenced by the VMs
+	AvailabilitySets []*compute.AvailabilitySet
+	// PlacementGroups - Placement groups referenced by the VMs
+	PlacementGroups []*compute.PlacementGroup
+	// Nodes - Node inventory
+	Nodes []Node
+}
+
+// Violation explains why a VM could not be placed, or why a soft constraint was not met
+type Violation struct {
+	// VirtualMachine
+	VirtualMachine string
+	// Constraint - One of the Constraint* values
+	Constraint string
+	// Reason
+	Reason string
+}
+
+func (v Violation) String() string {
+	return fmt.Sprintf("%s: %s constraint: %s", v.VirtualMachine, v.Constraint, v.Reason)
+}
+
+// Plan is the computed placement
+type Plan struct {
+	// Assignments - VM name to node name
+	Assignments map[string]string
+	// FaultDomains - VM name to fault domain index, for VMs in an availability set
+	FaultDomains map[string]int
+	// Violations - Hard constraints that could not be met; the plan is infeasible if any are present
+	Violations []Violation
+	// Warnings - Soft constraints that could not be met
+	Warnings []Violation
+}
+
+// Feasible reports whether every VM was placed without violating a hard constraint
+func (p *Plan) Feasible() bool {
+	return len(p.Violations) == 0
+}
+
+// nodeFilter keeps the candidate nodes that satisfy a constraint
+type nodeFilter struct {
+	constraint string
+	hard       bool
+	reason     string
+	keep       func(node *Node) bool
+}
+
+type avsetState struct {
+	faultDomainCount int
+	members          int
+	faultDomainNodes map[int]string
+}
+
+type planner struct {
+	request    Request
+	plan       *Plan
+	nodes      []*Node
+	zones      map[string]string
+	avsets     map[string]*avsetState
+	pgroups    map[string]*compute.PlacementGroup
+	pgroupVMs  map[string][]string
+	placements map[string]string
+}
+
+// ComputePlacement places the requested VMs on the node inventory. VMs are placed greedily in
+// request order on the candidate node with the most free memory, so the result is deterministic.
+func ComputePlacement(request Request) *Plan {
+	p := &planner{
+		request:    request,
+		plan:       &Plan{Assignments: map[string]string{}, FaultDomains: map[string]int{}, Violations: []Violation{}, Warnings: []Violation{}},
+		zones:      map[string]string{},
+		avsets:     map[string]*avsetState{},
+		pgroups:    map[string]*compute.PlacementGroup{},
+		pgroupVMs:  map[string][]string{},
+		placements: map[string]string{},
+	}
+	for i := range request.Nodes {
+		node := request.Nodes[i]
+		p.nodes = append(p.nodes, &node)
+		p.zones[node.Name] = node.Zone
+	}
+	for _, avset := range request.AvailabilitySets {
+		if avset == nil || avset.Name == nil {
+			continue
+		}
+		count := 1
+		if avset.PlatformFaultDomainCount != nil && *avset.PlatformFaultDomainCount > 0 {
+			count = int(*avset.PlatformFaultDomainCount)
+		}
+		p.avsets[*avset.Name] = &avsetState{faultDomainCount: count, faultDomainNodes: map[int]string{}}
+	}
+	for _, pgroup := range request.PlacementGroups {
+		if pgroup != nil && pgroup.Name != nil {
+			p.pgroups[*pgroup.Name] = pgroup
+		}
+	}
+
+	for _, vmRequest := range request.VirtualMachines {
+		p.place(vmRequest)
+	}
+	return p.plan
+}
+
+func (p *planner) place(vmRequest VirtualMachineRequest) {
+	vm := vmRequest.VirtualMachine
+	if vm == nil || vm.Name == nil {
+		p.violate("", ConstraintReference, "virtual machine has no name")
+		return
+	}
+	name := *vm.Name
+	cpu, memory := getRequirements(vmRequest)
+
+	filters := []nodeFilter{{
+		constraint: ConstraintCapacity,
+		hard:       true,
+		reason:     fmt.Sprintf("no node has %d CPUs and %d MB of memory available", cpu, memory),
+		keep: func(node *Node) bool {
+			return node.CPUCount >= cpu && node.MemoryMB >= memory
+		},
+	}}
+
+	zoneFilter, ok := p.getZoneFilter(vm)
+	if ok {
+		filters = append(filters, zoneFilter)
+	}
+
+	pgroupFilters, pgroupName, err := p.getPlacementGroupFilters(vm)
+	if err != nil {
+		p.violate(name, ConstraintReference, err.Error())
+		return
+	}
+	filters = append(filters, pgroupFilters...)
+
+	avsetFilter, avset, faultDomain, err := p.getAvailabilitySetFilter(vm)
+	if err != nil {
+		p.violate(name, ConstraintReference, err.Error())
+		return
+	}
+	if avset != nil {
+		filters = append(filters, avsetFilter)
+	}
+
+	candidates := p.nodes
+	for _, filter := range filters {
+		kept := []*Node{}
+		for _, node := range candidates {
+			if filter.keep(node) {
+				kept = append(kept, node)
+			}
+		}
+		if len(kept) > 0 {
+			candidates = kept
+			continue
+		}
+		if filter.hard {
+			p.violate(name, filter.constraint, filter.reason)
+			return
+		}
+		p.plan.Warnings = append(p.plan.Warnings, Violation{VirtualMachine: name, Constraint: filter.constraint, Reason: filter.reason})
+	}
+	if len(candidates) == 0 {
+		p.violate(name, ConstraintCapacity, "node inventory is empty")
+		return
+	}
+
+	// spread by picking the node with the most free memory
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].MemoryMB != candidates[j].MemoryMB {
+			return candidates[i].MemoryMB > candidates[j].MemoryMB
+		}
+		return candidates[i].Name < candidates[j].Name
+	})
+	node := candidates[0]
+	node.CPUCount -= cpu
+	node.MemoryMB -= memory
+
+	p.plan.Assignments[name] = node.Name
+	p.placements[name] = node.Name
+	if len(pgroupName) > 0 {
+		p.pgroupVMs[pgroupName] = append(p.pgroupVMs[pgroupName], name)
+	}
+	if avset != nil {
+		avset.members++
+		avset.faultDomainNodes[faultDomain] = node.Name
+		p.plan.FaultDomains[name] = faultDomain
+	}
+}
+
+func (p *planner) violate(vmName, constraint, reason string) {
+	p.plan.Violations = append(p.plan.Violations, Violation{VirtualMachine: vmName, Constraint: constraint, Reason: reason})
+}
+
+func (p *planner) getZoneFilter(vm *compute.VirtualMachine) (nodeFilter, bool) {
+	if vm.VirtualMachineProperties == nil || vm.ZoneConfiguration == nil || vm.ZoneConfiguration.Zones == nil || len(*vm.ZoneConfiguration.Zones) == 0 {
+		return nodeFilter{}, false
+	}
+	zones := map[string]bool{}
+	names := []string{}
+	for _, zone := range *vm.ZoneConfiguration.Zones {
+		if zone.Name != nil {
+			zones[*zone.Name] = true
+			names = append(names, *zone.Name)
+		}
+	}
+	strict := vm.ZoneConfiguration.StrictPlacement != nil && *vm.ZoneConfiguration.StrictPlacement
+	return nodeFilter{
+		constraint: ConstraintZone,
+		hard:       strict,
+		reason:     fmt.Sprintf("no node with capacity in zones %v", names),
+		keep: func(node *Node) bool {
+			return zones[node.Zone]
+		},
+	}, true
+}
+
+func (p *planner) getPlacementGroupFilters(vm *compute.VirtualMachine) ([]nodeFilter, string, error) {
+	if vm.VirtualMachineProperties == nil || vm.PlacementGroupProfile == nil || vm.PlacementGroupProfile.Name == nil {
+		return nil, "", nil
+	}
+	name := *vm.PlacementGroupProfile.Name
+	pgroup, ok := p.pgroups[name]
+	if !ok {
+		return nil, "", fmt.Errorf("placement group [%s] is not part of the request", name)
+	}
+	if pgroup.PlacementGroupProperties == nil {
+		return nil, name, nil
+	}
+
+	filters := []nodeFilter{}
+	if pgroup.Zones != nil && len(*pgroup.Zones) > 0 {
+		zones := map[string]bool{}
+		for _, zone := range *pgroup.Zones {
+			zones[zone] = true
+		}
+		filters = append(filters, nodeFilter{
+			constraint: ConstraintPlacementGroup,
+			hard:       true,
+			reason:     fmt.Sprintf("no node with capacity in the zones %v of placement group [%s]", *pgroup.Zones, name),
+			keep: func(node *Node) bool {
+				return zones[node.Zone]
+			},
+		})
+	}
+
+	// domain is the node for a Server scope, and the zone of the node for a Zone scope
+	domain := func(nodeName string) string {
+		if pgroup.Scope == compute.ZoneScope {
+			return p.zones[nodeName]
+		}
+		return nodeName
+	}
+	used := map[string]bool{}
+	for _, member := range p.pgroupVMs[name] {
+		used[domain(p.placements[member])] = true
+	}
+	if len(used) == 0 {
+		return filters, name, nil
+	}
+
+	scope := pgroup.Scope
+	if len(scope) == 0 {
+		scope = compute.ServerScope
+	}
+	switch pgroup.Type {
+	case compute.Affinity:
+		filters = append(filters, nodeFilter{
+			constraint: ConstraintPlacementGroup,
+			hard:       pgroup.StrictPlacement,
+			reason:     fmt.Sprintf("affinity to the other members of placement group [%s] within the same %s cannot be met", name, scope),
+			keep: func(node *Node) bool {
+				return used[domain(node.Name)]
+			},
+		})
+	case compute.AntiAffinity, compute.StrictAntiAffinity:
+		filters = append(filters, nodeFilter{
+			constraint: ConstraintPlacementGroup,
+			hard:       pgroup.StrictPlacement || pgroup.Type == compute.StrictAntiAffinity,
+			reason:     fmt.Sprintf("no %s left that is free of other members of placement group [%s]", scope, name),
+			keep: func(node *Node) bool {
+				return !used[domain(node.Name)]
+			},
+		})
+	}
+	return filters, name, nil
+}
+
+// getAvailabilitySetFilter assigns the VM the next fault domain of its availability set. Each fault
+// domain is pinned to a single node, and no two fault domains share a node.
+func (p *planner) getAvailabilitySetFilter(vm *compute.VirtualMachine) (nodeFilter, *avsetState, int, error) {
+	if vm.VirtualMachineProperties == nil || vm.AvailabilitySetProfile == nil || vm.AvailabilitySetProfile.Name == nil {
+		return nodeFilter{}, nil, 0, nil
+	}
+	name := *vm.AvailabilitySetProfile.Name
+	avset, ok := p.avsets[name]
+	if !ok {
+		return nodeFilter{}, nil, 0, fmt.Errorf("availability set [%s] is not part of the request", name)
+	}
+
+	faultDomain := avset.members % avset.faultDomainCount
+	if pinned, ok := avset.faultDomainNodes[faultDomain]; ok {
+		return nodeFilter{
+			constraint: ConstraintAvailabilitySet,
+			hard:       true,
+			reason:     fmt.Sprintf("fault domain %d of availability set [%s] is on node [%s], which cannot host the VM", faultDomain, name, pinned),
+			keep: func(node *Node) bool {
+				return node.Name == pinned
+			},
+		}, avset, faultDomain, nil
+	}
+
+	taken := map[string]bool{}
+	for _, node := range avset.faultDomainNodes {
+		taken[node] = true
+	}
+	return nodeFilter{
+		constraint: ConstraintAvailabilitySet,
+		hard:       true,
+		reason:     fmt.Sprintf("availability set [%s] needs %d fault domains on distinct nodes, no free node is left for fault domain %d", name, avset.faultDomainCount, faultDomain),
+		keep: func(node *Node) bool {
+			return !taken[node.Name]
+		},
+	}, avset, faultDomain, nil
+}
+
+// getRequirements returns the CPU count and memory of the VM, taken from the size catalog for named and
+// custom sizes unless the request sets them
+func getRequirements(vmRequest VirtualMachineRequest) (int32, int64) {
+	cpu, memory := vmRequest.CPUCount, vmRequest.MemoryMB
+	vm := vmRequest.VirtualMachine
+	if (cpu != 0 && memory != 0) || vm == nil || vm.VirtualMachineProperties == nil {
+		return cpu, memory
+	}
+	hardware, ok := compute.GetVirtualMachineSizeCatalog().GetHardware(vm.HardwareProfile)
+	if !ok {
+		return cpu, memory
+	}
+	if cpu == 0 {
+		cpu = hardware.CPUCount
+	}
+	if memory == 0 {
+		memory = int64(hardware.MemoryMB)
+	}
+	return cpu, memory
+}
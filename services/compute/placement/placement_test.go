@@ -0,0 +1,187 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the Apache v2.0 License.
+
+package placement
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/microsoft/moc-sdk-for-go/services/compute"
+	"github.com/microsoft/moc/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newVMRequest(name string) VirtualMachineRequest {
+	return VirtualMachineRequest{
+		VirtualMachine: &compute.VirtualMachine{
+			Name:                     &name,
+			VirtualMachineProperties: &compute.VirtualMachineProperties{},
+		},
+		CPUCount: 2,
+		MemoryMB: 1024,
+	}
+}
+
+func newVMRequests(count int) []VirtualMachineRequest {
+	requests := []VirtualMachineRequest{}
+	for i := 0; i < count; i++ {
+		requests = append(requests, newVMRequest(fmt.Sprintf("vm%d", i)))
+	}
+	return requests
+}
+
+func newNodes() []Node {
+	return []Node{
+		{Name: "node1", Zone: "zone1", CPUCount: 16, MemoryMB: 16384},
+		{Name: "node2", Zone: "zone1", CPUCount: 16, MemoryMB: 16384},
+		{Name: "node3", Zone: "zone2", CPUCount: 16, MemoryMB: 16384},
+	}
+}
+
+func Test_ComputePlacementAvailabilitySet(t *testing.T) {
+	avsetName := "avset"
+	faultDomains := int32(2)
+	vms := newVMRequests(4)
+	for _, vm := range vms {
+		vm.VirtualMachine.AvailabilitySetProfile = &compute.AvailabilitySetReference{Name: &avsetName}
+	}
+
+	plan := ComputePlacement(Request{
+		VirtualMachines:  vms,
+		AvailabilitySets: []*compute.AvailabilitySet{{Name: &avsetName, PlatformFaultDomainCount: &faultDomains}},
+		Nodes:            newNodes(),
+	})
+
+	assert.True(t, plan.Feasible())
+	assert.Equal(t, map[string]int{"vm0": 0, "vm1": 1, "vm2": 0, "vm3": 1}, plan.FaultDomains)
+	assert.NotEqual(t, plan.Assignments["vm0"], plan.Assignments["vm1"])
+	assert.Equal(t, plan.Assignments["vm0"], plan.Assignments["vm2"])
+	assert.Equal(t, plan.Assignments["vm1"], plan.Assignments["vm3"])
+}
+
+func Test_ComputePlacementStrictAntiAffinity(t *testing.T) {
+	pgroupName := "pgroup"
+	vms := newVMRequests(4)
+	for _, vm := range vms {
+		vm.VirtualMachine.PlacementGroupProfile = &compute.PlacementGroupReference{Name: &pgroupName}
+	}
+
+	plan := ComputePlacement(Request{
+		VirtualMachines: vms,
+		PlacementGroups: []*compute.PlacementGroup{{
+			Name:                     &pgroupName,
+			PlacementGroupProperties: &compute.PlacementGroupProperties{Type: compute.StrictAntiAffinity, Scope: compute.ServerScope},
+		}},
+		Nodes: newNodes(),
+	})
+
+	assert.False(t, plan.Feasible())
+	assert.Len(t, plan.Assignments, 3)
+	assert.Len(t, plan.Violations, 1)
+	assert.Equal(t, "vm3", plan.Violations[0].VirtualMachine)
+	assert.Equal(t, ConstraintPlacementGroup, plan.Violations[0].Constraint)
+}
+
+func Test_ComputePlacementZoneAffinity(t *testing.T) {
+	pgroupName := "pgroup"
+	vms := newVMRequests(3)
+	for _, vm := range vms {
+		vm.VirtualMachine.PlacementGroupProfile = &compute.PlacementGroupReference{Name: &pgroupName}
+	}
+
+	plan := ComputePlacement(Request{
+		VirtualMachines: vms,
+		PlacementGroups: []*compute.PlacementGroup{{
+			Name:                     &pgroupName,
+			PlacementGroupProperties: &compute.PlacementGroupProperties{Type: compute.Affinity, Scope: compute.ZoneScope, StrictPlacement: true},
+		}},
+		Nodes: newNodes(),
+	})
+
+	assert.True(t, plan.Feasible())
+	zones := map[string]string{"node1": "zone1", "node2": "zone1", "node3": "zone2"}
+	zone := zones[plan.Assignments["vm0"]]
+	for _, node := range plan.Assignments {
+		assert.Equal(t, zone, zones[node])
+	}
+}
+
+func Test_ComputePlacementZonesAndCapacity(t *testing.T) {
+	zone := "zone3"
+	strict := false
+	vms := newVMRequests(2)
+	vms[0].VirtualMachine.ZoneConfiguration = &compute.ZoneConfiguration{
+		Zones:           &[]compute.Zone{{Name: &zone}},
+		StrictPlacement: &strict,
+	}
+	vms[1].MemoryMB = 32768
+
+	plan := ComputePlacement(Request{VirtualMachines: vms, Nodes: newNodes()})
+
+	assert.Contains(t, plan.Assignments, "vm0")
+	assert.Len(t, plan.Warnings, 1)
+	assert.Equal(t, ConstraintZone, plan.Warnings[0].Constraint)
+	assert.Len(t, plan.Violations, 1)
+	assert.Equal(t, ConstraintCapacity, plan.Violations[0].Constraint)
+
+	strict = true
+	plan = ComputePlacement(Request{VirtualMachines: vms[:1], Nodes: newNodes()})
+	assert.False(t, plan.Feasible())
+	assert.Equal(t, ConstraintZone, plan.Violations[0].Constraint)
+}
+
+func Test_ComputePlacementUnknownReference(t *testing.T) {
+	avsetName := "missing"
+	vms := newVMRequests(1)
+	vms[0].VirtualMachine.AvailabilitySetProfile = &compute.AvailabilitySetReference{Name: &avsetName}
+
+	plan := ComputePlacement(Request{VirtualMachines: vms, Nodes: newNodes()})
+
+	assert.False(t, plan.Feasible())
+	assert.Equal(t, ConstraintReference, plan.Violations[0].Constraint)
+}
+
+func Test_ComputePlacementNamedSize(t *testing.T) {
+	info, ok := compute.GetVirtualMachineSizeCatalog().Get(compute.VirtualMachineSizeTypesStandardD8sV3)
+	require.True(t, ok)
+	vms := []VirtualMachineRequest{newVMRequest("vm0")}
+	vms[0].CPUCount, vms[0].MemoryMB = 0, 0
+	vms[0].VirtualMachine.HardwareProfile = &compute.HardwareProfile{VMSize: compute.VirtualMachineSizeTypesStandardD8sV3}
+
+	small := []Node{{Name: "small", CPUCount: info.CPUCount - 1, MemoryMB: int64(info.MemoryMB)}}
+	plan := ComputePlacement(Request{VirtualMachines: vms, Nodes: small})
+	assert.False(t, plan.Feasible())
+	assert.Equal(t, ConstraintCapacity, plan.Violations[0].Constraint)
+
+	fitting := append(small, Node{Name: "fitting", CPUCount: info.CPUCount, MemoryMB: int64(info.MemoryMB)})
+	plan = ComputePlacement(Request{VirtualMachines: vms, Nodes: fitting})
+	assert.True(t, plan.Feasible())
+	assert.Equal(t, "fitting", plan.Assignments["vm0"])
+}
+
+// fakePrecheck answers prechecks with a fixed result
+type fakePrecheck struct {
+	ok  bool
+	err error
+}
+
+func (f *fakePrecheck) Precheck(ctx context.Context, group string, vms []*compute.VirtualMachine) (bool, error) {
+	return f.ok, f.err
+}
+
+func Test_Plan_Precheck(t *testing.T) {
+	request := Request{VirtualMachines: newVMRequests(2), Nodes: newNodes()}
+
+	planner := &Planner{vmclient: &fakePrecheck{ok: false}}
+	plan, err := planner.Plan(context.Background(), "group", request)
+	require.NoError(t, err)
+	assert.False(t, plan.Feasible())
+	assert.Equal(t, ConstraintPrecheck, plan.Violations[0].Constraint)
+
+	planner = &Planner{vmclient: &fakePrecheck{err: errors.Wrapf(errors.Failed, "agent unreachable")}}
+	_, err = planner.Plan(context.Background(), "group", request)
+	assert.ErrorIs(t, err, errors.Failed)
+}
@@ -0,0 +1,62 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the Apache v2.0 License.
+
+package placement
+
+import (
+	"context"
+
+	"github.com/microsoft/moc-sdk-for-go/services/compute"
+	"github.com/microsoft/moc-sdk-for-go/services/compute/virtualmachine"
+	"github.com/microsoft/moc/pkg/auth"
+	"github.com/microsoft/moc/pkg/errors"
+)
+
+// Planner computes VM placements and cross-checks them with the cloud agent
+type Planner struct {
+	vmclient precheckService
+}
+
+// precheckService is the part of the virtual machine client used to precheck plans
+type precheckService interface {
+	Precheck(ctx context.Context, group string, vms []*compute.VirtualMachine) (bool, error)
+}
+
+// NewPlanner returns a planner that prechecks feasible plans against the cloud agent
+func NewPlanner(cloudFQDN string, authorizer auth.Authorizer) (*Planner, error) {
+	vmclient, err := virtualmachine.NewVirtualMachineClient(cloudFQDN, authorizer)
+	if err != nil {
+		return nil, err
+	}
+	return &Planner{vmclient: vmclient}, nil
+}
+
+// Plan computes a placement for the request. When the plan is feasible, the VMs are also
+// prechecked with the cloud agent: a precheck the agent rejects is reported as a violation, and a precheck
+// that cannot be run is returned as an error.
+func (p *Planner) Plan(ctx context.Context, group string, request Request) (*Plan, error) {
+	if len(group) == 0 {
+		return nil, errors.Wrapf(errors.InvalidGroup, "Group not specified")
+	}
+	if len(request.VirtualMachines) == 0 {
+		return nil, errors.Wrapf(errors.InvalidInput, "No virtual machines to place")
+	}
+
+	plan := ComputePlacement(request)
+	if !plan.Feasible() {
+		return plan, nil
+	}
+
+	vms := []*compute.VirtualMachine{}
+	for _, vmRequest := range request.VirtualMachines {
+		vms = append(vms, vmRequest.VirtualMachine)
+	}
+	ok, err := p.vmclient.Precheck(ctx, group, vms)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Unable to precheck virtual machines")
+	}
+	if !ok {
+		plan.Violations = append(plan.Violations, Violation{Constraint: ConstraintPrecheck, Reason: "the cloud agent cannot place the virtual machines"})
+	}
+	return plan, nil
+}
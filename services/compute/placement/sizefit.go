@@ -0,0 +1,79 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the Apache v2.0 License.
+
+package placement
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/microsoft/moc-sdk-for-go/services/compute"
+	"github.com/microsoft/moc/pkg/errors"
+)
+
+// sizeFitProbeName names the VM prechecked by CheckSizeFits; it is never created
+const sizeFitProbeName = "size-fit-probe"
+
+// SizeFit is the result of checking a VM size against the nodes
+type SizeFit struct {
+	// Fits - A VM of the size can be placed on at least one node
+	Fits bool
+	// Nodes - Nodes with enough available capacity for the size
+	Nodes []string
+	// Reason - Why the size does not fit
+	Reason string
+}
+
+// GetSizeFit checks that a VM of size, or of customSize when size is Custom, fits in the available
+// capacity of at least one node. GPUs are not checked.
+func GetSizeFit(size compute.VirtualMachineSizeTypes, customSize *compute.VirtualMachineCustomSize, nodes []Node) (*SizeFit, error) {
+	info, ok := compute.GetVirtualMachineSizeCatalog().GetHardware(&compute.HardwareProfile{VMSize: size, CustomSize: customSize})
+	if !ok {
+		if size == compute.VirtualMachineSizeTypesCustom {
+			return nil, errors.Wrapf(errors.InvalidInput, "Custom size requires CpuCount and MemoryMB")
+		}
+		return nil, errors.Wrapf(errors.InvalidInput, "Unsupported virtual machine size [%s]", size)
+	}
+
+	fit := &SizeFit{Nodes: []string{}}
+	for _, node := range nodes {
+		if node.CPUCount >= info.CPUCount && node.MemoryMB >= int64(info.MemoryMB) {
+			fit.Nodes = append(fit.Nodes, node.Name)
+		}
+	}
+	fit.Fits = len(fit.Nodes) > 0
+	if !fit.Fits {
+		fit.Reason = fmt.Sprintf("no node has %d CPUs and %d MB of memory available", info.CPUCount, info.MemoryMB)
+	}
+	return fit, nil
+}
+
+// CheckSizeFits checks the size against the capacity of the nodes and, when it fits, prechecks a VM of the
+// size with the cloud agent, which also accounts for GPUs and resources the node inventory does not track.
+// The size does not fit when the precheck returns false without an error; precheck errors are returned.
+func (p *Planner) CheckSizeFits(ctx context.Context, group string, size compute.VirtualMachineSizeTypes, customSize *compute.VirtualMachineCustomSize, nodes []Node) (*SizeFit, error) {
+	if len(group) == 0 {
+		return nil, errors.Wrapf(errors.InvalidGroup, "Group not specified")
+	}
+	fit, err := GetSizeFit(size, customSize, nodes)
+	if err != nil || !fit.Fits {
+		return fit, err
+	}
+
+	name := sizeFitProbeName
+	probe := &compute.VirtualMachine{
+		Name: &name,
+		VirtualMachineProperties: &compute.VirtualMachineProperties{
+			HardwareProfile: &compute.HardwareProfile{VMSize: size, CustomSize: customSize},
+		},
+	}
+	ok, err := p.vmclient.Precheck(ctx, group, []*compute.VirtualMachine{probe})
+	if err != nil {
+		return nil, errors.Wrapf(err, "Unable to precheck virtual machine size [%s]", size)
+	}
+	if !ok {
+		fit.Fits = false
+		fit.Reason = "the cloud agent cannot place the virtual machine"
+	}
+	return fit, nil
+}
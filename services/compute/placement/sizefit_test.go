@@ -0,0 +1,48 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the Apache v2.0 License.
+
+package placement
+
+import (
+	"testing"
+
+	"github.com/microsoft/moc-sdk-for-go/services/compute"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_GetSizeFit(t *testing.T) {
+	nodes := []Node{
+		{Name: "node1", CPUCount: 4, MemoryMB: 8192},
+		{Name: "node2", CPUCount: 16, MemoryMB: 65536},
+	}
+	cpu, memory := int32(8), int32(16384)
+	custom := &compute.VirtualMachineCustomSize{CpuCount: &cpu, MemoryMB: &memory}
+
+	fit, err := GetSizeFit(compute.VirtualMachineSizeTypesCustom, custom, nodes)
+	require.NoError(t, err)
+	assert.True(t, fit.Fits)
+	assert.Equal(t, []string{"node2"}, fit.Nodes)
+
+	cpu = 32
+	fit, err = GetSizeFit(compute.VirtualMachineSizeTypesCustom, custom, nodes)
+	require.NoError(t, err)
+	assert.False(t, fit.Fits)
+	assert.NotEmpty(t, fit.Reason)
+
+	_, err = GetSizeFit(compute.VirtualMachineSizeTypesCustom, nil, nodes)
+	assert.Error(t, err)
+}
+
+func Test_GetSizeFit_CatalogSize(t *testing.T) {
+	info, ok := compute.GetVirtualMachineSizeCatalog().Get(compute.VirtualMachineSizeTypesStandardA2V2)
+	require.True(t, ok)
+
+	fit, err := GetSizeFit(compute.VirtualMachineSizeTypesStandardA2V2, nil, []Node{{Name: "node1", CPUCount: info.CPUCount, MemoryMB: int64(info.MemoryMB)}})
+	require.NoError(t, err)
+	assert.True(t, fit.Fits)
+
+	fit, err = GetSizeFit(compute.VirtualMachineSizeTypesStandardA2V2, nil, []Node{{Name: "node1", CPUCount: info.CPUCount - 1, MemoryMB: int64(info.MemoryMB)}})
+	require.NoError(t, err)
+	assert.False(t, fit.Fits)
+}
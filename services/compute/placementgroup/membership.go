@@ -0,0 +1,110 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the Apache v2.0 License.
+
+package placementgroup
+
+import (
+	"context"
+
+	"github.com/microsoft/moc-sdk-for-go/services/compute"
+	"github.com/microsoft/moc-sdk-for-go/services/compute/virtualmachine"
+	"github.com/microsoft/moc/pkg/errors"
+)
+
+// AddVirtualMachine adds the VM to the placement group and returns the updated membership
+func (c *PlacementGroupClient) AddVirtualMachine(ctx context.Context, group, name, vmName string) (*compute.Membership, error) {
+	if _, err := c.getPlacementGroup(ctx, group, name); err != nil {
+		return nil, err
+	}
+
+	vmclient, err := c.getVMClient()
+	if err != nil {
+		return nil, err
+	}
+	err = vmclient.Update(ctx, group, vmName, virtualmachine.UpdateFunc(func(ctx context.Context, vm *compute.VirtualMachine) (*compute.VirtualMachine, error) {
+		return vm, addMember(vm, group, name)
+	}))
+	if err != nil {
+		return nil, err
+	}
+	return c.ListMembers(ctx, group, name)
+}
+
+// RemoveVirtualMachine removes the VM from the placement group and returns the updated membership
+func (c *PlacementGroupClient) RemoveVirtualMachine(ctx context.Context, group, name, vmName string) (*compute.Membership, error) {
+	if _, err := c.getPlacementGroup(ctx, group, name); err != nil {
+		return nil, err
+	}
+
+	vmclient, err := c.getVMClient()
+	if err != nil {
+		return nil, err
+	}
+	err = vmclient.Update(ctx, group, vmName, virtualmachine.UpdateFunc(func(ctx context.Context, vm *compute.VirtualMachine) (*compute.VirtualMachine, error) {
+		return vm, removeMember(vm, name)
+	}))
+	if err != nil {
+		return nil, err
+	}
+	return c.ListMembers(ctx, group, name)
+}
+
+// ListMembers returns the VMs of the placement group and how they are spread across host nodes. The cloud agent
+// does not report the fault domain of a VM, so the host node distribution is the only one available.
+func (c *PlacementGroupClient) ListMembers(ctx context.Context, group, name string) (*compute.Membership, error) {
+	pgroup, err := c.getPlacementGroup(ctx, group, name)
+	if err != nil {
+		return nil, err
+	}
+
+	members := []*compute.VirtualMachineReference{}
+	if pgroup.PlacementGroupProperties != nil {
+		members = pgroup.VirtualMachines
+	}
+	vmclient, err := c.getVMClient()
+	if err != nil {
+		return nil, err
+	}
+	distribution, err := vmclient.GetHostNodeDistribution(ctx, group, members)
+	if err != nil {
+		return nil, err
+	}
+	return &compute.Membership{
+		VirtualMachines: members,
+		HostNodes:       distribution,
+	}, nil
+}
+
+func (c *PlacementGroupClient) getPlacementGroup(ctx context.Context, group, name string) (*compute.PlacementGroup, error) {
+	pgroups, err := c.Get(ctx, group, name)
+	if err != nil {
+		return nil, err
+	}
+	if pgroups == nil || len(*pgroups) == 0 {
+		return nil, errors.Wrapf(errors.NotFound, "Placement group [%s] not found", name)
+	}
+	return &(*pgroups)[0], nil
+}
+
+func addMember(vm *compute.VirtualMachine, group, name string) error {
+	if vm.VirtualMachineProperties == nil {
+		return errors.Wrapf(errors.InvalidInput, "Virtual Machine has no properties")
+	}
+	if current := vm.PlacementGroupProfile; current != nil && current.Name != nil && len(*current.Name) > 0 {
+		if *current.Name == name {
+			return errors.Wrapf(errors.AlreadyExists, "Virtual Machine is already a member of placement group [%s]", name)
+		}
+		return errors.Wrapf(errors.InvalidInput, "Virtual Machine is a member of placement group [%s], remove it first", *current.Name)
+	}
+	pgroupName, pgroupGroup := name, group
+	vm.PlacementGroupProfile = &compute.PlacementGroupReference{Name: &pgroupName, GroupName: &pgroupGroup}
+	return nil
+}
+
+func removeMember(vm *compute.VirtualMachine, name string) error {
+	if vm.VirtualMachineProperties == nil || vm.PlacementGroupProfile == nil || vm.PlacementGroupProfile.Name == nil || *vm.PlacementGroupProfile.Name != name {
+		return errors.Wrapf(errors.NotFound, "Virtual Machine is not a member of placement group [%s]", name)
+	}
+	vm.PlacementGroupProfile = nil
+	return nil
+}
@@ -103,3 +103,19 @@ func Test_getRpcWssdVirtualMachineReference(t *testing.T) {
 	assert.Equal(t, 1, len(b))
 	assert.Nil(t, b[0])
 }
+
+func Test_addRemoveMember(t *testing.T) {
+	vm := &compute.VirtualMachine{VirtualMachineProperties: &compute.VirtualMachineProperties{}}
+
+	assert.Error(t, removeMember(vm, name))
+	assert.NoError(t, addMember(vm, group, name))
+	assert.Equal(t, name, *vm.PlacementGroupProfile.Name)
+	assert.Equal(t, group, *vm.PlacementGroupProfile.GroupName)
+	assert.Error(t, addMember(vm, group, name))
+	assert.Error(t, addMember(vm, group, "pgroup2"))
+	assert.Error(t, removeMember(vm, "pgroup2"))
+	assert.NoError(t, removeMember(vm, name))
+	assert.Nil(t, vm.PlacementGroupProfile)
+
+	assert.Error(t, addMember(&compute.VirtualMachine{}, group, name))
+}
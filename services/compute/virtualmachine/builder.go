@@ -0,0 +1,570 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the Apache v2.0 License.
+
+package virtualmachine
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"math/big"
+	"strings"
+
+	"github.com/microsoft/moc-sdk-for-go/services/compute"
+	"github.com/microsoft/moc/pkg/errors"
+	"github.com/microsoft/moc/pkg/validations"
+)
+
+// minimumRSAKeyBits is the smallest RSA SSH public key accepted by the validation
+const minimumRSAKeyBits = 2048
+
+// ValidationErrors aggregates every problem found while validating a Virtual Machine definition
+type ValidationErrors []error
+
+func (v ValidationErrors) Error() string {
+	messages := make([]string, 0, len(v))
+	for _, err := range v {
+		messages = append(messages, err.Error())
+	}
+	return strings.Join(messages, "; ")
+}
+
+// Unwrap exposes the individual validation errors
+func (v ValidationErrors) Unwrap() []error {
+	return v
+}
+
+// VirtualMachineBuilder assembles a compute.VirtualMachine with sane defaults
+type VirtualMachineBuilder struct {
+	vm *compute.VirtualMachine
+}
+
+// NewVirtualMachineBuilder returns a builder for a Virtual Machine named name. The defaults are
+// the Default VM size, an enabled guest agent, cloud-init bootstrap and no zone or security settings.
+func NewVirtualMachineBuilder(name string) *VirtualMachineBuilder {
+	vmName := name
+	guestAgentEnabled := true
+	return &VirtualMachineBuilder{
+		vm: &compute.VirtualMachine{
+			Name: &vmName,
+			Tags: map[string]*string{},
+			VirtualMachineProperties: &compute.VirtualMachineProperties{
+				StorageProfile: &compute.StorageProfile{
+					DataDisks: &[]compute.DataDisk{},
+				},
+				OsProfile: &compute.OSProfile{
+					OsBootstrapEngine: compute.CloudInit,
+				},
+				NetworkProfile: &compute.NetworkProfile{
+					NetworkInterfaces: &[]compute.NetworkInterfaceReference{},
+				},
+				HardwareProfile: &compute.HardwareProfile{
+					VMSize: compute.VirtualMachineSizeTypesDefault,
+				},
+				GuestAgentProfile: &compute.GuestAgentProfile{
+					Enabled: &guestAgentEnabled,
+				},
+				VmType: compute.Tenant,
+			},
+		},
+	}
+}
+
+// WithLocation sets the location of the Virtual Machine
+func (b *VirtualMachineBuilder) WithLocation(location string) *VirtualMachineBuilder {
+	b.vm.Location = &location
+	return b
+}
+
+// WithTag adds a tag to the Virtual Machine
+func (b *VirtualMachineBuilder) WithTag(key, value string) *VirtualMachineBuilder {
+	b.vm.Tags[key] = &value
+	return b
+}
+
+// WithImage sets the gallery image the OS disk is created from
+func (b *VirtualMachineBuilder) WithImage(imageName string) *VirtualMachineBuilder {
+	b.vm.StorageProfile.ImageReference = &compute.ImageReference{Name: &imageName}
+	return b
+}
+
+// WithOsDisk boots the Virtual Machine from an existing virtual hard disk
+func (b *VirtualMachineBuilder) WithOsDisk(diskName string) *VirtualMachineBuilder {
+	b.vm.StorageProfile.OsDisk = &compute.OSDisk{Vhd: &compute.VirtualHardDisk{URI: &diskName}}
+	return b
+}
+
+// WithDataDisk attaches an existing virtual hard disk
+func (b *VirtualMachineBuilder) WithDataDisk(diskName string) *VirtualMachineBuilder {
+	*b.vm.StorageProfile.DataDisks = append(*b.vm.StorageProfile.DataDisks, compute.DataDisk{Vhd: &compute.VirtualHardDisk{URI: &diskName}})
+	return b
+}
+
+// WithNetworkInterface attaches an existing network interface. The first interface is the primary one.
+func (b *VirtualMachineBuilder) WithNetworkInterface(nicName string) *VirtualMachineBuilder {
+	primary := len(*b.vm.NetworkProfile.NetworkInterfaces) == 0
+	*b.vm.NetworkProfile.NetworkInterfaces = append(*b.vm.NetworkProfile.NetworkInterfaces, compute.NetworkInterfaceReference{
+		ID:                                  &nicName,
+		NetworkInterfaceReferenceProperties: &compute.NetworkInterfaceReferenceProperties{Primary: &primary},
+	})
+	return b
+}
+
+// WithLinux configures a Linux guest. Password authentication is disabled when SSH keys are given.
+func (b *VirtualMachineBuilder) WithLinux(computerName, adminUsername string, sshPublicKeys ...string) *VirtualMachineBuilder {
+	osProfile := b.vm.OsProfile
+	osProfile.OsType = compute.Linux
+	osProfile.ComputerName = &computerName
+	osProfile.AdminUsername = &adminUsername
+	osProfile.WindowsConfiguration = nil
+
+	disablePassword := len(sshPublicKeys) > 0
+	osProfile.LinuxConfiguration = &compute.LinuxConfiguration{
+		SSH:                           getSSHConfiguration(sshPublicKeys),
+		DisablePasswordAuthentication: &disablePassword,
+	}
+	return b
+}
+
+// WithWindows configures a Windows guest
+func (b *VirtualMachineBuilder) WithWindows(computerName, adminUsername, adminPassword string) *VirtualMachineBuilder {
+	osProfile := b.vm.OsProfile
+	osProfile.OsType = compute.Windows
+	osProfile.ComputerName = &computerName
+	osProfile.AdminUsername = &adminUsername
+	osProfile.AdminPassword = &adminPassword
+	osProfile.LinuxConfiguration = nil
+
+	enableAutomaticUpdates := false
+	timeZone := "UTC"
+	osProfile.WindowsConfiguration = &compute.WindowsConfiguration{
+		EnableAutomaticUpdates: &enableAutomaticUpdates,
+		TimeZone:               &timeZone,
+	}
+	return b
+}
+
+// WithAdminPassword sets the password of the administrator account
+func (b *VirtualMachineBuilder) WithAdminPassword(password string) *VirtualMachineBuilder {
+	b.vm.OsProfile.AdminPassword = &password
+	return b
+}
+
+// WithCustomData sets the base-64 encoded custom data passed to the bootstrap engine
+func (b *VirtualMachineBuilder) WithCustomData(customData string) *VirtualMachineBuilder {
+	b.vm.OsProfile.CustomData = &customData
+	return b
+}
+
+// WithBootstrapEngine overrides the default cloud-init bootstrap engine
+func (b *VirtualMachineBuilder) WithBootstrapEngine(engine compute.OperatingSystemBootstrapEngine) *VirtualMachineBuilder {
+	b.vm.OsProfile.OsBootstrapEngine = engine
+	return b
+}
+
+// WithProxy sets the proxy configuration of the guest
+func (b *VirtualMachineBuilder) WithProxy(proxy *compute.ProxyConfiguration) *VirtualMachineBuilder {
+	b.vm.OsProfile.ProxyConfiguration = proxy
+	return b
+}
+
+// WithSize sets a predefined VM size
+func (b *VirtualMachineBuilder) WithSize(size compute.VirtualMachineSizeTypes) *VirtualMachineBuilder {
+	b.vm.HardwareProfile.VMSize = size
+	b.vm.HardwareProfile.CustomSize = nil
+	return b
+}
+
+// WithCustomSize sets a Custom VM size with the given processor count and memory
+func (b *VirtualMachineBuilder) WithCustomSize(cpuCount, memoryMB int32) *VirtualMachineBuilder {
+	b.vm.HardwareProfile.VMSize = compute.VirtualMachineSizeTypesCustom
+	b.vm.HardwareProfile.CustomSize = &compute.VirtualMachineCustomSize{
+		CpuCount: &cpuCount,
+		MemoryMB: &memoryMB,
+	}
+	return b
+}
+
+// WithDynamicMemory enables dynamic memory
+func (b *VirtualMachineBuilder) WithDynamicMemory(config *compute.DynamicMemoryConfiguration) *VirtualMachineBuilder {
+	b.vm.HardwareProfile.DynamicMemoryConfig = config
+	return b
+}
+
+// WithGPU assigns a GPU to the Virtual Machine
+func (b *VirtualMachineBuilder) WithGPU(gpuName string, assignment compute.Assignment, partitionSizeMB uint64) *VirtualMachineBuilder {
+	b.vm.HardwareProfile.VirtualMachineGPUs = append(b.vm.HardwareProfile.VirtualMachineGPUs, &compute.VirtualMachineGPU{
+		Assignment:      &assignment,
+		PartitionSizeMB: &partitionSizeMB,
+		Name:            &gpuName,
+	})
+	return b
+}
+
+// WithSecurity sets the security type, secure boot and TPM settings
+func (b *VirtualMachineBuilder) WithSecurity(securityType compute.SecurityTypes, secureBoot, enableTPM bool) *VirtualMachineBuilder {
+	b.vm.SecurityProfile = &compute.SecurityProfile{
+		SecurityType: securityType,
+		EnableTPM:    &enableTPM,
+		UefiSettings: &compute.UefiSettings{SecureBootEnabled: &secureBoot},
+	}
+	return b
+}
+
+// WithZones restricts placement to the given zones
+func (b *VirtualMachineBuilder) WithZones(strictPlacement bool, zones ...string) *VirtualMachineBuilder {
+	zoneRefs := []compute.Zone{}
+	for i := range zones {
+		zoneRefs = append(zoneRefs, compute.Zone{Name: &zones[i]})
+	}
+	b.vm.ZoneConfiguration = &compute.ZoneConfiguration{
+		Zones:           &zoneRefs,
+		StrictPlacement: &strictPlacement,
+	}
+	return b
+}
+
+// WithAvailabilitySet places the Virtual Machine in an availability set
+func (b *VirtualMachineBuilder) WithAvailabilitySet(group, name string) *VirtualMachineBuilder {
+	b.vm.AvailabilitySetProfile = &compute.AvailabilitySetReference{Name: &name, GroupName: &group}
+	return b
+}
+
+// WithPlacementGroup places the Virtual Machine in a placement group
+func (b *VirtualMachineBuilder) WithPlacementGroup(group, name string) *VirtualMachineBuilder {
+	b.vm.PlacementGroupProfile = &compute.PlacementGroupReference{Name: &name, GroupName: &group}
+	return b
+}
+
+// WithGuestAgent enables or disables the guest agent
+func (b *VirtualMachineBuilder) WithGuestAgent(enabled bool) *VirtualMachineBuilder {
+	b.vm.GuestAgentProfile = &compute.GuestAgentProfile{Enabled: &enabled}
+	return b
+}
+
+// Build validates the Virtual Machine and returns it, or returns every validation error found
+func (b *VirtualMachineBuilder) Build() (*compute.VirtualMachine, error) {
+	if err := ValidateVirtualMachine(b.vm); err != nil {
+		return nil, err
+	}
+	return b.vm, nil
+}
+
+func getSSHConfiguration(keys []string) *compute.SSHConfiguration {
+	if len(keys) == 0 {
+		return nil
+	}
+	publicKeys := []compute.SSHPublicKey{}
+	for i := range keys {
+		publicKeys = append(publicKeys, compute.SSHPublicKey{KeyData: &keys[i]})
+	}
+	return &compute.SSHConfiguration{PublicKeys: &publicKeys}
+}
+
+// ValidateVirtualMachine checks a Virtual Machine definition on the client side and returns a
+// ValidationErrors listing every problem found, or nil.
+func ValidateVirtualMachine(vm *compute.VirtualMachine) error {
+	if vm == nil {
+		return errors.Wrapf(errors.InvalidInput, "Virtual Machine is nil")
+	}
+
+	errs := ValidationErrors{}
+	if vm.Name == nil || len(*vm.Name) == 0 {
+		errs = append(errs, errors.Wrapf(errors.InvalidInput, "Virtual Machine name is missing"))
+	}
+	if vm.VirtualMachineProperties == nil {
+		return append(errs, errors.Wrapf(errors.InvalidInput, "Virtual Machine properties are missing"))
+	}
+
+	errs = append(errs, validateStorageProfile(vm.StorageProfile)...)
+	errs = append(errs, validateOSProfile(vm.OsProfile)...)
+	errs = append(errs, validateNetworkProfile(vm.NetworkProfile)...)
+	errs = append(errs, validateHardwareProfile(vm.HardwareProfile)...)
+	errs = append(errs, validateSecurityProfile(vm.SecurityProfile, vm.StorageProfile)...)
+	errs = append(errs, validateZoneConfiguration(vm.ZoneConfiguration)...)
+	errs = append(errs, validateReferences(vm)...)
+
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+func validateStorageProfile(s *compute.StorageProfile) []error {
+	if s == nil {
+		return []error{errors.Wrapf(errors.InvalidInput, "Storage profile is missing")}
+	}
+	errs := []error{}
+	hasImage := s.ImageReference != nil && s.ImageReference.Name != nil && len(*s.ImageReference.Name) > 0
+	hasOsDisk := s.OsDisk != nil && s.OsDisk.Vhd != nil && s.OsDisk.Vhd.URI != nil && len(*s.OsDisk.Vhd.URI) > 0
+	if !hasImage && !hasOsDisk {
+		errs = append(errs, errors.Wrapf(errors.InvalidInput, "Either an image reference or an OS disk is required"))
+	}
+	if s.DataDisks != nil {
+		seen := map[string]bool{}
+		for _, disk := range *s.DataDisks {
+			if disk.Vhd == nil || disk.Vhd.URI == nil || len(*disk.Vhd.URI) == 0 {
+				errs = append(errs, errors.Wrapf(errors.InvalidInput, "Data disk name is missing"))
+				continue
+			}
+			if seen[*disk.Vhd.URI] {
+				errs = append(errs, errors.Wrapf(errors.InvalidInput, "Data disk [%s] is attached more than once", *disk.Vhd.URI))
+			}
+			seen[*disk.Vhd.URI] = true
+		}
+	}
+	return errs
+}
+
+func validateOSProfile(o *compute.OSProfile) []error {
+	if o == nil {
+		return []error{errors.Wrapf(errors.InvalidInput, "OS profile is missing")}
+	}
+	errs := []error{}
+	if o.ComputerName == nil || len(*o.ComputerName) == 0 {
+		errs = append(errs, errors.Wrapf(errors.InvalidInput, "ComputerName is missing"))
+	}
+	if o.AdminUsername == nil || len(*o.AdminUsername) == 0 {
+		errs = append(errs, errors.Wrapf(errors.InvalidInput, "AdminUsername is missing"))
+	}
+
+	switch o.OsType {
+	case compute.Linux:
+		if o.WindowsConfiguration != nil {
+			errs = append(errs, errors.Wrapf(errors.InvalidInput, "Windows configuration is set on a Linux Virtual Machine"))
+		}
+		if o.OsBootstrapEngine == compute.WindowsAnswerFiles {
+			errs = append(errs, errors.Wrapf(errors.InvalidInput, "Windows answer files cannot bootstrap a Linux Virtual Machine"))
+		}
+	case compute.Windows:
+		if o.LinuxConfiguration != nil {
+			errs = append(errs, errors.Wrapf(errors.InvalidInput, "Linux configuration is set on a Windows Virtual Machine"))
+		}
+		if o.AdminPassword == nil || len(*o.AdminPassword) == 0 {
+			errs = append(errs, errors.Wrapf(errors.InvalidInput, "AdminPassword is required for a Windows Virtual Machine"))
+		}
+	default:
+		errs = append(errs, errors.Wrapf(errors.InvalidInput, "OS type [%s] is not supported", o.OsType))
+	}
+
+	var ssh *compute.SSHConfiguration
+	if o.LinuxConfiguration != nil {
+		ssh = o.LinuxConfiguration.SSH
+		if o.LinuxConfiguration.DisablePasswordAuthentication != nil && *o.LinuxConfiguration.DisablePasswordAuthentication &&
+			(ssh == nil || ssh.PublicKeys == nil || len(*ssh.PublicKeys) == 0) {
+			errs = append(errs, errors.Wrapf(errors.InvalidInput, "Password authentication is disabled but no SSH public key is set"))
+		}
+	} else if o.WindowsConfiguration != nil {
+		ssh = o.WindowsConfiguration.SSH
+	}
+	if ssh != nil && ssh.PublicKeys != nil {
+		for i, key := range *ssh.PublicKeys {
+			if key.KeyData == nil {
+				errs = append(errs, errors.Wrapf(errors.InvalidInput, "SSH KeyData is missing"))
+				continue
+			}
+			if err := ValidateSSHPublicKey(*key.KeyData); err != nil {
+				errs = append(errs, errors.Wrapf(err, "SSH public key %d", i))
+			}
+		}
+	}
+	return append(errs, validateProxyConfiguration(o.ProxyConfiguration)...)
+}
+
+// validateProxyConfiguration checks the proxy URLs. It also runs before every create request, see
+// virtualMachineValidations.
+func validateProxyConfiguration(p *compute.ProxyConfiguration) []error {
+	errs := []error{}
+	if p == nil {
+		return errs
+	}
+	for _, proxyURL := range []*string{p.HttpProxy, p.HttpsProxy} {
+		if proxyURL != nil && *proxyURL != "" {
+			if _, err := validations.ValidateProxyURL(*proxyURL); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errs
+}
+
+func validateNetworkProfile(n *compute.NetworkProfile) []error {
+	if n == nil || n.NetworkInterfaces == nil || len(*n.NetworkInterfaces) == 0 {
+		return []error{errors.Wrapf(errors.InvalidInput, "At least one network interface is required")}
+	}
+	errs := []error{}
+	seen := map[string]bool{}
+	primaries := 0
+	for _, nic := range *n.NetworkInterfaces {
+		if nic.ID == nil || len(*nic.ID) == 0 {
+			errs = append(errs, errors.Wrapf(errors.InvalidInput, "Network Interface ID/Name is missing"))
+			continue
+		}
+		if seen[*nic.ID] {
+			errs = append(errs, errors.Wrapf(errors.InvalidInput, "Network interface [%s] is attached more than once", *nic.ID))
+		}
+		seen[*nic.ID] = true
+		if nic.NetworkInterfaceReferenceProperties != nil && nic.Primary != nil && *nic.Primary {
+			primaries++
+		}
+	}
+	if primaries > 1 {
+		errs = append(errs, errors.Wrapf(errors.InvalidInput, "Only one network interface can be primary"))
+	}
+	return errs
+}
+
+func validateHardwareProfile(h *compute.HardwareProfile) []error {
+	if h == nil {
+		return nil
+	}
+	errs := []error{}
+	if h.VMSize == compute.VirtualMachineSizeTypesCustom {
+		if h.CustomSize == nil || h.CustomSize.CpuCount == nil || h.CustomSize.MemoryMB == nil {
+			errs = append(errs, errors.Wrapf(errors.InvalidInput, "Custom VM size requires a CPU count and memory"))
+		} else if *h.CustomSize.CpuCount <= 0 || *h.CustomSize.MemoryMB <= 0 {
+			errs = append(errs, errors.Wrapf(errors.InvalidInput, "Custom VM size requires a positive CPU count and memory"))
+		}
+	} else if h.CustomSize != nil {
+		errs = append(errs, errors.Wrapf(errors.InvalidInput, "Custom size is set but VM size is [%s], not Custom", h.VMSize))
+	}
+	if err := validateDynamicMemoryConfiguration(h.DynamicMemoryConfig); err != nil {
+		errs = append(errs, err)
+	}
+	for _, gpu := range h.VirtualMachineGPUs {
+		if gpu == nil {
+			continue
+		}
+		if gpu.Assignment == nil {
+			errs = append(errs, errors.Wrapf(errors.InvalidInput, "GPU assignment is not specified"))
+			continue
+		}
+		switch *gpu.Assignment {
+		case compute.GpuDDA, compute.GpuP, compute.GpuPV, compute.GpuDefault:
+		default:
+			errs = append(errs, errors.Wrapf(errors.InvalidInput, "Unsupported GPU assignment type [%s]", *gpu.Assignment))
+		}
+	}
+	return errs
+}
+
+func validateSecurityProfile(s *compute.SecurityProfile, storage *compute.StorageProfile) []error {
+	errs := []error{}
+	securityType := compute.SecurityTypes("")
+	if s != nil {
+		securityType = s.SecurityType
+		switch securityType {
+		case "", compute.TrustedLaunch, compute.ConfidentialVM:
+		default:
+			errs = append(errs, errors.Wrapf(errors.InvalidInput, "Security type [%s] is not supported", securityType))
+		}
+		if securityType == compute.ConfidentialVM && (s.EnableTPM == nil || !*s.EnableTPM) {
+			errs = append(errs, errors.Wrapf(errors.InvalidInput, "Confidential VMs require a TPM"))
+		}
+		secureBoot := s.UefiSettings != nil && s.UefiSettings.SecureBootEnabled != nil && *s.UefiSettings.SecureBootEnabled
+		if (securityType == compute.TrustedLaunch || securityType == compute.ConfidentialVM) && !secureBoot {
+			errs = append(errs, errors.Wrapf(errors.InvalidInput, "Security type [%s] requires secure boot", securityType))
+		}
+		// UEFI (Gen2) settings only apply once a security type is set
+		if securityType == "" && (secureBoot || (s.EnableTPM != nil && *s.EnableTPM)) {
+			errs = append(errs, errors.Wrapf(errors.InvalidInput, "Secure boot and TPM require a UEFI (Gen2) virtual machine, set a security type"))
+		}
+	}
+	if storage != nil && storage.OsDisk != nil && storage.OsDisk.ManagedDisk != nil && storage.OsDisk.ManagedDisk.SecurityProfile != nil &&
+		storage.OsDisk.ManagedDisk.SecurityProfile.SecurityEncryptionType == compute.NonPersistedTPM && securityType != compute.ConfidentialVM {
+		errs = append(errs, errors.Wrapf(errors.InvalidInput, "NonPersistedTPM disk encryption can only be set for Confidential VMs"))
+	}
+	return errs
+}
+
+func validateZoneConfiguration(z *compute.ZoneConfiguration) []error {
+	if z == nil || z.Zones == nil {
+		return nil
+	}
+	errs := []error{}
+	if z.StrictPlacement == nil {
+		errs = append(errs, errors.Wrapf(errors.InvalidInput, "Zone strict placement is not specified"))
+	} else if *z.StrictPlacement && len(*z.Zones) == 0 {
+		errs = append(errs, errors.Wrapf(errors.InvalidInput, "Strict zone placement requires at least one zone"))
+	}
+	seen := map[string]bool{}
+	for _, zone := range *z.Zones {
+		if zone.Name == nil || len(*zone.Name) == 0 {
+			errs = append(errs, errors.Wrapf(errors.InvalidInput, "Zone name is missing"))
+			continue
+		}
+		if seen[*zone.Name] {
+			errs = append(errs, errors.Wrapf(errors.InvalidInput, "Zone [%s] is listed more than once", *zone.Name))
+		}
+		seen[*zone.Name] = true
+	}
+	return errs
+}
+
+func validateReferences(vm *compute.VirtualMachine) []error {
+	errs := []error{}
+	if a := vm.AvailabilitySetProfile; a != nil && (a.Name == nil || a.GroupName == nil) {
+		errs = append(errs, errors.Wrapf(errors.InvalidInput, "Availability set reference requires a name and group"))
+	}
+	if p := vm.PlacementGroupProfile; p != nil && (p.Name == nil || p.GroupName == nil) {
+		errs = append(errs, errors.Wrapf(errors.InvalidInput, "Placement group reference requires a name and group"))
+	}
+	return errs
+}
+
+// ValidateSSHPublicKey checks that key is an OpenSSH authorized_keys entry whose embedded key type
+// matches its declared type. RSA keys must be at least 2048 bits.
+func ValidateSSHPublicKey(key string) error {
+	fields := strings.Fields(key)
+	if len(fields) < 2 {
+		return errors.Wrapf(errors.InvalidInput, "SSH public key must be in the form '<type> <base64 key> [comment]'")
+	}
+	keyType := fields[0]
+	switch keyType {
+	case "ssh-rsa", "ssh-ed25519", "ecdsa-sha2-nistp256", "ecdsa-sha2-nistp384", "ecdsa-sha2-nistp521",
+		"sk-ssh-ed25519@openssh.com", "sk-ecdsa-sha2-nistp256@openssh.com":
+	default:
+		return errors.Wrapf(errors.InvalidInput, "SSH public key type [%s] is not supported", keyType)
+	}
+
+	blob, err := base64.StdEncoding.DecodeString(fields[1])
+	if err != nil {
+		return errors.Wrapf(errors.InvalidInput, "SSH public key data is not valid base64")
+	}
+	reader := bytes.NewReader(blob)
+	embeddedType, err := readSSHString(reader)
+	if err != nil || string(embeddedType) != keyType {
+		return errors.Wrapf(errors.InvalidInput, "SSH public key data does not match key type [%s]", keyType)
+	}
+
+	if keyType == "ssh-rsa" {
+		if _, err := readSSHString(reader); err != nil { // public exponent
+			return errors.Wrapf(errors.InvalidInput, "SSH RSA public key is truncated")
+		}
+		modulus, err := readSSHString(reader)
+		if err != nil {
+			return errors.Wrapf(errors.InvalidInput, "SSH RSA public key is truncated")
+		}
+		if bits := new(big.Int).SetBytes(modulus).BitLen(); bits < minimumRSAKeyBits {
+			return errors.Wrapf(errors.InvalidInput, "SSH RSA public key is %d bits, at least %d are required", bits, minimumRSAKeyBits)
+		}
+	}
+	return nil
+}
+
+// readSSHString reads a length-prefixed string from an SSH wire format blob
+func readSSHString(reader *bytes.Reader) ([]byte, error) {
+	var length uint32
+	if err := binary.Read(reader, binary.BigEndian, &length); err != nil {
+		return nil, err
+	}
+	if int64(length) > int64(reader.Len()) {
+		return nil, errors.Wrapf(errors.InvalidInput, "SSH string length exceeds key data")
+	}
+	value := make([]byte, length)
+	if _, err := io.ReadFull(reader, value); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
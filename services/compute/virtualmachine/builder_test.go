@@ -0,0 +1,148 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the Apache v2.0 License.
+
+package virtualmachine
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/binary"
+	"math/big"
+	"testing"
+
+	"github.com/microsoft/moc-sdk-for-go/services/compute"
+	"github.com/stretchr/testify/assert"
+)
+
+func sshRSAPublicKey(t *testing.T, bits int) string {
+	key, err := rsa.GenerateKey(rand.Reader, bits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var blob bytes.Buffer
+	for _, field := range [][]byte{[]byte("ssh-rsa"), big.NewInt(int64(key.E)).Bytes(), append([]byte{0}, key.N.Bytes()...)} {
+		binary.Write(&blob, binary.BigEndian, uint32(len(field)))
+		blob.Write(field)
+	}
+	return "ssh-rsa " + base64.StdEncoding.EncodeToString(blob.Bytes()) + " user@host"
+}
+
+func Test_ValidateSSHPublicKey(t *testing.T) {
+	assert.NoError(t, ValidateSSHPublicKey(sshRSAPublicKey(t, 2048)))
+	assert.Error(t, ValidateSSHPublicKey(sshRSAPublicKey(t, 1024)))
+	assert.Error(t, ValidateSSHPublicKey("ssh-rsa"))
+	assert.Error(t, ValidateSSHPublicKey("ssh-rsa not-base64!"))
+	assert.Error(t, ValidateSSHPublicKey("ssh-dss AAAA"))
+
+	// declared type does not match the embedded type
+	mismatched := "ssh-ed25519 " + sshRSAPublicKey(t, 2048)[len("ssh-rsa "):]
+	assert.Error(t, ValidateSSHPublicKey(mismatched))
+}
+
+func Test_VirtualMachineBuilder(t *testing.T) {
+	vm, err := NewVirtualMachineBuilder("vm1").
+		WithImage("ubuntu").
+		WithLinux("vm1", "azureuser", sshRSAPublicKey(t, 2048)).
+		WithNetworkInterface("vm1-nic").
+		WithCustomSize(4, 8192).
+		WithSecurity(compute.TrustedLaunch, true, true).
+		WithZones(true, "zone1").
+		Build()
+	assert.NoError(t, err)
+	if assert.NotNil(t, vm) {
+		assert.Equal(t, compute.Linux, vm.OsProfile.OsType)
+		assert.True(t, *(*vm.NetworkProfile.NetworkInterfaces)[0].Primary)
+		assert.True(t, *vm.GuestAgentProfile.Enabled)
+	}
+}
+
+func Test_VirtualMachineBuilderAggregatesErrors(t *testing.T) {
+	invalidProxy := "//proxy:3128"
+	builder := NewVirtualMachineBuilder("vm1").
+		WithWindows("vm1", "admin", "").
+		WithSize(compute.VirtualMachineSizeTypesCustom).
+		WithSecurity("", true, false).
+		WithProxy(&compute.ProxyConfiguration{HttpProxy: &invalidProxy}).
+		WithZones(true)
+	_, err := builder.Build()
+
+	validationErrors, ok := err.(ValidationErrors)
+	if !assert.True(t, ok, "expected ValidationErrors, got %v", err) {
+		return
+	}
+	// missing image/OS disk, missing password, missing NIC, custom size without
+	// values, secure boot without a security type, invalid proxy URL, strict placement without zones
+	assert.Len(t, validationErrors, 7)
+}
+
+func Test_validateSecurityProfile(t *testing.T) {
+	enabled, disabled := true, false
+	tests := []struct {
+		name    string
+		profile *compute.SecurityProfile
+		storage *compute.StorageProfile
+		errs    int
+	}{
+		{name: "none"},
+		{name: "empty", profile: &compute.SecurityProfile{}},
+		{
+			name:    "trusted launch",
+			profile: &compute.SecurityProfile{SecurityType: compute.TrustedLaunch, EnableTPM: &enabled, UefiSettings: &compute.UefiSettings{SecureBootEnabled: &enabled}},
+		},
+		{
+			name:    "trusted launch without secure boot",
+			profile: &compute.SecurityProfile{SecurityType: compute.TrustedLaunch, UefiSettings: &compute.UefiSettings{SecureBootEnabled: &disabled}},
+			errs:    1,
+		},
+		{
+			name:    "trusted launch without UEFI settings",
+			profile: &compute.SecurityProfile{SecurityType: compute.TrustedLaunch},
+			errs:    1,
+		},
+		{
+			name:    "confidential",
+			profile: &compute.SecurityProfile{SecurityType: compute.ConfidentialVM, EnableTPM: &enabled, UefiSettings: &compute.UefiSettings{SecureBootEnabled: &enabled}},
+			storage: &compute.StorageProfile{OsDisk: &compute.OSDisk{ManagedDisk: &compute.VirtualMachineManagedDiskParameters{
+				SecurityProfile: &compute.VMDiskSecurityProfile{SecurityEncryptionType: compute.NonPersistedTPM},
+			}}},
+		},
+		{
+			name:    "confidential without TPM or secure boot",
+			profile: &compute.SecurityProfile{SecurityType: compute.ConfidentialVM},
+			errs:    2,
+		},
+		{
+			name:    "secure boot without security type",
+			profile: &compute.SecurityProfile{UefiSettings: &compute.UefiSettings{SecureBootEnabled: &enabled}},
+			errs:    1,
+		},
+		{
+			name:    "TPM without security type",
+			profile: &compute.SecurityProfile{EnableTPM: &enabled},
+			errs:    1,
+		},
+		{
+			name:    "disabled without security type",
+			profile: &compute.SecurityProfile{EnableTPM: &disabled, UefiSettings: &compute.UefiSettings{SecureBootEnabled: &disabled}},
+		},
+		{
+			name:    "unsupported security type",
+			profile: &compute.SecurityProfile{SecurityType: "Standard"},
+			errs:    1,
+		},
+		{
+			name: "NonPersistedTPM without confidential",
+			storage: &compute.StorageProfile{OsDisk: &compute.OSDisk{ManagedDisk: &compute.VirtualMachineManagedDiskParameters{
+				SecurityProfile: &compute.VMDiskSecurityProfile{SecurityEncryptionType: compute.NonPersistedTPM},
+			}}},
+			errs: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Len(t, validateSecurityProfile(tt.profile, tt.storage), tt.errs)
+		})
+	}
+}
@@ -82,18 +82,30 @@ func (c *VirtualMachineClient) Query(ctx context.Context, group, query string) (
 
 // Start the Virtual Machine
 func (c *VirtualMachineClient) Start(ctx context.Context, group string, name string) (err error) {
+	err = c.validatePowerOperation(ctx, group, name, PowerOperationStart)
+	if err != nil {
+		return
+	}
 	err = c.internal.Start(ctx, group, name)
 	return
 }
 
 // Stop the Virtual Machine
 func (c *VirtualMachineClient) Stop(ctx context.Context, group string, name string) (err error) {
+	err = c.validatePowerOperation(ctx, group, name, PowerOperationStop)
+	if err != nil {
+		return
+	}
 	err = c.internal.Stop(ctx, group, name)
 	return
 }
 
 // Stop the Virtual Machine gracefully
 func (c *VirtualMachineClient) StopGraceful(ctx context.Context, group string, name string) (err error) {
+	err = c.validatePowerOperation(ctx, group, name, PowerOperationStopGraceful)
+	if err != nil {
+		return
+	}
 	err = c.internal.StopGraceful(ctx, group, name)
 	return
 }
@@ -110,12 +122,20 @@ func (c *VirtualMachineClient) Restart(ctx context.Context, group string, name s
 
 // Pause the Virtual Machine
 func (c *VirtualMachineClient) Pause(ctx context.Context, group string, name string) (err error) {
+	err = c.validatePowerOperation(ctx, group, name, PowerOperationPause)
+	if err != nil {
+		return
+	}
 	err = c.internal.Pause(ctx, group, name)
 	return
 }
 
 // Save the Virtual Machine
 func (c *VirtualMachineClient) Save(ctx context.Context, group string, name string) (err error) {
+	err = c.validatePowerOperation(ctx, group, name, PowerOperationSave)
+	if err != nil {
+		return
+	}
 	err = c.internal.Save(ctx, group, name)
 	return
 }
@@ -130,6 +150,14 @@ type UpdateFunctor interface {
 	Update(context.Context, *compute.VirtualMachine) (*compute.VirtualMachine, error)
 }
 
+// UpdateFunc adapts a function to an UpdateFunctor
+type UpdateFunc func(context.Context, *compute.VirtualMachine) (*compute.VirtualMachine, error)
+
+// Update calls f
+func (f UpdateFunc) Update(ctx context.Context, vm *compute.VirtualMachine) (*compute.VirtualMachine, error) {
+	return f(ctx, vm)
+}
+
 // Update the VM with a retry
 func (c *VirtualMachineClient) Update(ctx context.Context, group string, vmName string, updateFunctor UpdateFunctor) (err error) {
 	for {
@@ -418,3 +446,28 @@ func (c *VirtualMachineClient) GetHostNodeName(ctx context.Context, group string
 func (c *VirtualMachineClient) GetHostNodeIpAddress(ctx context.Context, group string, name string) (*compute.VirtualMachineHostNodeIpAddress, error) {
 	return c.internal.GetHostNodeIpAddress(ctx, group, name)
 }
+
+// GetHostNodeDistribution groups the referenced VMs by the host node they run on. References without
+// a group default to group. VMs that are not placed on a node yet are listed under the empty key.
+func (c *VirtualMachineClient) GetHostNodeDistribution(ctx context.Context, group string, vms []*compute.VirtualMachineReference) (map[string][]string, error) {
+	distribution := map[string][]string{}
+	for _, ref := range vms {
+		if ref == nil || ref.Name == nil {
+			continue
+		}
+		vmGroup := group
+		if ref.GroupName != nil && len(*ref.GroupName) > 0 {
+			vmGroup = *ref.GroupName
+		}
+		host, err := c.GetHostNodeName(ctx, vmGroup, *ref.Name)
+		if err != nil {
+			return nil, err
+		}
+		nodeName := ""
+		if host != nil && host.HostNodeName != nil {
+			nodeName = *host.HostNodeName
+		}
+		distribution[nodeName] = append(distribution[nodeName], *ref.Name)
+	}
+	return distribution, nil
+}
@@ -0,0 +1,356 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the Apache v2.0 License.
+
+package virtualmachine
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/microsoft/moc-sdk-for-go/services/compute"
+	"github.com/microsoft/moc-sdk-for-go/services/compute/galleryimage"
+	"github.com/microsoft/moc-sdk-for-go/services/network"
+	"github.com/microsoft/moc-sdk-for-go/services/network/networkinterface"
+	"github.com/microsoft/moc-sdk-for-go/services/storage/virtualharddisk"
+	"github.com/microsoft/moc/pkg/errors"
+)
+
+// generalizeScripts are run through RunCommand to generalize the guest before capture. Both scripts shut
+// the guest down once it is generalized, so that it does not boot again with its identity reset. The
+// Linux script schedules the shutdown a minute out so that RunCommand can return.
+var generalizeScripts = map[compute.OperatingSystemTypes]string{
+	compute.Windows: `& "$env:SystemRoot\System32\Sysprep\sysprep.exe" /generalize /oobe /shutdown /quiet`,
+	compute.Linux:   "cloud-init clean --logs --seed && rm -f /etc/ssh/ssh_host_* && truncate -s 0 /etc/machine-id && shutdown -h +1",
+}
+
+// CaptureImageOptions controls how CaptureImage prepares the source Virtual Machine
+type CaptureImageOptions struct {
+	// Generalize - Generalize the guest OS before capture. The guest shuts itself down once generalized, and
+	// the VM is left stopped.
+	Generalize bool
+	// StopTimeout - How long to wait for the VM to stop, defaults to 10 minutes
+	StopTimeout time.Duration
+}
+
+// Clone creates a new Virtual Machine named name from the source Virtual Machine. The OS disk and
+// data disks are copied, and a new network interface is created for each source interface on the
+// same subnets with dynamically allocated addresses. The source must be stopped. Resources created
+// before a failure are removed.
+func (c *VirtualMachineClient) Clone(ctx context.Context, group, sourceName, name string) (vm *compute.VirtualMachine, err error) {
+	if len(name) == 0 {
+		return nil, errors.Wrapf(errors.InvalidInput, "Clone requires a target VM name")
+	}
+	source, err := c.getVirtualMachine(ctx, group, sourceName)
+	if err != nil {
+		return nil, err
+	}
+	if state := GetPowerState(source); state != compute.PowerStateStopped {
+		return nil, errors.Wrapf(errors.InvalidInput, "Virtual Machine [%s] must be stopped to be cloned, current power state [%s]", sourceName, state)
+	}
+	if source.StorageProfile == nil || source.StorageProfile.OsDisk == nil || source.StorageProfile.OsDisk.Vhd == nil || source.StorageProfile.OsDisk.Vhd.URI == nil {
+		return nil, errors.Wrapf(errors.InvalidConfiguration, "Virtual Machine [%s] has no OS disk", sourceName)
+	}
+
+	vhdCli, err := virtualharddisk.NewVirtualHardDiskClient(c.cloudFQDN, c.authorizer)
+	if err != nil {
+		return nil, err
+	}
+	nicCli, err := networkinterface.NewInterfaceClient(c.cloudFQDN, c.authorizer)
+	if err != nil {
+		return nil, err
+	}
+
+	createdDisks := []string{}
+	createdNics := []string{}
+	defer func() {
+		if err == nil {
+			return
+		}
+		for _, nic := range createdNics {
+			if cleanupErr := nicCli.Delete(ctx, group, nic); cleanupErr != nil {
+				log.Printf("Failed to clean up network interface [%s]: %v\n", nic, cleanupErr)
+			}
+		}
+		for _, disk := range createdDisks {
+			if cleanupErr := vhdCli.Delete(ctx, group, "", disk); cleanupErr != nil {
+				log.Printf("Failed to clean up virtual hard disk [%s]: %v\n", disk, cleanupErr)
+			}
+		}
+	}()
+
+	cloneDisk := func(diskName string) (string, error) {
+		target := fmt.Sprintf("%s-%s", name, diskName)
+		if _, err := vhdCli.Clone(ctx, group, "", diskName, target); err != nil {
+			return "", errors.Wrapf(err, "Failed to clone disk [%s]", diskName)
+		}
+		createdDisks = append(createdDisks, target)
+		return target, nil
+	}
+
+	osDisk, err := cloneDisk(*source.StorageProfile.OsDisk.Vhd.URI)
+	if err != nil {
+		return nil, err
+	}
+	dataDisks := []compute.DataDisk{}
+	if source.StorageProfile.DataDisks != nil {
+		for _, disk := range *source.StorageProfile.DataDisks {
+			if disk.Vhd == nil || disk.Vhd.URI == nil {
+				continue
+			}
+			dataDisk, err := cloneDisk(*disk.Vhd.URI)
+			if err != nil {
+				return nil, err
+			}
+			dataDisks = append(dataDisks, compute.DataDisk{Vhd: &compute.VirtualHardDisk{URI: &dataDisk}})
+		}
+	}
+
+	nics := []compute.NetworkInterfaceReference{}
+	if source.NetworkProfile != nil && source.NetworkProfile.NetworkInterfaces != nil {
+		for _, ref := range *source.NetworkProfile.NetworkInterfaces {
+			if ref.ID == nil {
+				continue
+			}
+			sourceNics, err := nicCli.Get(ctx, group, *ref.ID)
+			if err != nil {
+				return nil, err
+			}
+			if sourceNics == nil || len(*sourceNics) == 0 {
+				return nil, errors.Wrapf(errors.NotFound, "Network interface [%s] not found", *ref.ID)
+			}
+			nicName := fmt.Sprintf("%s-%s", name, *ref.ID)
+			if _, err := nicCli.CreateOrUpdate(ctx, group, nicName, cloneNetworkInterface(&(*sourceNics)[0], nicName)); err != nil {
+				return nil, errors.Wrapf(err, "Failed to create network interface [%s]", nicName)
+			}
+			createdNics = append(createdNics, nicName)
+			nics = append(nics, compute.NetworkInterfaceReference{
+				ID:                                  &nicName,
+				NetworkInterfaceReferenceProperties: ref.NetworkInterfaceReferenceProperties,
+			})
+		}
+	}
+
+	target := cloneVirtualMachine(source, name, osDisk, dataDisks, nics)
+	vm, err = c.CreateOrUpdate(ctx, group, name, target)
+	if err != nil {
+		return nil, err
+	}
+	return vm, nil
+}
+
+// cloneVirtualMachine returns a copy of the source VM definition pointing at the cloned resources
+func cloneVirtualMachine(source *compute.VirtualMachine, name, osDisk string, dataDisks []compute.DataDisk, nics []compute.NetworkInterfaceReference) *compute.VirtualMachine {
+	props := *source.VirtualMachineProperties
+	props.ProvisioningState = nil
+	props.ValidationStatus = nil
+	props.Statuses = nil
+	props.GuestAgentInstanceView = nil
+	props.VMID = nil
+	props.Host = nil
+
+	storageProfile := *source.StorageProfile
+	osDiskProfile := *source.StorageProfile.OsDisk
+	osDiskProfile.Name = nil
+	osDiskProfile.Vhd = &compute.VirtualHardDisk{URI: &osDisk}
+	storageProfile.OsDisk = &osDiskProfile
+	storageProfile.DataDisks = &dataDisks
+	// The cloned OS disk already contains the image
+	storageProfile.ImageReference = nil
+	props.StorageProfile = &storageProfile
+
+	props.NetworkProfile = &compute.NetworkProfile{NetworkInterfaces: &nics}
+
+	if source.OsProfile != nil {
+		osProfile := *source.OsProfile
+		computerName := name
+		osProfile.ComputerName = &computerName
+		props.OsProfile = &osProfile
+	}
+
+	return &compute.VirtualMachine{
+		Name:                     &name,
+		Tags:                     source.Tags,
+		Location:                 source.Location,
+		Zones:                    source.Zones,
+		Plan:                     source.Plan,
+		VirtualMachineProperties: &props,
+	}
+}
+
+// cloneNetworkInterface returns a new interface on the same subnets as source. Addresses are
+// allocated dynamically and the MAC address is left for the agent to assign.
+func cloneNetworkInterface(source *network.Interface, name string) *network.Interface {
+	nic := &network.Interface{
+		Name:     &name,
+		Location: source.Location,
+		Tags:     source.Tags,
+	}
+	if source.InterfacePropertiesFormat == nil {
+		return nic
+	}
+
+	ipConfigs := []network.InterfaceIPConfiguration{}
+	if source.IPConfigurations != nil {
+		for _, ipConfig := range *source.IPConfigurations {
+			clone := network.InterfaceIPConfiguration{Name: ipConfig.Name}
+			if ipConfig.InterfaceIPConfigurationPropertiesFormat != nil {
+				dynamic := network.Dynamic
+				clone.InterfaceIPConfigurationPropertiesFormat = &network.InterfaceIPConfigurationPropertiesFormat{
+					Subnet:                    ipConfig.Subnet,
+					Primary:                   ipConfig.Primary,
+					PrivateIPAddressVersion:   ipConfig.PrivateIPAddressVersion,
+					PrivateIPAllocationMethod: &dynamic,
+					NetworkSecurityGroup:      ipConfig.NetworkSecurityGroup,
+				}
+			}
+			ipConfigs = append(ipConfigs, clone)
+		}
+	}
+
+	nic.InterfacePropertiesFormat = &network.InterfacePropertiesFormat{
+		IPConfigurations:               &ipConfigs,
+		DNSSettings:                    source.DNSSettings,
+		EnableAcceleratedNetworking:    source.EnableAcceleratedNetworking,
+		EnableIPForwarding:             source.EnableIPForwarding,
+		EnableMACSpoofing:              source.EnableMACSpoofing,
+		EnableDHCPGuard:                source.EnableDHCPGuard,
+		EnableRouterAdvertisementGuard: source.EnableRouterAdvertisementGuard,
+	}
+	return nic
+}
+
+// CaptureImage creates a gallery image named imageName from the OS disk of the Virtual Machine.
+// A running VM is stopped for the capture and started again afterwards, unless it is generalized.
+// If location is empty, the location of the VM is used.
+func (c *VirtualMachineClient) CaptureImage(ctx context.Context, group, vmName, location, imageName string, options CaptureImageOptions) (*compute.GalleryImage, error) {
+	if len(imageName) == 0 {
+		return nil, errors.Wrapf(errors.InvalidInput, "CaptureImage requires an image name")
+	}
+	if options.StopTimeout <= 0 {
+		options.StopTimeout = 10 * time.Minute
+	}
+
+	vm, err := c.getVirtualMachine(ctx, group, vmName)
+	if err != nil {
+		return nil, err
+	}
+	if vm.StorageProfile == nil || vm.StorageProfile.OsDisk == nil || vm.StorageProfile.OsDisk.Vhd == nil || vm.StorageProfile.OsDisk.Vhd.URI == nil {
+		return nil, errors.Wrapf(errors.InvalidConfiguration, "Virtual Machine [%s] has no OS disk", vmName)
+	}
+	if len(location) == 0 && vm.Location != nil {
+		location = *vm.Location
+	}
+	osType := compute.Windows
+	if vm.OsProfile != nil && len(vm.OsProfile.OsType) > 0 {
+		osType = vm.OsProfile.OsType
+	}
+
+	initialState := GetPowerState(vm)
+	if options.Generalize {
+		if initialState != compute.PowerStateRunning {
+			return nil, errors.Wrapf(errors.InvalidInput, "Virtual Machine [%s] must be running to be generalized, current power state [%s]", vmName, initialState)
+		}
+		if err := c.generalize(ctx, group, vmName, osType); err != nil {
+			return nil, err
+		}
+	}
+	// A generalized guest shuts itself down, stopping it earlier could interrupt the generalization
+	if err := c.stopForCapture(ctx, group, vmName, options.StopTimeout, !options.Generalize); err != nil {
+		return nil, err
+	}
+
+	image, captureErr := c.captureOsDisk(ctx, group, location, imageName, *vm.StorageProfile.OsDisk.Vhd.URI, osType, options.Generalize)
+
+	if !options.Generalize && initialState == compute.PowerStateRunning {
+		if err := c.Start(ctx, group, vmName); err != nil {
+			if captureErr != nil {
+				return nil, captureErr
+			}
+			return image, errors.Wrapf(err, "Image [%s] was captured but Virtual Machine [%s] failed to start", imageName, vmName)
+		}
+	}
+	return image, captureErr
+}
+
+func (c *VirtualMachineClient) generalize(ctx context.Context, group, vmName string, osType compute.OperatingSystemTypes) error {
+	script, ok := generalizeScripts[osType]
+	if !ok {
+		return errors.Wrapf(errors.NotSupported, "Generalize is not supported for OS type [%s]", osType)
+	}
+	response, err := c.RunCommand(ctx, group, vmName, &compute.VirtualMachineRunCommandRequest{
+		Source: &compute.VirtualMachineRunCommandScriptSource{Script: &script},
+	})
+	if err != nil {
+		return errors.Wrapf(err, "Failed to generalize Virtual Machine [%s]", vmName)
+	}
+	if response != nil && response.InstanceView != nil && response.InstanceView.ExecutionState == compute.ExecutionStateFailed {
+		message := ""
+		if response.InstanceView.Error != nil {
+			message = *response.InstanceView.Error
+		}
+		return errors.Wrapf(errors.Failed, "Failed to generalize Virtual Machine [%s]: %s", vmName, message)
+	}
+	return nil
+}
+
+// stopForCapture waits for the VM to stop, and first requests a graceful stop when requestStop is set
+func (c *VirtualMachineClient) stopForCapture(ctx context.Context, group, vmName string, timeout time.Duration, requestStop bool) error {
+	vm, err := c.getVirtualMachine(ctx, group, vmName)
+	if err != nil {
+		return err
+	}
+	switch GetPowerState(vm) {
+	case compute.PowerStateStopped:
+		return nil
+	case compute.PowerStateStopping:
+	default:
+		if !requestStop {
+			break
+		}
+		if err := c.StopGraceful(ctx, group, vmName); err != nil {
+			return err
+		}
+	}
+
+	waitCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	return c.WaitForPowerState(waitCtx, group, vmName, compute.PowerStateStopped)
+}
+
+func (c *VirtualMachineClient) captureOsDisk(ctx context.Context, group, location, imageName, osDiskName string, osType compute.OperatingSystemTypes, generalized bool) (*compute.GalleryImage, error) {
+	vhdCli, err := virtualharddisk.NewVirtualHardDiskClient(c.cloudFQDN, c.authorizer)
+	if err != nil {
+		return nil, err
+	}
+	vhds, err := vhdCli.Get(ctx, group, "", osDiskName)
+	if err != nil {
+		return nil, err
+	}
+	if vhds == nil || len(*vhds) == 0 || (*vhds)[0].VirtualHardDiskProperties == nil || (*vhds)[0].Path == nil {
+		return nil, errors.Wrapf(errors.NotFound, "Path of OS disk [%s] not found", osDiskName)
+	}
+	osDisk := (*vhds)[0]
+
+	osState := compute.Specialized
+	if generalized {
+		osState = compute.Generalized
+	}
+	image := &compute.GalleryImage{
+		Name:     &imageName,
+		Location: &location,
+		GalleryImageProperties: &compute.GalleryImageProperties{
+			OsType:              osType,
+			OsState:             osState,
+			HyperVGeneration:    osDisk.HyperVGeneration,
+			CloudInitDataSource: osDisk.CloudInitDataSource,
+		},
+	}
+
+	imageCli, err := galleryimage.NewGalleryImageClient(c.cloudFQDN, c.authorizer)
+	if err != nil {
+		return nil, err
+	}
+	return imageCli.CreateOrUpdate(ctx, location, *osDisk.Path, imageName, image)
+}
@@ -0,0 +1,104 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the Apache v2.0 License.
+
+package virtualmachine
+
+import (
+	"testing"
+
+	"github.com/microsoft/moc-sdk-for-go/services/compute"
+	"github.com/microsoft/moc-sdk-for-go/services/network"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_cloneVirtualMachine(t *testing.T) {
+	sourceName, computerName, osDisk, dataDisk, nic := "vm1", "vm1", "vm1-os", "vm1-data", "vm1-nic"
+	state, vmID, location, publisher := "CREATED", "1234", "location", "publisher"
+	source := &compute.VirtualMachine{
+		Name:     &sourceName,
+		Location: &location,
+		VirtualMachineProperties: &compute.VirtualMachineProperties{
+			StorageProfile: &compute.StorageProfile{
+				ImageReference: &compute.ImageReference{Publisher: &publisher},
+				OsDisk:         &compute.OSDisk{Name: &osDisk, Vhd: &compute.VirtualHardDisk{URI: &osDisk}},
+				DataDisks:      &[]compute.DataDisk{{Vhd: &compute.VirtualHardDisk{URI: &dataDisk}}},
+			},
+			OsProfile:         &compute.OSProfile{ComputerName: &computerName, OsType: compute.Linux},
+			NetworkProfile:    &compute.NetworkProfile{NetworkInterfaces: &[]compute.NetworkInterfaceReference{{ID: &nic}}},
+			ProvisioningState: &state,
+			VMID:              &vmID,
+			Statuses:          map[string]*string{"PowerState": &state},
+		},
+	}
+
+	clonedDisk, clonedNic := "vm2-vm1-data", "vm2-vm1-nic"
+	dataDisks := []compute.DataDisk{{Vhd: &compute.VirtualHardDisk{URI: &clonedDisk}}}
+	nics := []compute.NetworkInterfaceReference{{ID: &clonedNic}}
+	clone := cloneVirtualMachine(source, "vm2", "vm2-vm1-os", dataDisks, nics)
+
+	assert.Equal(t, "vm2", *clone.Name)
+	assert.Equal(t, "location", *clone.Location)
+	assert.Nil(t, clone.ProvisioningState)
+	assert.Nil(t, clone.VMID)
+	assert.Nil(t, clone.Statuses)
+	assert.Nil(t, clone.StorageProfile.ImageReference)
+	assert.Nil(t, clone.StorageProfile.OsDisk.Name)
+	assert.Equal(t, "vm2-vm1-os", *clone.StorageProfile.OsDisk.Vhd.URI)
+	assert.Equal(t, dataDisks, *clone.StorageProfile.DataDisks)
+	assert.Equal(t, nics, *clone.NetworkProfile.NetworkInterfaces)
+	assert.Equal(t, "vm2", *clone.OsProfile.ComputerName)
+	assert.Equal(t, compute.Linux, clone.OsProfile.OsType)
+
+	// The source definition is left untouched
+	assert.Equal(t, "vm1", *source.OsProfile.ComputerName)
+	assert.Equal(t, "vm1-os", *source.StorageProfile.OsDisk.Vhd.URI)
+	assert.Equal(t, "vm1-os", *source.StorageProfile.OsDisk.Name)
+	assert.NotNil(t, source.StorageProfile.ImageReference)
+	assert.Equal(t, "1234", *source.VMID)
+}
+
+func Test_cloneNetworkInterface(t *testing.T) {
+	location, ipConfigName, subnet, address, mac := "location", "ipconfig1", "/virtualnetworks/vnet1/subnets/subnet1", "10.0.0.4", "00:15:5d:00:00:01"
+	static := network.Static
+	primary, forwarding := true, true
+	source := &network.Interface{
+		Location: &location,
+		InterfacePropertiesFormat: &network.InterfacePropertiesFormat{
+			MacAddress:         &mac,
+			EnableIPForwarding: &forwarding,
+			IPConfigurations: &[]network.InterfaceIPConfiguration{{
+				Name: &ipConfigName,
+				InterfaceIPConfigurationPropertiesFormat: &network.InterfaceIPConfigurationPropertiesFormat{
+					Subnet:                    &network.APIEntityReference{ID: &subnet},
+					PrivateIPAddress:          &address,
+					PrivateIPAllocationMethod: &static,
+					Primary:                   &primary,
+				},
+			}},
+		},
+	}
+
+	nic := cloneNetworkInterface(source, "vm2-nic")
+	assert.Equal(t, "vm2-nic", *nic.Name)
+	assert.Equal(t, "location", *nic.Location)
+	assert.Nil(t, nic.MacAddress)
+	assert.True(t, *nic.EnableIPForwarding)
+	require.Len(t, *nic.IPConfigurations, 1)
+	ipConfig := (*nic.IPConfigurations)[0]
+	assert.Equal(t, "ipconfig1", *ipConfig.Name)
+	assert.Equal(t, subnet, *ipConfig.Subnet.ID)
+	assert.True(t, *ipConfig.Primary)
+	assert.Nil(t, ipConfig.PrivateIPAddress)
+	assert.Equal(t, network.Dynamic, *ipConfig.PrivateIPAllocationMethod)
+
+	nic = cloneNetworkInterface(&network.Interface{Location: &location}, "vm3-nic")
+	assert.Equal(t, "vm3-nic", *nic.Name)
+	assert.Nil(t, nic.InterfacePropertiesFormat)
+}
+
+func Test_generalizeScripts(t *testing.T) {
+	// Both scripts leave the guest shutting down
+	assert.Contains(t, generalizeScripts[compute.Windows], "/shutdown")
+	assert.Contains(t, generalizeScripts[compute.Linux], "shutdown -h")
+}
@@ -0,0 +1,287 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the Apache v2.0 License.
+
+package virtualmachine
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/microsoft/moc-sdk-for-go/services/compute"
+	"github.com/microsoft/moc/pkg/errors"
+)
+
+// FleetOperation is a lifecycle operation applied to a set of virtual machines
+type FleetOperation string
+
+const (
+	FleetOperationStart        FleetOperation = "Start"
+	FleetOperationStopGraceful FleetOperation = "StopGraceful"
+	FleetOperationRestart      FleetOperation = "Restart"
+	FleetOperationSave         FleetOperation = "Save"
+)
+
+// DefaultFleetWaitTimeout is how long Start and Restart wait for a VM to be running when FleetOptions
+// does not set WaitTimeout
+const DefaultFleetWaitTimeout = 10 * time.Minute
+
+// FleetSelector selects the virtual machines a fleet operation applies to
+type FleetSelector struct {
+	// Group - Resource group of the virtual machines
+	Group string
+	// Query - Optional JMESPath query used to filter the virtual machines in the group
+	Query string
+	// Tags - Optional tags that a virtual machine must carry, with matching values, to be selected
+	Tags map[string]string
+}
+
+// FleetOptions bounds the concurrency of a fleet operation
+type FleetOptions struct {
+	// MaxPerHostNode - Maximum number of in-flight operations per host node, 0 means unbounded
+	MaxPerHostNode int
+	// MaxPerAvailabilitySet - Maximum number of in-flight operations per availability set, 0 means unbounded
+	MaxPerAvailabilitySet int
+	// MaxConcurrency - Maximum number of in-flight operations overall, 0 means unbounded
+	MaxConcurrency int
+	// WaitTimeout - How long Start and Restart hold their slot waiting for the VM to be running, 0 means
+	// DefaultFleetWaitTimeout. A VM that is not running when the timeout expires fails the operation.
+	WaitTimeout time.Duration
+}
+
+// FleetOutcome is the result of a fleet operation on a single virtual machine
+type FleetOutcome struct {
+	Name            string
+	HostNodeName    string
+	AvailabilitySet string
+	StartTime       time.Time
+	EndTime         time.Time
+	Error           error
+}
+
+// fleetMember is a selected virtual machine with its fault domains resolved
+type fleetMember struct {
+	name            string
+	hostNodeName    string
+	availabilitySet string
+}
+
+// RunFleetOperation applies op to every virtual machine matched by selector, keeping at most
+// the configured number of operations in flight per host node and per availability set.
+// Virtual machines on an unknown host node or outside an availability set are only bound by
+// MaxConcurrency. Outcomes are returned in selection order; the returned error is only set
+// when the selection itself fails.
+func (c *VirtualMachineClient) RunFleetOperation(ctx context.Context, selector FleetSelector, op FleetOperation, options FleetOptions) ([]FleetOutcome, error) {
+	if len(selector.Group) == 0 {
+		return nil, errors.Wrapf(errors.InvalidGroup, "Group not specified")
+	}
+	if options.MaxPerHostNode < 0 || options.MaxPerAvailabilitySet < 0 || options.MaxConcurrency < 0 {
+		return nil, errors.Wrapf(errors.InvalidInput, "Fleet concurrency limits cannot be negative")
+	}
+	if options.WaitTimeout < 0 {
+		return nil, errors.Wrapf(errors.InvalidInput, "Fleet wait timeout cannot be negative")
+	}
+	opFunc, err := c.getFleetOperationFunc(selector.Group, op, options.WaitTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	members, err := c.selectFleetMembers(ctx, selector)
+	if err != nil {
+		return nil, err
+	}
+
+	return runFleetMembers(ctx, members, options, opFunc), nil
+}
+
+func (c *VirtualMachineClient) getFleetOperationFunc(group string, op FleetOperation, waitTimeout time.Duration) (func(context.Context, string) error, error) {
+	if waitTimeout == 0 {
+		waitTimeout = DefaultFleetWaitTimeout
+	}
+	waitRunning := func(ctx context.Context, name string) error {
+		waitCtx, cancel := context.WithTimeout(ctx, waitTimeout)
+		defer cancel()
+		return c.WaitForPowerState(waitCtx, group, name, compute.PowerStateRunning)
+	}
+
+	switch op {
+	case FleetOperationStart:
+		return func(ctx context.Context, name string) error {
+			if err := c.Start(ctx, group, name); err != nil {
+				return err
+			}
+			return waitRunning(ctx, name)
+		}, nil
+	case FleetOperationRestart:
+		return func(ctx context.Context, name string) error {
+			if err := c.Restart(ctx, group, name); err != nil {
+				return err
+			}
+			return waitRunning(ctx, name)
+		}, nil
+	case FleetOperationStopGraceful:
+		return func(ctx context.Context, name string) error {
+			return c.StopGraceful(ctx, group, name)
+		}, nil
+	case FleetOperationSave:
+		return func(ctx context.Context, name string) error {
+			return c.Save(ctx, group, name)
+		}, nil
+	default:
+		return nil, errors.Wrapf(errors.NotSupported, "Unknown fleet operation [%s]", op)
+	}
+}
+
+func (c *VirtualMachineClient) selectFleetMembers(ctx context.Context, selector FleetSelector) ([]fleetMember, error) {
+	var vms *[]compute.VirtualMachine
+	var err error
+	if len(selector.Query) > 0 {
+		vms, err = c.Query(ctx, selector.Group, selector.Query)
+	} else {
+		vms, err = c.Get(ctx, selector.Group, "")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if vms == nil {
+		return nil, nil
+	}
+
+	members := []fleetMember{}
+	for i := range *vms {
+		vm := &(*vms)[i]
+		if vm.Name == nil || !matchesTags(vm.Tags, selector.Tags) {
+			continue
+		}
+
+		member := fleetMember{name: *vm.Name}
+		if vm.VirtualMachineProperties != nil {
+			if vm.Host != nil && vm.Host.ID != nil {
+				member.hostNodeName = *vm.Host.ID
+			}
+			if vm.AvailabilitySetProfile != nil && vm.AvailabilitySetProfile.Name != nil {
+				member.availabilitySet = *vm.AvailabilitySetProfile.Name
+			}
+		}
+		if len(member.hostNodeName) == 0 {
+			hostNode, err := c.GetHostNodeName(ctx, selector.Group, member.name)
+			if err != nil {
+				return nil, errors.Wrapf(err, "Failed to get host node of Virtual Machine [%s]", member.name)
+			}
+			if hostNode.HostNodeName != nil {
+				member.hostNodeName = *hostNode.HostNodeName
+			}
+		}
+		members = append(members, member)
+	}
+	return members, nil
+}
+
+func matchesTags(tags map[string]*string, required map[string]string) bool {
+	for key, value := range required {
+		tag, ok := tags[key]
+		if !ok || tag == nil || *tag != value {
+			return false
+		}
+	}
+	return true
+}
+
+// fleetLimiter tracks in-flight operations per fault domain
+type fleetLimiter struct {
+	options   FleetOptions
+	total     int
+	hostNodes map[string]int
+	sets      map[string]int
+}
+
+func newFleetLimiter(options FleetOptions) *fleetLimiter {
+	return &fleetLimiter{
+		options:   options,
+		hostNodes: map[string]int{},
+		sets:      map[string]int{},
+	}
+}
+
+func (l *fleetLimiter) canAcquire(m fleetMember) bool {
+	if l.options.MaxConcurrency > 0 && l.total >= l.options.MaxConcurrency {
+		return false
+	}
+	if l.options.MaxPerHostNode > 0 && len(m.hostNodeName) > 0 && l.hostNodes[m.hostNodeName] >= l.options.MaxPerHostNode {
+		return false
+	}
+	if l.options.MaxPerAvailabilitySet > 0 && len(m.availabilitySet) > 0 && l.sets[m.availabilitySet] >= l.options.MaxPerAvailabilitySet {
+		return false
+	}
+	return true
+}
+
+func (l *fleetLimiter) acquire(m fleetMember) {
+	l.total++
+	l.hostNodes[m.hostNodeName]++
+	l.sets[m.availabilitySet]++
+}
+
+func (l *fleetLimiter) release(m fleetMember) {
+	l.total--
+	l.hostNodes[m.hostNodeName]--
+	l.sets[m.availabilitySet]--
+}
+
+// runFleetMembers runs opFunc on every member while honouring the limits in options
+func runFleetMembers(ctx context.Context, members []fleetMember, options FleetOptions, opFunc func(context.Context, string) error) []FleetOutcome {
+	outcomes := make([]FleetOutcome, len(members))
+	for i, m := range members {
+		outcomes[i] = FleetOutcome{
+			Name:            m.name,
+			HostNodeName:    m.hostNodeName,
+			AvailabilitySet: m.availabilitySet,
+		}
+	}
+
+	limiter := newFleetLimiter(options)
+	done := make(chan int)
+	pending := make([]int, len(members))
+	for i := range members {
+		pending[i] = i
+	}
+
+	var wg sync.WaitGroup
+	inFlight := 0
+	for len(pending) > 0 || inFlight > 0 {
+		if ctx.Err() == nil {
+			remaining := pending[:0]
+			for _, i := range pending {
+				if !limiter.canAcquire(members[i]) {
+					remaining = append(remaining, i)
+					continue
+				}
+				limiter.acquire(members[i])
+				inFlight++
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					outcomes[i].StartTime = time.Now()
+					outcomes[i].Error = opFunc(ctx, members[i].name)
+					outcomes[i].EndTime = time.Now()
+					done <- i
+				}(i)
+			}
+			pending = remaining
+		} else {
+			for _, i := range pending {
+				outcomes[i].Error = errors.Wrapf(ctx.Err(), "Fleet operation was not started on Virtual Machine [%s]", members[i].name)
+			}
+			pending = nil
+		}
+
+		if inFlight == 0 {
+			continue
+		}
+		i := <-done
+		limiter.release(members[i])
+		inFlight--
+	}
+	wg.Wait()
+	return outcomes
+}
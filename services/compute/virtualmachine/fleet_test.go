@@ -0,0 +1,173 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the Apache v2.0 License.
+
+package virtualmachine
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/microsoft/moc-sdk-for-go/services/compute"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_runFleetMembersHonoursLimits(t *testing.T) {
+	members := []fleetMember{}
+	for i := 0; i < 12; i++ {
+		members = append(members, fleetMember{
+			name:            fmt.Sprintf("vm%d", i),
+			hostNodeName:    fmt.Sprintf("node%d", i%2),
+			availabilitySet: fmt.Sprintf("avset%d", i%3),
+		})
+	}
+	byName := map[string]fleetMember{}
+	for _, m := range members {
+		byName[m.name] = m
+	}
+
+	var mu sync.Mutex
+	nodes := map[string]int{}
+	sets := map[string]int{}
+	maxNode, maxSet := 0, 0
+	opFunc := func(ctx context.Context, name string) error {
+		m := byName[name]
+		mu.Lock()
+		nodes[m.hostNodeName]++
+		sets[m.availabilitySet]++
+		if nodes[m.hostNodeName] > maxNode {
+			maxNode = nodes[m.hostNodeName]
+		}
+		if sets[m.availabilitySet] > maxSet {
+			maxSet = sets[m.availabilitySet]
+		}
+		mu.Unlock()
+
+		time.Sleep(5 * time.Millisecond)
+
+		mu.Lock()
+		nodes[m.hostNodeName]--
+		sets[m.availabilitySet]--
+		mu.Unlock()
+		if name == "vm3" {
+			return fmt.Errorf("failed")
+		}
+		return nil
+	}
+
+	outcomes := runFleetMembers(context.Background(), members, FleetOptions{MaxPerHostNode: 2, MaxPerAvailabilitySet: 1}, opFunc)
+
+	assert.Len(t, outcomes, len(members))
+	assert.LessOrEqual(t, maxNode, 2)
+	assert.LessOrEqual(t, maxSet, 1)
+	for i, outcome := range outcomes {
+		assert.Equal(t, members[i].name, outcome.Name)
+		if outcome.Name == "vm3" {
+			assert.Error(t, outcome.Error)
+		} else {
+			assert.NoError(t, outcome.Error)
+		}
+	}
+}
+
+func Test_runFleetMembersCancelled(t *testing.T) {
+	members := []fleetMember{{name: "vm0", hostNodeName: "node0"}, {name: "vm1", hostNodeName: "node0"}}
+	ctx, cancel := context.WithCancel(context.Background())
+
+	outcomes := runFleetMembers(ctx, members, FleetOptions{MaxPerHostNode: 1}, func(ctx context.Context, name string) error {
+		cancel()
+		return nil
+	})
+
+	assert.NoError(t, outcomes[0].Error)
+	assert.Error(t, outcomes[1].Error)
+}
+
+// fakeFleetAgent returns from Start before the VM is running: the VM reports Starting for the next
+// startingGets Gets. Methods outside the fleet operations are left to the embedded nil Service.
+type fakeFleetAgent struct {
+	Service
+	mu           sync.Mutex
+	startingGets int
+	states       map[string]string
+	gets         map[string]int
+	// overlapped is set when a VM was started while another VM was not yet running
+	overlapped bool
+}
+
+func (f *fakeFleetAgent) Get(ctx context.Context, group, name string) (*[]compute.VirtualMachine, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.states[name] == "Starting" {
+		f.gets[name]++
+		if f.gets[name] > f.startingGets {
+			f.states[name] = "Running"
+		}
+	}
+	state := f.states[name]
+	return &[]compute.VirtualMachine{{
+		Name:                     &name,
+		VirtualMachineProperties: &compute.VirtualMachineProperties{Statuses: map[string]*string{"PowerState": &state}},
+	}}, nil
+}
+
+func (f *fakeFleetAgent) StopGraceful(ctx context.Context, group, name string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.states[name] = "Stopped"
+	return nil
+}
+
+func (f *fakeFleetAgent) Start(ctx context.Context, group, name string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for other, state := range f.states {
+		if other != name && state != "Running" {
+			f.overlapped = true
+		}
+	}
+	f.states[name] = "Starting"
+	f.gets[name] = 0
+	return nil
+}
+
+func Test_runFleetMembersWaitsForRunning(t *testing.T) {
+	defer func(interval time.Duration) { powerStatePollInterval = interval }(powerStatePollInterval)
+	powerStatePollInterval = time.Millisecond
+
+	agent := &fakeFleetAgent{
+		startingGets: 3,
+		states:       map[string]string{"vm0": "Running", "vm1": "Running"},
+		gets:         map[string]int{},
+	}
+	c := &VirtualMachineClient{internal: agent}
+	members := []fleetMember{{name: "vm0", hostNodeName: "node0"}, {name: "vm1", hostNodeName: "node0"}}
+
+	opFunc, err := c.getFleetOperationFunc("group", FleetOperationRestart, 0)
+	assert.NoError(t, err)
+	outcomes := runFleetMembers(context.Background(), members, FleetOptions{MaxPerHostNode: 1}, opFunc)
+
+	assert.NoError(t, outcomes[0].Error)
+	assert.NoError(t, outcomes[1].Error)
+	assert.False(t, agent.overlapped, "vm1 was restarted before vm0 was running again")
+	assert.Equal(t, "Running", agent.states["vm0"])
+	assert.Equal(t, "Running", agent.states["vm1"])
+
+	agent.startingGets = 1000
+	opFunc, err = c.getFleetOperationFunc("group", FleetOperationRestart, 10*time.Millisecond)
+	assert.NoError(t, err)
+	outcomes = runFleetMembers(context.Background(), members[:1], FleetOptions{}, opFunc)
+	assert.ErrorIs(t, outcomes[0].Error, context.DeadlineExceeded)
+}
+
+func Test_matchesTags(t *testing.T) {
+	prod := "prod"
+	tags := map[string]*string{"env": &prod}
+
+	assert.True(t, matchesTags(tags, nil))
+	assert.True(t, matchesTags(tags, map[string]string{"env": "prod"}))
+	assert.False(t, matchesTags(tags, map[string]string{"env": "dev"}))
+	assert.False(t, matchesTags(tags, map[string]string{"tier": "web"}))
+}
@@ -0,0 +1,179 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the Apache v2.0 License.
+
+package virtualmachine
+
+import (
+	"context"
+	"time"
+
+	"github.com/microsoft/moc-sdk-for-go/services/compute"
+	"github.com/microsoft/moc/pkg/errors"
+)
+
+// HardwareChangeResult reports the outcome of a targeted hardware change
+type HardwareChangeResult struct {
+	// RestartRequired - The change was persisted but only takes effect once the VM is restarted
+	RestartRequired bool
+}
+
+// hardwareChange mutates the hardware profile of vm in place and reports whether the
+// change can only be applied while the VM is off
+type hardwareChange func(vm *compute.VirtualMachine) (offlineOnly bool, err error)
+
+// AttachGPU assigns a GPU to the Virtual Machine with the given assignment mode.
+// partitionSizeMB is only used by partitioned assignments and may be 0.
+func (c *VirtualMachineClient) AttachGPU(ctx context.Context, group, vmName, gpuName string, assignment compute.Assignment, partitionSizeMB uint64) (*HardwareChangeResult, error) {
+	switch assignment {
+	case compute.GpuDDA, compute.GpuP, compute.GpuPV, compute.GpuDefault:
+	default:
+		return nil, errors.Wrapf(errors.InvalidInput, "Unsupported GPU assignment type [%s]", assignment)
+	}
+	if assignment == compute.GpuDDA && len(gpuName) == 0 {
+		return nil, errors.Wrapf(errors.InvalidInput, "GPU name is required for assignment [%s]", assignment)
+	}
+
+	return c.applyHardwareChange(ctx, group, vmName, func(vm *compute.VirtualMachine) (bool, error) {
+		return attachGPU(vm.HardwareProfile, vmName, gpuName, assignment, partitionSizeMB)
+	})
+}
+
+// attachGPU adds the GPU to the hardware profile
+func attachGPU(hw *compute.HardwareProfile, vmName, gpuName string, assignment compute.Assignment, partitionSizeMB uint64) (offlineOnly bool, err error) {
+	for _, gpu := range hw.VirtualMachineGPUs {
+		if gpu != nil && gpu.Name != nil && len(gpuName) > 0 && *gpu.Name == gpuName {
+			return false, errors.Wrapf(errors.AlreadyExists, "GPU [%s] is already attached to the VM [%s]", gpuName, vmName)
+		}
+	}
+	name := gpuName
+	mode := assignment
+	size := partitionSizeMB
+	hw.VirtualMachineGPUs = append(hw.VirtualMachineGPUs, &compute.VirtualMachineGPU{
+		Assignment:      &mode,
+		PartitionSizeMB: &size,
+		Name:            &name,
+	})
+	// Hyper-V only assigns or partitions GPUs while the VM is off
+	return true, nil
+}
+
+// DetachGPU removes the named GPU from the Virtual Machine
+func (c *VirtualMachineClient) DetachGPU(ctx context.Context, group, vmName, gpuName string) (*HardwareChangeResult, error) {
+	if len(gpuName) == 0 {
+		return nil, errors.Wrapf(errors.InvalidInput, "GPU name is not specified")
+	}
+
+	return c.applyHardwareChange(ctx, group, vmName, func(vm *compute.VirtualMachine) (bool, error) {
+		return detachGPU(vm.HardwareProfile, vmName, gpuName)
+	})
+}
+
+// detachGPU removes the named GPU from the hardware profile
+func detachGPU(hw *compute.HardwareProfile, vmName, gpuName string) (offlineOnly bool, err error) {
+	gpus := hw.VirtualMachineGPUs
+	for i, gpu := range gpus {
+		if gpu != nil && gpu.Name != nil && *gpu.Name == gpuName {
+			hw.VirtualMachineGPUs = append(gpus[:i:i], gpus[i+1:]...)
+			return true, nil
+		}
+	}
+	return false, errors.Wrapf(errors.NotFound, "GPU [%s] is not attached to the VM [%s]", gpuName, vmName)
+}
+
+// SetDynamicMemory enables or updates dynamic memory on the Virtual Machine. Passing nil disables it.
+func (c *VirtualMachineClient) SetDynamicMemory(ctx context.Context, group, vmName string, config *compute.DynamicMemoryConfiguration) (*HardwareChangeResult, error) {
+	if err := validateDynamicMemoryConfiguration(config); err != nil {
+		return nil, err
+	}
+
+	return c.applyHardwareChange(ctx, group, vmName, func(vm *compute.VirtualMachine) (bool, error) {
+		return setDynamicMemory(vm.HardwareProfile, config), nil
+	})
+}
+
+// setDynamicMemory replaces the dynamic memory configuration of the hardware profile
+func setDynamicMemory(hw *compute.HardwareProfile, config *compute.DynamicMemoryConfiguration) (offlineOnly bool) {
+	// Enabling or disabling dynamic memory needs the VM off; adjusting an existing configuration does not
+	offlineOnly = (hw.DynamicMemoryConfig == nil) != (config == nil)
+	hw.DynamicMemoryConfig = config
+	return offlineOnly
+}
+
+// SetCPUCount changes the virtual processor count of a Virtual Machine with a Custom size
+func (c *VirtualMachineClient) SetCPUCount(ctx context.Context, group, vmName string, cpuCount int32) (*HardwareChangeResult, error) {
+	if cpuCount <= 0 {
+		return nil, errors.Wrapf(errors.InvalidInput, "CPU count must be greater than 0")
+	}
+
+	return c.applyHardwareChange(ctx, group, vmName, func(vm *compute.VirtualMachine) (bool, error) {
+		return setCPUCount(vm.HardwareProfile, vmName, cpuCount)
+	})
+}
+
+// setCPUCount changes the virtual processor count of the Custom size of the hardware profile
+func setCPUCount(hw *compute.HardwareProfile, vmName string, cpuCount int32) (offlineOnly bool, err error) {
+	if hw.VMSize != compute.VirtualMachineSizeTypesCustom || hw.CustomSize == nil || hw.CustomSize.MemoryMB == nil {
+		return false, errors.Wrapf(errors.InvalidInput, "Virtual Machine [%s] does not use a Custom size, use Resize to change its size", vmName)
+	}
+	count := cpuCount
+	hw.CustomSize = &compute.VirtualMachineCustomSize{
+		CpuCount: &count,
+		MemoryMB: hw.CustomSize.MemoryMB,
+	}
+	// Hyper-V does not support hot-adding virtual processors
+	return true, nil
+}
+
+func validateDynamicMemoryConfiguration(config *compute.DynamicMemoryConfiguration) error {
+	if config == nil {
+		return nil
+	}
+	if config.MinimumMemoryMB != nil && config.MaximumMemoryMB != nil && *config.MinimumMemoryMB > *config.MaximumMemoryMB {
+		return errors.Wrapf(errors.InvalidInput, "Minimum memory [%d MB] is greater than maximum memory [%d MB]", *config.MinimumMemoryMB, *config.MaximumMemoryMB)
+	}
+	if config.TargetMemoryBuffer != nil && (*config.TargetMemoryBuffer < 5 || *config.TargetMemoryBuffer > 2000) {
+		return errors.Wrapf(errors.InvalidInput, "Target memory buffer [%d] must be between 5 and 2000 percent", *config.TargetMemoryBuffer)
+	}
+	return nil
+}
+
+// applyHardwareChange runs a read-modify-write of the VM's hardware profile. The modified VM is
+// prechecked against node capacity before it is pushed, and the update is retried on stale versions.
+func (c *VirtualMachineClient) applyHardwareChange(ctx context.Context, group, vmName string, change hardwareChange) (*HardwareChangeResult, error) {
+	for {
+		vm, err := c.getVirtualMachine(ctx, group, vmName)
+		if err != nil {
+			return nil, err
+		}
+		if vm.VirtualMachineProperties == nil {
+			return nil, errors.Wrapf(errors.InvalidInput, "Virtual Machine [%s] has no properties", vmName)
+		}
+		if vm.HardwareProfile == nil {
+			vm.HardwareProfile = &compute.HardwareProfile{VMSize: compute.VirtualMachineSizeTypesDefault}
+		}
+
+		offlineOnly, err := change(vm)
+		if err != nil {
+			return nil, err
+		}
+
+		if _, err = c.Precheck(ctx, group, []*compute.VirtualMachine{vm}); err != nil {
+			return nil, errors.Wrapf(err, "Hardware change on Virtual Machine [%s] failed precheck", vmName)
+		}
+
+		_, err = c.CreateOrUpdate(ctx, group, vmName, vm)
+		if err != nil {
+			if errors.IsInvalidVersion(err) {
+				// Retry only on invalid version
+				time.Sleep(100 * time.Millisecond)
+				continue
+			}
+			return nil, err
+		}
+
+		powerState := GetPowerState(vm)
+		return &HardwareChangeResult{
+			RestartRequired: offlineOnly && powerState != compute.PowerStateStopped,
+		}, nil
+	}
+}
@@ -0,0 +1,103 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the Apache v2.0 License.
+
+package virtualmachine
+
+import (
+	"testing"
+
+	"github.com/microsoft/moc-sdk-for-go/services/compute"
+	"github.com/microsoft/moc/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_validateDynamicMemoryConfiguration(t *testing.T) {
+	min := uint64(1024)
+	max := uint64(4096)
+	buffer := uint32(20)
+	badBuffer := uint32(1)
+
+	assert.NoError(t, validateDynamicMemoryConfiguration(nil))
+	assert.NoError(t, validateDynamicMemoryConfiguration(&compute.DynamicMemoryConfiguration{
+		MinimumMemoryMB:    &min,
+		MaximumMemoryMB:    &max,
+		TargetMemoryBuffer: &buffer,
+	}))
+	assert.Error(t, validateDynamicMemoryConfiguration(&compute.DynamicMemoryConfiguration{
+		MinimumMemoryMB: &max,
+		MaximumMemoryMB: &min,
+	}))
+	assert.Error(t, validateDynamicMemoryConfiguration(&compute.DynamicMemoryConfiguration{
+		TargetMemoryBuffer: &badBuffer,
+	}))
+}
+
+func Test_attachGPU(t *testing.T) {
+	hw := &compute.HardwareProfile{}
+	offlineOnly, err := attachGPU(hw, "vm1", "gpu1", compute.GpuDDA, 0)
+	require.NoError(t, err)
+	assert.True(t, offlineOnly)
+	require.Len(t, hw.VirtualMachineGPUs, 1)
+	assert.Equal(t, "gpu1", *hw.VirtualMachineGPUs[0].Name)
+	assert.Equal(t, compute.GpuDDA, *hw.VirtualMachineGPUs[0].Assignment)
+
+	_, err = attachGPU(hw, "vm1", "gpu1", compute.GpuDDA, 0)
+	assert.ErrorIs(t, err, errors.AlreadyExists)
+	assert.Len(t, hw.VirtualMachineGPUs, 1)
+
+	// Unnamed partitions never collide
+	_, err = attachGPU(hw, "vm1", "", compute.GpuP, 1024)
+	require.NoError(t, err)
+	_, err = attachGPU(hw, "vm1", "", compute.GpuP, 1024)
+	require.NoError(t, err)
+	require.Len(t, hw.VirtualMachineGPUs, 3)
+	assert.Equal(t, uint64(1024), *hw.VirtualMachineGPUs[2].PartitionSizeMB)
+}
+
+func Test_detachGPU(t *testing.T) {
+	gpu1, gpu2 := "gpu1", "gpu2"
+	hw := &compute.HardwareProfile{VirtualMachineGPUs: []*compute.VirtualMachineGPU{{Name: &gpu1}, nil, {Name: &gpu2}}}
+
+	offlineOnly, err := detachGPU(hw, "vm1", "gpu1")
+	require.NoError(t, err)
+	assert.True(t, offlineOnly)
+	require.Len(t, hw.VirtualMachineGPUs, 2)
+	assert.Equal(t, "gpu2", *hw.VirtualMachineGPUs[1].Name)
+
+	_, err = detachGPU(hw, "vm1", "gpu1")
+	assert.ErrorIs(t, err, errors.NotFound)
+	assert.Len(t, hw.VirtualMachineGPUs, 2)
+}
+
+func Test_setDynamicMemory(t *testing.T) {
+	hw := &compute.HardwareProfile{}
+	config := &compute.DynamicMemoryConfiguration{}
+
+	assert.True(t, setDynamicMemory(hw, config))
+	assert.Equal(t, config, hw.DynamicMemoryConfig)
+	assert.False(t, setDynamicMemory(hw, &compute.DynamicMemoryConfiguration{}))
+	assert.True(t, setDynamicMemory(hw, nil))
+	assert.Nil(t, hw.DynamicMemoryConfig)
+}
+
+func Test_setCPUCount(t *testing.T) {
+	memoryMB := int32(4096)
+	_, err := setCPUCount(&compute.HardwareProfile{VMSize: compute.VirtualMachineSizeTypesDefault}, "vm1", 4)
+	assert.ErrorIs(t, err, errors.InvalidInput)
+	_, err = setCPUCount(&compute.HardwareProfile{VMSize: compute.VirtualMachineSizeTypesCustom}, "vm1", 4)
+	assert.ErrorIs(t, err, errors.InvalidInput)
+
+	cpuCount := int32(2)
+	hw := &compute.HardwareProfile{
+		VMSize:     compute.VirtualMachineSizeTypesCustom,
+		CustomSize: &compute.VirtualMachineCustomSize{CpuCount: &cpuCount, MemoryMB: &memoryMB},
+	}
+	offlineOnly, err := setCPUCount(hw, "vm1", 4)
+	require.NoError(t, err)
+	assert.True(t, offlineOnly)
+	assert.Equal(t, int32(4), *hw.CustomSize.CpuCount)
+	assert.Equal(t, int32(4096), *hw.CustomSize.MemoryMB)
+	// The previous size is not modified in place
+	assert.Equal(t, int32(2), cpuCount)
+}
@@ -0,0 +1,300 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the Apache v2.0 License.
+
+package virtualmachine
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/microsoft/moc-sdk-for-go/services/compute"
+	"github.com/microsoft/moc-sdk-for-go/services/network/networkinterface"
+	"github.com/microsoft/moc-sdk-for-go/services/storage/virtualharddisk"
+	"github.com/microsoft/moc/pkg/errors"
+)
+
+// PowerOperation is an operation that changes the power state of a virtual machine
+type PowerOperation string
+
+const (
+	PowerOperationStart        PowerOperation = "Start"
+	PowerOperationStop         PowerOperation = "Stop"
+	PowerOperationStopGraceful PowerOperation = "StopGraceful"
+	PowerOperationPause        PowerOperation = "Pause"
+	PowerOperationSave         PowerOperation = "Save"
+)
+
+// powerStateTransitions lists the power states from which each operation may be issued.
+// Re-issuing an operation while the VM is already in (or moving to) its target state is
+// allowed so that callers stay idempotent.
+var powerStateTransitions = map[PowerOperation][]compute.VirtualMachinePowerState{
+	PowerOperationStart:        {compute.PowerStateStopped, compute.PowerStatePaused, compute.PowerStateSaved, compute.PowerStateRunning, compute.PowerStateStarting},
+	PowerOperationStop:         {compute.PowerStateRunning, compute.PowerStateStopped, compute.PowerStatePaused, compute.PowerStateSaved, compute.PowerStateStarting, compute.PowerStateStopping},
+	PowerOperationStopGraceful: {compute.PowerStateRunning, compute.PowerStateStopped, compute.PowerStateStopping},
+	PowerOperationPause:        {compute.PowerStateRunning, compute.PowerStatePaused},
+	PowerOperationSave:         {compute.PowerStateRunning, compute.PowerStatePaused, compute.PowerStateSaved},
+}
+
+// powerStatePollInterval is the interval between Gets while waiting for a power state
+var powerStatePollInterval = 2 * time.Second
+
+// ParsePowerState converts the PowerState status reported by the agent into a typed power state
+func ParsePowerState(state string) compute.VirtualMachinePowerState {
+	switch strings.ToLower(strings.TrimSpace(state)) {
+	case "running":
+		return compute.PowerStateRunning
+	case "off", "stopped":
+		return compute.PowerStateStopped
+	case "paused":
+		return compute.PowerStatePaused
+	case "saved":
+		return compute.PowerStateSaved
+	case "starting":
+		return compute.PowerStateStarting
+	case "stopping":
+		return compute.PowerStateStopping
+	default:
+		return compute.PowerStateUnknown
+	}
+}
+
+// GetPowerState returns the typed power state of the virtual machine
+func GetPowerState(vm *compute.VirtualMachine) compute.VirtualMachinePowerState {
+	if vm == nil || vm.VirtualMachineProperties == nil || vm.Statuses == nil {
+		return compute.PowerStateUnknown
+	}
+	state, ok := vm.Statuses["PowerState"]
+	if !ok || state == nil {
+		return compute.PowerStateUnknown
+	}
+	return ParsePowerState(*state)
+}
+
+// ValidatePowerStateTransition returns an error if the operation cannot be issued while
+// the virtual machine is in the given power state. An unknown state is never rejected.
+func ValidatePowerStateTransition(current compute.VirtualMachinePowerState, op PowerOperation) error {
+	allowed, ok := powerStateTransitions[op]
+	if !ok {
+		return errors.Wrapf(errors.NotSupported, "Unknown power operation [%s]", op)
+	}
+	if current == compute.PowerStateUnknown || current == "" {
+		return nil
+	}
+	for _, state := range allowed {
+		if state == current {
+			return nil
+		}
+	}
+	return errors.Wrapf(errors.InvalidInput, "Unable to %s a Virtual Machine in power state [%s]", op, current)
+}
+
+// GetGuestAgentHealth summarizes the statuses reported by the guest agent
+func GetGuestAgentHealth(view *compute.GuestAgentInstanceView) compute.GuestAgentHealthState {
+	if view == nil || len(view.Statuses) == 0 {
+		return compute.GuestAgentHealthUnknown
+	}
+	health := compute.GuestAgentHealthUnknown
+	for _, status := range view.Statuses {
+		if status == nil {
+			continue
+		}
+		switch status.Level {
+		case compute.StatusLevelError:
+			return compute.GuestAgentHealthUnhealthy
+		case compute.StatusLevelWarning:
+			health = compute.GuestAgentHealthDegraded
+		case compute.StatusLevelInfo:
+			if health == compute.GuestAgentHealthUnknown {
+				health = compute.GuestAgentHealthHealthy
+			}
+		}
+	}
+	return health
+}
+
+// getGuestAgentUptime approximates the uptime from the oldest status timestamp reported by the guest agent
+func getGuestAgentUptime(view *compute.GuestAgentInstanceView, now time.Time) *time.Duration {
+	if view == nil {
+		return nil
+	}
+	var oldest time.Time
+	for _, status := range view.Statuses {
+		if status == nil || len(status.Time) == 0 {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, status.Time)
+		if err != nil {
+			continue
+		}
+		if oldest.IsZero() || t.Before(oldest) {
+			oldest = t
+		}
+	}
+	if oldest.IsZero() || oldest.After(now) {
+		return nil
+	}
+	uptime := now.Sub(oldest)
+	return &uptime
+}
+
+// InstanceView returns the runtime state of the Virtual Machine, its disks and network interfaces
+func (c *VirtualMachineClient) InstanceView(ctx context.Context, group, name string) (*compute.VirtualMachineInstanceView, error) {
+	vm, err := c.getVirtualMachine(ctx, group, name)
+	if err != nil {
+		return nil, err
+	}
+
+	view := &compute.VirtualMachineInstanceView{
+		PowerState:             GetPowerState(vm),
+		ProvisioningState:      vm.ProvisioningState,
+		GuestAgentHealth:       GetGuestAgentHealth(vm.GuestAgentInstanceView),
+		GuestAgentInstanceView: vm.GuestAgentInstanceView,
+	}
+	if vm.Host != nil && vm.Host.ID != nil && len(*vm.Host.ID) > 0 {
+		view.HostNodeName = vm.Host.ID
+	} else {
+		hostNode, err := c.GetHostNodeName(ctx, group, name)
+		if err != nil {
+			return nil, err
+		}
+		view.HostNodeName = hostNode.HostNodeName
+	}
+	if view.PowerState == compute.PowerStateRunning {
+		view.Uptime = getGuestAgentUptime(vm.GuestAgentInstanceView, time.Now())
+	}
+
+	view.Disks, err = c.getDiskInstanceViews(ctx, group, vm)
+	if err != nil {
+		return nil, err
+	}
+	view.NetworkInterfaces, err = c.getNetworkInterfaceInstanceViews(ctx, group, vm)
+	if err != nil {
+		return nil, err
+	}
+	return view, nil
+}
+
+// WaitForPowerState polls the Virtual Machine until it reaches the requested power state
+// or the context is done. Use a context deadline to bound the wait.
+func (c *VirtualMachineClient) WaitForPowerState(ctx context.Context, group, name string, state compute.VirtualMachinePowerState) error {
+	ticker := time.NewTicker(powerStatePollInterval)
+	defer ticker.Stop()
+	for {
+		vm, err := c.getVirtualMachine(ctx, group, name)
+		if err != nil {
+			return err
+		}
+		current := GetPowerState(vm)
+		if current == state {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return errors.Wrapf(ctx.Err(), "Virtual Machine [%s] did not reach power state [%s], last observed [%s]", name, state, current)
+		case <-ticker.C:
+		}
+	}
+}
+
+// validatePowerOperation fetches the Virtual Machine and validates that op can be issued in its current power state
+func (c *VirtualMachineClient) validatePowerOperation(ctx context.Context, group, name string, op PowerOperation) error {
+	vm, err := c.getVirtualMachine(ctx, group, name)
+	if err != nil {
+		return err
+	}
+	return ValidatePowerStateTransition(GetPowerState(vm), op)
+}
+
+// getVirtualMachine returns the single Virtual Machine with the given name
+func (c *VirtualMachineClient) getVirtualMachine(ctx context.Context, group, name string) (*compute.VirtualMachine, error) {
+	vms, err := c.Get(ctx, group, name)
+	if err != nil {
+		return nil, err
+	}
+	if vms == nil || len(*vms) == 0 {
+		return nil, errors.Wrapf(errors.NotFound, "Virtual Machine [%s] not found", name)
+	}
+	return &(*vms)[0], nil
+}
+
+func (c *VirtualMachineClient) getDiskInstanceViews(ctx context.Context, group string, vm *compute.VirtualMachine) ([]compute.DiskInstanceView, error) {
+	if vm.StorageProfile == nil {
+		return nil, nil
+	}
+
+	diskNames := []string{}
+	if vm.StorageProfile.OsDisk != nil && vm.StorageProfile.OsDisk.Vhd != nil && vm.StorageProfile.OsDisk.Vhd.URI != nil && len(*vm.StorageProfile.OsDisk.Vhd.URI) > 0 {
+		diskNames = append(diskNames, *vm.StorageProfile.OsDisk.Vhd.URI)
+	}
+	if vm.StorageProfile.DataDisks != nil {
+		for _, disk := range *vm.StorageProfile.DataDisks {
+			if disk.Vhd != nil && disk.Vhd.URI != nil && len(*disk.Vhd.URI) > 0 {
+				diskNames = append(diskNames, *disk.Vhd.URI)
+			}
+		}
+	}
+	if len(diskNames) == 0 {
+		return nil, nil
+	}
+
+	vhdCli, err := virtualharddisk.NewVirtualHardDiskClient(c.cloudFQDN, c.authorizer)
+	if err != nil {
+		return nil, err
+	}
+
+	views := []compute.DiskInstanceView{}
+	for i := range diskNames {
+		view := compute.DiskInstanceView{Name: &diskNames[i]}
+		vhds, err := vhdCli.Get(ctx, group, "", diskNames[i])
+		if err != nil && !errors.IsNotFound(err) {
+			return nil, err
+		}
+		if err == nil && vhds != nil && len(*vhds) > 0 && (*vhds)[0].VirtualHardDiskProperties != nil {
+			view.Statuses = (*vhds)[0].Statuses
+			if state, ok := view.Statuses["ProvisionState"]; ok {
+				view.ProvisioningState = state
+			}
+		}
+		views = append(views, view)
+	}
+	return views, nil
+}
+
+func (c *VirtualMachineClient) getNetworkInterfaceInstanceViews(ctx context.Context, group string, vm *compute.VirtualMachine) ([]compute.NetworkInterfaceInstanceView, error) {
+	if vm.NetworkProfile == nil || vm.NetworkProfile.NetworkInterfaces == nil || len(*vm.NetworkProfile.NetworkInterfaces) == 0 {
+		return nil, nil
+	}
+
+	nicCli, err := networkinterface.NewInterfaceClient(c.cloudFQDN, c.authorizer)
+	if err != nil {
+		return nil, err
+	}
+
+	views := []compute.NetworkInterfaceInstanceView{}
+	for _, vmnic := range *vm.NetworkProfile.NetworkInterfaces {
+		if vmnic.ID == nil {
+			continue
+		}
+		view := compute.NetworkInterfaceInstanceView{Name: vmnic.ID}
+		nics, err := nicCli.Get(ctx, group, *vmnic.ID)
+		if err != nil && !errors.IsNotFound(err) {
+			return nil, err
+		}
+		if err == nil && nics != nil && len(*nics) > 0 && (*nics)[0].InterfacePropertiesFormat != nil {
+			nic := (*nics)[0]
+			view.ProvisioningState = nic.ProvisioningState
+			view.Statuses = nic.Statuses
+			if nic.IPConfigurations != nil {
+				for _, ipConfig := range *nic.IPConfigurations {
+					if ipConfig.InterfaceIPConfigurationPropertiesFormat != nil && ipConfig.PrivateIPAddress != nil && len(*ipConfig.PrivateIPAddress) > 0 {
+						view.PrivateIPAddresses = append(view.PrivateIPAddresses, *ipConfig.PrivateIPAddress)
+					}
+				}
+			}
+		}
+		views = append(views, view)
+	}
+	return views, nil
+}
@@ -0,0 +1,86 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the Apache v2.0 License.
+
+package virtualmachine
+
+import (
+	"testing"
+	"time"
+
+	"github.com/microsoft/moc-sdk-for-go/services/compute"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_ParsePowerState(t *testing.T) {
+	cases := map[string]compute.VirtualMachinePowerState{
+		"Running":  compute.PowerStateRunning,
+		"Off":      compute.PowerStateStopped,
+		"stopped":  compute.PowerStateStopped,
+		"Paused":   compute.PowerStatePaused,
+		"Saved":    compute.PowerStateSaved,
+		"Starting": compute.PowerStateStarting,
+		"Stopping": compute.PowerStateStopping,
+		"Unknown":  compute.PowerStateUnknown,
+		"":         compute.PowerStateUnknown,
+	}
+	for input, expected := range cases {
+		assert.Equal(t, expected, ParsePowerState(input), "ParsePowerState(%q)", input)
+	}
+}
+
+func Test_GetPowerState(t *testing.T) {
+	assert.Equal(t, compute.PowerStateUnknown, GetPowerState(nil))
+
+	running := "Running"
+	vm := &compute.VirtualMachine{
+		VirtualMachineProperties: &compute.VirtualMachineProperties{
+			Statuses: map[string]*string{"PowerState": &running},
+		},
+	}
+	assert.Equal(t, compute.PowerStateRunning, GetPowerState(vm))
+}
+
+func Test_ValidatePowerStateTransition(t *testing.T) {
+	assert.NoError(t, ValidatePowerStateTransition(compute.PowerStateStopped, PowerOperationStart))
+	assert.NoError(t, ValidatePowerStateTransition(compute.PowerStateRunning, PowerOperationPause))
+	assert.NoError(t, ValidatePowerStateTransition(compute.PowerStatePaused, PowerOperationSave))
+	assert.NoError(t, ValidatePowerStateTransition(compute.PowerStateUnknown, PowerOperationPause))
+
+	assert.Error(t, ValidatePowerStateTransition(compute.PowerStateStopped, PowerOperationPause))
+	assert.Error(t, ValidatePowerStateTransition(compute.PowerStateStopped, PowerOperationSave))
+	assert.Error(t, ValidatePowerStateTransition(compute.PowerStateStopping, PowerOperationStart))
+	assert.Error(t, ValidatePowerStateTransition(compute.PowerStateRunning, PowerOperation("Hibernate")))
+}
+
+func Test_GetGuestAgentHealth(t *testing.T) {
+	assert.Equal(t, compute.GuestAgentHealthUnknown, GetGuestAgentHealth(nil))
+
+	view := &compute.GuestAgentInstanceView{
+		Statuses: []*compute.InstanceViewStatus{
+			{Level: compute.StatusLevelInfo},
+		},
+	}
+	assert.Equal(t, compute.GuestAgentHealthHealthy, GetGuestAgentHealth(view))
+
+	view.Statuses = append(view.Statuses, &compute.InstanceViewStatus{Level: compute.StatusLevelWarning})
+	assert.Equal(t, compute.GuestAgentHealthDegraded, GetGuestAgentHealth(view))
+
+	view.Statuses = append(view.Statuses, &compute.InstanceViewStatus{Level: compute.StatusLevelError})
+	assert.Equal(t, compute.GuestAgentHealthUnhealthy, GetGuestAgentHealth(view))
+}
+
+func Test_getGuestAgentUptime(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	view := &compute.GuestAgentInstanceView{
+		Statuses: []*compute.InstanceViewStatus{
+			{Time: "2024-01-01T11:00:00Z"},
+			{Time: "2024-01-01T10:00:00Z"},
+			{Time: "not a time"},
+		},
+	}
+	uptime := getGuestAgentUptime(view, now)
+	if assert.NotNil(t, uptime) {
+		assert.Equal(t, 2*time.Hour, *uptime)
+	}
+	assert.Nil(t, getGuestAgentUptime(&compute.GuestAgentInstanceView{}, now))
+}
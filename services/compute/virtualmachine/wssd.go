@@ -13,7 +13,6 @@ import (
 	"github.com/microsoft/moc/pkg/errors"
 	"github.com/microsoft/moc/pkg/marshal"
 	prototags "github.com/microsoft/moc/pkg/tags"
-	"github.com/microsoft/moc/pkg/validations"
 	wssdcloudproto "github.com/microsoft/moc/rpc/common"
 
 	wssdcloudclient "github.com/microsoft/moc-sdk-for-go/pkg/client"
@@ -402,22 +401,11 @@ func getWssdTags(tags map[string]*string) *wssdcloudproto.Tags {
 }
 
 func (c *client) virtualMachineValidations(opType wssdcloudproto.Operation, vmss *compute.VirtualMachine) error {
-	if vmss.OsProfile == nil {
+	if vmss.OsProfile == nil || opType != wssdcloudproto.Operation_POST {
 		return nil
 	}
-	if vmss.OsProfile.ProxyConfiguration != nil && opType == wssdcloudproto.Operation_POST {
-		if vmss.OsProfile.ProxyConfiguration.HttpProxy != nil && *vmss.OsProfile.ProxyConfiguration.HttpProxy != "" {
-			_, err := validations.ValidateProxyURL(*vmss.OsProfile.ProxyConfiguration.HttpProxy)
-			if err != nil {
-				return err
-			}
-		}
-		if vmss.OsProfile.ProxyConfiguration.HttpsProxy != nil && *vmss.OsProfile.ProxyConfiguration.HttpsProxy != "" {
-			_, err := validations.ValidateProxyURL(*vmss.OsProfile.ProxyConfiguration.HttpsProxy)
-			if err != nil {
-				return err
-			}
-		}
+	if errs := validateProxyConfiguration(vmss.OsProfile.ProxyConfiguration); len(errs) > 0 {
+		return errs[0]
 	}
 	return nil
 }
@@ -0,0 +1,302 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the Apache v2.0 License.
+
+package autoscale
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/microsoft/moc-sdk-for-go/services/compute"
+	"github.com/microsoft/moc/pkg/errors"
+)
+
+const defaultEvaluationInterval = time.Minute
+
+// ComparisonOperator compares a metric value against a rule threshold
+type ComparisonOperator string
+
+const (
+	GreaterThan        ComparisonOperator = "GreaterThan"
+	GreaterThanOrEqual ComparisonOperator = "GreaterThanOrEqual"
+	LessThan           ComparisonOperator = "LessThan"
+	LessThanOrEqual    ComparisonOperator = "LessThanOrEqual"
+)
+
+// ScaleDirection is the direction a rule moves the capacity in
+type ScaleDirection string
+
+const (
+	ScaleOut ScaleDirection = "Out"
+	ScaleIn  ScaleDirection = "In"
+)
+
+// ScaleRule changes the capacity by ChangeCount when Metric compares to Threshold.
+// Scale out happens when any scale out rule matches; scale in only when every scale in rule matches.
+type ScaleRule struct {
+	// Metric - Name of the metric, as understood by the MetricsSource
+	Metric string
+	// Operator - How the metric value is compared against Threshold
+	Operator ComparisonOperator
+	// Threshold
+	Threshold float64
+	// Direction
+	Direction ScaleDirection
+	// ChangeCount - Number of instances added or removed. Defaults to 1.
+	ChangeCount int64
+	// Cooldown - Minimum time since the previous capacity change before this rule can fire
+	Cooldown time.Duration
+}
+
+// Profile describes the capacity bounds and scale rules of an autoscaled scale set
+type Profile struct {
+	// MinCapacity
+	MinCapacity int64
+	// MaxCapacity
+	MaxCapacity int64
+	// Rules
+	Rules []ScaleRule
+	// EvaluationInterval - How often Run evaluates the rules. Defaults to 1 minute.
+	EvaluationInterval time.Duration
+}
+
+// ScaleSetClient is the subset of the scale set client used by the autoscaler
+type ScaleSetClient interface {
+	Get(context.Context, string, string) (*[]compute.VirtualMachineScaleSet, error)
+	Scale(context.Context, string, string, int64) (*compute.VirtualMachineScaleSet, error)
+}
+
+// Decision records the outcome of one evaluation
+type Decision struct {
+	// Time
+	Time time.Time
+	// Metrics - Metric values observed during the evaluation
+	Metrics map[string]float64
+	// CurrentCapacity
+	CurrentCapacity int64
+	// NewCapacity - Equal to CurrentCapacity when no change was made
+	NewCapacity int64
+	// Reason
+	Reason string
+}
+
+// Scaled reports whether the decision changed the capacity
+func (d *Decision) Scaled() bool {
+	return d.NewCapacity != d.CurrentCapacity
+}
+
+// Autoscaler drives the capacity of a single scale set from metrics
+type Autoscaler struct {
+	group     string
+	name      string
+	profile   Profile
+	client    ScaleSetClient
+	metrics   MetricsSource
+	lastScale time.Time
+}
+
+// NewAutoscaler returns an autoscaler for the named scale set.
+// A *virtualmachinescaleset.VirtualMachineScaleSetClient satisfies ScaleSetClient.
+func NewAutoscaler(client ScaleSetClient, metrics MetricsSource, group, name string, profile Profile) (*Autoscaler, error) {
+	if client == nil || metrics == nil {
+		return nil, errors.Wrapf(errors.InvalidInput, "Scale set client and metrics source are required")
+	}
+	if len(group) == 0 {
+		return nil, errors.Wrapf(errors.InvalidGroup, "Group not specified")
+	}
+	if len(name) == 0 {
+		return nil, errors.Wrapf(errors.InvalidInput, "Scale set name not specified")
+	}
+	profile, err := getProfile(profile)
+	if err != nil {
+		return nil, err
+	}
+	return &Autoscaler{
+		group:   group,
+		name:    name,
+		profile: profile,
+		client:  client,
+		metrics: metrics,
+	}, nil
+}
+
+// Run evaluates the profile every EvaluationInterval until the context is done.
+// Evaluation errors are passed to onError, if set, and do not stop the loop.
+func (a *Autoscaler) Run(ctx context.Context, onError func(error)) error {
+	ticker := time.NewTicker(a.profile.EvaluationInterval)
+	defer ticker.Stop()
+	for {
+		if _, err := a.Evaluate(ctx, time.Now()); err != nil && onError != nil {
+			onError(err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Evaluate reads the current capacity and metrics, and scales the scale set if a rule fires
+func (a *Autoscaler) Evaluate(ctx context.Context, now time.Time) (*Decision, error) {
+	capacity, err := a.getCapacity(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	values := map[string]float64{}
+	for _, rule := range a.profile.Rules {
+		if _, ok := values[rule.Metric]; ok {
+			continue
+		}
+		value, err := a.metrics.GetMetric(ctx, a.group, a.name, rule.Metric)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Failed to read metric [%s] for scale set [%s]", rule.Metric, a.name)
+		}
+		values[rule.Metric] = value
+	}
+
+	decision := decide(a.profile, capacity, values, now.Sub(a.lastScale))
+	decision.Time = now
+	if !decision.Scaled() {
+		return decision, nil
+	}
+
+	if _, err := a.client.Scale(ctx, a.group, a.name, decision.NewCapacity); err != nil {
+		return nil, errors.Wrapf(err, "Failed to scale [%s] from %d to %d", a.name, decision.CurrentCapacity, decision.NewCapacity)
+	}
+	a.lastScale = now
+	return decision, nil
+}
+
+func (a *Autoscaler) getCapacity(ctx context.Context) (int64, error) {
+	vmsss, err := a.client.Get(ctx, a.group, a.name)
+	if err != nil {
+		return 0, err
+	}
+	if vmsss == nil || len(*vmsss) == 0 {
+		return 0, errors.Wrapf(errors.NotFound, "Virtual Machine Scale Set [%s] not found", a.name)
+	}
+	vmss := (*vmsss)[0]
+	if vmss.Sku == nil || vmss.Sku.Capacity == nil {
+		return 0, errors.Wrapf(errors.InvalidConfiguration, "Virtual Machine Scale Set [%s] has no Sku capacity", a.name)
+	}
+	return *vmss.Sku.Capacity, nil
+}
+
+// decide applies the profile to the observed capacity and metric values.
+// sinceLastScale is the time elapsed since the previous capacity change.
+func decide(profile Profile, capacity int64, values map[string]float64, sinceLastScale time.Duration) *Decision {
+	decision := &Decision{
+		Metrics:         values,
+		CurrentCapacity: capacity,
+		NewCapacity:     capacity,
+	}
+
+	if capacity < profile.MinCapacity {
+		decision.NewCapacity = profile.MinCapacity
+		decision.Reason = fmt.Sprintf("capacity below minimum %d", profile.MinCapacity)
+		return decision
+	}
+	if capacity > profile.MaxCapacity {
+		decision.NewCapacity = profile.MaxCapacity
+		decision.Reason = fmt.Sprintf("capacity above maximum %d", profile.MaxCapacity)
+		return decision
+	}
+
+	var scaleOut, scaleIn *ScaleRule
+	scaleInRules, scaleInMatches := 0, 0
+	for i := range profile.Rules {
+		rule := &profile.Rules[i]
+		matched := rule.matches(values[rule.Metric])
+		switch rule.Direction {
+		case ScaleOut:
+			if matched && sinceLastScale >= rule.Cooldown && (scaleOut == nil || rule.ChangeCount > scaleOut.ChangeCount) {
+				scaleOut = rule
+			}
+		case ScaleIn:
+			scaleInRules++
+			if matched && sinceLastScale >= rule.Cooldown {
+				scaleInMatches++
+				if scaleIn == nil || rule.ChangeCount < scaleIn.ChangeCount {
+					scaleIn = rule
+				}
+			}
+		}
+	}
+
+	switch {
+	case scaleOut != nil && capacity < profile.MaxCapacity:
+		decision.NewCapacity = capacity + scaleOut.ChangeCount
+		if decision.NewCapacity > profile.MaxCapacity {
+			decision.NewCapacity = profile.MaxCapacity
+		}
+		decision.Reason = scaleOut.String()
+	case scaleOut == nil && scaleIn != nil && scaleInMatches == scaleInRules && capacity > profile.MinCapacity:
+		decision.NewCapacity = capacity - scaleIn.ChangeCount
+		if decision.NewCapacity < profile.MinCapacity {
+			decision.NewCapacity = profile.MinCapacity
+		}
+		decision.Reason = scaleIn.String()
+	default:
+		decision.Reason = "no rule fired"
+	}
+	return decision
+}
+
+func (r *ScaleRule) matches(value float64) bool {
+	switch r.Operator {
+	case GreaterThan:
+		return value > r.Threshold
+	case GreaterThanOrEqual:
+		return value >= r.Threshold
+	case LessThan:
+		return value < r.Threshold
+	case LessThanOrEqual:
+		return value <= r.Threshold
+	}
+	return false
+}
+
+func (r *ScaleRule) String() string {
+	return fmt.Sprintf("scale %s by %d: %s %s %v", r.Direction, r.ChangeCount, r.Metric, r.Operator, r.Threshold)
+}
+
+// getProfile validates the profile and fills in defaults
+func getProfile(profile Profile) (Profile, error) {
+	if profile.MinCapacity < 0 || profile.MaxCapacity < profile.MinCapacity {
+		return profile, errors.Wrapf(errors.InvalidInput, "Invalid capacity bounds [%d, %d]", profile.MinCapacity, profile.MaxCapacity)
+	}
+	if profile.EvaluationInterval < 0 {
+		return profile, errors.Wrapf(errors.InvalidInput, "Evaluation interval cannot be negative")
+	}
+	if profile.EvaluationInterval == 0 {
+		profile.EvaluationInterval = defaultEvaluationInterval
+	}
+
+	rules := make([]ScaleRule, len(profile.Rules))
+	for i, rule := range profile.Rules {
+		if len(rule.Metric) == 0 {
+			return profile, errors.Wrapf(errors.InvalidInput, "Scale rule %d has no metric", i)
+		}
+		switch rule.Operator {
+		case GreaterThan, GreaterThanOrEqual, LessThan, LessThanOrEqual:
+		default:
+			return profile, errors.Wrapf(errors.InvalidInput, "Scale rule %d has unsupported operator [%s]", i, rule.Operator)
+		}
+		if rule.Direction != ScaleOut && rule.Direction != ScaleIn {
+			return profile, errors.Wrapf(errors.InvalidInput, "Scale rule %d has unsupported direction [%s]", i, rule.Direction)
+		}
+		if rule.ChangeCount < 0 || rule.Cooldown < 0 {
+			return profile, errors.Wrapf(errors.InvalidInput, "Scale rule %d has a negative change count or cooldown", i)
+		}
+		if rule.ChangeCount == 0 {
+			rule.ChangeCount = 1
+		}
+		rules[i] = rule
+	}
+	profile.Rules = rules
+	return profile, nil
+}
@@ -0,0 +1,95 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the Apache v2.0 License.
+
+package autoscale
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func cpuProfile() Profile {
+	return Profile{
+		MinCapacity:        1,
+		MaxCapacity:        4,
+		EvaluationInterval: time.Minute,
+		Rules: []ScaleRule{
+			{Metric: MetricCPUPercentage, Operator: GreaterThan, Threshold: 70, Direction: ScaleOut, Cooldown: 5 * time.Minute},
+			{Metric: MetricCPUPercentage, Operator: LessThan, Threshold: 30, Direction: ScaleIn, Cooldown: 10 * time.Minute},
+		},
+	}
+}
+
+func Test_SimulationHonoursCooldowns(t *testing.T) {
+	metrics := NewSyntheticMetrics(map[string][]float64{
+		MetricCPUPercentage: {80, 80, 80, 80, 80, 80, 20},
+	})
+	simulation, err := NewSimulation(cpuProfile(), metrics, 2, time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	assert.NoError(t, err)
+
+	decisions, err := simulation.Run(context.Background(), 16)
+	assert.NoError(t, err)
+	assert.Len(t, decisions, 16)
+
+	scaled := map[int]int64{}
+	for i, decision := range decisions {
+		if decision.Scaled() {
+			scaled[i] = decision.NewCapacity
+		}
+	}
+	// scale out at once, again after the 5 minute cooldown, and in 10 minutes after the last change
+	assert.Equal(t, map[int]int64{0: 3, 5: 4, 15: 3}, scaled)
+	assert.Equal(t, int64(3), simulation.ScaleSet.Capacity())
+}
+
+func Test_SimulationStaysWithinBounds(t *testing.T) {
+	metrics := NewSyntheticMetrics(map[string][]float64{MetricCPUPercentage: {99}})
+	profile := cpuProfile()
+	profile.Rules[0].Cooldown = 0
+	profile.Rules[0].ChangeCount = 3
+	simulation, err := NewSimulation(profile, metrics, 0, time.Now())
+	assert.NoError(t, err)
+
+	decisions, err := simulation.Run(context.Background(), 3)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), decisions[0].NewCapacity)
+	assert.Equal(t, int64(4), decisions[1].NewCapacity)
+	assert.False(t, decisions[2].Scaled())
+}
+
+func Test_decideScaleInNeedsEveryRule(t *testing.T) {
+	profile, err := getProfile(Profile{
+		MinCapacity: 1,
+		MaxCapacity: 10,
+		Rules: []ScaleRule{
+			{Metric: "cpu", Operator: LessThan, Threshold: 30, Direction: ScaleIn},
+			{Metric: "queue", Operator: LessThanOrEqual, Threshold: 0, Direction: ScaleIn},
+		},
+	})
+	assert.NoError(t, err)
+
+	decision := decide(profile, 5, map[string]float64{"cpu": 10, "queue": 4}, time.Hour)
+	assert.False(t, decision.Scaled())
+
+	decision = decide(profile, 5, map[string]float64{"cpu": 10, "queue": 0}, time.Hour)
+	assert.Equal(t, int64(4), decision.NewCapacity)
+}
+
+func Test_getProfileValidation(t *testing.T) {
+	_, err := getProfile(Profile{MinCapacity: 3, MaxCapacity: 2})
+	assert.Error(t, err)
+
+	_, err = getProfile(Profile{MaxCapacity: 2, Rules: []ScaleRule{{Metric: "cpu", Operator: "Equal", Direction: ScaleOut}}})
+	assert.Error(t, err)
+
+	_, err = getProfile(Profile{MaxCapacity: 2, Rules: []ScaleRule{{Operator: LessThan, Direction: ScaleIn}}})
+	assert.Error(t, err)
+
+	profile, err := getProfile(Profile{MaxCapacity: 2, Rules: []ScaleRule{{Metric: "cpu", Operator: LessThan, Direction: ScaleIn}}})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), profile.Rules[0].ChangeCount)
+	assert.Equal(t, defaultEvaluationInterval, profile.EvaluationInterval)
+}
@@ -0,0 +1,27 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the Apache v2.0 License.
+
+package autoscale
+
+import (
+	"context"
+)
+
+const (
+	// MetricCPUPercentage is the conventional name of the average CPU utilization of a scale set, in percent
+	MetricCPUPercentage = "Percentage CPU"
+)
+
+// MetricsSource provides the metric values scale rules are evaluated against.
+// Implementations return a single value aggregated over the instances of the scale set.
+type MetricsSource interface {
+	GetMetric(ctx context.Context, group, scaleSetName, metric string) (float64, error)
+}
+
+// MetricsSourceFunc adapts a function to a MetricsSource
+type MetricsSourceFunc func(ctx context.Context, group, scaleSetName, metric string) (float64, error)
+
+// GetMetric calls f
+func (f MetricsSourceFunc) GetMetric(ctx context.Context, group, scaleSetName, metric string) (float64, error) {
+	return f(ctx, group, scaleSetName, metric)
+}
@@ -0,0 +1,133 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the Apache v2.0 License.
+
+package autoscale
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/microsoft/moc-sdk-for-go/services/compute"
+	"github.com/microsoft/moc/pkg/errors"
+)
+
+// SyntheticMetrics replays a fixed series of values per metric, one value per read.
+// The last value of a series is repeated once the series is exhausted.
+type SyntheticMetrics struct {
+	mu     sync.Mutex
+	series map[string][]float64
+}
+
+// NewSyntheticMetrics returns a metrics source replaying series
+func NewSyntheticMetrics(series map[string][]float64) *SyntheticMetrics {
+	copied := map[string][]float64{}
+	for metric, values := range series {
+		copied[metric] = append([]float64{}, values...)
+	}
+	return &SyntheticMetrics{series: copied}
+}
+
+// GetMetric returns the next value of the metric series
+func (m *SyntheticMetrics) GetMetric(ctx context.Context, group, scaleSetName, metric string) (float64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	values := m.series[metric]
+	if len(values) == 0 {
+		return 0, errors.Wrapf(errors.NotFound, "No synthetic values for metric [%s]", metric)
+	}
+	value := values[0]
+	if len(values) > 1 {
+		m.series[metric] = values[1:]
+	}
+	return value, nil
+}
+
+// SimulatedScaleSet is an in-memory ScaleSetClient that only tracks capacity
+type SimulatedScaleSet struct {
+	mu       sync.Mutex
+	name     string
+	capacity int64
+}
+
+// NewSimulatedScaleSet returns a simulated scale set with the given initial capacity
+func NewSimulatedScaleSet(name string, capacity int64) *SimulatedScaleSet {
+	return &SimulatedScaleSet{name: name, capacity: capacity}
+}
+
+// Capacity returns the current simulated capacity
+func (s *SimulatedScaleSet) Capacity() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.capacity
+}
+
+// Get returns the simulated scale set
+func (s *SimulatedScaleSet) Get(ctx context.Context, group, name string) (*[]compute.VirtualMachineScaleSet, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if name != s.name {
+		return &[]compute.VirtualMachineScaleSet{}, nil
+	}
+	return &[]compute.VirtualMachineScaleSet{s.get()}, nil
+}
+
+// Scale sets the simulated capacity
+func (s *SimulatedScaleSet) Scale(ctx context.Context, group, name string, capacity int64) (*compute.VirtualMachineScaleSet, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if name != s.name {
+		return nil, errors.Wrapf(errors.NotFound, "Virtual Machine Scale Set [%s] not found", name)
+	}
+	if capacity < 0 {
+		return nil, errors.Wrapf(errors.InvalidInput, "Capacity [%d] cannot be negative", capacity)
+	}
+	s.capacity = capacity
+	vmss := s.get()
+	return &vmss, nil
+}
+
+func (s *SimulatedScaleSet) get() compute.VirtualMachineScaleSet {
+	name := s.name
+	capacity := s.capacity
+	return compute.VirtualMachineScaleSet{
+		Name: &name,
+		Sku:  &compute.Sku{Capacity: &capacity},
+	}
+}
+
+// Simulation runs an autoscaler against a simulated scale set on a virtual clock
+type Simulation struct {
+	// Autoscaler
+	Autoscaler *Autoscaler
+	// ScaleSet
+	ScaleSet *SimulatedScaleSet
+	clock    time.Time
+}
+
+// NewSimulation returns a simulation of profile against metrics, starting at the given capacity and time
+func NewSimulation(profile Profile, metrics MetricsSource, capacity int64, start time.Time) (*Simulation, error) {
+	const group, name = "simulation", "simulation"
+	scaleSet := NewSimulatedScaleSet(name, capacity)
+	autoscaler, err := NewAutoscaler(scaleSet, metrics, group, name, profile)
+	if err != nil {
+		return nil, err
+	}
+	return &Simulation{Autoscaler: autoscaler, ScaleSet: scaleSet, clock: start}, nil
+}
+
+// Run performs the given number of evaluations, advancing the virtual clock by the evaluation interval
+// after each one, and returns every decision in order
+func (s *Simulation) Run(ctx context.Context, steps int) ([]Decision, error) {
+	decisions := []Decision{}
+	for i := 0; i < steps; i++ {
+		decision, err := s.Autoscaler.Evaluate(ctx, s.clock)
+		if err != nil {
+			return decisions, err
+		}
+		decisions = append(decisions, *decision)
+		s.clock = s.clock.Add(s.Autoscaler.profile.EvaluationInterval)
+	}
+	return decisions, nil
+}
@@ -17,9 +17,19 @@ type Service interface {
 	Delete(context.Context, string, string) error
 }
 
+// instanceClient runs the operations on the Virtual Machine instances of the scale set
+type instanceClient interface {
+	Start(context.Context, string, string) error
+	Stop(context.Context, string, string) error
+	Restart(context.Context, string, string) error
+	Delete(context.Context, string, string) error
+	InstanceView(context.Context, string, string) (*compute.VirtualMachineInstanceView, error)
+}
+
 type VirtualMachineScaleSetClient struct {
 	compute.BaseClient
 	internal Service
+	vmclient instanceClient
 }
 
 func NewVirtualMachineScaleSetClient(cloudFQDN string, authorizer auth.Authorizer) (*VirtualMachineScaleSetClient, error) {
@@ -28,7 +38,7 @@ func NewVirtualMachineScaleSetClient(cloudFQDN string, authorizer auth.Authorize
 		return nil, err
 	}
 
-	return &VirtualMachineScaleSetClient{internal: c}, nil
+	return &VirtualMachineScaleSetClient{internal: c, vmclient: c.vmclient}, nil
 }
 
 // Get methods invokes the client Get method
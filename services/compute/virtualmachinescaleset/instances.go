@@ -0,0 +1,210 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the Apache v2.0 License.
+
+package virtualmachinescaleset
+
+import (
+	"context"
+	"time"
+
+	"github.com/microsoft/moc-sdk-for-go/services/compute"
+	"github.com/microsoft/moc/pkg/errors"
+)
+
+// scaleSetChange mutates the scale set in place before it is pushed back
+type scaleSetChange func(vmss *compute.VirtualMachineScaleSet) error
+
+// Scale sets the number of instances in the scale set
+func (c *VirtualMachineScaleSetClient) Scale(ctx context.Context, group, name string, capacity int64) (*compute.VirtualMachineScaleSet, error) {
+	if capacity < 0 {
+		return nil, errors.Wrapf(errors.InvalidInput, "Capacity [%d] cannot be negative", capacity)
+	}
+	return c.update(ctx, group, name, func(vmss *compute.VirtualMachineScaleSet) error {
+		newCapacity := capacity
+		vmss.Sku.Capacity = &newCapacity
+		return nil
+	})
+}
+
+// StartInstances starts the given instances of the scale set
+func (c *VirtualMachineScaleSetClient) StartInstances(ctx context.Context, group, name string, instances []string) error {
+	return c.runInstanceOperation(ctx, group, name, instances, c.vmclient.Start)
+}
+
+// StopInstances stops the given instances of the scale set
+func (c *VirtualMachineScaleSetClient) StopInstances(ctx context.Context, group, name string, instances []string) error {
+	return c.runInstanceOperation(ctx, group, name, instances, c.vmclient.Stop)
+}
+
+// RestartInstances restarts the given instances of the scale set
+func (c *VirtualMachineScaleSetClient) RestartInstances(ctx context.Context, group, name string, instances []string) error {
+	return c.runInstanceOperation(ctx, group, name, instances, c.vmclient.Restart)
+}
+
+// ReimageInstances replaces the given instances with fresh ones built from the current scale set model.
+// The instances are deleted and the scale set is re-applied at the same capacity so that the missing
+// instances are provisioned again. Returns the names of the replacement instances.
+func (c *VirtualMachineScaleSetClient) ReimageInstances(ctx context.Context, group, name string, instances []string) ([]string, error) {
+	before, err := c.instanceNames(ctx, group, name)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateInstances(name, before, instances); err != nil {
+		return nil, err
+	}
+
+	for _, instance := range instances {
+		if err := c.vmclient.Delete(ctx, group, instance); err != nil {
+			return nil, errors.Wrapf(err, "Failed to delete instance [%s] of scale set [%s]", instance, name)
+		}
+	}
+
+	if _, err := c.update(ctx, group, name, func(vmss *compute.VirtualMachineScaleSet) error { return nil }); err != nil {
+		return nil, err
+	}
+
+	after, err := c.instanceNames(ctx, group, name)
+	if err != nil {
+		return nil, err
+	}
+	return getReplacementInstances(before, after, instances), nil
+}
+
+// DeleteInstances deletes the given instances and then lowers the scale set capacity accordingly. The
+// capacity is lowered only once the instances are gone, so that the scale set does not pick other
+// instances to remove. If a deletion fails the capacity is left unchanged.
+func (c *VirtualMachineScaleSetClient) DeleteInstances(ctx context.Context, group, name string, instances []string) error {
+	members, err := c.instanceNames(ctx, group, name)
+	if err != nil {
+		return err
+	}
+	if err := validateInstances(name, members, instances); err != nil {
+		return err
+	}
+
+	for _, instance := range instances {
+		if err := c.vmclient.Delete(ctx, group, instance); err != nil {
+			return errors.Wrapf(err, "Failed to delete instance [%s] of scale set [%s]", instance, name)
+		}
+	}
+
+	_, err = c.update(ctx, group, name, func(vmss *compute.VirtualMachineScaleSet) error {
+		capacity := *vmss.Sku.Capacity - int64(len(instances))
+		if capacity < 0 {
+			capacity = 0
+		}
+		vmss.Sku.Capacity = &capacity
+		return nil
+	})
+	return err
+}
+
+// runInstanceOperation validates that every instance belongs to the scale set before running op on each of them
+func (c *VirtualMachineScaleSetClient) runInstanceOperation(ctx context.Context, group, name string, instances []string, op func(context.Context, string, string) error) error {
+	members, err := c.instanceNames(ctx, group, name)
+	if err != nil {
+		return err
+	}
+	if err := validateInstances(name, members, instances); err != nil {
+		return err
+	}
+
+	for _, instance := range instances {
+		if err := op(ctx, group, instance); err != nil {
+			return errors.Wrapf(err, "Operation failed on instance [%s] of scale set [%s]", instance, name)
+		}
+	}
+	return nil
+}
+
+// update runs a read-modify-write of the scale set, retrying on stale versions
+func (c *VirtualMachineScaleSetClient) update(ctx context.Context, group, name string, change scaleSetChange) (*compute.VirtualMachineScaleSet, error) {
+	for {
+		vmsss, err := c.Get(ctx, group, name)
+		if err != nil {
+			return nil, err
+		}
+		if vmsss == nil || len(*vmsss) == 0 {
+			return nil, errors.Wrapf(errors.NotFound, "Virtual Machine Scale Set [%s] not found", name)
+		}
+
+		vmss := (*vmsss)[0]
+		if vmss.Sku == nil || vmss.Sku.Capacity == nil {
+			return nil, errors.Wrapf(errors.InvalidConfiguration, "Virtual Machine Scale Set [%s] has no Sku capacity", name)
+		}
+		if err := change(&vmss); err != nil {
+			return nil, err
+		}
+
+		updated, err := c.CreateOrUpdate(ctx, group, name, &vmss)
+		if err != nil {
+			if errors.IsInvalidVersion(err) {
+				// Retry only on invalid version
+				time.Sleep(100 * time.Millisecond)
+				continue
+			}
+			return nil, err
+		}
+		return updated, nil
+	}
+}
+
+// instanceNames returns the names of the Virtual Machines in the scale set
+func (c *VirtualMachineScaleSetClient) instanceNames(ctx context.Context, group, name string) ([]string, error) {
+	vms, err := c.List(ctx, group, name)
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	if vms == nil {
+		return names, nil
+	}
+	for _, vm := range *vms {
+		if vm.Name != nil {
+			names = append(names, *vm.Name)
+		}
+	}
+	return names, nil
+}
+
+func validateInstances(name string, members, instances []string) error {
+	if len(instances) == 0 {
+		return errors.Wrapf(errors.InvalidInput, "No instances specified")
+	}
+	memberSet := map[string]bool{}
+	for _, member := range members {
+		memberSet[member] = true
+	}
+	seen := map[string]bool{}
+	for _, instance := range instances {
+		if !memberSet[instance] {
+			return errors.Wrapf(errors.NotFound, "Instance [%s] is not part of scale set [%s]", instance, name)
+		}
+		if seen[instance] {
+			return errors.Wrapf(errors.InvalidInput, "Instance [%s] is specified more than once", instance)
+		}
+		seen[instance] = true
+	}
+	return nil
+}
+
+// getReplacementInstances returns the members in after that replace the reimaged instances, i.e. every
+// member except those that were already present and not reimaged. This holds whether or not the
+// scale set reuses instance names.
+func getReplacementInstances(before, after, reimaged []string) []string {
+	untouched := map[string]bool{}
+	for _, member := range before {
+		untouched[member] = true
+	}
+	for _, instance := range reimaged {
+		delete(untouched, instance)
+	}
+
+	replacements := []string{}
+	for _, member := range after {
+		if !untouched[member] {
+			replacements = append(replacements, member)
+		}
+	}
+	return replacements
+}
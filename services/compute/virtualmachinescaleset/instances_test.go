@@ -0,0 +1,89 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the Apache v2.0 License.
+
+package virtualmachinescaleset
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/microsoft/moc-sdk-for-go/services/compute"
+	"github.com/microsoft/moc/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeScaleSetAgent reconciles the instances of a scale set with its capacity on every update, removing
+// the oldest instances when the capacity is lowered
+type fakeScaleSetAgent struct {
+	capacity  int64
+	instances []string
+	created   int
+}
+
+func (f *fakeScaleSetAgent) Get(ctx context.Context, group, name string) (*[]compute.VirtualMachineScaleSet, error) {
+	capacity := f.capacity
+	return &[]compute.VirtualMachineScaleSet{{Name: &name, Sku: &compute.Sku{Capacity: &capacity}}}, nil
+}
+
+func (f *fakeScaleSetAgent) GetVirtualMachines(ctx context.Context, group, name string) (*[]compute.VirtualMachine, error) {
+	vms := []compute.VirtualMachine{}
+	for i := range f.instances {
+		vms = append(vms, compute.VirtualMachine{Name: &f.instances[i]})
+	}
+	return &vms, nil
+}
+
+func (f *fakeScaleSetAgent) CreateOrUpdate(ctx context.Context, group, name string, vmss *compute.VirtualMachineScaleSet) (*compute.VirtualMachineScaleSet, error) {
+	f.capacity = *vmss.Sku.Capacity
+	for int64(len(f.instances)) > f.capacity {
+		f.instances = f.instances[1:]
+	}
+	for int64(len(f.instances)) < f.capacity {
+		f.instances = append(f.instances, fmt.Sprintf("vmss-new%d", f.created))
+		f.created++
+	}
+	return vmss, nil
+}
+
+func (f *fakeScaleSetAgent) Delete(ctx context.Context, group, name string) error {
+	return nil
+}
+
+// fakeInstances deletes instances from the fake agent
+type fakeInstances struct {
+	agent *fakeScaleSetAgent
+}
+
+func (f *fakeInstances) Start(ctx context.Context, group, name string) error   { return nil }
+func (f *fakeInstances) Stop(ctx context.Context, group, name string) error    { return nil }
+func (f *fakeInstances) Restart(ctx context.Context, group, name string) error { return nil }
+
+func (f *fakeInstances) Delete(ctx context.Context, group, name string) error {
+	for i, instance := range f.agent.instances {
+		if instance == name {
+			f.agent.instances = append(f.agent.instances[:i:i], f.agent.instances[i+1:]...)
+			return nil
+		}
+	}
+	return errors.Wrapf(errors.NotFound, "Virtual Machine [%s] not found", name)
+}
+
+func (f *fakeInstances) InstanceView(ctx context.Context, group, name string) (*compute.VirtualMachineInstanceView, error) {
+	return &compute.VirtualMachineInstanceView{PowerState: compute.PowerStateRunning}, nil
+}
+
+func Test_DeleteInstances(t *testing.T) {
+	agent := &fakeScaleSetAgent{capacity: 4, instances: []string{"vmss-0", "vmss-1", "vmss-2", "vmss-3"}}
+	c := &VirtualMachineScaleSetClient{internal: agent, vmclient: &fakeInstances{agent: agent}}
+
+	// lowering the capacity first would have the agent remove vmss-0 and vmss-1 on its own
+	require.NoError(t, c.DeleteInstances(context.Background(), "group", "vmss", []string{"vmss-2", "vmss-3"}))
+	assert.Equal(t, []string{"vmss-0", "vmss-1"}, agent.instances)
+	assert.Equal(t, int64(2), agent.capacity)
+
+	err := c.DeleteInstances(context.Background(), "group", "vmss", []string{"vmss-2"})
+	assert.ErrorIs(t, err, errors.NotFound)
+	assert.Equal(t, []string{"vmss-0", "vmss-1"}, agent.instances)
+}
@@ -0,0 +1,152 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the Apache v2.0 License.
+
+package virtualmachinescaleset
+
+import (
+	"context"
+	"time"
+
+	"github.com/microsoft/moc-sdk-for-go/services/compute"
+	"github.com/microsoft/moc/pkg/errors"
+)
+
+const (
+	defaultHealthTimeout = 10 * time.Minute
+	healthPollInterval   = 5 * time.Second
+)
+
+// RollingUpgradePolicy controls how UpdateModel rolls a new model out to existing instances
+type RollingUpgradePolicy struct {
+	// MaxUnavailable - Maximum number of instances out of service at the same time. Defaults to 1.
+	MaxUnavailable int
+	// BatchSize - Number of instances reimaged per batch. Defaults to, and is capped at, MaxUnavailable.
+	BatchSize int
+	// PauseBetweenBatches - Time to wait after a batch is healthy before starting the next one
+	PauseBetweenBatches time.Duration
+	// HealthGate - Require the guest agent of each replacement instance to report healthy before moving on
+	HealthGate bool
+	// HealthTimeout - Time allowed for a batch to become healthy. Defaults to 10 minutes.
+	HealthTimeout time.Duration
+}
+
+// RollingUpgradeStatus reports the progress of a rolling upgrade
+type RollingUpgradeStatus struct {
+	// Upgraded - Instances running the new model
+	Upgraded []string
+	// Pending - Instances still running the previous model
+	Pending []string
+}
+
+// UpdateModel replaces the VM profile of the scale set and rolls it out to the existing instances in batches.
+// The rollout stops at the first batch that fails or does not become healthy; the returned status is
+// populated in that case as well.
+func (c *VirtualMachineScaleSetClient) UpdateModel(ctx context.Context, group, name string, profile *compute.VirtualMachineScaleSetVMProfile, policy RollingUpgradePolicy) (*RollingUpgradeStatus, error) {
+	if profile == nil {
+		return nil, errors.Wrapf(errors.InvalidInput, "Virtual Machine Scale Set VM Profile is missing")
+	}
+	policy, err := getRollingUpgradePolicy(policy)
+	if err != nil {
+		return nil, err
+	}
+
+	instances, err := c.instanceNames(ctx, group, name)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = c.update(ctx, group, name, func(vmss *compute.VirtualMachineScaleSet) error {
+		if vmss.VirtualMachineScaleSetProperties == nil {
+			vmss.VirtualMachineScaleSetProperties = &compute.VirtualMachineScaleSetProperties{}
+		}
+		vmss.VirtualMachineProfile = profile
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	status := &RollingUpgradeStatus{Upgraded: []string{}, Pending: instances}
+	batches := getRollingBatches(instances, policy)
+	for i, batch := range batches {
+		if i > 0 && policy.PauseBetweenBatches > 0 {
+			select {
+			case <-ctx.Done():
+				return status, ctx.Err()
+			case <-time.After(policy.PauseBetweenBatches):
+			}
+		}
+
+		replacements, err := c.ReimageInstances(ctx, group, name, batch)
+		if err != nil {
+			return status, errors.Wrapf(err, "Rolling upgrade of scale set [%s] failed at batch %d", name, i+1)
+		}
+		status.Pending = status.Pending[len(batch):]
+
+		if err := c.waitForHealthyInstances(ctx, group, replacements, policy); err != nil {
+			return status, errors.Wrapf(err, "Rolling upgrade of scale set [%s] stopped at batch %d", name, i+1)
+		}
+		status.Upgraded = append(status.Upgraded, replacements...)
+	}
+
+	return status, nil
+}
+
+// waitForHealthyInstances waits until every instance is running and, with a health gate, reports a healthy guest agent
+func (c *VirtualMachineScaleSetClient) waitForHealthyInstances(ctx context.Context, group string, instances []string, policy RollingUpgradePolicy) error {
+	ctx, cancel := context.WithTimeout(ctx, policy.HealthTimeout)
+	defer cancel()
+
+	for _, instance := range instances {
+		for {
+			view, err := c.vmclient.InstanceView(ctx, group, instance)
+			if err == nil && isInstanceHealthy(view, policy.HealthGate) {
+				break
+			}
+
+			select {
+			case <-ctx.Done():
+				return errors.Wrapf(errors.Failed, "Instance [%s] did not become healthy within %s", instance, policy.HealthTimeout)
+			case <-time.After(healthPollInterval):
+			}
+		}
+	}
+	return nil
+}
+
+func isInstanceHealthy(view *compute.VirtualMachineInstanceView, healthGate bool) bool {
+	if view == nil || view.PowerState != compute.PowerStateRunning {
+		return false
+	}
+	return !healthGate || view.GuestAgentHealth == compute.GuestAgentHealthHealthy
+}
+
+// getRollingUpgradePolicy validates the policy and fills in defaults
+func getRollingUpgradePolicy(policy RollingUpgradePolicy) (RollingUpgradePolicy, error) {
+	if policy.MaxUnavailable < 0 || policy.BatchSize < 0 || policy.PauseBetweenBatches < 0 || policy.HealthTimeout < 0 {
+		return policy, errors.Wrapf(errors.InvalidInput, "Rolling upgrade policy values cannot be negative")
+	}
+	if policy.MaxUnavailable == 0 {
+		policy.MaxUnavailable = 1
+	}
+	if policy.BatchSize == 0 || policy.BatchSize > policy.MaxUnavailable {
+		policy.BatchSize = policy.MaxUnavailable
+	}
+	if policy.HealthTimeout == 0 {
+		policy.HealthTimeout = defaultHealthTimeout
+	}
+	return policy, nil
+}
+
+// getRollingBatches splits instances into batches of policy.BatchSize, preserving order
+func getRollingBatches(instances []string, policy RollingUpgradePolicy) [][]string {
+	batches := [][]string{}
+	for start := 0; start < len(instances); start += policy.BatchSize {
+		end := start + policy.BatchSize
+		if end > len(instances) {
+			end = len(instances)
+		}
+		batches = append(batches, instances[start:end])
+	}
+	return batches
+}
@@ -0,0 +1,67 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the Apache v2.0 License.
+
+package virtualmachinescaleset
+
+import (
+	"testing"
+	"time"
+
+	"github.com/microsoft/moc-sdk-for-go/services/compute"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_getRollingUpgradePolicyDefaults(t *testing.T) {
+	policy, err := getRollingUpgradePolicy(RollingUpgradePolicy{})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, policy.MaxUnavailable)
+	assert.Equal(t, 1, policy.BatchSize)
+	assert.Equal(t, defaultHealthTimeout, policy.HealthTimeout)
+
+	policy, err = getRollingUpgradePolicy(RollingUpgradePolicy{MaxUnavailable: 2, BatchSize: 5, HealthTimeout: time.Minute})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, policy.BatchSize)
+	assert.Equal(t, time.Minute, policy.HealthTimeout)
+
+	_, err = getRollingUpgradePolicy(RollingUpgradePolicy{BatchSize: -1})
+	assert.Error(t, err)
+}
+
+func Test_getRollingBatches(t *testing.T) {
+	instances := []string{"vm0", "vm1", "vm2", "vm3", "vm4"}
+
+	batches := getRollingBatches(instances, RollingUpgradePolicy{BatchSize: 2})
+	assert.Equal(t, [][]string{{"vm0", "vm1"}, {"vm2", "vm3"}, {"vm4"}}, batches)
+
+	assert.Empty(t, getRollingBatches([]string{}, RollingUpgradePolicy{BatchSize: 2}))
+}
+
+func Test_getReplacementInstances(t *testing.T) {
+	before := []string{"vm0", "vm1", "vm2"}
+
+	// new names
+	assert.Equal(t, []string{"vm3"}, getReplacementInstances(before, []string{"vm0", "vm2", "vm3"}, []string{"vm1"}))
+	// reused names
+	assert.Equal(t, []string{"vm1"}, getReplacementInstances(before, []string{"vm0", "vm1", "vm2"}, []string{"vm1"}))
+}
+
+func Test_validateInstances(t *testing.T) {
+	members := []string{"vm0", "vm1"}
+
+	assert.NoError(t, validateInstances("vmss", members, []string{"vm1"}))
+	assert.Error(t, validateInstances("vmss", members, []string{}))
+	assert.Error(t, validateInstances("vmss", members, []string{"vm2"}))
+	assert.Error(t, validateInstances("vmss", members, []string{"vm0", "vm0"}))
+}
+
+func Test_isInstanceHealthy(t *testing.T) {
+	view := &compute.VirtualMachineInstanceView{PowerState: compute.PowerStateRunning, GuestAgentHealth: compute.GuestAgentHealthDegraded}
+
+	assert.True(t, isInstanceHealthy(view, false))
+	assert.False(t, isInstanceHealthy(view, true))
+	view.GuestAgentHealth = compute.GuestAgentHealthHealthy
+	assert.True(t, isInstanceHealthy(view, true))
+	view.PowerState = compute.PowerStateStopped
+	assert.False(t, isInstanceHealthy(view, false))
+	assert.False(t, isInstanceHealthy(nil, false))
+}
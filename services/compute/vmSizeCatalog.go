@@ -0,0 +1,135 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the Apache v2.0 License.
+
+package compute
+
+import (
+	"sort"
+	"strings"
+
+	wcommon "github.com/microsoft/moc/common"
+	cloudcompute "github.com/microsoft/moc/rpc/common"
+)
+
+// VirtualMachineSizeInfo describes the hardware of a virtual machine size
+type VirtualMachineSizeInfo struct {
+	// Size
+	Size VirtualMachineSizeTypes
+	// CPUCount
+	CPUCount int32
+	// MemoryMB
+	MemoryMB int32
+	// GPUCount
+	GPUCount int32
+	// HPN - The size uses high performance networking
+	HPN bool
+}
+
+// VirtualMachineSizeRequirements filters the sizes recommended by the catalog. Zero values are not checked.
+type VirtualMachineSizeRequirements struct {
+	// MinCPUCount
+	MinCPUCount int32
+	// MaxCPUCount
+	MaxCPUCount int32
+	// MinMemoryMB
+	MinMemoryMB int32
+	// MaxMemoryMB
+	MaxMemoryMB int32
+	// MinGPUCount
+	MinGPUCount int32
+	// HPN - Require (true) or exclude (false) high performance networking sizes
+	HPN *bool
+}
+
+// VirtualMachineSizeCatalog is the hardware of the supported sizes, smallest first
+type VirtualMachineSizeCatalog []VirtualMachineSizeInfo
+
+// GetVirtualMachineSizeCatalog returns the catalog of the sizes supported by the cloud agent
+func GetVirtualMachineSizeCatalog() VirtualMachineSizeCatalog {
+	catalog := VirtualMachineSizeCatalog{}
+	for sizeType, vmSize := range wcommon.VirtualMachineSize_value {
+		size := GetCloudSdkVirtualMachineSizeFromCloudVirtualMachineSize(cloudcompute.VirtualMachineSizeType(sizeType))
+		if size == VirtualMachineSizeTypesCustom || vmSize.CpuCount <= 0 {
+			continue
+		}
+		catalog = append(catalog, VirtualMachineSizeInfo{
+			Size:     size,
+			CPUCount: vmSize.CpuCount,
+			MemoryMB: vmSize.MemoryMB,
+			GPUCount: vmSize.GpuCount,
+			HPN:      strings.HasSuffix(string(size), "_HPN"),
+		})
+	}
+	catalog.sort()
+	return catalog
+}
+
+// Get returns the hardware of the size
+func (c VirtualMachineSizeCatalog) Get(size VirtualMachineSizeTypes) (VirtualMachineSizeInfo, bool) {
+	for _, info := range c {
+		if info.Size == size {
+			return info, true
+		}
+	}
+	return VirtualMachineSizeInfo{}, false
+}
+
+// GetHardware returns the CPU count and memory of the hardware profile, from its custom size when the size is Custom.
+// Returns false if they are not known.
+func (c VirtualMachineSizeCatalog) GetHardware(profile *HardwareProfile) (VirtualMachineSizeInfo, bool) {
+	if profile == nil {
+		return VirtualMachineSizeInfo{}, false
+	}
+	if profile.VMSize != VirtualMachineSizeTypesCustom {
+		return c.Get(profile.VMSize)
+	}
+	if profile.CustomSize == nil || profile.CustomSize.CpuCount == nil || profile.CustomSize.MemoryMB == nil {
+		return VirtualMachineSizeInfo{}, false
+	}
+	return VirtualMachineSizeInfo{
+		Size:     VirtualMachineSizeTypesCustom,
+		CPUCount: *profile.CustomSize.CpuCount,
+		MemoryMB: *profile.CustomSize.MemoryMB,
+	}, true
+}
+
+// Recommend returns the sizes that meet the requirements, smallest first. Default is left out since it
+// duplicates a named size.
+func (c VirtualMachineSizeCatalog) Recommend(requirements VirtualMachineSizeRequirements) []VirtualMachineSizeInfo {
+	recommended := []VirtualMachineSizeInfo{}
+	for _, info := range c {
+		if info.Size != VirtualMachineSizeTypesDefault && requirements.matches(info) {
+			recommended = append(recommended, info)
+		}
+	}
+	return recommended
+}
+
+func (r VirtualMachineSizeRequirements) matches(info VirtualMachineSizeInfo) bool {
+	switch {
+	case info.CPUCount < r.MinCPUCount, r.MaxCPUCount > 0 && info.CPUCount > r.MaxCPUCount:
+		return false
+	case info.MemoryMB < r.MinMemoryMB, r.MaxMemoryMB > 0 && info.MemoryMB > r.MaxMemoryMB:
+		return false
+	case info.GPUCount < r.MinGPUCount:
+		return false
+	case r.HPN != nil && info.HPN != *r.HPN:
+		return false
+	}
+	return true
+}
+
+func (c VirtualMachineSizeCatalog) sort() {
+	sort.Slice(c, func(i, j int) bool {
+		if c[i].CPUCount != c[j].CPUCount {
+			return c[i].CPUCount < c[j].CPUCount
+		}
+		if c[i].MemoryMB != c[j].MemoryMB {
+			return c[i].MemoryMB < c[j].MemoryMB
+		}
+		if c[i].GPUCount != c[j].GPUCount {
+			return c[i].GPUCount < c[j].GPUCount
+		}
+		return c[i].Size < c[j].Size
+	})
+}
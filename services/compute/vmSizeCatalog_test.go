@@ -0,0 +1,47 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the Apache v2.0 License.
+
+package compute
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Recommend(t *testing.T) {
+	catalog := GetVirtualMachineSizeCatalog()
+	hpn := true
+	recommended := catalog.Recommend(VirtualMachineSizeRequirements{MinCPUCount: 4, HPN: &hpn})
+	require.NotEmpty(t, recommended)
+	for i, info := range recommended {
+		assert.True(t, info.HPN)
+		assert.GreaterOrEqual(t, info.CPUCount, int32(4))
+		if i > 0 {
+			assert.LessOrEqual(t, recommended[i-1].CPUCount, info.CPUCount)
+		}
+	}
+	assert.Empty(t, catalog.Recommend(VirtualMachineSizeRequirements{MinCPUCount: 100000}))
+}
+
+func Test_GetHardware(t *testing.T) {
+	catalog := GetVirtualMachineSizeCatalog()
+	named, ok := catalog.Get(VirtualMachineSizeTypesStandardA2V2)
+	require.True(t, ok)
+
+	info, ok := catalog.GetHardware(&HardwareProfile{VMSize: VirtualMachineSizeTypesStandardA2V2})
+	require.True(t, ok)
+	assert.Equal(t, named, info)
+
+	cpu, memory := int32(6), int32(12288)
+	info, ok = catalog.GetHardware(&HardwareProfile{VMSize: VirtualMachineSizeTypesCustom, CustomSize: &VirtualMachineCustomSize{CpuCount: &cpu, MemoryMB: &memory}})
+	require.True(t, ok)
+	assert.Equal(t, cpu, info.CPUCount)
+	assert.Equal(t, memory, info.MemoryMB)
+
+	_, ok = catalog.GetHardware(&HardwareProfile{VMSize: VirtualMachineSizeTypesCustom})
+	assert.False(t, ok)
+	_, ok = catalog.GetHardware(nil)
+	assert.False(t, ok)
+}
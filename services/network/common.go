@@ -1,6 +1,8 @@
 package network
 
 import (
+	"net/netip"
+
 	wssdcommonproto "github.com/microsoft/moc/rpc/common"
 )
 
@@ -61,3 +63,14 @@ func getNetworkPolicyType(wssdPolicyType wssdcommonproto.NetworkPolicyType) Netw
 		return NetworkPolicyType_Invalid
 	}
 }
+
+// GetPrefixRange returns the first and last addresses of a prefix
+func GetPrefixRange(prefix netip.Prefix) (first, last netip.Addr) {
+	prefix = prefix.Masked()
+	bytes := prefix.Addr().AsSlice()
+	for bit := prefix.Bits(); bit < len(bytes)*8; bit++ {
+		bytes[bit/8] |= 0x80 >> (bit % 8)
+	}
+	last, _ = netip.AddrFromSlice(bytes)
+	return prefix.Addr(), last
+}
@@ -0,0 +1,274 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the Apache v2.0 License.
+
+package ipam
+
+import (
+	"context"
+	"time"
+
+	"github.com/microsoft/moc-sdk-for-go/services/network"
+	"github.com/microsoft/moc-sdk-for-go/services/network/loadbalancer"
+	"github.com/microsoft/moc-sdk-for-go/services/network/logicalnetwork"
+	"github.com/microsoft/moc-sdk-for-go/services/network/macpool"
+	"github.com/microsoft/moc-sdk-for-go/services/network/networkinterface"
+	"github.com/microsoft/moc-sdk-for-go/services/network/publicipaddress"
+	"github.com/microsoft/moc-sdk-for-go/services/network/vippool"
+	"github.com/microsoft/moc-sdk-for-go/services/network/virtualnetwork"
+	"github.com/microsoft/moc/pkg/auth"
+	"github.com/microsoft/moc/pkg/errors"
+)
+
+// Client reads the inventory of networks and address consumers from the cloud agent
+type Client struct {
+	vnetclient    *virtualnetwork.VirtualNetworkClient
+	lnetclient    *logicalnetwork.LogicalNetworkClient
+	nicclient     *networkinterface.InterfaceClient
+	vippoolclient *vippool.VipPoolClient
+	macpoolclient *macpool.MacPoolClient
+	lbclient      *loadbalancer.LoadBalancerClient
+	pipclient     *publicipaddress.PublicIPAddressAgentClient
+}
+
+// NewClient returns a client for the IPAM helpers
+func NewClient(cloudFQDN string, authorizer auth.Authorizer) (*Client, error) {
+	vnetclient, err := virtualnetwork.NewVirtualNetworkClient(cloudFQDN, authorizer)
+	if err != nil {
+		return nil, err
+	}
+	lnetclient, err := logicalnetwork.NewLogicalNetworkClient(cloudFQDN, authorizer)
+	if err != nil {
+		return nil, err
+	}
+	nicclient, err := networkinterface.NewInterfaceClient(cloudFQDN, authorizer)
+	if err != nil {
+		return nil, err
+	}
+	vippoolclient, err := vippool.NewVipPoolClient(cloudFQDN, authorizer)
+	if err != nil {
+		return nil, err
+	}
+	macpoolclient, err := macpool.NewMacPoolClient(cloudFQDN, authorizer)
+	if err != nil {
+		return nil, err
+	}
+	lbclient, err := loadbalancer.NewLoadBalancerClient(cloudFQDN, authorizer)
+	if err != nil {
+		return nil, err
+	}
+	pipclient, err := publicipaddress.NewPublicIPAddressClient(cloudFQDN, authorizer)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{
+		vnetclient:    vnetclient,
+		lnetclient:    lnetclient,
+		nicclient:     nicclient,
+		vippoolclient: vippoolclient,
+		macpoolclient: macpoolclient,
+		lbclient:      lbclient,
+		pipclient:     pipclient,
+	}, nil
+}
+
+// GetInventory lists the virtual networks, network interfaces, load balancers and public IP addresses of
+// the group, and the logical networks and VIP pools of the location
+func (c *Client) GetInventory(ctx context.Context, group, location string) (*Inventory, error) {
+	if len(group) == 0 {
+		return nil, errors.Wrapf(errors.InvalidGroup, "Group not specified")
+	}
+	if len(location) == 0 {
+		return nil, errors.Wrapf(errors.InvalidInput, "Location not specified")
+	}
+
+	inv := &Inventory{}
+	vnets, err := c.vnetclient.Get(ctx, group, "")
+	if err != nil {
+		return nil, err
+	}
+	if vnets != nil {
+		inv.VirtualNetworks = *vnets
+	}
+	lnets, err := c.lnetclient.Get(ctx, location, "")
+	if err != nil {
+		return nil, err
+	}
+	if lnets != nil {
+		inv.LogicalNetworks = *lnets
+	}
+	nics, err := c.nicclient.Get(ctx, group, "")
+	if err != nil {
+		return nil, err
+	}
+	if nics != nil {
+		inv.Interfaces = *nics
+	}
+	lbs, err := c.lbclient.Get(ctx, group, "")
+	if err != nil {
+		return nil, err
+	}
+	if lbs != nil {
+		inv.LoadBalancers = *lbs
+	}
+	pips, err := c.pipclient.Get(ctx, group, "")
+	if err != nil {
+		return nil, err
+	}
+	if pips != nil {
+		inv.PublicIPAddresses = *pips
+	}
+	vippools, err := c.vippoolclient.Get(ctx, location, "")
+	if err != nil {
+		return nil, err
+	}
+	if vippools != nil {
+		inv.VipPools = *vippools
+	}
+	return inv, nil
+}
+
+// GetPoolInventory lists the VIP pools and MAC pools of the location, and the network interfaces, load
+// balancers and public IP addresses of the groups that use their addresses
+func (c *Client) GetPoolInventory(ctx context.Context, location string, groups ...string) (*Inventory, error) {
+	if len(location) == 0 {
+		return nil, errors.Wrapf(errors.InvalidInput, "Location not specified")
+	}
+	if len(groups) == 0 {
+		return nil, errors.Wrapf(errors.InvalidGroup, "Group not specified")
+	}
+
+	inv := &Inventory{}
+	vippools, err := c.vippoolclient.Get(ctx, location, "")
+	if err != nil {
+		return nil, err
+	}
+	if vippools != nil {
+		inv.VipPools = *vippools
+	}
+	macpools, err := c.macpoolclient.Get(ctx, location, "")
+	if err != nil {
+		return nil, err
+	}
+	if macpools != nil {
+		inv.MACPools = *macpools
+	}
+	for _, group := range groups {
+		if len(group) == 0 {
+			return nil, errors.Wrapf(errors.InvalidGroup, "Group not specified")
+		}
+		nics, err := c.nicclient.Get(ctx, group, "")
+		if err != nil {
+			return nil, err
+		}
+		if nics != nil {
+			inv.Interfaces = append(inv.Interfaces, *nics...)
+		}
+		lbs, err := c.lbclient.Get(ctx, group, "")
+		if err != nil {
+			return nil, err
+		}
+		if lbs != nil {
+			inv.LoadBalancers = append(inv.LoadBalancers, *lbs...)
+		}
+		pips, err := c.pipclient.Get(ctx, group, "")
+		if err != nil {
+			return nil, err
+		}
+		if pips != nil {
+			inv.PublicIPAddresses = append(inv.PublicIPAddresses, *pips...)
+		}
+	}
+	return inv, nil
+}
+
+// GetPoolUsage returns the usage of the VIP pools and MAC pools of the location by the resources of the groups
+func (c *Client) GetPoolUsage(ctx context.Context, location string, groups ...string) ([]PoolUsage, error) {
+	inv, err := c.GetPoolInventory(ctx, location, groups...)
+	if err != nil {
+		return nil, err
+	}
+	return inv.GetPoolUsage()
+}
+
+// WatchPoolUsage polls the pool usage every interval until the context is done, and calls callback when the
+// utilization of a pool reaches threshold. The callback is called again for a pool only after its
+// utilization dropped below threshold.
+func (c *Client) WatchPoolUsage(ctx context.Context, location string, groups []string, interval time.Duration, threshold float64, callback ThresholdFunc) error {
+	if interval <= 0 {
+		return errors.Wrapf(errors.InvalidInput, "Interval must be positive")
+	}
+	if threshold < 0 || threshold > 1 {
+		return errors.Wrapf(errors.InvalidInput, "Threshold %v must be between 0 and 1", threshold)
+	}
+	alerted := map[string]bool{}
+	for {
+		usages, err := c.GetPoolUsage(ctx, location, groups...)
+		if err != nil {
+			return err
+		}
+		current := map[string]bool{}
+		_ = CheckPoolThresholds(usages, threshold, func(usage PoolUsage) {
+			key := string(usage.Kind) + "/" + usage.Name
+			current[key] = true
+			if !alerted[key] {
+				callback(usage)
+			}
+		})
+		alerted = current
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+}
+
+// ExpandVipPool grows the StartIP-EndIP range of the VIP pool by additional addresses without overlapping
+// the other VIP pools, the logical network subnets or their VM IP pools of the location
+func (c *Client) ExpandVipPool(ctx context.Context, location, name string, additional uint64) (*network.VipPool, error) {
+	if len(location) == 0 {
+		return nil, errors.Wrapf(errors.InvalidInput, "Location not specified")
+	}
+	vippools, err := c.vippoolclient.Get(ctx, location, "")
+	if err != nil {
+		return nil, err
+	}
+	inv := &Inventory{}
+	if vippools != nil {
+		inv.VipPools = *vippools
+	}
+	lnets, err := c.lnetclient.Get(ctx, location, "")
+	if err != nil {
+		return nil, err
+	}
+	if lnets != nil {
+		inv.LogicalNetworks = *lnets
+	}
+	expanded, err := inv.ExpandVipPool(name, additional)
+	if err != nil {
+		return nil, err
+	}
+	return c.vippoolclient.CreateOrUpdate(ctx, location, name, expanded)
+}
+
+// ExpandMACPool grows the range of the MAC pool by additional addresses without overlapping the other MAC
+// pools of the location
+func (c *Client) ExpandMACPool(ctx context.Context, location, name string, additional uint64) (*network.MACPool, error) {
+	if len(location) == 0 {
+		return nil, errors.Wrapf(errors.InvalidInput, "Location not specified")
+	}
+	macpools, err := c.macpoolclient.Get(ctx, location, "")
+	if err != nil {
+		return nil, err
+	}
+	inv := &Inventory{}
+	if macpools != nil {
+		inv.MACPools = *macpools
+	}
+	expanded, err := inv.ExpandMACPool(name, additional)
+	if err != nil {
+		return nil, err
+	}
+	return c.macpoolclient.CreateOrUpdate(ctx, location, name, expanded)
+}
@@ -0,0 +1,530 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the Apache v2.0 License.
+
+package ipam
+
+import (
+	"math"
+	"math/big"
+	"net/netip"
+	"sort"
+	"strings"
+
+	"github.com/microsoft/moc-sdk-for-go/services/network"
+	"github.com/microsoft/moc-sdk-for-go/services/network/networkinterface"
+	"github.com/microsoft/moc/pkg/errors"
+)
+
+const vipPoolPrefix = "vippools"
+
+// NetworkType is the kind of network a subnet belongs to
+type NetworkType string
+
+const (
+	// NetworkTypeVirtual - Subnet of a virtual network
+	NetworkTypeVirtual NetworkType = networkinterface.VNET_PREFIX
+	// NetworkTypeLogical - Subnet of a logical network
+	NetworkTypeLogical NetworkType = networkinterface.LNET_PREFIX
+)
+
+// SubnetReference identifies a subnet of a virtual or logical network
+type SubnetReference struct {
+	// NetworkType
+	NetworkType NetworkType
+	// Network - Name of the network
+	Network string
+	// Subnet - Name of the subnet
+	Subnet string
+}
+
+// String returns the reference in the /<networktype>/<network>/subnets/<subnet> form used by network interfaces
+func (r SubnetReference) String() string {
+	return "/" + string(r.NetworkType) + "/" + r.Network + "/subnets/" + r.Subnet
+}
+
+// Allocation is an IP address in use in a subnet
+type Allocation struct {
+	// Address
+	Address string
+	// Interface - Name of the network interface using the address, empty for registered addresses
+	Interface string
+	// Registered - The address is registered as allocated outside of MOC IPAM
+	Registered bool
+}
+
+// AddressRange is an inclusive range of IP addresses
+type AddressRange struct {
+	// Start
+	Start string
+	// End
+	End string
+	// Count - Number of addresses in the range, capped at math.MaxUint64
+	Count uint64
+}
+
+// SubnetUsage is the allocated and free addresses of a subnet
+type SubnetUsage struct {
+	// Subnet
+	Subnet SubnetReference
+	// AddressPrefixes - Address prefixes of the subnet
+	AddressPrefixes []string
+	// Allocated - Addresses in use, in address order
+	Allocated []Allocation
+	// Free - Addresses of the VM IP pools, or of the whole subnet when it has none, that are not in use and
+	// not reserved for VIPs
+	Free []AddressRange
+}
+
+// AddressBlock is an address prefix or range owned by a subnet or a VIP pool
+type AddressBlock struct {
+	// Owner - Subnet reference, or /vippools/<name> for VIP pools
+	Owner string
+	// Prefix - The prefix or range as configured
+	Prefix string
+}
+
+// Overlap is a pair of address blocks that share addresses
+type Overlap struct {
+	// First
+	First AddressBlock
+	// Second
+	Second AddressBlock
+}
+
+// Inventory is a snapshot of the networks and of the resources that use their addresses
+type Inventory struct {
+	// VirtualNetworks
+	VirtualNetworks []network.VirtualNetwork
+	// LogicalNetworks
+	LogicalNetworks []network.LogicalNetwork
+	// Interfaces
+	Interfaces []network.Interface
+	// VipPools
+	VipPools []network.VipPool
+	// MACPools
+	MACPools []network.MACPool
+	// LoadBalancers - Load balancers whose frontends use VIP pool addresses
+	LoadBalancers []network.LoadBalancer
+	// PublicIPAddresses - Public IP addresses allocated from VIP pools
+	PublicIPAddresses []network.PublicIPAddress
+}
+
+// addressRange is an inclusive range of addresses of the same family
+type addressRange struct {
+	start netip.Addr
+	end   netip.Addr
+}
+
+// block is an address block with its parsed range
+type block struct {
+	AddressBlock
+	addressRange
+}
+
+// subnet is a subnet of the inventory with its parsed prefixes and pools
+type subnet struct {
+	reference SubnetReference
+	prefixes  []string
+	ranges    []addressRange
+	pools     []network.IPPool
+}
+
+// GetSubnetUsage returns the allocated and free addresses of every subnet of the inventory
+func (inv *Inventory) GetSubnetUsage() ([]SubnetUsage, error) {
+	subnets, err := inv.getSubnets()
+	if err != nil {
+		return nil, err
+	}
+	vipBlocks, err := inv.getVipPoolBlocks()
+	if err != nil {
+		return nil, err
+	}
+	allocations := inv.getInterfaceAllocations(subnets)
+
+	usages := []SubnetUsage{}
+	for _, s := range subnets {
+		usage := SubnetUsage{Subnet: s.reference, AddressPrefixes: s.prefixes, Allocated: allocations[s.reference.String()]}
+
+		available := []addressRange{}
+		reserved := []addressRange{}
+		for _, pool := range s.pools {
+			poolRange, err := parseRange(pool.Start, pool.End)
+			if err != nil {
+				return nil, errors.Wrapf(err, "Invalid IP pool [%s] in subnet [%s]", pool.Name, s.reference)
+			}
+			if pool.Type == network.VIPPOOL {
+				reserved = append(reserved, poolRange)
+			} else {
+				available = append(available, poolRange)
+			}
+			for _, address := range pool.RegisteredIPAddresses {
+				usage.Allocated = append(usage.Allocated, Allocation{Address: address, Registered: true})
+			}
+		}
+		if len(available) == 0 {
+			for _, r := range s.ranges {
+				available = append(available, getHostRange(r))
+			}
+		}
+		for _, vip := range vipBlocks {
+			reserved = append(reserved, vip.addressRange)
+		}
+		for _, allocation := range usage.Allocated {
+			if address, err := netip.ParseAddr(allocation.Address); err == nil {
+				reserved = append(reserved, addressRange{start: address, end: address})
+			}
+		}
+
+		sortAllocations(usage.Allocated)
+		usage.Free = toAddressRanges(subtractRanges(available, reserved))
+		usages = append(usages, usage)
+	}
+	return usages, nil
+}
+
+// FindOverlaps returns the address blocks of subnets and VIP pools that overlap. Blocks of the same subnet
+// are not compared.
+func (inv *Inventory) FindOverlaps() ([]Overlap, error) {
+	blocks, err := inv.getBlocks()
+	if err != nil {
+		return nil, err
+	}
+	overlaps := []Overlap{}
+	for i := range blocks {
+		for j := i + 1; j < len(blocks); j++ {
+			if blocks[i].Owner != blocks[j].Owner && blocks[i].overlaps(blocks[j].addressRange) {
+				overlaps = append(overlaps, Overlap{First: blocks[i].AddressBlock, Second: blocks[j].AddressBlock})
+			}
+		}
+	}
+	return overlaps, nil
+}
+
+// ProposeSubnets returns up to count prefixes of prefixLength bits, inside addressSpace, that do not overlap
+// any subnet or VIP pool of the inventory, lowest addresses first. Returns NotFound with the prefixes found
+// if addressSpace has room for fewer than count.
+func (inv *Inventory) ProposeSubnets(addressSpace string, prefixLength, count int) ([]string, error) {
+	space, err := netip.ParsePrefix(strings.TrimSpace(addressSpace))
+	if err != nil {
+		return nil, errors.Wrapf(errors.InvalidInput, "Invalid address space [%s]", addressSpace)
+	}
+	space = space.Masked()
+	if prefixLength < space.Bits() || prefixLength > space.Addr().BitLen() {
+		return nil, errors.Wrapf(errors.InvalidInput, "Prefix length %d must be between %d and %d", prefixLength, space.Bits(), space.Addr().BitLen())
+	}
+	if count <= 0 {
+		return nil, errors.Wrapf(errors.InvalidInput, "Count must be positive")
+	}
+	blocks, err := inv.getBlocks()
+	if err != nil {
+		return nil, err
+	}
+
+	proposed := []string{}
+	candidate := netip.PrefixFrom(space.Addr(), prefixLength)
+	for len(proposed) < count && space.Contains(candidate.Addr()) {
+		candidateRange := prefixRange(candidate)
+		var next netip.Addr
+		for _, b := range blocks {
+			if b.overlaps(candidateRange) && (!next.IsValid() || next.Less(b.end)) {
+				next = b.end
+			}
+		}
+		if !next.IsValid() {
+			proposed = append(proposed, candidate.String())
+			next = candidateRange.end
+		}
+		// continue at the first prefix boundary after the candidate or the blocks it overlaps
+		if next = next.Next(); !next.IsValid() {
+			break
+		}
+		if aligned := netip.PrefixFrom(next, prefixLength).Masked(); aligned.Addr() != next {
+			if next = prefixRange(aligned).end.Next(); !next.IsValid() {
+				break
+			}
+		}
+		candidate = netip.PrefixFrom(next, prefixLength)
+	}
+
+	if len(proposed) < count {
+		return proposed, errors.Wrapf(errors.NotFound, "Address space [%s] has room for %d of %d /%d subnets", addressSpace, len(proposed), count, prefixLength)
+	}
+	return proposed, nil
+}
+
+func (inv *Inventory) getSubnets() ([]subnet, error) {
+	subnets := []subnet{}
+	add := func(reference SubnetReference, prefix *string, prefixes *[]string, pools []network.IPPool) error {
+		s := subnet{reference: reference, pools: pools, prefixes: []string{}}
+		if prefix != nil && len(*prefix) > 0 {
+			s.prefixes = append(s.prefixes, *prefix)
+		}
+		if prefixes != nil {
+			s.prefixes = append(s.prefixes, *prefixes...)
+		}
+		for _, p := range s.prefixes {
+			parsed, err := netip.ParsePrefix(strings.TrimSpace(p))
+			if err != nil {
+				return errors.Wrapf(errors.InvalidInput, "Invalid address prefix [%s] in subnet [%s]", p, reference)
+			}
+			s.ranges = append(s.ranges, prefixRange(parsed.Masked()))
+		}
+		subnets = append(subnets, s)
+		return nil
+	}
+
+	for _, vnet := range inv.VirtualNetworks {
+		if vnet.VirtualNetworkPropertiesFormat == nil || vnet.Subnets == nil {
+			continue
+		}
+		for _, sn := range *vnet.Subnets {
+			if sn.SubnetPropertiesFormat == nil {
+				continue
+			}
+			reference := SubnetReference{NetworkType: NetworkTypeVirtual, Network: getName(vnet.Name), Subnet: getName(sn.Name)}
+			if err := add(reference, sn.AddressPrefix, sn.AddressPrefixes, sn.IPPools); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for _, lnet := range inv.LogicalNetworks {
+		if lnet.LogicalNetworkPropertiesFormat == nil || lnet.Subnets == nil {
+			continue
+		}
+		for _, sn := range *lnet.Subnets {
+			if sn.LogicalSubnetPropertiesFormat == nil {
+				continue
+			}
+			reference := SubnetReference{NetworkType: NetworkTypeLogical, Network: getName(lnet.Name), Subnet: getName(sn.Name)}
+			if err := add(reference, sn.AddressPrefix, sn.AddressPrefixes, sn.IPPools); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return subnets, nil
+}
+
+func (inv *Inventory) getVipPoolBlocks() ([]block, error) {
+	blocks := []block{}
+	for _, vp := range inv.VipPools {
+		if vp.VipPoolPropertiesFormat == nil {
+			continue
+		}
+		owner := "/" + vipPoolPrefix + "/" + getName(vp.Name)
+		var (
+			r      addressRange
+			prefix string
+			err    error
+		)
+		switch {
+		case vp.StartIP != nil && len(*vp.StartIP) > 0 && vp.EndIP != nil && len(*vp.EndIP) > 0:
+			prefix = *vp.StartIP + "-" + *vp.EndIP
+			r, err = parseRange(*vp.StartIP, *vp.EndIP)
+		case vp.IPPrefix != nil && len(*vp.IPPrefix) > 0:
+			prefix = *vp.IPPrefix
+			var parsed netip.Prefix
+			if parsed, err = netip.ParsePrefix(strings.TrimSpace(prefix)); err == nil {
+				r = prefixRange(parsed.Masked())
+			}
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, errors.Wrapf(errors.InvalidInput, "Invalid address range [%s] in vip pool [%s]", prefix, getName(vp.Name))
+		}
+		blocks = append(blocks, block{AddressBlock: AddressBlock{Owner: owner, Prefix: prefix}, addressRange: r})
+	}
+	return blocks, nil
+}
+
+func (inv *Inventory) getBlocks() ([]block, error) {
+	subnets, err := inv.getSubnets()
+	if err != nil {
+		return nil, err
+	}
+	blocks := []block{}
+	for _, s := range subnets {
+		for i, r := range s.ranges {
+			blocks = append(blocks, block{AddressBlock: AddressBlock{Owner: s.reference.String(), Prefix: s.prefixes[i]}, addressRange: r})
+		}
+	}
+	vipBlocks, err := inv.getVipPoolBlocks()
+	if err != nil {
+		return nil, err
+	}
+	return append(blocks, vipBlocks...), nil
+}
+
+// getInterfaceAllocations returns the addresses of the network interfaces by subnet reference. An IP
+// configuration without a parsable subnet reference is attributed to the only subnet containing its address.
+func (inv *Inventory) getInterfaceAllocations(subnets []subnet) map[string][]Allocation {
+	allocations := map[string][]Allocation{}
+	for _, nic := range inv.Interfaces {
+		if nic.InterfacePropertiesFormat == nil || nic.IPConfigurations == nil {
+			continue
+		}
+		for _, ipConfig := range *nic.IPConfigurations {
+			if ipConfig.InterfaceIPConfigurationPropertiesFormat == nil || ipConfig.PrivateIPAddress == nil || len(*ipConfig.PrivateIPAddress) == 0 {
+				continue
+			}
+			address, err := netip.ParseAddr(*ipConfig.PrivateIPAddress)
+			if err != nil {
+				continue
+			}
+			owner := ""
+			if ipConfig.Subnet != nil && ipConfig.Subnet.ID != nil {
+				owner = normalizeSubnetID(*ipConfig.Subnet.ID)
+			}
+			if len(owner) == 0 {
+				owner = findSubnet(subnets, address)
+			}
+			if len(owner) > 0 {
+				allocations[owner] = append(allocations[owner], Allocation{Address: address.String(), Interface: getName(nic.Name)})
+			}
+		}
+	}
+	return allocations
+}
+
+// normalizeSubnetID returns the /<networktype>/<network>/subnets/<subnet> form of a network interface subnet
+// reference, or empty if it cannot be parsed
+func normalizeSubnetID(id string) string {
+	networkPrefix, networkName, subnetName, err := networkinterface.ParseSubnetID(id)
+	if err != nil {
+		return ""
+	}
+	return SubnetReference{NetworkType: NetworkType(networkPrefix), Network: networkName, Subnet: subnetName}.String()
+}
+
+func findSubnet(subnets []subnet, address netip.Addr) string {
+	found := ""
+	for _, s := range subnets {
+		for _, r := range s.ranges {
+			if r.contains(address) {
+				if len(found) > 0 {
+					return ""
+				}
+				found = s.reference.String()
+				break
+			}
+		}
+	}
+	return found
+}
+
+func parseRange(start, end string) (addressRange, error) {
+	startAddress, err := netip.ParseAddr(strings.TrimSpace(start))
+	if err != nil {
+		return addressRange{}, errors.Wrapf(errors.InvalidInput, "Invalid start address [%s]", start)
+	}
+	endAddress, err := netip.ParseAddr(strings.TrimSpace(end))
+	if err != nil {
+		return addressRange{}, errors.Wrapf(errors.InvalidInput, "Invalid end address [%s]", end)
+	}
+	if startAddress.BitLen() != endAddress.BitLen() || endAddress.Less(startAddress) {
+		return addressRange{}, errors.Wrapf(errors.InvalidInput, "Invalid address range [%s-%s]", start, end)
+	}
+	return addressRange{start: startAddress, end: endAddress}, nil
+}
+
+// prefixRange returns the first and last addresses of a masked prefix
+func prefixRange(prefix netip.Prefix) addressRange {
+	start, end := network.GetPrefixRange(prefix)
+	return addressRange{start: start, end: end}
+}
+
+// getHostRange leaves out the network and broadcast addresses of IPv4 subnets larger than /31
+func getHostRange(r addressRange) addressRange {
+	if r.start.Is4() && r.start.Next().Less(r.end) {
+		return addressRange{start: r.start.Next(), end: r.end.Prev()}
+	}
+	return r
+}
+
+func (r addressRange) contains(address netip.Addr) bool {
+	return address.BitLen() == r.start.BitLen() && !address.Less(r.start) && !r.end.Less(address)
+}
+
+func (r addressRange) overlaps(o addressRange) bool {
+	return r.start.BitLen() == o.start.BitLen() && !r.end.Less(o.start) && !o.end.Less(r.start)
+}
+
+// subtractRanges returns the parts of the available ranges outside every reserved range, merged and sorted
+func subtractRanges(available, reserved []addressRange) []addressRange {
+	sort.Slice(reserved, func(i, j int) bool { return reserved[i].start.Less(reserved[j].start) })
+	remaining := []addressRange{}
+	for _, r := range mergeRanges(available) {
+		for _, cut := range reserved {
+			if !r.overlaps(cut) {
+				continue
+			}
+			if r.start.Less(cut.start) {
+				remaining = append(remaining, addressRange{start: r.start, end: cut.start.Prev()})
+			}
+			if !cut.end.Less(r.end) {
+				r = addressRange{}
+				break
+			}
+			r.start = cut.end.Next()
+		}
+		if r.start.IsValid() {
+			remaining = append(remaining, r)
+		}
+	}
+	return remaining
+}
+
+func mergeRanges(ranges []addressRange) []addressRange {
+	sorted := make([]addressRange, len(ranges))
+	copy(sorted, ranges)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].start.Less(sorted[j].start) })
+	merged := []addressRange{}
+	for _, r := range sorted {
+		last := len(merged) - 1
+		if last >= 0 && merged[last].start.BitLen() == r.start.BitLen() &&
+			(merged[last].overlaps(r) || merged[last].end.Next() == r.start) {
+			if merged[last].end.Less(r.end) {
+				merged[last].end = r.end
+			}
+			continue
+		}
+		merged = append(merged, r)
+	}
+	return merged
+}
+
+func toAddressRanges(ranges []addressRange) []AddressRange {
+	result := []AddressRange{}
+	for _, r := range ranges {
+		result = append(result, AddressRange{Start: r.start.String(), End: r.end.String(), Count: r.count()})
+	}
+	return result
+}
+
+func (r addressRange) count() uint64 {
+	start := new(big.Int).SetBytes(r.start.AsSlice())
+	end := new(big.Int).SetBytes(r.end.AsSlice())
+	count := end.Sub(end, start).Add(end, big.NewInt(1))
+	if !count.IsUint64() {
+		return math.MaxUint64
+	}
+	return count.Uint64()
+}
+
+func sortAllocations(allocations []Allocation) {
+	sort.SliceStable(allocations, func(i, j int) bool {
+		a, errA := netip.ParseAddr(allocations[i].Address)
+		b, errB := netip.ParseAddr(allocations[j].Address)
+		if errA != nil || errB != nil {
+			return errB != nil && errA == nil
+		}
+		return a.Less(b)
+	})
+}
+
+func getName(name *string) string {
+	if name == nil {
+		return ""
+	}
+	return *name
+}
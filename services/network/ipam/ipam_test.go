@@ -0,0 +1,144 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the Apache v2.0 License.
+
+package ipam
+
+import (
+	"testing"
+
+	"github.com/microsoft/moc-sdk-for-go/services/network"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func strPtr(s string) *string {
+	return &s
+}
+
+func newInventory() *Inventory {
+	return &Inventory{
+		VirtualNetworks: []network.VirtualNetwork{{
+			Name: strPtr("vnet1"),
+			VirtualNetworkPropertiesFormat: &network.VirtualNetworkPropertiesFormat{
+				Subnets: &[]network.Subnet{{
+					Name:                   strPtr("web"),
+					SubnetPropertiesFormat: &network.SubnetPropertiesFormat{AddressPrefix: strPtr("10.0.0.0/26")},
+				}},
+			},
+		}},
+		LogicalNetworks: []network.LogicalNetwork{{
+			Name: strPtr("lnet1"),
+			LogicalNetworkPropertiesFormat: &network.LogicalNetworkPropertiesFormat{
+				Subnets: &[]network.LogicalSubnet{{
+					Name: strPtr("mgmt"),
+					LogicalSubnetPropertiesFormat: &network.LogicalSubnetPropertiesFormat{
+						AddressPrefix: strPtr("10.0.1.0/24"),
+						IPPools: []network.IPPool{
+							{Name: "vms", Type: network.VM, Start: "10.0.1.10", End: "10.0.1.20", RegisteredIPAddresses: []string{"10.0.1.12"}},
+							{Name: "vips", Type: network.VIPPOOL, Start: "10.0.1.200", End: "10.0.1.250"},
+						},
+					},
+				}},
+			},
+		}},
+		Interfaces: []network.Interface{
+			newInterface("nic1", "/virtualnetworks/vnet1/subnets/web", "10.0.0.5"),
+			newInterface("nic2", "/logicalnetworks/lnet1/subnets/mgmt", "10.0.1.10"),
+			newInterface("nic3", "", "10.0.1.15"),
+		},
+		VipPools: []network.VipPool{{
+			Name:                    strPtr("vip1"),
+			VipPoolPropertiesFormat: &network.VipPoolPropertiesFormat{IPPrefix: strPtr("10.0.0.48/28")},
+		}},
+	}
+}
+
+func newInterface(name, subnetID, address string) network.Interface {
+	ipConfig := network.InterfaceIPConfiguration{
+		InterfaceIPConfigurationPropertiesFormat: &network.InterfaceIPConfigurationPropertiesFormat{PrivateIPAddress: &address},
+	}
+	if len(subnetID) > 0 {
+		ipConfig.Subnet = &network.APIEntityReference{ID: &subnetID}
+	}
+	return network.Interface{
+		Name:                      &name,
+		InterfacePropertiesFormat: &network.InterfacePropertiesFormat{IPConfigurations: &[]network.InterfaceIPConfiguration{ipConfig}},
+	}
+}
+
+func Test_GetSubnetUsage(t *testing.T) {
+	usages, err := newInventory().GetSubnetUsage()
+	require.NoError(t, err)
+	require.Len(t, usages, 2)
+
+	web := usages[0]
+	assert.Equal(t, "/virtualnetworks/vnet1/subnets/web", web.Subnet.String())
+	assert.Equal(t, []Allocation{{Address: "10.0.0.5", Interface: "nic1"}}, web.Allocated)
+	// network and broadcast addresses, nic1 and the vip pool are left out
+	assert.Equal(t, []AddressRange{
+		{Start: "10.0.0.1", End: "10.0.0.4", Count: 4},
+		{Start: "10.0.0.6", End: "10.0.0.47", Count: 42},
+	}, web.Free)
+
+	mgmt := usages[1]
+	assert.Equal(t, []Allocation{
+		{Address: "10.0.1.10", Interface: "nic2"},
+		{Address: "10.0.1.12", Registered: true},
+		{Address: "10.0.1.15", Interface: "nic3"},
+	}, mgmt.Allocated)
+	assert.Equal(t, []AddressRange{
+		{Start: "10.0.1.11", End: "10.0.1.11", Count: 1},
+		{Start: "10.0.1.13", End: "10.0.1.14", Count: 2},
+		{Start: "10.0.1.16", End: "10.0.1.20", Count: 5},
+	}, mgmt.Free)
+}
+
+func Test_FindOverlaps(t *testing.T) {
+	inv := newInventory()
+	overlaps, err := inv.FindOverlaps()
+	require.NoError(t, err)
+	require.Len(t, overlaps, 1)
+	assert.Equal(t, AddressBlock{Owner: "/virtualnetworks/vnet1/subnets/web", Prefix: "10.0.0.0/26"}, overlaps[0].First)
+	assert.Equal(t, AddressBlock{Owner: "/vippools/vip1", Prefix: "10.0.0.48/28"}, overlaps[0].Second)
+
+	inv.VipPools = nil
+	overlaps, err = inv.FindOverlaps()
+	require.NoError(t, err)
+	assert.Empty(t, overlaps)
+}
+
+func Test_ProposeSubnets(t *testing.T) {
+	inv := newInventory()
+	proposed, err := inv.ProposeSubnets("10.0.0.0/23", 26, 3)
+	require.NoError(t, err)
+	// 10.0.0.0/26 is used by the web subnet, 10.0.1.0/24 by the mgmt subnet
+	assert.Equal(t, []string{"10.0.0.64/26", "10.0.0.128/26", "10.0.0.192/26"}, proposed)
+
+	proposed, err = inv.ProposeSubnets("10.0.0.0/23", 26, 4)
+	assert.Error(t, err)
+	assert.Len(t, proposed, 3)
+
+	proposed, err = inv.ProposeSubnets("10.0.0.0/24", 28, 1)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"10.0.0.64/28"}, proposed)
+
+	_, err = inv.ProposeSubnets("10.0.0.0/24", 16, 1)
+	assert.Error(t, err)
+	_, err = inv.ProposeSubnets("not-a-prefix", 26, 1)
+	assert.Error(t, err)
+}
+
+func Test_ProposeSubnets_IPv6(t *testing.T) {
+	inv := &Inventory{VirtualNetworks: []network.VirtualNetwork{{
+		Name: strPtr("vnet6"),
+		VirtualNetworkPropertiesFormat: &network.VirtualNetworkPropertiesFormat{
+			Subnets: &[]network.Subnet{{
+				Name:                   strPtr("a"),
+				SubnetPropertiesFormat: &network.SubnetPropertiesFormat{AddressPrefix: strPtr("fd00::/64")},
+			}},
+		},
+	}}}
+	proposed, err := inv.ProposeSubnets("fd00::/48", 64, 2)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"fd00:0:0:1::/64", "fd00:0:0:2::/64"}, proposed)
+}
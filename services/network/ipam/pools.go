@@ -0,0 +1,435 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the Apache v2.0 License.
+
+package ipam
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"net"
+	"net/netip"
+	"sort"
+	"strings"
+
+	"github.com/microsoft/moc-sdk-for-go/services/network"
+	"github.com/microsoft/moc/pkg/errors"
+)
+
+// PoolKind is the kind of address pool
+type PoolKind string
+
+const (
+	// PoolKindVipPool - Pool of IP addresses for load balancer frontends and public IP addresses
+	PoolKindVipPool PoolKind = "VipPool"
+	// PoolKindMACPool - Pool of MAC addresses for network interfaces
+	PoolKindMACPool PoolKind = "MACPool"
+)
+
+// PoolAllocation is an address of a pool in use by a resource
+type PoolAllocation struct {
+	// Address
+	Address string
+	// Resource - /networkinterfaces/<name>, /loadbalancers/<name> or /publicipaddresses/<name>
+	Resource string
+}
+
+// PoolUsage is the allocated addresses of a VIP or MAC pool
+type PoolUsage struct {
+	// Kind
+	Kind PoolKind
+	// Name
+	Name string
+	// Range - The range or prefix as configured
+	Range string
+	// Total - Number of addresses in the pool, capped at math.MaxUint64
+	Total uint64
+	// Allocated - Distinct addresses in use, capped at Total
+	Allocated uint64
+	// Allocations - Addresses in use, in address order. An address shared by several resources is listed
+	// once per resource.
+	Allocations []PoolAllocation
+	// Utilization - Allocated / Total, between 0 and 1
+	Utilization float64
+}
+
+// Available returns the number of addresses of the pool that are not in use
+func (u PoolUsage) Available() uint64 {
+	return u.Total - u.Allocated
+}
+
+// ThresholdFunc is called with the usage of a pool whose utilization reached the threshold
+type ThresholdFunc func(usage PoolUsage)
+
+// CheckPoolThresholds calls callback with each usage whose utilization is at or above threshold, a
+// fraction between 0 and 1
+func CheckPoolThresholds(usages []PoolUsage, threshold float64, callback ThresholdFunc) error {
+	if threshold < 0 || threshold > 1 {
+		return errors.Wrapf(errors.InvalidInput, "Threshold %v must be between 0 and 1", threshold)
+	}
+	for _, usage := range usages {
+		if usage.Utilization >= threshold {
+			callback(usage)
+		}
+	}
+	return nil
+}
+
+// span is an inclusive range of addresses as integers
+type span struct {
+	start *big.Int
+	end   *big.Int
+}
+
+// GetPoolUsage returns the usage of every VIP pool and MAC pool of the inventory. VIP pool addresses are
+// used by load balancer frontends and public IP addresses, MAC pool addresses by network interfaces.
+func (inv *Inventory) GetPoolUsage() ([]PoolUsage, error) {
+	usages := []PoolUsage{}
+
+	vipBlocks, err := inv.getVipPoolBlocks()
+	if err != nil {
+		return nil, err
+	}
+	vipAllocations := inv.getVipAllocations()
+	for _, b := range vipBlocks {
+		usage := PoolUsage{Kind: PoolKindVipPool, Name: strings.TrimPrefix(b.Owner, "/"+vipPoolPrefix+"/"), Range: b.Prefix, Total: b.count()}
+		for _, allocation := range vipAllocations {
+			if address, err := netip.ParseAddr(allocation.Address); err == nil && b.contains(address) {
+				usage.Allocations = append(usage.Allocations, allocation)
+			}
+		}
+		usages = append(usages, finishPoolUsage(usage, func(address string) string {
+			if parsed, err := netip.ParseAddr(address); err == nil {
+				return parsed.String()
+			}
+			return address
+		}))
+	}
+
+	for _, mp := range inv.MACPools {
+		name := getName(mp.Name)
+		r, prefix, ok, err := getMACPoolSpan(mp)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+		usage := PoolUsage{Kind: PoolKindMACPool, Name: name, Range: prefix, Total: countSpan(r)}
+		for _, nic := range inv.Interfaces {
+			if nic.InterfacePropertiesFormat == nil || nic.MacAddress == nil {
+				continue
+			}
+			if mac, err := parseMAC(*nic.MacAddress); err == nil && r.contains(mac) {
+				usage.Allocations = append(usage.Allocations, PoolAllocation{Address: *nic.MacAddress, Resource: "/networkinterfaces/" + getName(nic.Name)})
+			}
+		}
+		usages = append(usages, finishPoolUsage(usage, func(address string) string {
+			if mac, err := net.ParseMAC(strings.TrimSpace(address)); err == nil {
+				return mac.String()
+			}
+			return strings.ToLower(address)
+		}))
+	}
+	return usages, nil
+}
+
+// ExpandVipPool returns a copy of the VIP pool whose StartIP-EndIP range is grown by additional addresses,
+// at the end first and then at the start, without leaving the IPPrefix of the pool or the subnets holding it,
+// and without overlapping the other VIP pools, the other subnets or the VM IP pools of the subnets, the
+// blocks checked by FindOverlaps. Pools defined only by a prefix cannot be grown.
+func (inv *Inventory) ExpandVipPool(name string, additional uint64) (*network.VipPool, error) {
+	if additional == 0 {
+		return nil, errors.Wrapf(errors.InvalidInput, "Number of additional addresses must be positive")
+	}
+	blocks, err := inv.getBlocks()
+	if err != nil {
+		return nil, err
+	}
+	subnets, err := inv.getSubnets()
+	if err != nil {
+		return nil, err
+	}
+	var pool *network.VipPool
+	for i := range inv.VipPools {
+		if getName(inv.VipPools[i].Name) == name {
+			pool = &inv.VipPools[i]
+		}
+	}
+	if pool == nil || pool.VipPoolPropertiesFormat == nil {
+		return nil, errors.Wrapf(errors.NotFound, "Vip pool [%s] not found", name)
+	}
+	if pool.StartIP == nil || len(*pool.StartIP) == 0 || pool.EndIP == nil || len(*pool.EndIP) == 0 {
+		return nil, errors.Wrapf(errors.InvalidInput, "Vip pool [%s] has no StartIP and EndIP range to expand", name)
+	}
+	current, err := parseRange(*pool.StartIP, *pool.EndIP)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Invalid range in vip pool [%s]", name)
+	}
+
+	bitLen := current.start.BitLen()
+	limit := span{start: big.NewInt(0), end: maxValue(bitLen)}
+	if pool.IPPrefix != nil && len(*pool.IPPrefix) > 0 {
+		prefix, err := netip.ParsePrefix(strings.TrimSpace(*pool.IPPrefix))
+		if err != nil {
+			return nil, errors.Wrapf(errors.InvalidInput, "Invalid IPPrefix [%s] in vip pool [%s]", *pool.IPPrefix, name)
+		}
+		limit = toSpan(prefixRange(prefix.Masked()))
+	}
+	others := []span{}
+	for _, b := range blocks {
+		if b.Owner == "/"+vipPoolPrefix+"/"+name || b.start.BitLen() != bitLen {
+			continue
+		}
+		// a subnet holding the pool bounds its growth, other subnets and pools block it
+		if !strings.HasPrefix(b.Owner, "/"+vipPoolPrefix+"/") && b.contains(current.start) && b.contains(current.end) {
+			limit = intersectSpans(limit, toSpan(b.addressRange))
+			continue
+		}
+		others = append(others, toSpan(b.addressRange))
+	}
+	for _, sn := range subnets {
+		for _, ipPool := range sn.pools {
+			if ipPool.Type == network.VIPPOOL {
+				continue
+			}
+			poolRange, err := parseRange(ipPool.Start, ipPool.End)
+			if err != nil {
+				return nil, errors.Wrapf(err, "Invalid IP pool [%s] in subnet [%s]", ipPool.Name, sn.reference)
+			}
+			if poolRange.start.BitLen() == bitLen {
+				others = append(others, toSpan(poolRange))
+			}
+		}
+	}
+
+	grown, err := growSpan(toSpan(current), limit, others, additional)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Unable to expand vip pool [%s]", name)
+	}
+	expanded := *pool
+	properties := *pool.VipPoolPropertiesFormat
+	startIP := toAddr(grown.start, bitLen).String()
+	endIP := toAddr(grown.end, bitLen).String()
+	properties.StartIP = &startIP
+	properties.EndIP = &endIP
+	expanded.VipPoolPropertiesFormat = &properties
+	return &expanded, nil
+}
+
+// ExpandMACPool returns a copy of the MAC pool whose range is grown by additional addresses, at the end
+// first and then at the start, without overlapping the other MAC pools. The new addresses keep the
+// separator and case of the configured ones.
+func (inv *Inventory) ExpandMACPool(name string, additional uint64) (*network.MACPool, error) {
+	if additional == 0 {
+		return nil, errors.Wrapf(errors.InvalidInput, "Number of additional addresses must be positive")
+	}
+	var pool *network.MACPool
+	others := []span{}
+	for i := range inv.MACPools {
+		r, _, ok, err := getMACPoolSpan(inv.MACPools[i])
+		if err != nil {
+			return nil, err
+		}
+		if getName(inv.MACPools[i].Name) == name {
+			if !ok {
+				return nil, errors.Wrapf(errors.InvalidInput, "Mac pool [%s] has no range to expand", name)
+			}
+			pool = &inv.MACPools[i]
+		} else if ok {
+			others = append(others, r)
+		}
+	}
+	if pool == nil {
+		return nil, errors.Wrapf(errors.NotFound, "Mac pool [%s] not found", name)
+	}
+	current, _, _, _ := getMACPoolSpan(*pool)
+
+	grown, err := growSpan(current, span{start: big.NewInt(0), end: maxValue(48)}, others, additional)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Unable to expand mac pool [%s]", name)
+	}
+	expanded := *pool
+	properties := *pool.MACPoolPropertiesFormat
+	start := formatMAC(grown.start, *pool.Range.StartMACAddress)
+	end := formatMAC(grown.end, *pool.Range.EndMACAddress)
+	properties.Range = &network.MACRange{StartMACAddress: &start, EndMACAddress: &end}
+	expanded.MACPoolPropertiesFormat = &properties
+	return &expanded, nil
+}
+
+// getVipAllocations returns the addresses of the load balancer frontends and of the public IP addresses
+func (inv *Inventory) getVipAllocations() []PoolAllocation {
+	allocations := []PoolAllocation{}
+	for _, lb := range inv.LoadBalancers {
+		if lb.LoadBalancerPropertiesFormat == nil || lb.FrontendIPConfigurations == nil {
+			continue
+		}
+		for _, frontend := range *lb.FrontendIPConfigurations {
+			if frontend.FrontendIPConfigurationPropertiesFormat != nil && frontend.IPAddress != nil && len(*frontend.IPAddress) > 0 {
+				allocations = append(allocations, PoolAllocation{Address: *frontend.IPAddress, Resource: "/loadbalancers/" + getName(lb.Name)})
+			}
+		}
+	}
+	for _, pip := range inv.PublicIPAddresses {
+		if pip.PublicIPAddressPropertiesFormat != nil && pip.IPAddress != nil && len(*pip.IPAddress) > 0 {
+			allocations = append(allocations, PoolAllocation{Address: *pip.IPAddress, Resource: "/publicipaddresses/" + getName(pip.Name)})
+		}
+	}
+	return allocations
+}
+
+// finishPoolUsage sorts the allocations and counts the distinct addresses, compared by their key
+func finishPoolUsage(usage PoolUsage, key func(address string) string) PoolUsage {
+	sort.SliceStable(usage.Allocations, func(i, j int) bool {
+		a, b := key(usage.Allocations[i].Address), key(usage.Allocations[j].Address)
+		if a != b {
+			if addrA, errA := netip.ParseAddr(a); errA == nil {
+				if addrB, errB := netip.ParseAddr(b); errB == nil {
+					return addrA.Less(addrB)
+				}
+			}
+			return a < b
+		}
+		return usage.Allocations[i].Resource < usage.Allocations[j].Resource
+	})
+	distinct := map[string]bool{}
+	for _, allocation := range usage.Allocations {
+		distinct[key(allocation.Address)] = true
+	}
+	usage.Allocated = uint64(len(distinct))
+	if usage.Allocated > usage.Total {
+		usage.Allocated = usage.Total
+	}
+	if usage.Total > 0 {
+		usage.Utilization = float64(usage.Allocated) / float64(usage.Total)
+	}
+	return usage
+}
+
+// getMACPoolSpan returns the range of the MAC pool, and false when the pool has no range
+func getMACPoolSpan(mp network.MACPool) (span, string, bool, error) {
+	if mp.MACPoolPropertiesFormat == nil || mp.Range == nil || mp.Range.StartMACAddress == nil || mp.Range.EndMACAddress == nil {
+		return span{}, "", false, nil
+	}
+	prefix := *mp.Range.StartMACAddress + "-" + *mp.Range.EndMACAddress
+	start, errStart := parseMAC(*mp.Range.StartMACAddress)
+	end, errEnd := parseMAC(*mp.Range.EndMACAddress)
+	if errStart != nil || errEnd != nil || end.Cmp(start) < 0 {
+		return span{}, prefix, false, errors.Wrapf(errors.InvalidInput, "Invalid MAC range [%s] in mac pool [%s]", prefix, getName(mp.Name))
+	}
+	return span{start: start, end: end}, prefix, true, nil
+}
+
+// growSpan grows current by additional values inside limit, at the end first and then at the start,
+// stopping before the first other span on each side
+func growSpan(current, limit span, others []span, additional uint64) (span, error) {
+	upper := new(big.Int).Set(limit.end)
+	lower := new(big.Int).Set(limit.start)
+	for _, o := range others {
+		if o.start.Cmp(current.end) > 0 {
+			if bound := new(big.Int).Sub(o.start, big.NewInt(1)); bound.Cmp(upper) < 0 {
+				upper = bound
+			}
+		} else if o.end.Cmp(current.start) < 0 {
+			if bound := new(big.Int).Add(o.end, big.NewInt(1)); bound.Cmp(lower) > 0 {
+				lower = bound
+			}
+		} else {
+			return span{}, errors.Wrapf(errors.InvalidInput, "The range already overlaps another pool")
+		}
+	}
+
+	remaining := new(big.Int).SetUint64(additional)
+	grown := span{start: new(big.Int).Set(current.start), end: new(big.Int).Set(current.end)}
+	if room := new(big.Int).Sub(upper, grown.end); room.Sign() > 0 {
+		step := minInt(room, remaining)
+		grown.end.Add(grown.end, step)
+		remaining.Sub(remaining, step)
+	}
+	if room := new(big.Int).Sub(grown.start, lower); remaining.Sign() > 0 && room.Sign() > 0 {
+		step := minInt(room, remaining)
+		grown.start.Sub(grown.start, step)
+		remaining.Sub(remaining, step)
+	}
+	if remaining.Sign() > 0 {
+		return span{}, errors.Wrapf(errors.InvalidInput, "Only %s of %d addresses are free next to the range",
+			new(big.Int).Sub(new(big.Int).SetUint64(additional), remaining).String(), additional)
+	}
+	return grown, nil
+}
+
+func (s span) contains(value *big.Int) bool {
+	return value.Cmp(s.start) >= 0 && value.Cmp(s.end) <= 0
+}
+
+func countSpan(s span) uint64 {
+	count := new(big.Int).Sub(s.end, s.start)
+	count.Add(count, big.NewInt(1))
+	if !count.IsUint64() {
+		return math.MaxUint64
+	}
+	return count.Uint64()
+}
+
+func toSpan(r addressRange) span {
+	return span{start: new(big.Int).SetBytes(r.start.AsSlice()), end: new(big.Int).SetBytes(r.end.AsSlice())}
+}
+
+func toAddr(value *big.Int, bitLen int) netip.Addr {
+	bytes := make([]byte, bitLen/8)
+	value.FillBytes(bytes)
+	addr, _ := netip.AddrFromSlice(bytes)
+	return addr
+}
+
+func maxValue(bits int) *big.Int {
+	max := new(big.Int).Lsh(big.NewInt(1), uint(bits))
+	return max.Sub(max, big.NewInt(1))
+}
+
+// intersectSpans returns the values of a that are also in b. The spans must overlap.
+func intersectSpans(a, b span) span {
+	start := a.start
+	if b.start.Cmp(start) > 0 {
+		start = b.start
+	}
+	return span{start: new(big.Int).Set(start), end: minInt(a.end, b.end)}
+}
+
+func minInt(a, b *big.Int) *big.Int {
+	if a.Cmp(b) < 0 {
+		return new(big.Int).Set(a)
+	}
+	return new(big.Int).Set(b)
+}
+
+// parseMAC parses a 48-bit MAC address written with colons, hyphens or dots
+func parseMAC(address string) (*big.Int, error) {
+	mac, err := net.ParseMAC(strings.TrimSpace(address))
+	if err != nil {
+		return nil, err
+	}
+	if len(mac) != 6 {
+		return nil, errors.Wrapf(errors.InvalidInput, "Invalid MAC address [%s]", address)
+	}
+	return new(big.Int).SetBytes(mac), nil
+}
+
+// formatMAC formats a 48-bit value with the separator and case of like: colons, hyphens or the dotted
+// xxxx.xxxx.xxxx form
+func formatMAC(value *big.Int, like string) string {
+	bytes := make([]byte, 6)
+	value.FillBytes(bytes)
+	formatted := net.HardwareAddr(bytes).String()
+	switch {
+	case strings.Contains(like, "."):
+		formatted = fmt.Sprintf("%02x%02x.%02x%02x.%02x%02x", bytes[0], bytes[1], bytes[2], bytes[3], bytes[4], bytes[5])
+	case strings.Contains(like, "-"):
+		formatted = strings.ReplaceAll(formatted, ":", "-")
+	}
+	if strings.ToUpper(like) == like {
+		formatted = strings.ToUpper(formatted)
+	}
+	return formatted
+}
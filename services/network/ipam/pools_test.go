@@ -0,0 +1,205 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the Apache v2.0 License.
+
+package ipam
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/microsoft/moc-sdk-for-go/services/network"
+	"github.com/microsoft/moc/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newPoolInventory() *Inventory {
+	return &Inventory{
+		VipPools: []network.VipPool{
+			{
+				Name:                    strPtr("vip1"),
+				VipPoolPropertiesFormat: &network.VipPoolPropertiesFormat{StartIP: strPtr("10.0.0.10"), EndIP: strPtr("10.0.0.13")},
+			},
+			{
+				Name:                    strPtr("vip2"),
+				VipPoolPropertiesFormat: &network.VipPoolPropertiesFormat{StartIP: strPtr("10.0.0.16"), EndIP: strPtr("10.0.0.20")},
+			},
+		},
+		MACPools: []network.MACPool{
+			{
+				Name: strPtr("mac1"),
+				MACPoolPropertiesFormat: &network.MACPoolPropertiesFormat{
+					Range: &network.MACRange{StartMACAddress: strPtr("00-15-5D-00-00-00"), EndMACAddress: strPtr("00-15-5D-00-00-03")},
+				},
+			},
+			{
+				Name: strPtr("mac2"),
+				MACPoolPropertiesFormat: &network.MACPoolPropertiesFormat{
+					Range: &network.MACRange{StartMACAddress: strPtr("00-15-5D-00-00-08"), EndMACAddress: strPtr("00-15-5D-00-00-0F")},
+				},
+			},
+		},
+		Interfaces: []network.Interface{
+			{Name: strPtr("nic1"), InterfacePropertiesFormat: &network.InterfacePropertiesFormat{MacAddress: strPtr("00:15:5d:00:00:01")}},
+			{Name: strPtr("nic2"), InterfacePropertiesFormat: &network.InterfacePropertiesFormat{MacAddress: strPtr("00-15-5D-00-00-02")}},
+			{Name: strPtr("nic3"), InterfacePropertiesFormat: &network.InterfacePropertiesFormat{MacAddress: strPtr("00-15-5D-00-00-30")}},
+			{Name: strPtr("nic4"), InterfacePropertiesFormat: &network.InterfacePropertiesFormat{MacAddress: strPtr("0015.5d00.0002")}},
+		},
+		LoadBalancers: []network.LoadBalancer{{
+			Name: strPtr("lb1"),
+			LoadBalancerPropertiesFormat: &network.LoadBalancerPropertiesFormat{
+				FrontendIPConfigurations: &[]network.FrontendIPConfiguration{{
+					FrontendIPConfigurationPropertiesFormat: &network.FrontendIPConfigurationPropertiesFormat{IPAddress: strPtr("10.0.0.10")},
+				}},
+			},
+		}},
+		PublicIPAddresses: []network.PublicIPAddress{
+			{Name: strPtr("pip1"), PublicIPAddressPropertiesFormat: &network.PublicIPAddressPropertiesFormat{IPAddress: strPtr("10.0.0.10")}},
+			{Name: strPtr("pip2"), PublicIPAddressPropertiesFormat: &network.PublicIPAddressPropertiesFormat{IPAddress: strPtr("10.0.0.12")}},
+			{Name: strPtr("pip3"), PublicIPAddressPropertiesFormat: &network.PublicIPAddressPropertiesFormat{IPAddress: strPtr("10.0.0.17")}},
+		},
+	}
+}
+
+func Test_GetPoolUsage(t *testing.T) {
+	usages, err := newPoolInventory().GetPoolUsage()
+	require.NoError(t, err)
+	require.Len(t, usages, 4)
+
+	vip1 := usages[0]
+	assert.Equal(t, PoolKindVipPool, vip1.Kind)
+	assert.Equal(t, "vip1", vip1.Name)
+	assert.Equal(t, uint64(4), vip1.Total)
+	assert.Equal(t, uint64(2), vip1.Allocated)
+	assert.Equal(t, uint64(2), vip1.Available())
+	assert.Equal(t, 0.5, vip1.Utilization)
+	assert.Equal(t, []PoolAllocation{
+		{Address: "10.0.0.10", Resource: "/loadbalancers/lb1"},
+		{Address: "10.0.0.10", Resource: "/publicipaddresses/pip1"},
+		{Address: "10.0.0.12", Resource: "/publicipaddresses/pip2"},
+	}, vip1.Allocations)
+
+	assert.Equal(t, "vip2", usages[1].Name)
+	assert.Equal(t, uint64(1), usages[1].Allocated)
+
+	mac1 := usages[2]
+	assert.Equal(t, PoolKindMACPool, mac1.Kind)
+	assert.Equal(t, uint64(4), mac1.Total)
+	assert.Equal(t, uint64(2), mac1.Allocated)
+	assert.Equal(t, []PoolAllocation{
+		{Address: "00:15:5d:00:00:01", Resource: "/networkinterfaces/nic1"},
+		{Address: "00-15-5D-00-00-02", Resource: "/networkinterfaces/nic2"},
+		{Address: "0015.5d00.0002", Resource: "/networkinterfaces/nic4"},
+	}, mac1.Allocations)
+	assert.Equal(t, uint64(0), usages[3].Allocated)
+}
+
+func Test_CheckPoolThresholds(t *testing.T) {
+	usages, err := newPoolInventory().GetPoolUsage()
+	require.NoError(t, err)
+
+	alerted := []string{}
+	require.NoError(t, CheckPoolThresholds(usages, 0.5, func(usage PoolUsage) {
+		alerted = append(alerted, usage.Name)
+	}))
+	assert.Equal(t, []string{"vip1", "mac1"}, alerted)
+	assert.Error(t, CheckPoolThresholds(usages, 1.5, func(PoolUsage) {}))
+}
+
+func Test_ExpandVipPool(t *testing.T) {
+	inv := newPoolInventory()
+
+	// 10.0.0.14-15 are free above vip1, then it grows downwards
+	expanded, err := inv.ExpandVipPool("vip1", 4)
+	require.NoError(t, err)
+	assert.Equal(t, "10.0.0.8", *expanded.StartIP)
+	assert.Equal(t, "10.0.0.15", *expanded.EndIP)
+	assert.Equal(t, "10.0.0.10", *inv.VipPools[0].StartIP)
+
+	inv.VipPools[0].IPPrefix = strPtr("10.0.0.8/29")
+	_, err = inv.ExpandVipPool("vip1", 5)
+	assert.ErrorIs(t, err, errors.InvalidInput)
+
+	_, err = inv.ExpandVipPool("missing", 1)
+	assert.ErrorIs(t, err, errors.NotFound)
+
+	inv.VipPools[1] = network.VipPool{Name: strPtr("vip2"), VipPoolPropertiesFormat: &network.VipPoolPropertiesFormat{IPPrefix: strPtr("10.0.1.0/28")}}
+	_, err = inv.ExpandVipPool("vip2", 1)
+	assert.ErrorIs(t, err, errors.InvalidInput)
+}
+
+func Test_ExpandVipPool_Subnets(t *testing.T) {
+	inv := newPoolInventory()
+	inv.LogicalNetworks = []network.LogicalNetwork{{
+		Name: strPtr("lnet1"),
+		LogicalNetworkPropertiesFormat: &network.LogicalNetworkPropertiesFormat{
+			Subnets: &[]network.LogicalSubnet{{
+				Name: strPtr("mgmt"),
+				LogicalSubnetPropertiesFormat: &network.LogicalSubnetPropertiesFormat{
+					AddressPrefix: strPtr("10.0.0.8/29"),
+					IPPools: []network.IPPool{
+						{Name: "vms", Type: network.VM, Start: "10.0.0.8", End: "10.0.0.8"},
+						{Name: "vips", Type: network.VIPPOOL, Start: "10.0.0.10", End: "10.0.0.15"},
+					},
+				},
+			}},
+		},
+	}}
+
+	// vip1 grows inside the subnet holding it, around its VM IP pool
+	expanded, err := inv.ExpandVipPool("vip1", 3)
+	require.NoError(t, err)
+	assert.Equal(t, "10.0.0.9", *expanded.StartIP)
+	assert.Equal(t, "10.0.0.15", *expanded.EndIP)
+	_, err = inv.ExpandVipPool("vip1", 4)
+	assert.ErrorIs(t, err, errors.InvalidInput)
+
+	// vip2 does not grow into the subnet
+	expanded, err = inv.ExpandVipPool("vip2", 1)
+	require.NoError(t, err)
+	assert.Equal(t, "10.0.0.21", *expanded.EndIP)
+	inv.VipPools[1].IPPrefix = strPtr("10.0.0.16/28")
+	inv.VipPools[1].EndIP = strPtr("10.0.0.31")
+	_, err = inv.ExpandVipPool("vip2", 1)
+	assert.ErrorIs(t, err, errors.InvalidInput)
+}
+
+func Test_ExpandMACPool(t *testing.T) {
+	inv := newPoolInventory()
+
+	expanded, err := inv.ExpandMACPool("mac1", 4)
+	require.NoError(t, err)
+	assert.Equal(t, "00-15-5D-00-00-00", *expanded.Range.StartMACAddress)
+	assert.Equal(t, "00-15-5D-00-00-07", *expanded.Range.EndMACAddress)
+
+	// 00-15-5D-00-00-04 to 07 are free above mac1, then it grows downwards
+	expanded, err = inv.ExpandMACPool("mac1", 6)
+	require.NoError(t, err)
+	assert.Equal(t, "00-15-5C-FF-FF-FE", *expanded.Range.StartMACAddress)
+	assert.Equal(t, "00-15-5D-00-00-07", *expanded.Range.EndMACAddress)
+
+	inv.MACPools[0].Range.StartMACAddress = strPtr("00-00-00-00-00-00")
+	_, err = inv.ExpandMACPool("mac1", 5)
+	assert.ErrorIs(t, err, errors.InvalidInput)
+	_, err = inv.ExpandMACPool("missing", 1)
+	assert.ErrorIs(t, err, errors.NotFound)
+
+	expanded, err = inv.ExpandMACPool("mac2", 2)
+	require.NoError(t, err)
+	assert.Equal(t, "00-15-5D-00-00-11", *expanded.Range.EndMACAddress)
+}
+
+func Test_formatMAC(t *testing.T) {
+	value, err := parseMAC("00:15:5d:00:04:ff")
+	require.NoError(t, err)
+	value.Add(value, big.NewInt(1))
+
+	assert.Equal(t, "00:15:5d:00:05:00", formatMAC(value, "00:15:5d:00:04:ff"))
+	assert.Equal(t, "00-15-5D-00-05-00", formatMAC(value, "00-15-5D-00-04-FF"))
+	assert.Equal(t, "0015.5d00.0500", formatMAC(value, "0015.5d00.04ff"))
+	assert.Equal(t, "0015.5D00.0500", formatMAC(value, "0015.5D00.04FF"))
+
+	dotted, err := parseMAC(formatMAC(value, "0015.5d00.04ff"))
+	require.NoError(t, err)
+	assert.Equal(t, value, dotted)
+}
@@ -0,0 +1,126 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the Apache v2.0 License.
+
+package loadbalancer
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	"github.com/microsoft/moc-sdk-for-go/services/admin/version"
+	"github.com/microsoft/moc/pkg/errors"
+)
+
+// DefaultMinimumV2MocVersion is the MOC release negotiated to Version_2_0 unless SetMinimumV2MocVersion
+// overrides it. It is the MOC release this SDK is built against, whose cloud agent accepts the V2 load
+// balancer shape.
+const DefaultMinimumV2MocVersion = "v0.43.3"
+
+// versionGetter returns the agent version and the MOC version of the cloud agent
+type versionGetter interface {
+	GetVersion(context.Context) (string, string, error)
+}
+
+// SetAPIVersion pins the API version used by the rule helpers, and skips the negotiation
+func (c *LoadBalancerClient) SetAPIVersion(apiVersion string) error {
+	if _, err := getApiVersion(apiVersion); err != nil {
+		return err
+	}
+	c.versionLock.Lock()
+	defer c.versionLock.Unlock()
+	c.apiVersion = &apiVersion
+	return nil
+}
+
+// SetMinimumV2MocVersion overrides DefaultMinimumV2MocVersion with the oldest MOC version whose cloud
+// agent accepts the V2 load balancer shape, such as v0.11.0, and discards the pinned or negotiated API
+// version. The agent does not report the load balancer shapes it accepts, so negotiation compares its
+// MOC version with this threshold.
+func (c *LoadBalancerClient) SetMinimumV2MocVersion(mocVersion string) error {
+	if _, err := parseMocVersion(mocVersion); err != nil {
+		return err
+	}
+	c.versionLock.Lock()
+	defer c.versionLock.Unlock()
+	c.minimumV2MocVersion = &mocVersion
+	c.apiVersion = nil
+	return nil
+}
+
+// NegotiateAPIVersion returns the API version of the load balancer shape accepted by the cloud agent.
+// It returns Version_2_0 when the MOC version of the agent is at least DefaultMinimumV2MocVersion, or the
+// threshold set by SetMinimumV2MocVersion, and Version_1_0 when it is older. The agent is queried once,
+// and the result is reused by later calls.
+func (c *LoadBalancerClient) NegotiateAPIVersion(ctx context.Context) (string, error) {
+	c.versionLock.Lock()
+	defer c.versionLock.Unlock()
+	if c.apiVersion != nil {
+		return *c.apiVersion, nil
+	}
+	if c.versions == nil {
+		versions, err := version.NewVersionClient(c.cloudFQDN, c.authorizer)
+		if err != nil {
+			return "", err
+		}
+		c.versions = versions
+	}
+	_, mocVersion, err := c.versions.GetVersion(ctx)
+	if err != nil {
+		return "", err
+	}
+	minimumV2MocVersion := DefaultMinimumV2MocVersion
+	if c.minimumV2MocVersion != nil {
+		minimumV2MocVersion = *c.minimumV2MocVersion
+	}
+	apiVersion, err := getApiVersionForMocVersion(mocVersion, minimumV2MocVersion)
+	if err != nil {
+		return "", err
+	}
+	c.apiVersion = &apiVersion
+	return apiVersion, nil
+}
+
+// getApiVersionForMocVersion returns the load balancer API version supported by a MOC version, given the
+// oldest MOC version supporting V2 load balancers
+func getApiVersionForMocVersion(mocVersion, minimumV2MocVersion string) (string, error) {
+	numbers, err := parseMocVersion(mocVersion)
+	if err != nil {
+		return "", err
+	}
+	minimum, err := parseMocVersion(minimumV2MocVersion)
+	if err != nil {
+		return "", err
+	}
+	for i := range numbers {
+		if numbers[i] != minimum[i] {
+			if numbers[i] > minimum[i] {
+				return Version_2_0, nil
+			}
+			return Version_1_0, nil
+		}
+	}
+	return Version_2_0, nil
+}
+
+// parseMocVersion parses the major, minor and patch numbers of versions such as v0.11.0 or
+// 1.2.3-rc1. Missing numbers are zero.
+func parseMocVersion(mocVersion string) ([3]int, error) {
+	numbers := [3]int{}
+	trimmed := strings.TrimPrefix(strings.TrimSpace(mocVersion), "v")
+	if i := strings.IndexAny(trimmed, "-+"); i >= 0 {
+		trimmed = trimmed[:i]
+	}
+	components := strings.Split(trimmed, ".")
+	if len(trimmed) == 0 || len(components) > len(numbers) {
+		return numbers, errors.Wrapf(errors.InvalidInput, "Unable to parse MOC version [%s]", mocVersion)
+	}
+	for i, component := range components {
+		number, err := strconv.Atoi(component)
+		if err != nil || number < 0 {
+			return numbers, errors.Wrapf(errors.InvalidInput, "Unable to parse MOC version [%s]", mocVersion)
+		}
+		numbers[i] = number
+	}
+	return numbers, nil
+}
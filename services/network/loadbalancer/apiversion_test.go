@@ -0,0 +1,76 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the Apache v2.0 License.
+
+package loadbalancer
+
+import (
+	"context"
+	"testing"
+
+	"github.com/microsoft/moc/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeVersions struct {
+	mocVersion string
+	calls      int
+}
+
+func (v *fakeVersions) GetVersion(ctx context.Context) (string, string, error) {
+	v.calls++
+	return "1.0.0.0", v.mocVersion, nil
+}
+
+func TestGetApiVersionForMocVersion(t *testing.T) {
+	for mocVersion, expected := range map[string]string{
+		"v0.10.9":     Version_1_0,
+		"0.9":         Version_1_0,
+		"0.11.0":      Version_2_0,
+		"v0.11.1-rc1": Version_2_0,
+		"v0.43.3":     Version_2_0,
+		"1":           Version_2_0,
+	} {
+		apiVersion, err := getApiVersionForMocVersion(mocVersion, "v0.11.0")
+		require.NoError(t, err, mocVersion)
+		assert.Equal(t, expected, apiVersion, mocVersion)
+	}
+
+	for _, mocVersion := range []string{"", "dev", "1.2.3.4", "v1.x"} {
+		_, err := getApiVersionForMocVersion(mocVersion, "v0.11.0")
+		assert.ErrorIs(t, err, errors.InvalidInput, mocVersion)
+		assert.False(t, errors.IsInvalidVersion(err), mocVersion)
+	}
+}
+
+func TestNegotiateAPIVersion(t *testing.T) {
+	versions := &fakeVersions{mocVersion: "v0.43.3"}
+	c := &LoadBalancerClient{versions: versions}
+
+	// The default threshold selects Version_2_0 for the MOC release the SDK is built against
+	apiVersion, err := c.NegotiateAPIVersion(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, Version_2_0, apiVersion)
+	_, err = c.NegotiateAPIVersion(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 1, versions.calls)
+
+	// Older agents are negotiated to Version_1_0 unless the threshold is lowered
+	versions.mocVersion = "v0.20.0"
+	c = &LoadBalancerClient{versions: versions}
+	apiVersion, err = c.NegotiateAPIVersion(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, Version_1_0, apiVersion)
+
+	assert.ErrorIs(t, c.SetMinimumV2MocVersion("dev"), errors.InvalidInput)
+	require.NoError(t, c.SetMinimumV2MocVersion("v0.11.0"))
+	apiVersion, err = c.NegotiateAPIVersion(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, Version_2_0, apiVersion)
+
+	require.NoError(t, c.SetAPIVersion(Version_1_0))
+	apiVersion, err = c.NegotiateAPIVersion(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, Version_1_0, apiVersion)
+	assert.Error(t, c.SetAPIVersion("3.0"))
+}
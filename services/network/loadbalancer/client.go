@@ -5,9 +5,13 @@ package loadbalancer
 
 import (
 	"context"
+	"sync"
+	"time"
 
 	"github.com/microsoft/moc-sdk-for-go/services/network"
+	"github.com/microsoft/moc-sdk-for-go/services/network/networkinterface"
 	"github.com/microsoft/moc/pkg/auth"
+	"github.com/microsoft/moc/pkg/errors"
 )
 
 // Service interface
@@ -25,7 +29,26 @@ type Service interface {
 // LoadBalancerClient structure
 type LoadBalancerClient struct {
 	network.BaseClient
-	internal Service
+	internal   Service
+	cloudFQDN  string
+	authorizer auth.Authorizer
+	// interfaces is created on first use by getInterfaces
+	interfaces     interfaceService
+	interfacesLock sync.Mutex
+	prober         Prober
+	// versions is created on first use by NegotiateAPIVersion
+	versions versionGetter
+	// apiVersion is the pinned or negotiated API version of the rule helpers
+	apiVersion *string
+	// minimumV2MocVersion overrides DefaultMinimumV2MocVersion
+	minimumV2MocVersion *string
+	versionLock         sync.Mutex
+}
+
+// interfaceService is the part of the network interface client used to find and update backends
+type interfaceService interface {
+	Get(context.Context, string, string) (*[]network.Interface, error)
+	CreateOrUpdate(context.Context, string, string, *network.Interface) (*network.Interface, error)
 }
 
 // NewLoadBalancerClient method returns new client
@@ -35,7 +58,21 @@ func NewLoadBalancerClient(cloudFQDN string, authorizer auth.Authorizer) (*LoadB
 		return nil, err
 	}
 
-	return &LoadBalancerClient{internal: c}, nil
+	return &LoadBalancerClient{internal: c, cloudFQDN: cloudFQDN, authorizer: authorizer, prober: &ProbeRunner{}}, nil
+}
+
+// getInterfaces returns the network interface client, which is created on first use
+func (c *LoadBalancerClient) getInterfaces() (interfaceService, error) {
+	c.interfacesLock.Lock()
+	defer c.interfacesLock.Unlock()
+	if c.interfaces == nil {
+		interfaces, err := networkinterface.NewInterfaceClient(c.cloudFQDN, c.authorizer)
+		if err != nil {
+			return nil, err
+		}
+		c.interfaces = interfaces
+	}
+	return c.interfaces, nil
 }
 
 // Get methods invokes the client Get method
@@ -48,12 +85,14 @@ func (c *LoadBalancerClient) GetWithVersion(ctx context.Context, group, name, ap
 	return c.internal.GetWithVersion(ctx, group, name, apiVersion)
 }
 
-// Ensure methods invokes create or update on the client
+// Ensure methods invokes create or update on the client. The inbound NAT rules of the load balancer are
+// sent to the agent and must have a name and both ports.
 func (c *LoadBalancerClient) CreateOrUpdate(ctx context.Context, group, name string, lb *network.LoadBalancer) (*network.LoadBalancer, error) {
 	return c.internal.CreateOrUpdate(ctx, group, name, lb)
 }
 
-// Ensure methods invokes create or update on the client
+// Ensure methods invokes create or update on the client. The inbound NAT rules of the load balancer are
+// sent to the agent and must have a name and both ports.
 func (c *LoadBalancerClient) CreateOrUpdateWithVersion(ctx context.Context, group, name string, lb *network.LoadBalancer, apiVersion string) (*network.LoadBalancer, error) {
 	return c.internal.CreateOrUpdateWithVersion(ctx, group, name, lb, apiVersion)
 }
@@ -79,3 +118,66 @@ func (c *LoadBalancerClient) Precheck(ctx context.Context, group string, loadBal
 func (c *LoadBalancerClient) PrecheckWithVersion(ctx context.Context, group string, loadBalancers []*network.LoadBalancer, apiVersion string) (bool, error) {
 	return c.internal.PrecheckWithVersion(ctx, group, loadBalancers, apiVersion)
 }
+
+// SetProber replaces the prober used by GetBackendHealth
+func (c *LoadBalancerClient) SetProber(prober Prober) {
+	c.prober = prober
+}
+
+// GetBackendHealth returns the probe state of each backend IP of the load balancer. The backends are
+// probed from the SDK host with the prober, as the cloud agent does not report probe results.
+func (c *LoadBalancerClient) GetBackendHealth(ctx context.Context, group, name string) ([]BackendHealth, error) {
+	if len(name) == 0 {
+		return nil, errors.Wrapf(errors.InvalidInput, "Missing load balancer name")
+	}
+	lbs, err := c.Get(ctx, group, name)
+	if err != nil {
+		return nil, err
+	}
+	if lbs == nil || len(*lbs) == 0 {
+		return nil, errors.Wrapf(errors.NotFound, "Load balancer [%s] not found", name)
+	}
+	nicClient, err := c.getInterfaces()
+	if err != nil {
+		return nil, err
+	}
+	nics, err := nicClient.Get(ctx, group, "")
+	if err != nil && !errors.IsNotFound(err) {
+		return nil, err
+	}
+	interfaces := []network.Interface{}
+	if nics != nil {
+		interfaces = *nics
+	}
+	prober := c.prober
+	if prober == nil {
+		prober = &ProbeRunner{}
+	}
+	return BackendHealthOf(ctx, &(*lbs)[0], interfaces, prober), nil
+}
+
+// WaitForHealthyBackends polls GetBackendHealth every interval until at least count backend IPs are
+// healthy, and returns the last health. It fails when the context is done first.
+func (c *LoadBalancerClient) WaitForHealthyBackends(ctx context.Context, group, name string, count int, interval time.Duration) ([]BackendHealth, error) {
+	if count < 1 {
+		return nil, errors.Wrapf(errors.InvalidInput, "Invalid healthy backend count [%d]", count)
+	}
+	if interval <= 0 {
+		interval = defaultProbeTimeout
+	}
+	for {
+		health, err := c.GetBackendHealth(ctx, group, name)
+		if err != nil {
+			return nil, err
+		}
+		healthy := HealthyBackendCount(health)
+		if healthy >= count {
+			return health, nil
+		}
+		select {
+		case <-ctx.Done():
+			return health, errors.Wrapf(errors.Failed, "Load balancer [%s] has %d of %d healthy backends: %v", name, healthy, count, ctx.Err())
+		case <-time.After(interval):
+		}
+	}
+}
@@ -0,0 +1,316 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the Apache v2.0 License.
+
+package loadbalancer
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/microsoft/moc-sdk-for-go/services/network"
+	"github.com/microsoft/moc/pkg/errors"
+)
+
+// ProbeState is the health of a backend as seen by a probe
+type ProbeState string
+
+const (
+	// ProbeStateHealthy - The last probe succeeded
+	ProbeStateHealthy ProbeState = "Healthy"
+	// ProbeStateUnhealthy - The last probe failed
+	ProbeStateUnhealthy ProbeState = "Unhealthy"
+	// ProbeStateUnknown - The backend could not be probed, such as when no rule of its pool has a probe
+	ProbeStateUnknown ProbeState = "Unknown"
+)
+
+// defaultProbeTimeout bounds a single client-side probe
+const defaultProbeTimeout = 5 * time.Second
+
+// BackendHealth is the probe result of one backend IP of a load balancer
+type BackendHealth struct {
+	// BackendAddressPool - Name of the backend pool
+	BackendAddressPool string `json:"backendAddressPool"`
+	// NetworkInterface - Name of the network interface that holds the IP, empty when the IP comes from the pool itself
+	NetworkInterface string `json:"networkInterface,omitempty"`
+	// IPAddress - Backend IP address
+	IPAddress string `json:"ipAddress"`
+	// Probe - Name of the probe, empty when no rule of the pool has a probe
+	Probe string `json:"probe,omitempty"`
+	// State
+	State ProbeState `json:"state"`
+	// LastProbeTime - When the probe was run, zero when the backend was not probed
+	LastProbeTime time.Time `json:"lastProbeTime,omitempty"`
+	// FailureReason - Why the backend is not healthy
+	FailureReason string `json:"failureReason,omitempty"`
+}
+
+// Prober checks a load balancer probe against a backend IP address
+type Prober interface {
+	Probe(ctx context.Context, probe network.Probe, ipAddress string) error
+}
+
+// ProbeRunner runs TCP, HTTP and HTTPS probes from the SDK host. It is the fallback used to
+// report backend health, as the cloud agent does not return per-backend probe results.
+type ProbeRunner struct {
+	// Timeout - Bound of a single probe, 5 seconds when zero
+	Timeout time.Duration
+	// HTTPClient - Client of HTTP and HTTPS probes. The default client does not verify server
+	// certificates, like the load balancer, and does not follow redirects.
+	HTTPClient *http.Client
+}
+
+// Probe runs the probe against the IP address. A TCP probe succeeds when the connection is accepted,
+// an HTTP or HTTPS probe when the request path answers 200 OK.
+func (r *ProbeRunner) Probe(ctx context.Context, probe network.Probe, ipAddress string) error {
+	if probe.ProbePropertiesFormat == nil || probe.Port == nil {
+		return errors.Wrapf(errors.InvalidInput, "Probe Port not set")
+	}
+	if *probe.Port < 1 || *probe.Port > 65535 {
+		return errors.Wrapf(errors.InvalidInput, "Probe Port [%d] must be between 1 and 65535", *probe.Port)
+	}
+	timeout := r.Timeout
+	if timeout <= 0 {
+		timeout = defaultProbeTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	address := net.JoinHostPort(ipAddress, strconv.Itoa(int(*probe.Port)))
+	switch probe.Protocol {
+	case network.ProbeProtocolTCP, "":
+		dialer := net.Dialer{}
+		conn, err := dialer.DialContext(ctx, "tcp", address)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	case network.ProbeProtocolHTTP, network.ProbeProtocolHTTPS:
+		path := "/"
+		if probe.RequestPath != nil && len(*probe.RequestPath) > 0 {
+			path = "/" + strings.TrimPrefix(*probe.RequestPath, "/")
+		}
+		scheme := "http"
+		if probe.Protocol == network.ProbeProtocolHTTPS {
+			scheme = "https"
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, scheme+"://"+address+path, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := r.httpClient().Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("%s returned %s", path, resp.Status)
+		}
+		return nil
+	default:
+		return errors.Wrapf(errors.InvalidInput, "Unknown Probe Protocol [%s]", probe.Protocol)
+	}
+}
+
+func (r *ProbeRunner) httpClient() *http.Client {
+	if r.HTTPClient != nil {
+		return r.HTTPClient
+	}
+	return &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// BackendHealthOf probes the backends of the load balancer with the prober. The backends are the IP
+// configurations of the network interfaces that reference a backend pool of the load balancer, and
+// those listed by the pool itself. Each backend is probed once per probe of the rules of its pool.
+// The result is sorted by pool, IP address and probe.
+func BackendHealthOf(ctx context.Context, lb *network.LoadBalancer, nics []network.Interface, prober Prober) []BackendHealth {
+	health := []BackendHealth{}
+	if lb == nil || lb.LoadBalancerPropertiesFormat == nil || lb.BackendAddressPools == nil {
+		return health
+	}
+
+	probes := map[string]network.Probe{}
+	if lb.Probes != nil {
+		for _, probe := range *lb.Probes {
+			if probe.Name != nil {
+				probes[*probe.Name] = probe
+			}
+		}
+	}
+	// Probe names of the rules of each pool
+	poolProbes := map[string][]string{}
+	if lb.LoadBalancingRules != nil {
+		for _, rule := range *lb.LoadBalancingRules {
+			if rule.LoadBalancingRulePropertiesFormat == nil || rule.BackendAddressPool == nil || rule.BackendAddressPool.ID == nil ||
+				rule.Probe == nil || rule.Probe.ID == nil {
+				continue
+			}
+			pool := *rule.BackendAddressPool.ID
+			if !contains(poolProbes[pool], *rule.Probe.ID) {
+				poolProbes[pool] = append(poolProbes[pool], *rule.Probe.ID)
+			}
+		}
+	}
+
+	for _, backend := range getBackends(lb, nics) {
+		names := poolProbes[backend.BackendAddressPool]
+		if len(names) == 0 {
+			backend.State = ProbeStateUnknown
+			backend.FailureReason = "No load balancing rule of the backend pool has a probe"
+			health = append(health, backend)
+			continue
+		}
+		for _, name := range names {
+			entry := backend
+			entry.Probe = name
+			health = append(health, entry)
+		}
+	}
+
+	var wg sync.WaitGroup
+	for i := range health {
+		if len(health[i].Probe) == 0 {
+			continue
+		}
+		probe, ok := probes[health[i].Probe]
+		if !ok {
+			health[i].State = ProbeStateUnknown
+			health[i].FailureReason = fmt.Sprintf("Probe [%s] not found in the load balancer", health[i].Probe)
+			continue
+		}
+		wg.Add(1)
+		go func(entry *BackendHealth, probe network.Probe) {
+			defer wg.Done()
+			entry.LastProbeTime = time.Now()
+			if err := prober.Probe(ctx, probe, entry.IPAddress); err != nil {
+				entry.State = ProbeStateUnhealthy
+				entry.FailureReason = err.Error()
+				return
+			}
+			entry.State = ProbeStateHealthy
+		}(&health[i], probe)
+	}
+	wg.Wait()
+
+	sort.SliceStable(health, func(i, j int) bool {
+		if health[i].BackendAddressPool != health[j].BackendAddressPool {
+			return health[i].BackendAddressPool < health[j].BackendAddressPool
+		}
+		if health[i].IPAddress != health[j].IPAddress {
+			return health[i].IPAddress < health[j].IPAddress
+		}
+		return health[i].Probe < health[j].Probe
+	})
+	return health
+}
+
+// HealthyBackendCount returns the number of backend IPs whose every probe is healthy
+func HealthyBackendCount(health []BackendHealth) int {
+	healthy := map[string]bool{}
+	for _, entry := range health {
+		key := entry.BackendAddressPool + "/" + entry.IPAddress
+		if _, ok := healthy[key]; !ok {
+			healthy[key] = true
+		}
+		healthy[key] = healthy[key] && entry.State == ProbeStateHealthy
+	}
+	count := 0
+	for _, ok := range healthy {
+		if ok {
+			count++
+		}
+	}
+	return count
+}
+
+// getBackends returns one entry per pool and IP address of the load balancer
+func getBackends(lb *network.LoadBalancer, nics []network.Interface) []BackendHealth {
+	lbName := ""
+	if lb.Name != nil {
+		lbName = *lb.Name
+	}
+	backends := []BackendHealth{}
+	seen := map[string]bool{}
+	add := func(pool, nic string, ipConfig network.InterfaceIPConfiguration) {
+		if ipConfig.InterfaceIPConfigurationPropertiesFormat == nil || ipConfig.PrivateIPAddress == nil || len(*ipConfig.PrivateIPAddress) == 0 {
+			return
+		}
+		key := pool + "/" + *ipConfig.PrivateIPAddress
+		if seen[key] {
+			return
+		}
+		seen[key] = true
+		backends = append(backends, BackendHealth{
+			BackendAddressPool: pool,
+			NetworkInterface:   nic,
+			IPAddress:          *ipConfig.PrivateIPAddress,
+		})
+	}
+
+	for _, pool := range *lb.BackendAddressPools {
+		if pool.Name == nil {
+			continue
+		}
+		for _, nic := range nics {
+			if nic.InterfacePropertiesFormat == nil || nic.IPConfigurations == nil {
+				continue
+			}
+			nicName := ""
+			if nic.Name != nil {
+				nicName = *nic.Name
+			}
+			for _, ipConfig := range *nic.IPConfigurations {
+				if ipConfig.InterfaceIPConfigurationPropertiesFormat == nil || ipConfig.LoadBalancerBackendAddressPools == nil {
+					continue
+				}
+				for _, ref := range *ipConfig.LoadBalancerBackendAddressPools {
+					if isPoolReference(ref.Name, lbName, *pool.Name) {
+						add(*pool.Name, nicName, ipConfig)
+					}
+				}
+			}
+		}
+		if pool.BackendAddressPoolPropertiesFormat != nil && pool.BackendIPConfigurations != nil {
+			for _, ipConfig := range *pool.BackendIPConfigurations {
+				add(*pool.Name, "", ipConfig)
+			}
+		}
+	}
+	return backends
+}
+
+// isPoolReference reports whether ref, either /loadbalancers/<lb>/backendaddresspools/<pool> or the
+// pool name, refers to the pool of the load balancer
+func isPoolReference(ref *string, lbName, poolName string) bool {
+	if ref == nil {
+		return false
+	}
+	components := strings.Split(*ref, "/")
+	if len(components) == 5 && strings.EqualFold(components[1], "loadbalancers") && strings.EqualFold(components[3], "backendaddresspools") {
+		return strings.EqualFold(components[2], lbName) && components[4] == poolName
+	}
+	return *ref == poolName
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
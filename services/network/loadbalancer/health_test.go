@@ -0,0 +1,196 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the Apache v2.0 License.
+
+package loadbalancer
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/microsoft/moc-sdk-for-go/services/network"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeProber struct {
+	failures map[string]error
+}
+
+func (p *fakeProber) Probe(ctx context.Context, probe network.Probe, ipAddress string) error {
+	return p.failures[*probe.Name+"@"+ipAddress]
+}
+
+func strPtr(s string) *string { return &s }
+
+func int32Ptr(i int32) *int32 { return &i }
+
+func listenerPort(t *testing.T, addr net.Addr) int32 {
+	_, port, err := net.SplitHostPort(addr.String())
+	require.NoError(t, err)
+	p, err := strconv.Atoi(port)
+	require.NoError(t, err)
+	return int32(p)
+}
+
+func healthTestLoadBalancer() *network.LoadBalancer {
+	return &network.LoadBalancer{
+		Name: strPtr("lb1"),
+		LoadBalancerPropertiesFormat: &network.LoadBalancerPropertiesFormat{
+			BackendAddressPools: &[]network.BackendAddressPool{
+				{Name: strPtr("pool1")},
+				{Name: strPtr("pool2")},
+			},
+			Probes: &[]network.Probe{
+				{Name: strPtr("http"), ProbePropertiesFormat: &network.ProbePropertiesFormat{Protocol: network.ProbeProtocolHTTP, Port: int32Ptr(80)}},
+			},
+			LoadBalancingRules: &[]network.LoadBalancingRule{
+				{
+					Name: strPtr("rule1"),
+					LoadBalancingRulePropertiesFormat: &network.LoadBalancingRulePropertiesFormat{
+						BackendAddressPool: &network.SubResource{ID: strPtr("pool1")},
+						Probe:              &network.SubResource{ID: strPtr("http")},
+					},
+				},
+			},
+		},
+	}
+}
+
+func healthTestInterface(name, ip string, pools ...string) network.Interface {
+	refs := []network.BackendAddressPool{}
+	for _, pool := range pools {
+		refs = append(refs, network.BackendAddressPool{Name: strPtr(pool)})
+	}
+	return network.Interface{
+		Name: strPtr(name),
+		InterfacePropertiesFormat: &network.InterfacePropertiesFormat{
+			IPConfigurations: &[]network.InterfaceIPConfiguration{
+				{
+					InterfaceIPConfigurationPropertiesFormat: &network.InterfaceIPConfigurationPropertiesFormat{
+						PrivateIPAddress:                strPtr(ip),
+						LoadBalancerBackendAddressPools: &refs,
+					},
+				},
+			},
+		},
+	}
+}
+
+func TestBackendHealthOf(t *testing.T) {
+	nics := []network.Interface{
+		healthTestInterface("nic1", "10.0.0.5", "/loadbalancers/lb1/backendaddresspools/pool1"),
+		healthTestInterface("nic2", "10.0.0.4", "pool1"),
+		healthTestInterface("nic3", "10.0.0.6", "pool2"),
+		healthTestInterface("nic4", "10.0.0.7", "/loadbalancers/lb2/backendaddresspools/pool1"),
+	}
+	prober := &fakeProber{failures: map[string]error{"http@10.0.0.5": assert.AnError}}
+
+	health := BackendHealthOf(context.Background(), healthTestLoadBalancer(), nics, prober)
+	require.Len(t, health, 3)
+
+	assert.Equal(t, "pool1", health[0].BackendAddressPool)
+	assert.Equal(t, "nic2", health[0].NetworkInterface)
+	assert.Equal(t, "10.0.0.4", health[0].IPAddress)
+	assert.Equal(t, "http", health[0].Probe)
+	assert.Equal(t, ProbeStateHealthy, health[0].State)
+	assert.False(t, health[0].LastProbeTime.IsZero())
+
+	assert.Equal(t, "10.0.0.5", health[1].IPAddress)
+	assert.Equal(t, ProbeStateUnhealthy, health[1].State)
+	assert.Equal(t, assert.AnError.Error(), health[1].FailureReason)
+
+	assert.Equal(t, "pool2", health[2].BackendAddressPool)
+	assert.Equal(t, ProbeStateUnknown, health[2].State)
+	assert.True(t, health[2].LastProbeTime.IsZero())
+	assert.NotEmpty(t, health[2].FailureReason)
+
+	assert.Equal(t, 1, HealthyBackendCount(health))
+}
+
+func TestBackendHealthOf_MissingProbe(t *testing.T) {
+	lb := healthTestLoadBalancer()
+	lb.Probes = nil
+	health := BackendHealthOf(context.Background(), lb, []network.Interface{healthTestInterface("nic1", "10.0.0.4", "pool1")}, &fakeProber{})
+	require.Len(t, health, 1)
+	assert.Equal(t, ProbeStateUnknown, health[0].State)
+	assert.Contains(t, health[0].FailureReason, "http")
+}
+
+func TestHealthyBackendCount(t *testing.T) {
+	health := []BackendHealth{
+		{BackendAddressPool: "pool1", IPAddress: "10.0.0.4", Probe: "a", State: ProbeStateHealthy},
+		{BackendAddressPool: "pool1", IPAddress: "10.0.0.4", Probe: "b", State: ProbeStateUnhealthy},
+		{BackendAddressPool: "pool1", IPAddress: "10.0.0.5", Probe: "a", State: ProbeStateHealthy},
+		{BackendAddressPool: "pool1", IPAddress: "10.0.0.5", Probe: "b", State: ProbeStateHealthy},
+	}
+	assert.Equal(t, 1, HealthyBackendCount(health))
+}
+
+func TestProbeRunner_TCP(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	port := listenerPort(t, listener.Addr())
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+
+	runner := &ProbeRunner{Timeout: time.Second}
+	probe := network.Probe{ProbePropertiesFormat: &network.ProbePropertiesFormat{Protocol: network.ProbeProtocolTCP, Port: int32Ptr(port)}}
+	assert.NoError(t, runner.Probe(context.Background(), probe, "127.0.0.1"))
+
+	listener.Close()
+	assert.Error(t, runner.Probe(context.Background(), probe, "127.0.0.1"))
+}
+
+func TestProbeRunner_HTTP(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/healthz" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+	port := listenerPort(t, server.Listener.Addr())
+
+	runner := &ProbeRunner{Timeout: time.Second}
+	probe := network.Probe{ProbePropertiesFormat: &network.ProbePropertiesFormat{
+		Protocol:    network.ProbeProtocolHTTP,
+		Port:        int32Ptr(port),
+		RequestPath: strPtr("healthz"),
+	}}
+	assert.NoError(t, runner.Probe(context.Background(), probe, "127.0.0.1"))
+
+	probe.RequestPath = strPtr("/other")
+	err := runner.Probe(context.Background(), probe, "127.0.0.1")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "503")
+}
+
+func TestProbeRunner_HTTPS(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	runner := &ProbeRunner{Timeout: time.Second}
+	probe := network.Probe{ProbePropertiesFormat: &network.ProbePropertiesFormat{
+		Protocol: network.ProbeProtocolHTTPS,
+		Port:     int32Ptr(listenerPort(t, server.Listener.Addr())),
+	}}
+	assert.NoError(t, runner.Probe(context.Background(), probe, "127.0.0.1"))
+}
+
+func TestProbeRunner_InvalidProbe(t *testing.T) {
+	runner := &ProbeRunner{}
+	assert.Error(t, runner.Probe(context.Background(), network.Probe{}, "127.0.0.1"))
+	assert.Error(t, runner.Probe(context.Background(), network.Probe{ProbePropertiesFormat: &network.ProbePropertiesFormat{Port: int32Ptr(0)}}, "127.0.0.1"))
+	assert.Error(t, runner.Probe(context.Background(), network.Probe{ProbePropertiesFormat: &network.ProbePropertiesFormat{Protocol: "Udp", Port: int32Ptr(80)}}, "127.0.0.1"))
+}
@@ -54,10 +54,51 @@ func getWssdLoadBalancer(networkLB *network.LoadBalancer, group string, apiVersi
 	if err != nil {
 		return nil, err
 	}
+	if err = getWssdInboundNatRules(networkLB.LoadBalancerPropertiesFormat, wssdCloudLB); err != nil {
+		return nil, err
+	}
 
 	return wssdCloudLB, nil
 }
 
+// Parse the inbound NAT rules of network.LoadBalancerPropertiesFormat, which use the
+// same shape for Legacy and V2 LBs. Earlier releases dropped the inbound NAT rules on write,
+// so a load balancer read back with NAT rules and written again now keeps them, and a rule
+// missing its name or ports fails the write.
+func getWssdInboundNatRules(lbp *network.LoadBalancerPropertiesFormat,
+	wssdCloudLB *wssdcloudnetwork.LoadBalancer) (err error) {
+	if lbp == nil || lbp.InboundNatRules == nil {
+		return nil
+	}
+	for _, natRule := range *lbp.InboundNatRules {
+		if natRule.Name == nil || *natRule.Name == "" {
+			return errors.Wrapf(errors.InvalidInput, "Inbound Nat Rule Name not specified")
+		}
+		if natRule.InboundNatRulePropertiesFormat == nil || natRule.FrontendPort == nil {
+			return errors.Wrapf(errors.InvalidInput, "Inbound Nat Rule Frontend port not specified")
+		}
+		if natRule.BackendPort == nil {
+			return errors.Wrapf(errors.InvalidInput, "Inbound Nat Rule Backend port not specified")
+		}
+
+		protocol := wssdcloudcommon.Protocol_All
+		if string(natRule.Protocol) != "" {
+			protocol, err = getWssdProtocol(string(natRule.Protocol))
+			if err != nil {
+				return err
+			}
+		}
+
+		wssdCloudLB.InboundNatRules = append(wssdCloudLB.InboundNatRules, &wssdcloudnetwork.InboundNatRule{
+			Name:         *natRule.Name,
+			FrontendPort: uint32(*natRule.FrontendPort),
+			BackendPort:  uint32(*natRule.BackendPort),
+			Protocol:     protocol,
+		})
+	}
+	return nil
+}
+
 // Parse the contents of network.LoadBalancerPropertiesFormat for a Legacy LB
 // return an error if there is a config error, or pass the formatted values into
 // the wssdcloudnetwork.LoadBalancer if they are valid
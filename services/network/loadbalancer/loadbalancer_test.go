@@ -0,0 +1,87 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the Apache v2.0 License.
+
+package loadbalancer
+
+import (
+	"testing"
+
+	"github.com/microsoft/moc-sdk-for-go/services/network"
+	"github.com/microsoft/moc/pkg/errors"
+	"github.com/microsoft/moc/pkg/status"
+	wssdcloudcommon "github.com/microsoft/moc/rpc/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newNatRule(name string, frontendPort, backendPort int32, protocol network.TransportProtocol) network.InboundNatRule {
+	return network.InboundNatRule{
+		Name: toStringPtr(name),
+		InboundNatRulePropertiesFormat: &network.InboundNatRulePropertiesFormat{
+			FrontendPort: toInt32Ptr(frontendPort),
+			BackendPort:  toInt32Ptr(backendPort),
+			Protocol:     protocol,
+		},
+	}
+}
+
+func Test_getWssdLoadBalancer_InboundNatRules(t *testing.T) {
+	for _, apiVersion := range []string{Version_1_0, Version_2_0} {
+		version, err := getApiVersion(apiVersion)
+		require.NoError(t, err)
+		networkLB := &network.LoadBalancer{
+			Name: toStringPtr("lb"),
+			LoadBalancerPropertiesFormat: &network.LoadBalancerPropertiesFormat{
+				InboundNatRules: &[]network.InboundNatRule{
+					newNatRule("ssh", 2222, 22, network.TransportProtocolTCP),
+					newNatRule("any", 5000, 5001, ""),
+				},
+			},
+		}
+
+		wssdLB, err := getWssdLoadBalancer(networkLB, "group", version)
+		require.NoError(t, err, apiVersion)
+		require.Len(t, wssdLB.InboundNatRules, 2, apiVersion)
+		assert.Equal(t, "ssh", wssdLB.InboundNatRules[0].Name)
+		assert.Equal(t, uint32(2222), wssdLB.InboundNatRules[0].FrontendPort)
+		assert.Equal(t, uint32(22), wssdLB.InboundNatRules[0].BackendPort)
+		assert.Equal(t, wssdcloudcommon.Protocol_Tcp, wssdLB.InboundNatRules[0].Protocol)
+		assert.Equal(t, wssdcloudcommon.Protocol_All, wssdLB.InboundNatRules[1].Protocol)
+
+		// The rules read back from the agent are the rules that were sent
+		wssdLB.Status = status.InitStatus()
+		roundTrip, err := getLoadBalancer(wssdLB)
+		require.NoError(t, err, apiVersion)
+		require.NotNil(t, roundTrip.InboundNatRules)
+		assert.Equal(t, []network.InboundNatRule{
+			newNatRule("ssh", 2222, 22, network.TransportProtocolTCP),
+			newNatRule("any", 5000, 5001, network.TransportProtocolAll),
+		}, *roundTrip.InboundNatRules)
+	}
+
+	wssdLB, err := getWssdLoadBalancer(&network.LoadBalancer{
+		Name:                         toStringPtr("lb"),
+		LoadBalancerPropertiesFormat: &network.LoadBalancerPropertiesFormat{},
+	}, "group", nil)
+	require.NoError(t, err)
+	assert.Empty(t, wssdLB.InboundNatRules)
+}
+
+func Test_getWssdLoadBalancer_InvalidInboundNatRules(t *testing.T) {
+	missingBackend := newNatRule("ssh", 2222, 22, network.TransportProtocolTCP)
+	missingBackend.BackendPort = nil
+	for name, natRule := range map[string]network.InboundNatRule{
+		"name":     newNatRule("", 2222, 22, network.TransportProtocolTCP),
+		"frontend": {Name: toStringPtr("ssh")},
+		"backend":  missingBackend,
+		"protocol": newNatRule("ssh", 2222, 22, "Gre"),
+	} {
+		_, err := getWssdLoadBalancer(&network.LoadBalancer{
+			Name: toStringPtr("lb"),
+			LoadBalancerPropertiesFormat: &network.LoadBalancerPropertiesFormat{
+				InboundNatRules: &[]network.InboundNatRule{natRule},
+			},
+		}, "group", nil)
+		assert.ErrorIs(t, err, errors.InvalidInput, name)
+	}
+}
@@ -0,0 +1,499 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the Apache v2.0 License.
+
+package loadbalancer
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/microsoft/moc-sdk-for-go/services/network"
+	"github.com/microsoft/moc/pkg/errors"
+)
+
+// maxUpdateAttempts bounds the read-modify-write cycles of a rule helper when the cloud agent keeps
+// reporting version conflicts
+const maxUpdateAttempts = 10
+
+// updateRetryDelay is the pause between attempts after a version conflict
+var updateRetryDelay = 100 * time.Millisecond
+
+// AddLoadBalancingRule adds a load balancing rule to the load balancer. The frontend IP configuration,
+// backend pool and probe the rule references must exist in the load balancer.
+func (c *LoadBalancerClient) AddLoadBalancingRule(ctx context.Context, group, name string, rule network.LoadBalancingRule) (*network.LoadBalancer, error) {
+	if rule.Name == nil || len(*rule.Name) == 0 {
+		return nil, errors.Wrapf(errors.InvalidInput, "LB Rule Name not specified")
+	}
+	if rule.LoadBalancingRulePropertiesFormat == nil || rule.FrontendPort == nil || rule.BackendPort == nil {
+		return nil, errors.Wrapf(errors.InvalidInput, "LB Rule [%s] Frontend and Backend ports must be specified", *rule.Name)
+	}
+	return c.update(ctx, group, name, func(lbp *network.LoadBalancerPropertiesFormat, apiVersion string) error {
+		if err := checkRuleName(lbp, *rule.Name); err != nil {
+			return err
+		}
+		if err := checkFrontendPort(lbp, rule.FrontendIPConfiguration, string(rule.Protocol), *rule.FrontendPort); err != nil {
+			return err
+		}
+		if rule.FrontendIPConfiguration != nil && !hasFrontendIPConfiguration(lbp, rule.FrontendIPConfiguration.ID) {
+			return errors.Wrapf(errors.InvalidInput, "LB Rule [%s] references unknown FrontendIPConfig [%s]", *rule.Name, getID(rule.FrontendIPConfiguration))
+		}
+		if rule.BackendAddressPool != nil && !hasBackendAddressPool(lbp, rule.BackendAddressPool.ID) {
+			return errors.Wrapf(errors.InvalidInput, "LB Rule [%s] references unknown BackendAddressPool [%s]", *rule.Name, getID(rule.BackendAddressPool))
+		}
+		if rule.Probe != nil && !hasProbe(lbp, rule.Probe.ID) {
+			return errors.Wrapf(errors.InvalidInput, "LB Rule [%s] references unknown Probe [%s]", *rule.Name, getID(rule.Probe))
+		}
+
+		rules := []network.LoadBalancingRule{}
+		if lbp.LoadBalancingRules != nil {
+			rules = *lbp.LoadBalancingRules
+		}
+		rules = append(rules, rule)
+		lbp.LoadBalancingRules = &rules
+		return nil
+	})
+}
+
+// AddInboundNatRule adds an inbound NAT rule to the load balancer
+func (c *LoadBalancerClient) AddInboundNatRule(ctx context.Context, group, name string, rule network.InboundNatRule) (*network.LoadBalancer, error) {
+	if rule.Name == nil || len(*rule.Name) == 0 {
+		return nil, errors.Wrapf(errors.InvalidInput, "Inbound Nat Rule Name not specified")
+	}
+	if rule.InboundNatRulePropertiesFormat == nil || rule.FrontendPort == nil || rule.BackendPort == nil {
+		return nil, errors.Wrapf(errors.InvalidInput, "Inbound Nat Rule [%s] Frontend and Backend ports must be specified", *rule.Name)
+	}
+	return c.update(ctx, group, name, func(lbp *network.LoadBalancerPropertiesFormat, apiVersion string) error {
+		if err := checkRuleName(lbp, *rule.Name); err != nil {
+			return err
+		}
+		if err := checkFrontendPort(lbp, rule.FrontendIPConfiguration, string(rule.Protocol), *rule.FrontendPort); err != nil {
+			return err
+		}
+
+		rules := []network.InboundNatRule{}
+		if lbp.InboundNatRules != nil {
+			rules = *lbp.InboundNatRules
+		}
+		rules = append(rules, rule)
+		lbp.InboundNatRules = &rules
+		return nil
+	})
+}
+
+// AddOutboundRule adds an outbound rule to the load balancer. Outbound rules need the V2 API version.
+func (c *LoadBalancerClient) AddOutboundRule(ctx context.Context, group, name string, rule network.OutboundRule) (*network.LoadBalancer, error) {
+	if rule.Name == nil || len(*rule.Name) == 0 {
+		return nil, errors.Wrapf(errors.InvalidInput, "Outbound Rule Name not set")
+	}
+	return c.update(ctx, group, name, func(lbp *network.LoadBalancerPropertiesFormat, apiVersion string) error {
+		if apiVersion != Version_2_0 {
+			return errors.Wrapf(errors.NotSupported, "Outbound rules need load balancer API version %s, the cloud agent uses [%s]", Version_2_0, apiVersion)
+		}
+		if err := checkRuleName(lbp, *rule.Name); err != nil {
+			return err
+		}
+		if rule.OutboundRulePropertiesFormat != nil {
+			if rule.BackendAddressPool != nil && !hasBackendAddressPool(lbp, rule.BackendAddressPool.ID) {
+				return errors.Wrapf(errors.InvalidInput, "Outbound Rule [%s] references unknown BackendAddressPool [%s]", *rule.Name, getID(rule.BackendAddressPool))
+			}
+			if rule.FrontendIPConfigurations != nil {
+				for _, frontend := range *rule.FrontendIPConfigurations {
+					if !hasFrontendIPConfiguration(lbp, frontend.ID) {
+						return errors.Wrapf(errors.InvalidInput, "Outbound Rule [%s] references unknown FrontendIPConfig [%s]", *rule.Name, getID(&frontend))
+					}
+				}
+			}
+		}
+
+		rules := []network.OutboundRule{}
+		if lbp.OutboundRules != nil {
+			rules = *lbp.OutboundRules
+		}
+		rules = append(rules, rule)
+		lbp.OutboundRules = &rules
+		return nil
+	})
+}
+
+// SetFrontendPublicIPAddress references the public IP address from the frontend IP configuration of the
+// load balancer. Frontend public IP addresses need the V2 API version.
+func (c *LoadBalancerClient) SetFrontendPublicIPAddress(ctx context.Context, group, name, frontendName, publicIPAddressName string) (*network.LoadBalancer, error) {
+	if len(frontendName) == 0 {
+		return nil, errors.Wrapf(errors.InvalidInput, "FrontendIPConfig Name not specified")
+	}
+	if len(publicIPAddressName) == 0 {
+		return nil, errors.Wrapf(errors.InvalidInput, "FrontendIPConfig Public-Ip not specified")
+	}
+	return c.update(ctx, group, name, func(lbp *network.LoadBalancerPropertiesFormat, apiVersion string) error {
+		if apiVersion != Version_2_0 {
+			return errors.Wrapf(errors.NotSupported, "Frontend public IP addresses need load balancer API version %s, the cloud agent uses [%s]", Version_2_0, apiVersion)
+		}
+		if lbp.FrontendIPConfigurations != nil {
+			for i := range *lbp.FrontendIPConfigurations {
+				frontend := &(*lbp.FrontendIPConfigurations)[i]
+				if frontend.Name == nil || *frontend.Name != frontendName {
+					continue
+				}
+				if frontend.FrontendIPConfigurationPropertiesFormat == nil {
+					frontend.FrontendIPConfigurationPropertiesFormat = &network.FrontendIPConfigurationPropertiesFormat{}
+				}
+				pip := publicIPAddressName
+				frontend.PublicIPAddress = &network.PublicIPAddress{ID: &pip}
+				return nil
+			}
+		}
+		return errors.Wrapf(errors.NotFound, "FrontendIPConfig [%s] not found in load balancer [%s]", frontendName, name)
+	})
+}
+
+// RemoveRule removes the load balancing, inbound NAT or outbound rule with the name from the load balancer.
+// Legacy load balancers do not keep the names of their load balancing rules.
+func (c *LoadBalancerClient) RemoveRule(ctx context.Context, group, name, ruleName string) (*network.LoadBalancer, error) {
+	if len(ruleName) == 0 {
+		return nil, errors.Wrapf(errors.InvalidInput, "Missing rule name")
+	}
+	return c.update(ctx, group, name, func(lbp *network.LoadBalancerPropertiesFormat, apiVersion string) error {
+		removed := false
+		if lbp.LoadBalancingRules != nil {
+			rules := []network.LoadBalancingRule{}
+			for _, rule := range *lbp.LoadBalancingRules {
+				if rule.Name != nil && *rule.Name == ruleName {
+					removed = true
+					continue
+				}
+				rules = append(rules, rule)
+			}
+			lbp.LoadBalancingRules = &rules
+		}
+		if lbp.InboundNatRules != nil {
+			rules := []network.InboundNatRule{}
+			for _, rule := range *lbp.InboundNatRules {
+				if rule.Name != nil && *rule.Name == ruleName {
+					removed = true
+					continue
+				}
+				rules = append(rules, rule)
+			}
+			lbp.InboundNatRules = &rules
+		}
+		if lbp.OutboundRules != nil {
+			rules := []network.OutboundRule{}
+			for _, rule := range *lbp.OutboundRules {
+				if rule.Name != nil && *rule.Name == ruleName {
+					removed = true
+					continue
+				}
+				rules = append(rules, rule)
+			}
+			lbp.OutboundRules = &rules
+		}
+		if !removed {
+			return errors.Wrapf(errors.NotFound, "Rule [%s] not found in load balancer [%s]", ruleName, name)
+		}
+		return nil
+	})
+}
+
+// AddBackendAddresses adds the IP configurations that hold the private IP addresses to the backend pool
+// of the load balancer. Each address must belong to a network interface of the group. Addresses already
+// in the pool are left as they are.
+func (c *LoadBalancerClient) AddBackendAddresses(ctx context.Context, group, name, poolName string, addresses ...string) ([]network.Interface, error) {
+	if len(poolName) == 0 {
+		return nil, errors.Wrapf(errors.InvalidInput, "Missing backend address pool name")
+	}
+	if len(addresses) == 0 {
+		return nil, errors.Wrapf(errors.InvalidInput, "Missing backend addresses")
+	}
+	apiVersion, err := c.NegotiateAPIVersion(ctx)
+	if err != nil {
+		return nil, err
+	}
+	lb, err := c.getLoadBalancer(ctx, group, name, apiVersion)
+	if err != nil {
+		return nil, err
+	}
+	if lb.LoadBalancerPropertiesFormat == nil || !hasBackendAddressPool(lb.LoadBalancerPropertiesFormat, &poolName) {
+		return nil, errors.Wrapf(errors.NotFound, "BackendAddressPool [%s] not found in load balancer [%s]", poolName, name)
+	}
+	// V2 network interfaces reference pools by qualified name, legacy ones by pool name
+	poolRef := poolName
+	if apiVersion == Version_2_0 {
+		poolRef = fmt.Sprintf("/loadbalancers/%s/backendaddresspools/%s", name, poolName)
+	}
+
+	interfaces, err := c.getInterfaces()
+	if err != nil {
+		return nil, err
+	}
+	nics, err := interfaces.Get(ctx, group, "")
+	if err != nil {
+		return nil, err
+	}
+	// Find the network interface of each address, keeping the order of first appearance
+	nicNames := []string{}
+	nicAddresses := map[string][]string{}
+	for _, address := range addresses {
+		nicName := ""
+		if nics != nil {
+			for _, nic := range *nics {
+				if nic.Name != nil && len(getIPConfigurations(&nic, address)) > 0 {
+					nicName = *nic.Name
+					break
+				}
+			}
+		}
+		if len(nicName) == 0 {
+			return nil, errors.Wrapf(errors.NotFound, "No network interface of group [%s] has address [%s]", group, address)
+		}
+		if _, ok := nicAddresses[nicName]; !ok {
+			nicNames = append(nicNames, nicName)
+		}
+		nicAddresses[nicName] = append(nicAddresses[nicName], address)
+	}
+
+	updated := []network.Interface{}
+	for _, nicName := range nicNames {
+		nic, err := c.addInterfaceToPool(ctx, group, nicName, nicAddresses[nicName], name, poolName, poolRef)
+		if err != nil {
+			return updated, err
+		}
+		updated = append(updated, *nic)
+	}
+	return updated, nil
+}
+
+// addInterfaceToPool adds the IP configurations of the network interface that hold the addresses to the
+// pool, retrying on version conflicts
+func (c *LoadBalancerClient) addInterfaceToPool(ctx context.Context, group, nicName string, addresses []string, lbName, poolName, poolRef string) (*network.Interface, error) {
+	interfaces, err := c.getInterfaces()
+	if err != nil {
+		return nil, err
+	}
+	for attempt := 1; ; attempt++ {
+		nics, err := interfaces.Get(ctx, group, nicName)
+		if err != nil {
+			return nil, err
+		}
+		if nics == nil || len(*nics) == 0 {
+			return nil, errors.Wrapf(errors.NotFound, "Network interface [%s] not found", nicName)
+		}
+		nic := (*nics)[0]
+
+		changed := false
+		for _, address := range addresses {
+			for _, ipConfig := range getIPConfigurations(&nic, address) {
+				pools := []network.BackendAddressPool{}
+				if ipConfig.LoadBalancerBackendAddressPools != nil {
+					pools = *ipConfig.LoadBalancerBackendAddressPools
+				}
+				member := false
+				for _, pool := range pools {
+					if isPoolReference(pool.Name, lbName, poolName) {
+						member = true
+						break
+					}
+				}
+				if member {
+					continue
+				}
+				ref := poolRef
+				pools = append(pools, network.BackendAddressPool{Name: &ref})
+				ipConfig.LoadBalancerBackendAddressPools = &pools
+				changed = true
+			}
+		}
+		if !changed {
+			return &nic, nil
+		}
+
+		result, err := interfaces.CreateOrUpdate(ctx, group, nicName, &nic)
+		if err == nil {
+			return result, nil
+		}
+		if !errors.IsInvalidVersion(err) || attempt >= maxUpdateAttempts {
+			return nil, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(updateRetryDelay):
+		}
+	}
+}
+
+// update reads the load balancer with the negotiated API version, applies modify to its properties and
+// writes it back. The whole cycle is retried when the write reports a version conflict.
+func (c *LoadBalancerClient) update(ctx context.Context, group, name string, modify func(lbp *network.LoadBalancerPropertiesFormat, apiVersion string) error) (*network.LoadBalancer, error) {
+	apiVersion, err := c.NegotiateAPIVersion(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for attempt := 1; ; attempt++ {
+		lb, err := c.getLoadBalancer(ctx, group, name, apiVersion)
+		if err != nil {
+			return nil, err
+		}
+		if lb.LoadBalancerPropertiesFormat == nil {
+			lb.LoadBalancerPropertiesFormat = &network.LoadBalancerPropertiesFormat{}
+		}
+		if err := modify(lb.LoadBalancerPropertiesFormat, apiVersion); err != nil {
+			return nil, err
+		}
+
+		result, err := c.CreateOrUpdateWithVersion(ctx, group, name, lb, apiVersion)
+		if err == nil {
+			return result, nil
+		}
+		if !errors.IsInvalidVersion(err) || attempt >= maxUpdateAttempts {
+			return nil, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(updateRetryDelay):
+		}
+	}
+}
+
+func (c *LoadBalancerClient) getLoadBalancer(ctx context.Context, group, name, apiVersion string) (*network.LoadBalancer, error) {
+	if len(name) == 0 {
+		return nil, errors.Wrapf(errors.InvalidInput, "Missing load balancer name")
+	}
+	lbs, err := c.GetWithVersion(ctx, group, name, apiVersion)
+	if err != nil {
+		return nil, err
+	}
+	if lbs == nil || len(*lbs) == 0 {
+		return nil, errors.Wrapf(errors.NotFound, "Load balancer [%s] not found", name)
+	}
+	return &(*lbs)[0], nil
+}
+
+// getIPConfigurations returns the IP configurations of the network interface with the private IP address
+func getIPConfigurations(nic *network.Interface, address string) []*network.InterfaceIPConfiguration {
+	ipConfigs := []*network.InterfaceIPConfiguration{}
+	if nic.InterfacePropertiesFormat == nil || nic.IPConfigurations == nil {
+		return ipConfigs
+	}
+	for i := range *nic.IPConfigurations {
+		ipConfig := &(*nic.IPConfigurations)[i]
+		if ipConfig.InterfaceIPConfigurationPropertiesFormat != nil && ipConfig.PrivateIPAddress != nil && *ipConfig.PrivateIPAddress == address {
+			ipConfigs = append(ipConfigs, ipConfig)
+		}
+	}
+	return ipConfigs
+}
+
+// checkRuleName fails when a load balancing, inbound NAT or outbound rule already has the name
+func checkRuleName(lbp *network.LoadBalancerPropertiesFormat, name string) error {
+	exists := false
+	if lbp.LoadBalancingRules != nil {
+		for _, rule := range *lbp.LoadBalancingRules {
+			exists = exists || (rule.Name != nil && *rule.Name == name)
+		}
+	}
+	if lbp.InboundNatRules != nil {
+		for _, rule := range *lbp.InboundNatRules {
+			exists = exists || (rule.Name != nil && *rule.Name == name)
+		}
+	}
+	if lbp.OutboundRules != nil {
+		for _, rule := range *lbp.OutboundRules {
+			exists = exists || (rule.Name != nil && *rule.Name == name)
+		}
+	}
+	if exists {
+		return errors.Wrapf(errors.AlreadyExists, "Rule [%s] already exists", name)
+	}
+	return nil
+}
+
+// checkFrontendPort fails when a load balancing or inbound NAT rule of the same frontend IP configuration
+// already uses the frontend port with an overlapping protocol
+func checkFrontendPort(lbp *network.LoadBalancerPropertiesFormat, frontend *network.SubResource, protocol string, port int32) error {
+	conflict := func(ruleName *string, ruleFrontend *network.SubResource, ruleProtocol string, rulePort *int32) error {
+		if rulePort == nil || *rulePort != port || getID(ruleFrontend) != getID(frontend) || !protocolsOverlap(ruleProtocol, protocol) {
+			return nil
+		}
+		existing := ""
+		if ruleName != nil {
+			existing = *ruleName
+		}
+		return errors.Wrapf(errors.InvalidInput, "Frontend port %d is already used by rule [%s]", port, existing)
+	}
+	if lbp.LoadBalancingRules != nil {
+		for _, rule := range *lbp.LoadBalancingRules {
+			if rule.LoadBalancingRulePropertiesFormat == nil {
+				continue
+			}
+			if err := conflict(rule.Name, rule.FrontendIPConfiguration, string(rule.Protocol), rule.FrontendPort); err != nil {
+				return err
+			}
+		}
+	}
+	if lbp.InboundNatRules != nil {
+		for _, rule := range *lbp.InboundNatRules {
+			if rule.InboundNatRulePropertiesFormat == nil {
+				continue
+			}
+			if err := conflict(rule.Name, rule.FrontendIPConfiguration, string(rule.Protocol), rule.FrontendPort); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// protocolsOverlap reports whether two transport protocols share traffic. An empty protocol is All.
+func protocolsOverlap(a, b string) bool {
+	a, b = normalizeProtocolCase(a), normalizeProtocolCase(b)
+	if len(a) == 0 || len(b) == 0 || a == "All" || b == "All" {
+		return true
+	}
+	return a == b
+}
+
+func hasFrontendIPConfiguration(lbp *network.LoadBalancerPropertiesFormat, name *string) bool {
+	if name == nil || lbp.FrontendIPConfigurations == nil {
+		return false
+	}
+	for _, frontend := range *lbp.FrontendIPConfigurations {
+		if frontend.Name != nil && *frontend.Name == *name {
+			return true
+		}
+	}
+	return false
+}
+
+func hasBackendAddressPool(lbp *network.LoadBalancerPropertiesFormat, name *string) bool {
+	if name == nil || lbp.BackendAddressPools == nil {
+		return false
+	}
+	for _, pool := range *lbp.BackendAddressPools {
+		if pool.Name != nil && *pool.Name == *name {
+			return true
+		}
+	}
+	return false
+}
+
+func hasProbe(lbp *network.LoadBalancerPropertiesFormat, name *string) bool {
+	if name == nil || lbp.Probes == nil {
+		return false
+	}
+	for _, probe := range *lbp.Probes {
+		if probe.Name != nil && *probe.Name == *name {
+			return true
+		}
+	}
+	return false
+}
+
+func getID(resource *network.SubResource) string {
+	if resource == nil || resource.ID == nil {
+		return ""
+	}
+	return strings.TrimSpace(*resource.ID)
+}
@@ -0,0 +1,202 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the Apache v2.0 License.
+
+package loadbalancer
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/microsoft/moc-sdk-for-go/services/network"
+	"github.com/microsoft/moc/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeService stores one load balancer and fails the first conflicts writes with a version conflict
+type fakeService struct {
+	Service
+	lb          *network.LoadBalancer
+	conflicts   int
+	writes      int
+	apiVersions []string
+}
+
+func (s *fakeService) GetWithVersion(ctx context.Context, group, name, apiVersion string) (*[]network.LoadBalancer, error) {
+	if s.lb == nil || *s.lb.Name != name {
+		return &[]network.LoadBalancer{}, nil
+	}
+	return &[]network.LoadBalancer{cloneLoadBalancer(s.lb)}, nil
+}
+
+func (s *fakeService) CreateOrUpdateWithVersion(ctx context.Context, group, name string, lb *network.LoadBalancer, apiVersion string) (*network.LoadBalancer, error) {
+	s.apiVersions = append(s.apiVersions, apiVersion)
+	if s.conflicts > 0 {
+		s.conflicts--
+		return nil, errors.Wrapf(errors.InvalidVersion, "version conflict")
+	}
+	s.writes++
+	stored := cloneLoadBalancer(lb)
+	s.lb = &stored
+	return lb, nil
+}
+
+// fakeInterfaces stores network interfaces by name
+type fakeInterfaces struct {
+	nics map[string]network.Interface
+}
+
+func (f *fakeInterfaces) Get(ctx context.Context, group, name string) (*[]network.Interface, error) {
+	nics := []network.Interface{}
+	for nicName, nic := range f.nics {
+		if len(name) == 0 || nicName == name {
+			nics = append(nics, nic)
+		}
+	}
+	return &nics, nil
+}
+
+func (f *fakeInterfaces) CreateOrUpdate(ctx context.Context, group, name string, nic *network.Interface) (*network.Interface, error) {
+	f.nics[name] = *nic
+	return nic, nil
+}
+
+func cloneLoadBalancer(lb *network.LoadBalancer) network.LoadBalancer {
+	data, _ := json.Marshal(lb)
+	clone := network.LoadBalancer{}
+	_ = json.Unmarshal(data, &clone)
+	return clone
+}
+
+func newRulesTestClient(apiVersion string) (*LoadBalancerClient, *fakeService) {
+	lb := healthTestLoadBalancer()
+	lb.FrontendIPConfigurations = &[]network.FrontendIPConfiguration{{Name: strPtr("fe1")}}
+	service := &fakeService{lb: lb}
+	c := &LoadBalancerClient{internal: service}
+	_ = c.SetAPIVersion(apiVersion)
+	return c, service
+}
+
+func TestAddLoadBalancingRule(t *testing.T) {
+	c, service := newRulesTestClient(Version_2_0)
+	service.conflicts = 1
+	updateRetryDelay = 0
+
+	rule := network.LoadBalancingRule{
+		Name: strPtr("rule2"),
+		LoadBalancingRulePropertiesFormat: &network.LoadBalancingRulePropertiesFormat{
+			FrontendIPConfiguration: &network.SubResource{ID: strPtr("fe1")},
+			BackendAddressPool:      &network.SubResource{ID: strPtr("pool2")},
+			Probe:                   &network.SubResource{ID: strPtr("http")},
+			Protocol:                network.TransportProtocolTCP,
+			FrontendPort:            int32Ptr(443),
+			BackendPort:             int32Ptr(8443),
+		},
+	}
+	_, err := c.AddLoadBalancingRule(context.Background(), "group", "lb1", rule)
+	require.NoError(t, err)
+	assert.Equal(t, 1, service.writes)
+	assert.Equal(t, []string{Version_2_0, Version_2_0}, service.apiVersions)
+	require.Len(t, *service.lb.LoadBalancingRules, 2)
+
+	_, err = c.AddLoadBalancingRule(context.Background(), "group", "lb1", rule)
+	assert.ErrorIs(t, err, errors.AlreadyExists)
+
+	rule.Name = strPtr("rule3")
+	_, err = c.AddLoadBalancingRule(context.Background(), "group", "lb1", rule)
+	assert.ErrorIs(t, err, errors.InvalidInput)
+
+	rule.FrontendPort = int32Ptr(444)
+	rule.Probe = &network.SubResource{ID: strPtr("missing")}
+	_, err = c.AddLoadBalancingRule(context.Background(), "group", "lb1", rule)
+	assert.ErrorIs(t, err, errors.InvalidInput)
+	assert.Equal(t, 1, service.writes)
+}
+
+func TestAddInboundNatRuleAndRemoveRule(t *testing.T) {
+	c, service := newRulesTestClient(Version_1_0)
+
+	rule := network.InboundNatRule{
+		Name: strPtr("ssh"),
+		InboundNatRulePropertiesFormat: &network.InboundNatRulePropertiesFormat{
+			Protocol:     network.TransportProtocolTCP,
+			FrontendPort: int32Ptr(2222),
+			BackendPort:  int32Ptr(22),
+		},
+	}
+	_, err := c.AddInboundNatRule(context.Background(), "group", "lb1", rule)
+	require.NoError(t, err)
+	require.Len(t, *service.lb.InboundNatRules, 1)
+
+	_, err = c.RemoveRule(context.Background(), "group", "lb1", "ssh")
+	require.NoError(t, err)
+	assert.Empty(t, *service.lb.InboundNatRules)
+	_, err = c.RemoveRule(context.Background(), "group", "lb1", "rule1")
+	require.NoError(t, err)
+	assert.Empty(t, *service.lb.LoadBalancingRules)
+
+	_, err = c.RemoveRule(context.Background(), "group", "lb1", "ssh")
+	assert.ErrorIs(t, err, errors.NotFound)
+	_, err = c.RemoveRule(context.Background(), "group", "missing", "ssh")
+	assert.ErrorIs(t, err, errors.NotFound)
+}
+
+func TestAddOutboundRule(t *testing.T) {
+	rule := network.OutboundRule{
+		Name: strPtr("snat"),
+		OutboundRulePropertiesFormat: &network.OutboundRulePropertiesFormat{
+			BackendAddressPool:       &network.SubResource{ID: strPtr("pool1")},
+			FrontendIPConfigurations: &[]network.SubResource{{ID: strPtr("fe1")}},
+		},
+	}
+
+	c, _ := newRulesTestClient(Version_1_0)
+	_, err := c.AddOutboundRule(context.Background(), "group", "lb1", rule)
+	assert.ErrorIs(t, err, errors.NotSupported)
+
+	c, service := newRulesTestClient(Version_2_0)
+	_, err = c.AddOutboundRule(context.Background(), "group", "lb1", rule)
+	require.NoError(t, err)
+	require.Len(t, *service.lb.OutboundRules, 1)
+}
+
+func TestSetFrontendPublicIPAddress(t *testing.T) {
+	c, _ := newRulesTestClient(Version_1_0)
+	_, err := c.SetFrontendPublicIPAddress(context.Background(), "group", "lb1", "fe1", "pip1")
+	assert.ErrorIs(t, err, errors.NotSupported)
+
+	c, service := newRulesTestClient(Version_2_0)
+	_, err = c.SetFrontendPublicIPAddress(context.Background(), "group", "lb1", "fe1", "pip1")
+	require.NoError(t, err)
+	assert.Equal(t, "pip1", *(*service.lb.FrontendIPConfigurations)[0].PublicIPAddress.ID)
+
+	_, err = c.SetFrontendPublicIPAddress(context.Background(), "group", "lb1", "missing", "pip1")
+	assert.ErrorIs(t, err, errors.NotFound)
+	_, err = c.SetFrontendPublicIPAddress(context.Background(), "group", "lb1", "fe1", "")
+	assert.ErrorIs(t, err, errors.InvalidInput)
+}
+
+func TestAddBackendAddresses(t *testing.T) {
+	c, _ := newRulesTestClient(Version_2_0)
+	interfaces := &fakeInterfaces{nics: map[string]network.Interface{
+		"nic1": healthTestInterface("nic1", "10.0.0.4"),
+		"nic2": healthTestInterface("nic2", "10.0.0.5", "/loadbalancers/lb1/backendaddresspools/pool2"),
+	}}
+	c.interfaces = interfaces
+
+	updated, err := c.AddBackendAddresses(context.Background(), "group", "lb1", "pool2", "10.0.0.4", "10.0.0.5")
+	require.NoError(t, err)
+	require.Len(t, updated, 2)
+	for _, name := range []string{"nic1", "nic2"} {
+		ipConfig := (*interfaces.nics[name].IPConfigurations)[0]
+		pools := *ipConfig.LoadBalancerBackendAddressPools
+		require.Len(t, pools, 1, name)
+		assert.Equal(t, "/loadbalancers/lb1/backendaddresspools/pool2", *pools[0].Name)
+	}
+
+	_, err = c.AddBackendAddresses(context.Background(), "group", "lb1", "pool2", "10.0.0.9")
+	assert.ErrorIs(t, err, errors.NotFound)
+	_, err = c.AddBackendAddresses(context.Background(), "group", "lb1", "missing", "10.0.0.4")
+	assert.ErrorIs(t, err, errors.NotFound)
+}
@@ -53,6 +53,90 @@ func (c *client) UpdateRegisteredIPs(ctx context.Context, locationName, name str
 		nil
 }
 
+// ReconcileResult describes the updates sent by AddRegisteredIPs,
+// RemoveRegisteredIPs or SyncRegisteredIPs. It is re-exported from the
+// shared registeredips package.
+type ReconcileResult = registeredips.ReconcileResult
+
+// AddRegisteredIPs registers the supplied IPs on their subnets in addition
+// to the IPs already registered there. It reads the current lists and sends a
+// full-replace update for the subnets that change. The update carries no
+// resource version, so the lists are read again after the update and changes
+// overwritten by a concurrent writer are applied again, up to a bounded number
+// of updates after which a Failed error is returned. IP-level rejections are
+// returned in the result and are not applied again.
+func (c *LogicalNetworkClient) AddRegisteredIPs(ctx context.Context, locationName, name string, subnetRegisteredIPs []SubnetRegisteredIPs) (*ReconcileResult, error) {
+	if err := validateRegisteredIPsTarget(locationName, name); err != nil {
+		return nil, err
+	}
+	return registeredips.Add(ctx, c.getRegisteredIPs(locationName, name), c.updateRegisteredIPs(locationName, name), subnetRegisteredIPs)
+}
+
+// RemoveRegisteredIPs unregisters the supplied IPs from their subnets,
+// keeping every other registered IP. See AddRegisteredIPs for the update
+// behavior.
+func (c *LogicalNetworkClient) RemoveRegisteredIPs(ctx context.Context, locationName, name string, subnetRegisteredIPs []SubnetRegisteredIPs) (*ReconcileResult, error) {
+	if err := validateRegisteredIPsTarget(locationName, name); err != nil {
+		return nil, err
+	}
+	return registeredips.Remove(ctx, c.getRegisteredIPs(locationName, name), c.updateRegisteredIPs(locationName, name), subnetRegisteredIPs)
+}
+
+// SyncRegisteredIPs makes the registered IPs of each supplied subnet equal
+// to its desired list, and only updates the subnets that differ. Subnets
+// that are not supplied are left untouched. See AddRegisteredIPs for the
+// update behavior.
+func (c *LogicalNetworkClient) SyncRegisteredIPs(ctx context.Context, locationName, name string, desired []SubnetRegisteredIPs) (*ReconcileResult, error) {
+	if err := validateRegisteredIPsTarget(locationName, name); err != nil {
+		return nil, err
+	}
+	return registeredips.Sync(ctx, c.getRegisteredIPs(locationName, name), c.updateRegisteredIPs(locationName, name), desired)
+}
+
+// getRegisteredIPs reads the registered IPs of every subnet of the network
+// from its IP pools.
+func (c *LogicalNetworkClient) getRegisteredIPs(locationName, name string) registeredips.CurrentFunc {
+	return func(ctx context.Context) (map[string][]string, error) {
+		networks, err := c.Get(ctx, locationName, name)
+		if err != nil {
+			return nil, err
+		}
+		if networks == nil || len(*networks) == 0 {
+			return nil, errors.Wrapf(errors.NotFound, "Logical Network [%s] not found", name)
+		}
+		stored := map[string][]string{}
+		n := (*networks)[0]
+		if n.LogicalNetworkPropertiesFormat == nil || n.Subnets == nil {
+			return stored, nil
+		}
+		for _, subnet := range *n.Subnets {
+			if subnet.Name == nil || subnet.LogicalSubnetPropertiesFormat == nil {
+				continue
+			}
+			for _, pool := range subnet.IPPools {
+				stored[*subnet.Name] = append(stored[*subnet.Name], pool.RegisteredIPAddresses...)
+			}
+		}
+		return stored, nil
+	}
+}
+
+func (c *LogicalNetworkClient) updateRegisteredIPs(locationName, name string) registeredips.UpdateFunc {
+	return func(ctx context.Context, subnetRegisteredIPs []SubnetRegisteredIPs) ([]SubnetRegisteredIPs, []IPAddressUpdateFailure, error) {
+		return c.UpdateRegisteredIPs(ctx, locationName, name, subnetRegisteredIPs)
+	}
+}
+
+func validateRegisteredIPsTarget(locationName, name string) error {
+	if len(locationName) == 0 {
+		return errors.Wrapf(errors.InvalidInput, "LocationName is not specified")
+	}
+	if len(name) == 0 {
+		return errors.Wrapf(errors.InvalidInput, "Name is not specified")
+	}
+	return nil
+}
+
 func subnetsToProto(in []SubnetRegisteredIPs) []*wssdcloudnetwork.LogicalSubnetIPUpdate {
 	out := make([]*wssdcloudnetwork.LogicalSubnetIPUpdate, 0, len(in))
 	for _, s := range in {
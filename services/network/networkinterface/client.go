@@ -23,6 +23,7 @@ type Service interface {
 type InterfaceClient struct {
 	network.BaseClient
 	internal Service
+	prefixes prefixResolver
 }
 
 // NewInterfaceClient method returns new client
@@ -32,7 +33,10 @@ func NewInterfaceClient(cloudFQDN string, authorizer auth.Authorizer) (*Interfac
 		return nil, err
 	}
 
-	return &InterfaceClient{internal: c}, nil
+	return &InterfaceClient{
+		internal: c,
+		prefixes: &networkPrefixResolver{cloudFQDN: cloudFQDN, authorizer: authorizer},
+	}, nil
 }
 
 // Get methods invokes the client Get method
@@ -0,0 +1,453 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the Apache v2.0 License.
+
+package networkinterface
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/microsoft/moc-sdk-for-go/services/network"
+	"github.com/microsoft/moc-sdk-for-go/services/network/logicalnetwork"
+	"github.com/microsoft/moc-sdk-for-go/services/network/virtualnetwork"
+	"github.com/microsoft/moc/pkg/auth"
+	"github.com/microsoft/moc/pkg/errors"
+)
+
+// maxUpdateAttempts bounds the read-modify-write cycles of a helper when the cloud agent keeps reporting
+// version conflicts
+const maxUpdateAttempts = 10
+
+// updateRetryDelay is the pause between attempts after a version conflict
+var updateRetryDelay = 100 * time.Millisecond
+
+// prefixResolver returns the address prefixes of a subnet referenced by an IP configuration
+type prefixResolver interface {
+	GetSubnetPrefixes(ctx context.Context, group, location, subnetID string) ([]string, error)
+}
+
+// networkPrefixResolver reads the subnet prefixes from the virtual network of the group or the logical
+// network of the location. The network clients are created on first use.
+type networkPrefixResolver struct {
+	cloudFQDN  string
+	authorizer auth.Authorizer
+	vnetclient *virtualnetwork.VirtualNetworkClient
+	lnetclient *logicalnetwork.LogicalNetworkClient
+	lock       sync.Mutex
+}
+
+func (r *networkPrefixResolver) getVirtualNetworkClient() (*virtualnetwork.VirtualNetworkClient, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.vnetclient == nil {
+		vnetclient, err := virtualnetwork.NewVirtualNetworkClient(r.cloudFQDN, r.authorizer)
+		if err != nil {
+			return nil, err
+		}
+		r.vnetclient = vnetclient
+	}
+	return r.vnetclient, nil
+}
+
+func (r *networkPrefixResolver) getLogicalNetworkClient() (*logicalnetwork.LogicalNetworkClient, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.lnetclient == nil {
+		lnetclient, err := logicalnetwork.NewLogicalNetworkClient(r.cloudFQDN, r.authorizer)
+		if err != nil {
+			return nil, err
+		}
+		r.lnetclient = lnetclient
+	}
+	return r.lnetclient, nil
+}
+
+// GetSubnetPrefixes returns the address prefixes of the subnet /virtualnetworks|logicalnetworks/<net>/subnets/<subnet>
+func (r *networkPrefixResolver) GetSubnetPrefixes(ctx context.Context, group, location, subnetID string) ([]string, error) {
+	networkPrefix, networkName, subnetName, err := ParseSubnetID(subnetID)
+	if err != nil {
+		return nil, err
+	}
+
+	switch networkPrefix {
+	case VNET_PREFIX:
+		vnetclient, err := r.getVirtualNetworkClient()
+		if err != nil {
+			return nil, err
+		}
+		vnets, err := vnetclient.Get(ctx, group, networkName)
+		if err != nil {
+			return nil, err
+		}
+		if vnets != nil && len(*vnets) > 0 && (*vnets)[0].VirtualNetworkPropertiesFormat != nil && (*vnets)[0].Subnets != nil {
+			for _, subnet := range *(*vnets)[0].Subnets {
+				if subnet.Name != nil && *subnet.Name == subnetName && subnet.SubnetPropertiesFormat != nil {
+					return getPrefixes(subnet.AddressPrefix, subnet.AddressPrefixes), nil
+				}
+			}
+		}
+	case LNET_PREFIX:
+		if len(location) == 0 {
+			return nil, errors.Wrapf(errors.InvalidInput, "Location not specified for logical network [%s]", networkName)
+		}
+		lnetclient, err := r.getLogicalNetworkClient()
+		if err != nil {
+			return nil, err
+		}
+		lnets, err := lnetclient.Get(ctx, location, networkName)
+		if err != nil {
+			return nil, err
+		}
+		if lnets != nil && len(*lnets) > 0 && (*lnets)[0].LogicalNetworkPropertiesFormat != nil && (*lnets)[0].Subnets != nil {
+			for _, subnet := range *(*lnets)[0].Subnets {
+				if subnet.Name != nil && *subnet.Name == subnetName && subnet.LogicalSubnetPropertiesFormat != nil {
+					return getPrefixes(subnet.AddressPrefix, subnet.AddressPrefixes), nil
+				}
+			}
+		}
+	}
+	return nil, errors.Wrapf(errors.NotFound, "Subnet [%s] not found", subnetID)
+}
+
+// AddIPConfiguration adds the IP configuration to the network interface. A static address must be inside
+// the prefixes of the subnet and not used by another IP configuration of the interface. When the new
+// configuration is primary, the others are made secondary.
+func (c *InterfaceClient) AddIPConfiguration(ctx context.Context, group, name string, ipConfig network.InterfaceIPConfiguration) (*network.Interface, error) {
+	if ipConfig.Name == nil || len(*ipConfig.Name) == 0 {
+		return nil, errors.Wrapf(errors.InvalidInput, "Missing IPConfiguration Name")
+	}
+	if ipConfig.InterfaceIPConfigurationPropertiesFormat == nil || ipConfig.Subnet == nil || ipConfig.Subnet.ID == nil || len(*ipConfig.Subnet.ID) == 0 {
+		return nil, errors.Wrapf(errors.InvalidConfiguration, "Missing Subnet Reference")
+	}
+	return c.update(ctx, group, name, func(nic *network.Interface) error {
+		ipConfigs := []network.InterfaceIPConfiguration{}
+		if nic.IPConfigurations != nil {
+			ipConfigs = *nic.IPConfigurations
+		}
+		for _, existing := range ipConfigs {
+			if existing.Name != nil && *existing.Name == *ipConfig.Name {
+				return errors.Wrapf(errors.AlreadyExists, "IPConfiguration [%s] already exists", *ipConfig.Name)
+			}
+		}
+		if ipConfig.PrivateIPAddress != nil && len(*ipConfig.PrivateIPAddress) > 0 {
+			if err := c.validateAddress(ctx, group, nic, &ipConfig, *ipConfig.PrivateIPAddress); err != nil {
+				return err
+			}
+		}
+		if ipConfig.Primary != nil && *ipConfig.Primary {
+			setPrimary(ipConfigs, "")
+		}
+		ipConfigs = append(ipConfigs, ipConfig)
+		nic.IPConfigurations = &ipConfigs
+		return nil
+	})
+}
+
+// RemoveIPConfiguration removes the IP configuration from the network interface. The primary and the last
+// IP configurations cannot be removed.
+func (c *InterfaceClient) RemoveIPConfiguration(ctx context.Context, group, name, ipConfigName string) (*network.Interface, error) {
+	return c.update(ctx, group, name, func(nic *network.Interface) error {
+		ipConfig, err := findIPConfiguration(nic, ipConfigName)
+		if err != nil {
+			return err
+		}
+		if len(*nic.IPConfigurations) == 1 {
+			return errors.Wrapf(errors.InvalidInput, "Cannot remove IPConfiguration [%s], the last of network interface [%s]", ipConfigName, name)
+		}
+		if ipConfig.Primary != nil && *ipConfig.Primary {
+			return errors.Wrapf(errors.InvalidInput, "Cannot remove primary IPConfiguration [%s], set another primary first", ipConfigName)
+		}
+		ipConfigs := []network.InterfaceIPConfiguration{}
+		for _, existing := range *nic.IPConfigurations {
+			if existing.Name == nil || *existing.Name != ipConfigName {
+				ipConfigs = append(ipConfigs, existing)
+			}
+		}
+		nic.IPConfigurations = &ipConfigs
+		return nil
+	})
+}
+
+// SetPrimary makes the IP configuration the primary one of the network interface
+func (c *InterfaceClient) SetPrimary(ctx context.Context, group, name, ipConfigName string) (*network.Interface, error) {
+	return c.update(ctx, group, name, func(nic *network.Interface) error {
+		if _, err := findIPConfiguration(nic, ipConfigName); err != nil {
+			return err
+		}
+		setPrimary(*nic.IPConfigurations, ipConfigName)
+		return nil
+	})
+}
+
+// SetStaticIP assigns the static address to the IP configuration. The address must be inside the prefixes
+// of its subnet and not used by another IP configuration of the interface.
+func (c *InterfaceClient) SetStaticIP(ctx context.Context, group, name, ipConfigName, address string) (*network.Interface, error) {
+	return c.update(ctx, group, name, func(nic *network.Interface) error {
+		ipConfig, err := findIPConfiguration(nic, ipConfigName)
+		if err != nil {
+			return err
+		}
+		if err := c.validateAddress(ctx, group, nic, ipConfig, address); err != nil {
+			return err
+		}
+		static := network.Static
+		ipConfig.PrivateIPAddress = &address
+		ipConfig.PrivateIPAllocationMethod = &static
+		return nil
+	})
+}
+
+// AttachNSG references the network security group from the IP configurations, or from all IP
+// configurations of the network interface when none is named
+func (c *InterfaceClient) AttachNSG(ctx context.Context, group, name, nsgName string, ipConfigNames ...string) (*network.Interface, error) {
+	if len(nsgName) == 0 {
+		return nil, errors.Wrapf(errors.InvalidInput, "Missing network security group name")
+	}
+	return c.update(ctx, group, name, func(nic *network.Interface) error {
+		ipConfigs, err := findIPConfigurations(nic, ipConfigNames)
+		if err != nil {
+			return err
+		}
+		for _, ipConfig := range ipConfigs {
+			nsg := nsgName
+			ipConfig.NetworkSecurityGroup = &network.SubResource{ID: &nsg}
+		}
+		return nil
+	})
+}
+
+// DetachNSG removes the network security group from the IP configurations, or from all IP configurations
+// of the network interface when none is named
+func (c *InterfaceClient) DetachNSG(ctx context.Context, group, name string, ipConfigNames ...string) (*network.Interface, error) {
+	return c.update(ctx, group, name, func(nic *network.Interface) error {
+		ipConfigs, err := findIPConfigurations(nic, ipConfigNames)
+		if err != nil {
+			return err
+		}
+		for _, ipConfig := range ipConfigs {
+			ipConfig.NetworkSecurityGroup = nil
+		}
+		return nil
+	})
+}
+
+// SetPublicIPAddress references the public IP address from the IP configuration. An empty name removes the
+// public IP address from the IP configuration.
+func (c *InterfaceClient) SetPublicIPAddress(ctx context.Context, group, name, ipConfigName, publicIPAddressName string) (*network.Interface, error) {
+	return c.update(ctx, group, name, func(nic *network.Interface) error {
+		ipConfig, err := findIPConfiguration(nic, ipConfigName)
+		if err != nil {
+			return err
+		}
+		if len(publicIPAddressName) == 0 {
+			ipConfig.PublicIPAddress = nil
+			return nil
+		}
+		pip := publicIPAddressName
+		ipConfig.PublicIPAddress = &network.PublicIPAddress{ID: &pip}
+		return nil
+	})
+}
+
+// SetDNSServers replaces the DNS servers of the network interface. An empty list clears them.
+func (c *InterfaceClient) SetDNSServers(ctx context.Context, group, name string, servers []string) (*network.Interface, error) {
+	for _, server := range servers {
+		if _, err := netip.ParseAddr(strings.TrimSpace(server)); err != nil {
+			return nil, errors.Wrapf(errors.InvalidInput, "Invalid DNS server address [%s]", server)
+		}
+	}
+	return c.update(ctx, group, name, func(nic *network.Interface) error {
+		dnsServers := append([]string{}, servers...)
+		if nic.DNSSettings == nil {
+			nic.DNSSettings = &network.InterfaceDNSSettings{}
+		}
+		nic.DNSSettings.DNSServers = &dnsServers
+		return nil
+	})
+}
+
+// SetMACAddress sets the MAC address of the network interface
+func (c *InterfaceClient) SetMACAddress(ctx context.Context, group, name, macAddress string) (*network.Interface, error) {
+	mac, err := net.ParseMAC(strings.TrimSpace(macAddress))
+	if err != nil || len(mac) != 6 {
+		return nil, errors.Wrapf(errors.InvalidInput, "Invalid MAC address [%s]", macAddress)
+	}
+	if mac[0]&0x01 != 0 {
+		return nil, errors.Wrapf(errors.InvalidInput, "MAC address [%s] is a multicast address", macAddress)
+	}
+	return c.update(ctx, group, name, func(nic *network.Interface) error {
+		address := strings.TrimSpace(macAddress)
+		nic.MacAddress = &address
+		return nil
+	})
+}
+
+// update reads the network interface, applies modify and writes it back. The whole cycle is retried when
+// the write reports a version conflict.
+func (c *InterfaceClient) update(ctx context.Context, group, name string, modify func(nic *network.Interface) error) (*network.Interface, error) {
+	if len(name) == 0 {
+		return nil, errors.Wrapf(errors.InvalidInput, "Missing network interface name")
+	}
+	for attempt := 1; ; attempt++ {
+		nics, err := c.Get(ctx, group, name)
+		if err != nil {
+			return nil, err
+		}
+		if nics == nil || len(*nics) == 0 {
+			return nil, errors.Wrapf(errors.NotFound, "Network interface [%s] not found", name)
+		}
+		nic := (*nics)[0]
+		if nic.InterfacePropertiesFormat == nil {
+			nic.InterfacePropertiesFormat = &network.InterfacePropertiesFormat{}
+		}
+		if err := modify(&nic); err != nil {
+			return nil, err
+		}
+
+		result, err := c.CreateOrUpdate(ctx, group, name, &nic)
+		if err == nil {
+			return result, nil
+		}
+		if !errors.IsInvalidVersion(err) || attempt >= maxUpdateAttempts {
+			return nil, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(updateRetryDelay):
+		}
+	}
+}
+
+// validateAddress checks that the address is a host address of the subnet of the IP configuration and that
+// no other IP configuration of the network interface uses it
+func (c *InterfaceClient) validateAddress(ctx context.Context, group string, nic *network.Interface, ipConfig *network.InterfaceIPConfiguration, address string) error {
+	parsed, err := netip.ParseAddr(strings.TrimSpace(address))
+	if err != nil {
+		return errors.Wrapf(errors.InvalidInput, "Invalid IP address [%s]", address)
+	}
+	if nic.IPConfigurations != nil {
+		for i := range *nic.IPConfigurations {
+			other := &(*nic.IPConfigurations)[i]
+			if other == ipConfig || other.InterfaceIPConfigurationPropertiesFormat == nil || other.PrivateIPAddress == nil {
+				continue
+			}
+			if otherAddress, err := netip.ParseAddr(strings.TrimSpace(*other.PrivateIPAddress)); err == nil && otherAddress == parsed {
+				return errors.Wrapf(errors.InvalidInput, "IP address [%s] is already used by IPConfiguration [%s]", address, getIPConfigurationName(other))
+			}
+		}
+	}
+
+	if ipConfig.Subnet == nil || ipConfig.Subnet.ID == nil || len(*ipConfig.Subnet.ID) == 0 {
+		return errors.Wrapf(errors.InvalidConfiguration, "Missing Subnet Reference")
+	}
+	if c.prefixes == nil {
+		return errors.Wrapf(errors.InvalidConfiguration, "Missing subnet prefix resolver")
+	}
+	location := ""
+	if nic.Location != nil {
+		location = *nic.Location
+	}
+	prefixes, err := c.prefixes.GetSubnetPrefixes(ctx, group, location, *ipConfig.Subnet.ID)
+	if err != nil {
+		return err
+	}
+	for _, p := range prefixes {
+		prefix, err := netip.ParsePrefix(strings.TrimSpace(p))
+		if err != nil || !prefix.Contains(parsed) {
+			continue
+		}
+		first, last := network.GetPrefixRange(prefix)
+		if parsed.Is4() && prefix.Bits() < 31 && (parsed == first || parsed == last) {
+			return errors.Wrapf(errors.InvalidInput, "IP address [%s] is the network or broadcast address of subnet prefix [%s]", address, p)
+		}
+		return nil
+	}
+	return errors.Wrapf(errors.InvalidInput, "IP address [%s] is outside the prefixes %v of subnet [%s]", address, prefixes, *ipConfig.Subnet.ID)
+}
+
+// findIPConfiguration returns the IP configuration with the name
+func findIPConfiguration(nic *network.Interface, ipConfigName string) (*network.InterfaceIPConfiguration, error) {
+	if len(ipConfigName) == 0 {
+		return nil, errors.Wrapf(errors.InvalidInput, "Missing IPConfiguration Name")
+	}
+	if nic.IPConfigurations != nil {
+		for i := range *nic.IPConfigurations {
+			ipConfig := &(*nic.IPConfigurations)[i]
+			if ipConfig.Name != nil && *ipConfig.Name == ipConfigName {
+				if ipConfig.InterfaceIPConfigurationPropertiesFormat == nil {
+					ipConfig.InterfaceIPConfigurationPropertiesFormat = &network.InterfaceIPConfigurationPropertiesFormat{}
+				}
+				return ipConfig, nil
+			}
+		}
+	}
+	return nil, errors.Wrapf(errors.NotFound, "IPConfiguration [%s] not found in network interface [%s]", ipConfigName, getName(nic.Name))
+}
+
+// findIPConfigurations returns the IP configurations with the names, or all of them when no name is given
+func findIPConfigurations(nic *network.Interface, ipConfigNames []string) ([]*network.InterfaceIPConfiguration, error) {
+	ipConfigs := []*network.InterfaceIPConfiguration{}
+	if len(ipConfigNames) == 0 {
+		if nic.IPConfigurations == nil || len(*nic.IPConfigurations) == 0 {
+			return nil, errors.Wrapf(errors.InvalidConfiguration, "Missing IPConfigurations")
+		}
+		for _, ipConfig := range *nic.IPConfigurations {
+			if ipConfig.Name != nil {
+				ipConfigNames = append(ipConfigNames, *ipConfig.Name)
+			}
+		}
+	}
+	for _, ipConfigName := range ipConfigNames {
+		ipConfig, err := findIPConfiguration(nic, ipConfigName)
+		if err != nil {
+			return nil, err
+		}
+		ipConfigs = append(ipConfigs, ipConfig)
+	}
+	return ipConfigs, nil
+}
+
+// setPrimary marks the IP configuration with the name as primary and the others as secondary
+func setPrimary(ipConfigs []network.InterfaceIPConfiguration, primaryName string) {
+	for i := range ipConfigs {
+		if ipConfigs[i].InterfaceIPConfigurationPropertiesFormat == nil {
+			ipConfigs[i].InterfaceIPConfigurationPropertiesFormat = &network.InterfaceIPConfigurationPropertiesFormat{}
+		}
+		primary := ipConfigs[i].Name != nil && *ipConfigs[i].Name == primaryName
+		ipConfigs[i].Primary = &primary
+	}
+}
+
+func getPrefixes(prefix *string, prefixes *[]string) []string {
+	result := []string{}
+	if prefix != nil && len(*prefix) > 0 {
+		result = append(result, *prefix)
+	}
+	if prefixes != nil {
+		for _, p := range *prefixes {
+			if len(p) > 0 && (prefix == nil || p != *prefix) {
+				result = append(result, p)
+			}
+		}
+	}
+	return result
+}
+
+func getIPConfigurationName(ipConfig *network.InterfaceIPConfiguration) string {
+	if ipConfig.Name == nil {
+		return ""
+	}
+	return *ipConfig.Name
+}
+
+func getName(name *string) string {
+	if name == nil {
+		return ""
+	}
+	return *name
+}
@@ -0,0 +1,224 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the Apache v2.0 License.
+
+package networkinterface
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/microsoft/moc-sdk-for-go/services/network"
+	"github.com/microsoft/moc/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeService stores one network interface and fails the first conflicts writes with a version conflict
+type fakeService struct {
+	Service
+	nic       *network.Interface
+	conflicts int
+	writes    int
+}
+
+func (s *fakeService) Get(ctx context.Context, group, name string) (*[]network.Interface, error) {
+	if s.nic == nil || *s.nic.Name != name {
+		return &[]network.Interface{}, nil
+	}
+	return &[]network.Interface{cloneInterface(s.nic)}, nil
+}
+
+func (s *fakeService) CreateOrUpdate(ctx context.Context, group, name string, nic *network.Interface) (*network.Interface, error) {
+	if s.conflicts > 0 {
+		s.conflicts--
+		return nil, errors.Wrapf(errors.InvalidVersion, "version conflict")
+	}
+	s.writes++
+	stored := cloneInterface(nic)
+	s.nic = &stored
+	return nic, nil
+}
+
+type fakePrefixes map[string][]string
+
+func (f fakePrefixes) GetSubnetPrefixes(ctx context.Context, group, location, subnetID string) ([]string, error) {
+	prefixes, ok := f[subnetID]
+	if !ok {
+		return nil, errors.Wrapf(errors.NotFound, "Subnet [%s] not found", subnetID)
+	}
+	return prefixes, nil
+}
+
+func cloneInterface(nic *network.Interface) network.Interface {
+	data, _ := json.Marshal(nic)
+	clone := network.Interface{}
+	_ = json.Unmarshal(data, &clone)
+	return clone
+}
+
+func strPtr(s string) *string { return &s }
+
+func boolPtr(b bool) *bool { return &b }
+
+func newIPConfigTestClient() (*InterfaceClient, *fakeService) {
+	service := &fakeService{nic: &network.Interface{
+		Name: strPtr("nic1"),
+		InterfacePropertiesFormat: &network.InterfacePropertiesFormat{
+			IPConfigurations: &[]network.InterfaceIPConfiguration{{
+				Name: strPtr("ipconfig1"),
+				InterfaceIPConfigurationPropertiesFormat: &network.InterfaceIPConfigurationPropertiesFormat{
+					PrivateIPAddress: strPtr("10.0.0.4"),
+					Primary:          boolPtr(true),
+					Subnet:           &network.APIEntityReference{ID: strPtr("/virtualnetworks/vnet1/subnets/subnet1")},
+				},
+			}},
+		},
+	}}
+	c := &InterfaceClient{
+		internal: service,
+		prefixes: fakePrefixes{"/virtualnetworks/vnet1/subnets/subnet1": {"10.0.0.0/24"}},
+	}
+	return c, service
+}
+
+func newIPConfiguration(name, address string, primary bool) network.InterfaceIPConfiguration {
+	return network.InterfaceIPConfiguration{
+		Name: strPtr(name),
+		InterfaceIPConfigurationPropertiesFormat: &network.InterfaceIPConfigurationPropertiesFormat{
+			PrivateIPAddress: strPtr(address),
+			Primary:          boolPtr(primary),
+			Subnet:           &network.APIEntityReference{ID: strPtr("/virtualnetworks/vnet1/subnets/subnet1")},
+		},
+	}
+}
+
+func Test_AddIPConfiguration(t *testing.T) {
+	c, service := newIPConfigTestClient()
+	service.conflicts = 1
+	updateRetryDelay = 0
+
+	_, err := c.AddIPConfiguration(context.Background(), "group", "nic1", newIPConfiguration("ipconfig2", "10.0.0.5", true))
+	require.NoError(t, err)
+	assert.Equal(t, 1, service.writes)
+	ipConfigs := *service.nic.IPConfigurations
+	require.Len(t, ipConfigs, 2)
+	assert.False(t, *ipConfigs[0].Primary)
+	assert.True(t, *ipConfigs[1].Primary)
+
+	for address, expected := range map[string]error{
+		"10.0.0.5":   errors.AlreadyExists,
+		"10.0.1.5":   errors.InvalidInput,
+		"10.0.0.0":   errors.InvalidInput,
+		"10.0.0.255": errors.InvalidInput,
+		"10.0.0.4":   errors.InvalidInput,
+		"10.0.0":     errors.InvalidInput,
+	} {
+		name := "ipconfig3"
+		if expected == errors.AlreadyExists {
+			name = "ipconfig2"
+		}
+		_, err = c.AddIPConfiguration(context.Background(), "group", "nic1", newIPConfiguration(name, address, false))
+		assert.ErrorIs(t, err, expected, address)
+	}
+	assert.Equal(t, 1, service.writes)
+
+	_, err = c.AddIPConfiguration(context.Background(), "group", "nic1", network.InterfaceIPConfiguration{Name: strPtr("ipconfig3")})
+	assert.ErrorIs(t, err, errors.InvalidConfiguration)
+	_, err = c.AddIPConfiguration(context.Background(), "group", "missing", newIPConfiguration("ipconfig3", "10.0.0.6", false))
+	assert.ErrorIs(t, err, errors.NotFound)
+}
+
+func Test_RemoveIPConfigurationAndSetPrimary(t *testing.T) {
+	c, service := newIPConfigTestClient()
+
+	_, err := c.RemoveIPConfiguration(context.Background(), "group", "nic1", "ipconfig1")
+	assert.ErrorIs(t, err, errors.InvalidInput)
+
+	_, err = c.AddIPConfiguration(context.Background(), "group", "nic1", newIPConfiguration("ipconfig2", "10.0.0.5", false))
+	require.NoError(t, err)
+	_, err = c.RemoveIPConfiguration(context.Background(), "group", "nic1", "ipconfig1")
+	assert.ErrorIs(t, err, errors.InvalidInput)
+
+	_, err = c.SetPrimary(context.Background(), "group", "nic1", "ipconfig2")
+	require.NoError(t, err)
+	_, err = c.RemoveIPConfiguration(context.Background(), "group", "nic1", "ipconfig1")
+	require.NoError(t, err)
+	ipConfigs := *service.nic.IPConfigurations
+	require.Len(t, ipConfigs, 1)
+	assert.Equal(t, "ipconfig2", *ipConfigs[0].Name)
+	assert.True(t, *ipConfigs[0].Primary)
+
+	_, err = c.SetPrimary(context.Background(), "group", "nic1", "ipconfig1")
+	assert.ErrorIs(t, err, errors.NotFound)
+}
+
+func Test_SetStaticIP(t *testing.T) {
+	c, service := newIPConfigTestClient()
+
+	_, err := c.SetStaticIP(context.Background(), "group", "nic1", "ipconfig1", "10.0.0.9")
+	require.NoError(t, err)
+	ipConfig := (*service.nic.IPConfigurations)[0]
+	assert.Equal(t, "10.0.0.9", *ipConfig.PrivateIPAddress)
+	assert.Equal(t, network.Static, *ipConfig.PrivateIPAllocationMethod)
+
+	_, err = c.SetStaticIP(context.Background(), "group", "nic1", "ipconfig1", "192.168.0.9")
+	assert.ErrorIs(t, err, errors.InvalidInput)
+	_, err = c.SetStaticIP(context.Background(), "group", "nic1", "missing", "10.0.0.10")
+	assert.ErrorIs(t, err, errors.NotFound)
+}
+
+func Test_AttachAndDetachNSG(t *testing.T) {
+	c, service := newIPConfigTestClient()
+	_, err := c.AddIPConfiguration(context.Background(), "group", "nic1", newIPConfiguration("ipconfig2", "10.0.0.5", false))
+	require.NoError(t, err)
+
+	_, err = c.AttachNSG(context.Background(), "group", "nic1", "nsg1")
+	require.NoError(t, err)
+	for _, ipConfig := range *service.nic.IPConfigurations {
+		assert.Equal(t, "nsg1", *ipConfig.NetworkSecurityGroup.ID)
+	}
+
+	_, err = c.DetachNSG(context.Background(), "group", "nic1", "ipconfig2")
+	require.NoError(t, err)
+	assert.NotNil(t, (*service.nic.IPConfigurations)[0].NetworkSecurityGroup)
+	assert.Nil(t, (*service.nic.IPConfigurations)[1].NetworkSecurityGroup)
+
+	_, err = c.AttachNSG(context.Background(), "group", "nic1", "")
+	assert.ErrorIs(t, err, errors.InvalidInput)
+	_, err = c.AttachNSG(context.Background(), "group", "nic1", "nsg1", "missing")
+	assert.ErrorIs(t, err, errors.NotFound)
+}
+
+func Test_SetDNSServersAndMACAddress(t *testing.T) {
+	c, service := newIPConfigTestClient()
+
+	_, err := c.SetDNSServers(context.Background(), "group", "nic1", []string{"10.0.0.2", "fd00::2"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"10.0.0.2", "fd00::2"}, *service.nic.DNSSettings.DNSServers)
+	_, err = c.SetDNSServers(context.Background(), "group", "nic1", []string{"dns.local"})
+	assert.ErrorIs(t, err, errors.InvalidInput)
+
+	_, err = c.SetMACAddress(context.Background(), "group", "nic1", "00-15-5D-00-00-01")
+	require.NoError(t, err)
+	assert.Equal(t, "00-15-5D-00-00-01", *service.nic.MacAddress)
+	for _, mac := range []string{"00-15-5D-00-01", "01-00-5E-00-00-01", "zz-15-5D-00-00-01"} {
+		_, err = c.SetMACAddress(context.Background(), "group", "nic1", mac)
+		assert.ErrorIs(t, err, errors.InvalidInput, mac)
+	}
+}
+
+func Test_SetPublicIPAddress(t *testing.T) {
+	c, service := newIPConfigTestClient()
+
+	_, err := c.SetPublicIPAddress(context.Background(), "group", "nic1", "ipconfig1", "pip1")
+	require.NoError(t, err)
+	assert.Equal(t, "pip1", *(*service.nic.IPConfigurations)[0].PublicIPAddress.ID)
+
+	_, err = c.SetPublicIPAddress(context.Background(), "group", "nic1", "ipconfig1", "")
+	require.NoError(t, err)
+	assert.Nil(t, (*service.nic.IPConfigurations)[0].PublicIPAddress)
+
+	_, err = c.SetPublicIPAddress(context.Background(), "group", "nic1", "missing", "pip1")
+	assert.ErrorIs(t, err, errors.NotFound)
+}
@@ -335,6 +335,24 @@ func getWssdSubnetReference(subnetId *string) (*wssdcommonproto.SubnetReference,
 	}, nil
 }
 
+// ParseSubnetID returns the network prefix, VNET_PREFIX or LNET_PREFIX, and the network and subnet names
+// of a subnet reference /virtualnetworks|logicalnetworks/<network>/subnets/<subnet>
+func ParseSubnetID(subnetID string) (networkPrefix, networkName, subnetName string, err error) {
+	components := strings.Split(subnetID, "/")
+	if len(components) != 5 || !strings.EqualFold(components[3], SUBNET_PREFIX) {
+		return "", "", "", errors.Wrapf(errors.InvalidInput, "Cannot parse subnet reference [%s]", subnetID)
+	}
+	switch {
+	case strings.EqualFold(components[1], VNET_PREFIX):
+		networkPrefix = VNET_PREFIX
+	case strings.EqualFold(components[1], LNET_PREFIX) || strings.EqualFold(components[1], LNET_PREFIX_LEGACY):
+		networkPrefix = LNET_PREFIX
+	default:
+		return "", "", "", errors.Wrapf(errors.InvalidInput, "Cannot parse network type of subnet reference [%s]", subnetID)
+	}
+	return networkPrefix, components[2], components[4], nil
+}
+
 func getQualifiedSubnetReference(subnet *wssdcommonproto.SubnetReference) *network.APIEntityReference {
 
 	var networkPrefix string
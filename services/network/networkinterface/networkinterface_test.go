@@ -0,0 +1,60 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the Apache v2.0 License.
+
+package networkinterface
+
+import (
+	"testing"
+
+	"github.com/microsoft/moc/pkg/errors"
+	wssdcommonproto "github.com/microsoft/moc/rpc/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_ParseSubnetID(t *testing.T) {
+	networkPrefix, networkName, subnetName, err := ParseSubnetID("/virtualnetworks/vnet1/subnets/subnet1")
+	require.NoError(t, err)
+	assert.Equal(t, VNET_PREFIX, networkPrefix)
+	assert.Equal(t, "vnet1", networkName)
+	assert.Equal(t, "subnet1", subnetName)
+
+	networkPrefix, networkName, _, err = ParseSubnetID("/LogicalNetwork/lnet1/Subnets/subnet1")
+	require.NoError(t, err)
+	assert.Equal(t, LNET_PREFIX, networkPrefix)
+	assert.Equal(t, "lnet1", networkName)
+
+	_, _, _, err = ParseSubnetID("subnet1")
+	assert.ErrorIs(t, err, errors.InvalidInput)
+	_, _, _, err = ParseSubnetID("/virtualnetworks/vnet1/ipconfigs/subnet1")
+	assert.ErrorIs(t, err, errors.InvalidInput)
+	_, _, _, err = ParseSubnetID("/networks/vnet1/subnets/subnet1")
+	assert.ErrorIs(t, err, errors.InvalidInput)
+}
+
+func Test_getWssdSubnetReference(t *testing.T) {
+	id := "/virtualnetworks/vnet1/subnets/subnet1"
+	subnet, err := getWssdSubnetReference(&id)
+	require.NoError(t, err)
+	assert.Equal(t, "vnet1", subnet.Network.ResourceRef.Name)
+	assert.Equal(t, wssdcommonproto.NetworkType_VIRTUAL_NETWORK, subnet.Network.NetworkType)
+	assert.Equal(t, "subnet1", subnet.ResourceRef.Name)
+
+	// the fourth component is not checked
+	id = "/LogicalNetwork/lnet1/ipconfigs/subnet1"
+	subnet, err = getWssdSubnetReference(&id)
+	require.NoError(t, err)
+	assert.Equal(t, "lnet1", subnet.Network.ResourceRef.Name)
+	assert.Equal(t, wssdcommonproto.NetworkType_LOGICAL_NETWORK, subnet.Network.NetworkType)
+	assert.Equal(t, "subnet1", subnet.ResourceRef.Name)
+
+	// other references are sent as a subnet id
+	id = "subnet1"
+	subnet, err = getWssdSubnetReference(&id)
+	require.NoError(t, err)
+	assert.Nil(t, subnet)
+
+	id = "/networks/vnet1/subnets/subnet1"
+	_, err = getWssdSubnetReference(&id)
+	assert.ErrorIs(t, err, errors.InvalidInput)
+}
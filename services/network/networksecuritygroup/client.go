@@ -21,7 +21,8 @@ type Service interface {
 // NetworkSecurityGroupAgentClient structure
 type NetworkSecurityGroupAgentClient struct {
 	network.BaseClient
-	internal Service
+	internal   Service
+	validation ValidationOptions
 }
 
 // NeNetworkSecurityGroupClient method returns new client
@@ -39,8 +40,12 @@ func (c *NetworkSecurityGroupAgentClient) Get(ctx context.Context, location, nam
 	return c.internal.Get(ctx, location, name)
 }
 
-// Ensure methods invokes create or update on the client
+// Ensure methods invokes create or update on the client. The security rules are validated first, see
+// SetValidationOptions.
 func (c *NetworkSecurityGroupAgentClient) CreateOrUpdate(ctx context.Context, location, name string, nsg *network.SecurityGroup) (*network.SecurityGroup, error) {
+	if err := c.validate(nsg); err != nil {
+		return nil, err
+	}
 	return c.internal.CreateOrUpdate(ctx, location, name, nsg)
 }
 
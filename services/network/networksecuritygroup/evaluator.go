@@ -0,0 +1,411 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the Apache v2.0 License.
+
+package networksecuritygroup
+
+import (
+	"context"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/microsoft/moc-sdk-for-go/services/network"
+	"github.com/microsoft/moc/pkg/errors"
+)
+
+const (
+	// ServiceTagVirtualNetwork - Address space of the virtual networks
+	ServiceTagVirtualNetwork = "VirtualNetwork"
+	// ServiceTagInternet - Any address outside the VirtualNetwork service tag
+	ServiceTagInternet = "Internet"
+	// ServiceTagAzureLoadBalancer - Address of the load balancer health probes
+	ServiceTagAzureLoadBalancer = "AzureLoadBalancer"
+)
+
+// Flow is the five-tuple, plus the application security groups of its endpoints, evaluated against security rules
+type Flow struct {
+	// Direction - Inbound or Outbound, relative to the network interface or subnet
+	Direction network.SecurityRuleDirection
+	// Protocol - Tcp, Udp, Icmp or Esp
+	Protocol network.SecurityRuleProtocol
+	// SourceAddress
+	SourceAddress string
+	// SourcePort - Ignored for Icmp and Esp
+	SourcePort uint16
+	// DestinationAddress
+	DestinationAddress string
+	// DestinationPort - Ignored for Icmp and Esp
+	DestinationPort uint16
+	// SourceApplicationSecurityGroups - Names of the application security groups of the source
+	SourceApplicationSecurityGroups []string
+	// DestinationApplicationSecurityGroups - Names of the application security groups of the destination
+	DestinationApplicationSecurityGroups []string
+}
+
+// Verdict is the outcome of evaluating a flow
+type Verdict struct {
+	// Access
+	Access network.SecurityRuleAccess
+	// SecurityGroup - Name of the network security group that decided the verdict
+	SecurityGroup string
+	// Rule - The rule that matched the flow, nil when no rule matched and the flow is implicitly denied
+	Rule *network.SecurityRule
+	// Default - The rule is one of the default security rules
+	Default bool
+}
+
+// EffectiveRule is a security rule in evaluation order
+type EffectiveRule struct {
+	// SecurityGroup - Name of the network security group of the rule
+	SecurityGroup string
+	// Rule
+	Rule network.SecurityRule
+	// Default - The rule is one of the default security rules
+	Default bool
+}
+
+// Attachment is the pair of network security groups applied to the traffic of a network interface. Either may be nil.
+// Inbound traffic is evaluated against the subnet group first, outbound traffic against the interface group first,
+// and a flow is allowed only if every group allows it.
+type Attachment struct {
+	// Subnet - Network security group of the subnet
+	Subnet *network.SecurityGroup
+	// Interface - Network security group of the network interface
+	Interface *network.SecurityGroup
+}
+
+// Evaluator evaluates flows offline
+type Evaluator struct {
+	// ServiceTags - Address prefixes of the service tags used by the rules, such as VirtualNetwork and
+	// AzureLoadBalancer. Internet matches any address outside VirtualNetwork.
+	ServiceTags map[string][]string
+}
+
+// Evaluate returns the verdict of the security group for the flow. Rules are evaluated by ascending
+// priority, custom rules before default rules of the same priority, and the first match decides.
+func (e *Evaluator) Evaluate(nsg *network.SecurityGroup, flow Flow) (*Verdict, error) {
+	if err := validateFlow(flow); err != nil {
+		return nil, err
+	}
+	name := getSecurityGroupName(nsg)
+	for _, effective := range GetEffectiveRules(nsg, flow.Direction) {
+		matched, err := e.matches(&effective.Rule, flow)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Invalid rule [%s] in network security group [%s]", getRuleName(&effective.Rule), name)
+		}
+		if matched {
+			rule := effective.Rule
+			return &Verdict{Access: rule.Access, SecurityGroup: name, Rule: &rule, Default: effective.Default}, nil
+		}
+	}
+	return &Verdict{Access: network.SecurityRuleAccessDeny, SecurityGroup: name}, nil
+}
+
+// EvaluateAttachment returns the verdict of the attachment for the flow: the first deny, or the allow of the
+// last group evaluated. A flow through an attachment without security groups is allowed.
+func (e *Evaluator) EvaluateAttachment(attachment Attachment, flow Flow) (*Verdict, error) {
+	verdict := &Verdict{Access: network.SecurityRuleAccessAllow}
+	for _, nsg := range attachment.groups(flow.Direction) {
+		var err error
+		if verdict, err = e.Evaluate(nsg, flow); err != nil {
+			return nil, err
+		}
+		if verdict.Access == network.SecurityRuleAccessDeny {
+			return verdict, nil
+		}
+	}
+	return verdict, nil
+}
+
+// GetEffectiveRules returns the rules of the security group for the direction, in evaluation order
+func GetEffectiveRules(nsg *network.SecurityGroup, direction network.SecurityRuleDirection) []EffectiveRule {
+	rules := []EffectiveRule{}
+	if nsg == nil || nsg.SecurityGroupPropertiesFormat == nil {
+		return rules
+	}
+	name := getSecurityGroupName(nsg)
+	add := func(securityRules *[]network.SecurityRule, isDefault bool) {
+		if securityRules == nil {
+			return
+		}
+		for _, rule := range *securityRules {
+			if rule.SecurityRulePropertiesFormat != nil && strings.EqualFold(string(rule.Direction), string(direction)) {
+				rules = append(rules, EffectiveRule{SecurityGroup: name, Rule: rule, Default: isDefault})
+			}
+		}
+	}
+	add(nsg.SecurityRules, false)
+	add(nsg.DefaultSecurityRules, true)
+
+	sort.SliceStable(rules, func(i, j int) bool {
+		pi, pj := getPriority(&rules[i].Rule), getPriority(&rules[j].Rule)
+		if pi != pj {
+			return pi < pj
+		}
+		return !rules[i].Default && rules[j].Default
+	})
+	return rules
+}
+
+// GetEffectiveRules returns the rules of the attachment for the direction, in evaluation order
+func (a Attachment) GetEffectiveRules(direction network.SecurityRuleDirection) []EffectiveRule {
+	rules := []EffectiveRule{}
+	for _, nsg := range a.groups(direction) {
+		rules = append(rules, GetEffectiveRules(nsg, direction)...)
+	}
+	return rules
+}
+
+func (a Attachment) groups(direction network.SecurityRuleDirection) []*network.SecurityGroup {
+	ordered := []*network.SecurityGroup{a.Subnet, a.Interface}
+	if strings.EqualFold(string(direction), string(network.SecurityRuleDirectionOutbound)) {
+		ordered = []*network.SecurityGroup{a.Interface, a.Subnet}
+	}
+	groups := []*network.SecurityGroup{}
+	for _, nsg := range ordered {
+		if nsg != nil {
+			groups = append(groups, nsg)
+		}
+	}
+	return groups
+}
+
+// GetAttachment resolves the network security groups applied to the IP configuration of a network interface
+// and to its subnet. Either may be nil.
+func (c *NetworkSecurityGroupAgentClient) GetAttachment(ctx context.Context, location string, subnet *network.Subnet, ipConfig *network.InterfaceIPConfiguration) (*Attachment, error) {
+	attachment := &Attachment{}
+	var err error
+	if subnet != nil && subnet.SubnetPropertiesFormat != nil {
+		if attachment.Subnet, err = c.getReferenced(ctx, location, subnet.NetworkSecurityGroup); err != nil {
+			return nil, err
+		}
+	}
+	if ipConfig != nil && ipConfig.InterfaceIPConfigurationPropertiesFormat != nil {
+		if attachment.Interface, err = c.getReferenced(ctx, location, ipConfig.NetworkSecurityGroup); err != nil {
+			return nil, err
+		}
+	}
+	return attachment, nil
+}
+
+// getReferenced returns the network security group named by the reference, or nil if there is no reference
+func (c *NetworkSecurityGroupAgentClient) getReferenced(ctx context.Context, location string, reference *network.SubResource) (*network.SecurityGroup, error) {
+	if reference == nil || reference.ID == nil || len(*reference.ID) == 0 {
+		return nil, nil
+	}
+	nsgs, err := c.Get(ctx, location, *reference.ID)
+	if err != nil {
+		return nil, err
+	}
+	if nsgs == nil || len(*nsgs) == 0 {
+		return nil, errors.Wrapf(errors.NotFound, "Network Security Group [%s] not found", *reference.ID)
+	}
+	return &(*nsgs)[0], nil
+}
+
+func (e *Evaluator) matches(rule *network.SecurityRule, flow Flow) (bool, error) {
+	if !matchesProtocol(rule.Protocol, flow.Protocol) {
+		return false, nil
+	}
+	if !isPortless(flow.Protocol) {
+		matched, err := matchesPorts(rule.SourcePortRange, rule.SourcePortRanges, flow.SourcePort)
+		if err != nil || !matched {
+			return false, err
+		}
+		matched, err = matchesPorts(rule.DestinationPortRange, rule.DestinationPortRanges, flow.DestinationPort)
+		if err != nil || !matched {
+			return false, err
+		}
+	}
+	matched, err := e.matchesEndpoint(rule.SourceAddressPrefix, rule.SourceAddressPrefixes, rule.SourceApplicationSecurityGroups,
+		flow.SourceAddress, flow.SourceApplicationSecurityGroups)
+	if err != nil || !matched {
+		return false, err
+	}
+	return e.matchesEndpoint(rule.DestinationAddressPrefix, rule.DestinationAddressPrefixes, rule.DestinationApplicationSecurityGroups,
+		flow.DestinationAddress, flow.DestinationApplicationSecurityGroups)
+}
+
+func matchesProtocol(ruleProtocol, flowProtocol network.SecurityRuleProtocol) bool {
+	return len(ruleProtocol) == 0 || ruleProtocol == network.SecurityRuleProtocolAsterisk ||
+		strings.EqualFold(string(ruleProtocol), string(flowProtocol))
+}
+
+func isPortless(protocol network.SecurityRuleProtocol) bool {
+	return strings.EqualFold(string(protocol), string(network.SecurityRuleProtocolIcmp)) ||
+		strings.EqualFold(string(protocol), string(network.SecurityRuleProtocolEsp))
+}
+
+// matchesPorts reports whether port is in any of the ranges. A rule without ranges matches any port.
+func matchesPorts(portRange *string, portRanges *[]string, port uint16) (bool, error) {
+	ranges := collect(portRange, portRanges)
+	if len(ranges) == 0 {
+		return true, nil
+	}
+	for _, r := range ranges {
+		low, high, err := parsePortRange(r)
+		if err != nil {
+			return false, err
+		}
+		if port >= low && port <= high {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func parsePortRange(portRange string) (uint16, uint16, error) {
+	portRange = strings.TrimSpace(portRange)
+	if portRange == "*" {
+		return 0, 65535, nil
+	}
+	lowText, highText, isRange := strings.Cut(portRange, "-")
+	if !isRange {
+		highText = lowText
+	}
+	low, err := strconv.ParseUint(strings.TrimSpace(lowText), 10, 16)
+	if err != nil {
+		return 0, 0, errors.Wrapf(errors.InvalidInput, "Invalid port range [%s]", portRange)
+	}
+	high, err := strconv.ParseUint(strings.TrimSpace(highText), 10, 16)
+	if err != nil || high < low {
+		return 0, 0, errors.Wrapf(errors.InvalidInput, "Invalid port range [%s]", portRange)
+	}
+	return uint16(low), uint16(high), nil
+}
+
+// matchesEndpoint reports whether the address, or one of the application security groups, of the flow endpoint
+// is in the prefixes or application security groups of the rule. A rule without either matches any endpoint.
+func (e *Evaluator) matchesEndpoint(prefix *string, prefixes *[]string, asgs *[]network.ApplicationSecurityGroup, address string, flowASGs []string) (bool, error) {
+	allPrefixes := collect(prefix, prefixes)
+	if asgs != nil && len(*asgs) > 0 {
+		for _, asg := range *asgs {
+			for _, name := range flowASGs {
+				if (asg.Name != nil && strings.EqualFold(*asg.Name, name)) || (asg.ID != nil && strings.EqualFold(*asg.ID, name)) {
+					return true, nil
+				}
+			}
+		}
+		if len(allPrefixes) == 0 {
+			return false, nil
+		}
+	}
+	if len(allPrefixes) == 0 {
+		return true, nil
+	}
+
+	ip := net.ParseIP(address)
+	for _, p := range allPrefixes {
+		matched, err := e.matchesPrefix(p, ip)
+		if err != nil || matched {
+			return matched, err
+		}
+	}
+	return false, nil
+}
+
+func (e *Evaluator) matchesPrefix(prefix string, ip net.IP) (bool, error) {
+	prefix = strings.TrimSpace(prefix)
+	if prefix == "*" || strings.EqualFold(prefix, "Any") {
+		return true, nil
+	}
+	if strings.ContainsAny(prefix, ".:") {
+		return matchesAddress(prefix, ip)
+	}
+
+	tagPrefixes, ok := e.getServiceTag(prefix)
+	if !ok && strings.EqualFold(prefix, ServiceTagInternet) {
+		if _, ok := e.getServiceTag(ServiceTagVirtualNetwork); !ok {
+			return false, errors.Wrapf(errors.InvalidInput, "Service tag [%s] requires the prefixes of [%s]", ServiceTagInternet, ServiceTagVirtualNetwork)
+		}
+		inVirtualNetwork, err := e.matchesPrefix(ServiceTagVirtualNetwork, ip)
+		return err == nil && !inVirtualNetwork, err
+	}
+	if !ok {
+		return false, errors.Wrapf(errors.InvalidInput, "Unknown service tag [%s]", prefix)
+	}
+	for _, p := range tagPrefixes {
+		matched, err := matchesAddress(p, ip)
+		if err != nil || matched {
+			return matched, err
+		}
+	}
+	return false, nil
+}
+
+func (e *Evaluator) getServiceTag(tag string) ([]string, bool) {
+	for name, prefixes := range e.ServiceTags {
+		if strings.EqualFold(name, tag) {
+			return prefixes, true
+		}
+	}
+	return nil, false
+}
+
+// matchesAddress reports whether ip is in the CIDR or equal to the address
+func matchesAddress(prefix string, ip net.IP) (bool, error) {
+	if strings.Contains(prefix, "/") {
+		_, cidr, err := net.ParseCIDR(prefix)
+		if err != nil {
+			return false, errors.Wrapf(errors.InvalidInput, "Invalid address prefix [%s]", prefix)
+		}
+		return ip != nil && cidr.Contains(ip), nil
+	}
+	address := net.ParseIP(prefix)
+	if address == nil {
+		return false, errors.Wrapf(errors.InvalidInput, "Invalid address prefix [%s]", prefix)
+	}
+	return ip != nil && address.Equal(ip), nil
+}
+
+func validateFlow(flow Flow) error {
+	if !strings.EqualFold(string(flow.Direction), string(network.SecurityRuleDirectionInbound)) &&
+		!strings.EqualFold(string(flow.Direction), string(network.SecurityRuleDirectionOutbound)) {
+		return errors.Wrapf(errors.InvalidInput, "Invalid flow direction [%s]", flow.Direction)
+	}
+	if len(flow.Protocol) == 0 || flow.Protocol == network.SecurityRuleProtocolAsterisk {
+		return errors.Wrapf(errors.InvalidInput, "Flow protocol not specified")
+	}
+	if net.ParseIP(flow.SourceAddress) == nil {
+		return errors.Wrapf(errors.InvalidInput, "Invalid flow source address [%s]", flow.SourceAddress)
+	}
+	if net.ParseIP(flow.DestinationAddress) == nil {
+		return errors.Wrapf(errors.InvalidInput, "Invalid flow destination address [%s]", flow.DestinationAddress)
+	}
+	return nil
+}
+
+func collect(value *string, values *[]string) []string {
+	all := []string{}
+	if value != nil && len(*value) > 0 {
+		all = append(all, *value)
+	}
+	if values != nil {
+		all = append(all, *values...)
+	}
+	return all
+}
+
+// getPriority returns the priority the cloud agent applies to the rule, which is defaultRulePriority when
+// the rule has no valid priority
+func getPriority(rule *network.SecurityRule) uint32 {
+	if rule.Priority == nil || !isValidPriority(*rule.Priority) {
+		return defaultRulePriority
+	}
+	return *rule.Priority
+}
+
+func getRuleName(rule *network.SecurityRule) string {
+	if rule.Name == nil {
+		return ""
+	}
+	return *rule.Name
+}
+
+func getSecurityGroupName(nsg *network.SecurityGroup) string {
+	if nsg == nil || nsg.Name == nil {
+		return ""
+	}
+	return *nsg.Name
+}
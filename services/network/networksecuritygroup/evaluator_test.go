@@ -0,0 +1,165 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the Apache v2.0 License.
+
+package networksecuritygroup
+
+import (
+	"context"
+	"testing"
+
+	"github.com/microsoft/moc-sdk-for-go/services/network"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newRule(name string, priority uint32, direction network.SecurityRuleDirection, access network.SecurityRuleAccess, protocol network.SecurityRuleProtocol, source, destination, destinationPorts string) network.SecurityRule {
+	return network.SecurityRule{
+		Name: &name,
+		SecurityRulePropertiesFormat: &network.SecurityRulePropertiesFormat{
+			Priority:                 &priority,
+			Direction:                direction,
+			Access:                   access,
+			Protocol:                 protocol,
+			SourceAddressPrefix:      &source,
+			DestinationAddressPrefix: &destination,
+			DestinationPortRange:     &destinationPorts,
+		},
+	}
+}
+
+func newSecurityGroup(name string, rules ...network.SecurityRule) *network.SecurityGroup {
+	defaults := []network.SecurityRule{
+		newRule("AllowVnetInBound", 65000, network.SecurityRuleDirectionInbound, network.SecurityRuleAccessAllow, network.SecurityRuleProtocolAsterisk, ServiceTagVirtualNetwork, ServiceTagVirtualNetwork, "*"),
+		newRule("DenyAllInBound", 65500, network.SecurityRuleDirectionInbound, network.SecurityRuleAccessDeny, network.SecurityRuleProtocolAsterisk, "*", "*", "*"),
+		newRule("AllowInternetOutBound", 65001, network.SecurityRuleDirectionOutbound, network.SecurityRuleAccessAllow, network.SecurityRuleProtocolAsterisk, "*", ServiceTagInternet, "*"),
+	}
+	return &network.SecurityGroup{
+		Name: &name,
+		SecurityGroupPropertiesFormat: &network.SecurityGroupPropertiesFormat{
+			SecurityRules:        &rules,
+			DefaultSecurityRules: &defaults,
+		},
+	}
+}
+
+func newEvaluator() *Evaluator {
+	return &Evaluator{ServiceTags: map[string][]string{ServiceTagVirtualNetwork: {"10.0.0.0/16"}}}
+}
+
+func inbound(source, destination string, port uint16) Flow {
+	return Flow{
+		Direction:          network.SecurityRuleDirectionInbound,
+		Protocol:           network.SecurityRuleProtocolTCP,
+		SourceAddress:      source,
+		SourcePort:         50000,
+		DestinationAddress: destination,
+		DestinationPort:    port,
+	}
+}
+
+func Test_Evaluate(t *testing.T) {
+	nsg := newSecurityGroup("web",
+		newRule("AllowHTTPS", 100, network.SecurityRuleDirectionInbound, network.SecurityRuleAccessAllow, network.SecurityRuleProtocolTCP, "*", "10.0.1.0/24", "443"),
+		newRule("DenySSH", 200, network.SecurityRuleDirectionInbound, network.SecurityRuleAccessDeny, network.SecurityRuleProtocolTCP, "*", "*", "20-22"),
+	)
+	e := newEvaluator()
+
+	verdict, err := e.Evaluate(nsg, inbound("203.0.113.5", "10.0.1.4", 443))
+	require.NoError(t, err)
+	assert.Equal(t, network.SecurityRuleAccessAllow, verdict.Access)
+	assert.Equal(t, "AllowHTTPS", *verdict.Rule.Name)
+	assert.False(t, verdict.Default)
+
+	// custom deny wins over the default VirtualNetwork allow
+	verdict, err = e.Evaluate(nsg, inbound("10.0.2.4", "10.0.1.4", 22))
+	require.NoError(t, err)
+	assert.Equal(t, network.SecurityRuleAccessDeny, verdict.Access)
+	assert.Equal(t, "DenySSH", *verdict.Rule.Name)
+
+	verdict, err = e.Evaluate(nsg, inbound("10.0.2.4", "10.0.1.4", 8080))
+	require.NoError(t, err)
+	assert.Equal(t, network.SecurityRuleAccessAllow, verdict.Access)
+	assert.Equal(t, "AllowVnetInBound", *verdict.Rule.Name)
+	assert.True(t, verdict.Default)
+
+	verdict, err = e.Evaluate(nsg, inbound("203.0.113.5", "10.0.1.4", 8080))
+	require.NoError(t, err)
+	assert.Equal(t, network.SecurityRuleAccessDeny, verdict.Access)
+	assert.Equal(t, "DenyAllInBound", *verdict.Rule.Name)
+
+	outbound := Flow{Direction: network.SecurityRuleDirectionOutbound, Protocol: network.SecurityRuleProtocolIcmp, SourceAddress: "10.0.1.4", DestinationAddress: "8.8.8.8"}
+	verdict, err = e.Evaluate(nsg, outbound)
+	require.NoError(t, err)
+	assert.Equal(t, network.SecurityRuleAccessAllow, verdict.Access)
+
+	outbound.DestinationAddress = "10.0.3.4"
+	verdict, err = e.Evaluate(nsg, outbound)
+	require.NoError(t, err)
+	assert.Equal(t, network.SecurityRuleAccessDeny, verdict.Access)
+	assert.Nil(t, verdict.Rule)
+}
+
+func Test_Evaluate_ApplicationSecurityGroups(t *testing.T) {
+	asg := "frontends"
+	rule := newRule("AllowFrontends", 100, network.SecurityRuleDirectionInbound, network.SecurityRuleAccessAllow, network.SecurityRuleProtocolTCP, "", "*", "8080")
+	rule.SourceApplicationSecurityGroups = &[]network.ApplicationSecurityGroup{{Name: &asg}}
+	nsg := newSecurityGroup("backend", rule)
+	e := newEvaluator()
+
+	flow := inbound("203.0.113.5", "10.0.1.4", 8080)
+	flow.SourceApplicationSecurityGroups = []string{"frontends"}
+	verdict, err := e.Evaluate(nsg, flow)
+	require.NoError(t, err)
+	assert.Equal(t, network.SecurityRuleAccessAllow, verdict.Access)
+
+	flow.SourceApplicationSecurityGroups = nil
+	verdict, err = e.Evaluate(nsg, flow)
+	require.NoError(t, err)
+	assert.Equal(t, network.SecurityRuleAccessDeny, verdict.Access)
+}
+
+func Test_EvaluateAttachment(t *testing.T) {
+	subnet := newSecurityGroup("subnet",
+		newRule("AllowHTTPS", 100, network.SecurityRuleDirectionInbound, network.SecurityRuleAccessAllow, network.SecurityRuleProtocolTCP, "*", "*", "443"))
+	nic := newSecurityGroup("nic",
+		newRule("DenyHTTPS", 100, network.SecurityRuleDirectionInbound, network.SecurityRuleAccessDeny, network.SecurityRuleProtocolTCP, "*", "*", "443"))
+	e := newEvaluator()
+
+	verdict, err := e.EvaluateAttachment(Attachment{Subnet: subnet, Interface: nic}, inbound("203.0.113.5", "10.0.1.4", 443))
+	require.NoError(t, err)
+	assert.Equal(t, network.SecurityRuleAccessDeny, verdict.Access)
+	assert.Equal(t, "nic", verdict.SecurityGroup)
+
+	verdict, err = e.EvaluateAttachment(Attachment{Subnet: subnet}, inbound("203.0.113.5", "10.0.1.4", 443))
+	require.NoError(t, err)
+	assert.Equal(t, network.SecurityRuleAccessAllow, verdict.Access)
+
+	rules := Attachment{Subnet: subnet, Interface: nic}.GetEffectiveRules(network.SecurityRuleDirectionInbound)
+	require.Len(t, rules, 6)
+	assert.Equal(t, "subnet", rules[0].SecurityGroup)
+	assert.Equal(t, "nic", rules[3].SecurityGroup)
+	assert.Equal(t, "DenyAllInBound", *rules[5].Rule.Name)
+}
+
+func Test_Evaluate_Invalid(t *testing.T) {
+	nsg := newSecurityGroup("bad",
+		newRule("BadPorts", 100, network.SecurityRuleDirectionInbound, network.SecurityRuleAccessAllow, network.SecurityRuleProtocolTCP, "*", "*", "90-80"))
+	_, err := newEvaluator().Evaluate(nsg, inbound("203.0.113.5", "10.0.1.4", 85))
+	assert.Error(t, err)
+
+	_, err = newEvaluator().Evaluate(newSecurityGroup("empty"), inbound("not-an-ip", "10.0.1.4", 85))
+	assert.Error(t, err)
+
+	// Internet requires the VirtualNetwork prefixes
+	outbound := Flow{Direction: network.SecurityRuleDirectionOutbound, Protocol: network.SecurityRuleProtocolTCP, SourceAddress: "10.0.1.4", DestinationAddress: "8.8.8.8"}
+	_, err = (&Evaluator{}).Evaluate(newSecurityGroup("empty"), outbound)
+	assert.Error(t, err)
+}
+
+func Test_CreateOrUpdate_DuplicateRuleNames(t *testing.T) {
+	nsg := newSecurityGroup("dup",
+		newRule("AllowHTTPS", 100, network.SecurityRuleDirectionInbound, network.SecurityRuleAccessAllow, network.SecurityRuleProtocolTCP, "*", "*", "443"),
+		newRule("AllowHTTPS", 110, network.SecurityRuleDirectionInbound, network.SecurityRuleAccessAllow, network.SecurityRuleProtocolTCP, "*", "*", "8443"))
+	_, err := (&client{}).CreateOrUpdate(context.Background(), "location", "dup", nsg)
+	assert.Error(t, err)
+}
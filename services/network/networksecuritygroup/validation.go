@@ -0,0 +1,435 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the Apache v2.0 License.
+
+package networksecuritygroup
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/microsoft/moc-sdk-for-go/services/network"
+	"github.com/microsoft/moc/pkg/errors"
+)
+
+// Severity of a validation finding
+type Severity int
+
+const (
+	// SeverityInfo - The rule is valid but worth reviewing
+	SeverityInfo Severity = iota
+	// SeverityWarning - The rule is valid but likely a mistake
+	SeverityWarning
+	// SeverityError - The rule is invalid
+	SeverityError
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityInfo:
+		return "Info"
+	case SeverityWarning:
+		return "Warning"
+	case SeverityError:
+		return "Error"
+	}
+	return fmt.Sprintf("Severity(%d)", int(s))
+}
+
+// FindingCode identifies the check that produced a finding
+type FindingCode string
+
+const (
+	// FindingCodeInvalidDirection - The rule direction is neither Inbound nor Outbound
+	FindingCodeInvalidDirection FindingCode = "InvalidDirection"
+	// FindingCodePriorityOutOfRange - The rule priority is missing or outside 100-65500, so the cloud agent applies 4096
+	FindingCodePriorityOutOfRange FindingCode = "PriorityOutOfRange"
+	// FindingCodeDuplicatePriority - Another rule of the same direction has the same priority
+	FindingCodeDuplicatePriority FindingCode = "DuplicatePriority"
+	// FindingCodeInvalidAddressPrefix - An address prefix is not *, an IP address, a CIDR or a service tag
+	FindingCodeInvalidAddressPrefix FindingCode = "InvalidAddressPrefix"
+	// FindingCodeInvalidPortRange - A port range is not *, a port or a low-high range
+	FindingCodeInvalidPortRange FindingCode = "InvalidPortRange"
+	// FindingCodeConflictingFields - Both the singular and the plural field of a prefix or port range are set
+	FindingCodeConflictingFields FindingCode = "ConflictingFields"
+	// FindingCodeShadowedRule - A rule with a lower priority value matches every flow the rule matches
+	FindingCodeShadowedRule FindingCode = "ShadowedRule"
+	// FindingCodeBroadAllow - An inbound rule allows traffic from any source
+	FindingCodeBroadAllow FindingCode = "BroadAllow"
+)
+
+// Finding is a problem found in a security rule
+type Finding struct {
+	// Severity
+	Severity Severity
+	// Code - The check that produced the finding
+	Code FindingCode
+	// Rule - Name of the rule
+	Rule string
+	// Message
+	Message string
+}
+
+func (f Finding) String() string {
+	return fmt.Sprintf("%s %s: rule [%s] %s", f.Severity, f.Code, f.Rule, f.Message)
+}
+
+// ValidationOptions configures the validation done by CreateOrUpdate
+type ValidationOptions struct {
+	// Disabled - Skip validation
+	Disabled bool
+	// FailureSeverity - Findings of this severity or higher fail CreateOrUpdate. Defaults to SeverityError.
+	FailureSeverity *Severity
+	// IgnoredCodes - Findings with these codes are left out
+	IgnoredCodes []FindingCode
+}
+
+// SetValidationOptions configures the validation done by CreateOrUpdate
+func (c *NetworkSecurityGroupAgentClient) SetValidationOptions(options ValidationOptions) {
+	c.validation = options
+}
+
+// Validate returns the findings of the custom security rules of the security group, most severe first.
+// Default rules are managed by the cloud agent and are not checked.
+func Validate(nsg *network.SecurityGroup) []Finding {
+	findings := []Finding{}
+	if nsg == nil || nsg.SecurityGroupPropertiesFormat == nil || nsg.SecurityRules == nil {
+		return findings
+	}
+
+	priorities := map[string]string{}
+	valid := []*network.SecurityRule{}
+	for i := range *nsg.SecurityRules {
+		rule := &(*nsg.SecurityRules)[i]
+		if rule.SecurityRulePropertiesFormat == nil {
+			continue
+		}
+		ruleFindings := validateRule(rule)
+		key := getPriorityKey(rule)
+		if other, exists := priorities[key]; exists {
+			ruleFindings = append(ruleFindings, newFinding(SeverityError, FindingCodeDuplicatePriority, rule,
+				"has the same %s priority %d as rule [%s]", rule.Direction, getPriority(rule), other))
+		} else {
+			priorities[key] = getRuleName(rule)
+		}
+		if !hasErrors(ruleFindings) {
+			valid = append(valid, rule)
+		}
+		findings = append(findings, ruleFindings...)
+	}
+	findings = append(findings, findShadowedRules(valid)...)
+
+	sort.SliceStable(findings, func(i, j int) bool {
+		return findings[i].Severity > findings[j].Severity
+	})
+	return findings
+}
+
+// validate runs the validation configured by the options and returns an error with the failing findings
+func (c *NetworkSecurityGroupAgentClient) validate(nsg *network.SecurityGroup) error {
+	if c.validation.Disabled {
+		return nil
+	}
+	failureSeverity := SeverityError
+	if c.validation.FailureSeverity != nil {
+		failureSeverity = *c.validation.FailureSeverity
+	}
+
+	failures := []string{}
+	for _, finding := range Validate(nsg) {
+		if finding.Severity >= failureSeverity && !isIgnored(finding.Code, c.validation.IgnoredCodes) {
+			failures = append(failures, finding.String())
+		}
+	}
+	if len(failures) > 0 {
+		return errors.Wrapf(errors.InvalidConfiguration, "Network Security Group [%s] failed validation: %s",
+			getSecurityGroupName(nsg), strings.Join(failures, "; "))
+	}
+	return nil
+}
+
+func validateRule(rule *network.SecurityRule) []Finding {
+	findings := []Finding{}
+	if !isInbound(rule.Direction) && !strings.EqualFold(string(rule.Direction), string(network.SecurityRuleDirectionOutbound)) {
+		findings = append(findings, newFinding(SeverityError, FindingCodeInvalidDirection, rule, "has invalid direction [%s]", rule.Direction))
+	}
+	if rule.Priority == nil {
+		findings = append(findings, newFinding(SeverityWarning, FindingCodePriorityOutOfRange, rule,
+			"has no priority, priority %d is applied", defaultRulePriority))
+	} else if !isValidPriority(*rule.Priority) {
+		findings = append(findings, newFinding(SeverityWarning, FindingCodePriorityOutOfRange, rule,
+			"has invalid priority %d, priority %d is applied", *rule.Priority, defaultRulePriority))
+	}
+
+	fields := []struct {
+		name     string
+		plural   string
+		value    *string
+		values   *[]string
+		validate func(string) error
+	}{
+		{"SourceAddressPrefix", "SourceAddressPrefixes", rule.SourceAddressPrefix, rule.SourceAddressPrefixes, validateAddressPrefix},
+		{"DestinationAddressPrefix", "DestinationAddressPrefixes", rule.DestinationAddressPrefix, rule.DestinationAddressPrefixes, validateAddressPrefix},
+		{"SourcePortRange", "SourcePortRanges", rule.SourcePortRange, rule.SourcePortRanges, validatePortRange},
+		{"DestinationPortRange", "DestinationPortRanges", rule.DestinationPortRange, rule.DestinationPortRanges, validatePortRange},
+	}
+	for _, field := range fields {
+		if field.value != nil && len(*field.value) > 0 && field.values != nil && len(*field.values) > 0 {
+			findings = append(findings, newFinding(SeverityError, FindingCodeConflictingFields, rule,
+				"sets both %s and %s", field.name, field.plural))
+		}
+		for _, value := range collect(field.value, field.values) {
+			if err := field.validate(value); err != nil {
+				code := FindingCodeInvalidPortRange
+				if strings.HasSuffix(field.name, "Prefix") {
+					code = FindingCodeInvalidAddressPrefix
+				}
+				findings = append(findings, newFinding(SeverityError, code, rule, "has invalid %s [%s]", field.name, value))
+			}
+		}
+	}
+
+	if isInbound(rule.Direction) && rule.Access == network.SecurityRuleAccessAllow {
+		source := getEndpoint(rule.SourceAddressPrefix, rule.SourceAddressPrefixes, rule.SourceApplicationSecurityGroups)
+		if source.any || source.hasPrefix(ServiceTagInternet) {
+			ports := collect(rule.DestinationPortRange, rule.DestinationPortRanges)
+			if len(ports) == 0 || contains(ports, "*") {
+				findings = append(findings, newFinding(SeverityWarning, FindingCodeBroadAllow, rule, "allows traffic from any source to any port"))
+			} else {
+				findings = append(findings, newFinding(SeverityInfo, FindingCodeBroadAllow, rule,
+					"allows traffic from any source to ports [%s]", strings.Join(ports, ",")))
+			}
+		}
+	}
+	return findings
+}
+
+func validateAddressPrefix(prefix string) error {
+	prefix = strings.TrimSpace(prefix)
+	switch {
+	case prefix == "*", strings.EqualFold(prefix, "Any"):
+		return nil
+	case strings.Contains(prefix, "/"):
+		_, _, err := net.ParseCIDR(prefix)
+		return err
+	case strings.ContainsAny(prefix, ".:"):
+		if net.ParseIP(prefix) == nil {
+			return errors.Wrapf(errors.InvalidInput, "Invalid address [%s]", prefix)
+		}
+		return nil
+	case strings.EqualFold(prefix, ServiceTagVirtualNetwork), strings.EqualFold(prefix, ServiceTagInternet),
+		strings.EqualFold(prefix, ServiceTagAzureLoadBalancer):
+		return nil
+	}
+	return errors.Wrapf(errors.InvalidInput, "Unknown service tag [%s]", prefix)
+}
+
+func validatePortRange(portRange string) error {
+	_, _, err := parsePortRange(portRange)
+	return err
+}
+
+// findShadowedRules reports rules that can never match because a rule evaluated before them, in the same
+// direction, matches every flow they match. The rules must be valid.
+func findShadowedRules(rules []*network.SecurityRule) []Finding {
+	findings := []Finding{}
+	sorted := make([]*network.SecurityRule, len(rules))
+	copy(sorted, rules)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return getPriority(sorted[i]) < getPriority(sorted[j])
+	})
+
+	for i, rule := range sorted {
+		for _, earlier := range sorted[:i] {
+			if !strings.EqualFold(string(earlier.Direction), string(rule.Direction)) || !covers(earlier, rule) {
+				continue
+			}
+			message := "can never match because rule [%s] with priority %d matches all of its traffic"
+			if earlier.Access != rule.Access {
+				message = "can never match because rule [%s] with priority %d matches all of its traffic with the opposite access"
+			}
+			findings = append(findings, newFinding(SeverityWarning, FindingCodeShadowedRule, rule, message, getRuleName(earlier), getPriority(earlier)))
+			break
+		}
+	}
+	return findings
+}
+
+// covers reports whether rule a matches every flow that rule b matches
+func covers(a, b *network.SecurityRule) bool {
+	if !matchesProtocol(a.Protocol, b.Protocol) || (len(a.Protocol) > 0 && a.Protocol != network.SecurityRuleProtocolAsterisk &&
+		(len(b.Protocol) == 0 || b.Protocol == network.SecurityRuleProtocolAsterisk)) {
+		return false
+	}
+	if !isPortless(b.Protocol) {
+		if !coversPorts(collect(a.SourcePortRange, a.SourcePortRanges), collect(b.SourcePortRange, b.SourcePortRanges)) ||
+			!coversPorts(collect(a.DestinationPortRange, a.DestinationPortRanges), collect(b.DestinationPortRange, b.DestinationPortRanges)) {
+			return false
+		}
+	}
+	return getEndpoint(a.SourceAddressPrefix, a.SourceAddressPrefixes, a.SourceApplicationSecurityGroups).
+		covers(getEndpoint(b.SourceAddressPrefix, b.SourceAddressPrefixes, b.SourceApplicationSecurityGroups)) &&
+		getEndpoint(a.DestinationAddressPrefix, a.DestinationAddressPrefixes, a.DestinationApplicationSecurityGroups).
+			covers(getEndpoint(b.DestinationAddressPrefix, b.DestinationAddressPrefixes, b.DestinationApplicationSecurityGroups))
+}
+
+// coversPorts reports whether every range of b is within a range of a. No ranges means any port.
+func coversPorts(a, b []string) bool {
+	if len(b) == 0 {
+		b = []string{"*"}
+	}
+	if len(a) == 0 {
+		a = []string{"*"}
+	}
+	for _, rb := range b {
+		lowB, highB, _ := parsePortRange(rb)
+		covered := false
+		for _, ra := range a {
+			lowA, highA, _ := parsePortRange(ra)
+			if lowA <= lowB && highB <= highA {
+				covered = true
+				break
+			}
+		}
+		if !covered {
+			return false
+		}
+	}
+	return true
+}
+
+// endpoint is the source or destination of a rule
+type endpoint struct {
+	any      bool
+	prefixes []string
+	asgs     []string
+}
+
+func getEndpoint(prefix *string, prefixes *[]string, asgs *[]network.ApplicationSecurityGroup) endpoint {
+	e := endpoint{prefixes: collect(prefix, prefixes)}
+	if asgs != nil {
+		for _, asg := range *asgs {
+			if asg.Name != nil {
+				e.asgs = append(e.asgs, *asg.Name)
+			} else if asg.ID != nil {
+				e.asgs = append(e.asgs, *asg.ID)
+			}
+		}
+	}
+	e.any = (len(e.asgs) == 0 && len(e.prefixes) == 0) || e.hasPrefix("*") || e.hasPrefix("Any")
+	return e
+}
+
+func (e endpoint) hasPrefix(prefix string) bool {
+	for _, p := range e.prefixes {
+		if strings.EqualFold(strings.TrimSpace(p), prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// covers reports whether every address and application security group of o is also in e
+func (e endpoint) covers(o endpoint) bool {
+	if e.any {
+		return true
+	}
+	if o.any {
+		return false
+	}
+	for _, asg := range o.asgs {
+		if !containsFold(e.asgs, asg) {
+			return false
+		}
+	}
+	for _, p := range o.prefixes {
+		if !e.coversPrefix(p) {
+			return false
+		}
+	}
+	return true
+}
+
+func (e endpoint) coversPrefix(prefix string) bool {
+	inner := parseNetwork(prefix)
+	for _, p := range e.prefixes {
+		if strings.EqualFold(strings.TrimSpace(p), strings.TrimSpace(prefix)) {
+			return true
+		}
+		outer := parseNetwork(p)
+		if inner == nil || outer == nil {
+			continue
+		}
+		innerOnes, innerBits := inner.Mask.Size()
+		outerOnes, outerBits := outer.Mask.Size()
+		if innerBits == outerBits && outerOnes <= innerOnes && outer.Contains(inner.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseNetwork returns the network of a CIDR or single address prefix, or nil for service tags
+func parseNetwork(prefix string) *net.IPNet {
+	prefix = strings.TrimSpace(prefix)
+	if _, cidr, err := net.ParseCIDR(prefix); err == nil {
+		return cidr
+	}
+	ip := net.ParseIP(prefix)
+	if ip == nil {
+		return nil
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}
+}
+
+func getPriorityKey(rule *network.SecurityRule) string {
+	return fmt.Sprintf("%s/%d", strings.ToLower(string(rule.Direction)), getPriority(rule))
+}
+
+func newFinding(severity Severity, code FindingCode, rule *network.SecurityRule, format string, args ...interface{}) Finding {
+	return Finding{Severity: severity, Code: code, Rule: getRuleName(rule), Message: fmt.Sprintf(format, args...)}
+}
+
+func isInbound(direction network.SecurityRuleDirection) bool {
+	return strings.EqualFold(string(direction), string(network.SecurityRuleDirectionInbound))
+}
+
+func hasErrors(findings []Finding) bool {
+	for _, finding := range findings {
+		if finding.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+func isIgnored(code FindingCode, ignored []FindingCode) bool {
+	for _, c := range ignored {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if strings.TrimSpace(v) == value {
+			return true
+		}
+	}
+	return false
+}
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,138 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the Apache v2.0 License.
+
+package networksecuritygroup
+
+import (
+	"context"
+	"testing"
+
+	"github.com/microsoft/moc-sdk-for-go/services/network"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeService struct {
+	Service
+	created *network.SecurityGroup
+}
+
+func (f *fakeService) CreateOrUpdate(ctx context.Context, location, name string, nsg *network.SecurityGroup) (*network.SecurityGroup, error) {
+	f.created = nsg
+	return nsg, nil
+}
+
+func findingCodes(findings []Finding) map[string][]FindingCode {
+	codes := map[string][]FindingCode{}
+	for _, finding := range findings {
+		codes[finding.Rule] = append(codes[finding.Rule], finding.Code)
+	}
+	return codes
+}
+
+func Test_Validate(t *testing.T) {
+	prefixes := []string{"10.0.0.0/24"}
+	conflicting := newRule("Conflicting", 130, network.SecurityRuleDirectionInbound, network.SecurityRuleAccessDeny, network.SecurityRuleProtocolTCP, "10.0.0.0/16", "*", "80")
+	conflicting.SourceAddressPrefixes = &prefixes
+
+	nsg := newSecurityGroup("lint",
+		newRule("AllowHTTPS", 100, network.SecurityRuleDirectionInbound, network.SecurityRuleAccessAllow, network.SecurityRuleProtocolTCP, "10.0.0.0/16", "*", "443"),
+		newRule("SamePriority", 100, network.SecurityRuleDirectionInbound, network.SecurityRuleAccessDeny, network.SecurityRuleProtocolUDP, "10.0.0.0/16", "*", "53"),
+		newRule("OutboundSamePriority", 100, network.SecurityRuleDirectionOutbound, network.SecurityRuleAccessAllow, network.SecurityRuleProtocolTCP, "*", "10.1.0.0/16", "443"),
+		newRule("TooLow", 50, network.SecurityRuleDirectionInbound, network.SecurityRuleAccessDeny, network.SecurityRuleProtocolTCP, "10.0.0.0/16", "*", "21"),
+		newRule("BadCIDR", 110, network.SecurityRuleDirectionInbound, network.SecurityRuleAccessDeny, network.SecurityRuleProtocolTCP, "10.0.0.0/33", "*", "22"),
+		newRule("BadPorts", 120, network.SecurityRuleDirectionInbound, network.SecurityRuleAccessDeny, network.SecurityRuleProtocolTCP, "10.0.0.0/16", "*", "90-80"),
+		conflicting,
+		newRule("Shadowed", 140, network.SecurityRuleDirectionInbound, network.SecurityRuleAccessDeny, network.SecurityRuleProtocolTCP, "10.0.1.0/24", "10.2.0.4", "443"),
+		newRule("AllowAll", 150, network.SecurityRuleDirectionInbound, network.SecurityRuleAccessAllow, network.SecurityRuleProtocolAsterisk, "*", "*", "*"),
+		newRule("AllowWeb", 160, network.SecurityRuleDirectionInbound, network.SecurityRuleAccessAllow, network.SecurityRuleProtocolTCP, ServiceTagInternet, "*", "8080"),
+	)
+
+	findings := Validate(nsg)
+	codes := findingCodes(findings)
+	assert.Contains(t, codes["SamePriority"], FindingCodeDuplicatePriority)
+	assert.NotContains(t, codes, "OutboundSamePriority")
+	assert.Contains(t, codes["TooLow"], FindingCodePriorityOutOfRange)
+	assert.Contains(t, codes["BadCIDR"], FindingCodeInvalidAddressPrefix)
+	assert.Contains(t, codes["BadPorts"], FindingCodeInvalidPortRange)
+	assert.Contains(t, codes["Conflicting"], FindingCodeConflictingFields)
+	assert.Contains(t, codes["Shadowed"], FindingCodeShadowedRule)
+	assert.Contains(t, codes["AllowWeb"], FindingCodeShadowedRule)
+	assert.Contains(t, codes["AllowWeb"], FindingCodeBroadAllow)
+	assert.Contains(t, codes["AllowAll"], FindingCodeBroadAllow)
+	assert.NotContains(t, codes, "AllowHTTPS")
+	assert.NotContains(t, codes["Shadowed"], FindingCodeBroadAllow)
+
+	require.NotEmpty(t, findings)
+	assert.Equal(t, SeverityError, findings[0].Severity)
+	for i := 1; i < len(findings); i++ {
+		assert.GreaterOrEqual(t, int(findings[i-1].Severity), int(findings[i].Severity))
+	}
+}
+
+func Test_Validate_BroadAllow(t *testing.T) {
+	findings := Validate(newSecurityGroup("web",
+		newRule("AllowWeb", 100, network.SecurityRuleDirectionInbound, network.SecurityRuleAccessAllow, network.SecurityRuleProtocolTCP, "*", "*", "443")))
+	require.Len(t, findings, 1)
+	assert.Equal(t, SeverityInfo, findings[0].Severity)
+	assert.Equal(t, FindingCodeBroadAllow, findings[0].Code)
+
+	findings = Validate(newSecurityGroup("web",
+		newRule("AllowOut", 100, network.SecurityRuleDirectionOutbound, network.SecurityRuleAccessAllow, network.SecurityRuleProtocolTCP, "*", "*", "*")))
+	assert.Empty(t, findings)
+}
+
+func Test_Validate_Priority(t *testing.T) {
+	missing := newRule("Missing", 100, network.SecurityRuleDirectionInbound, network.SecurityRuleAccessDeny, network.SecurityRuleProtocolTCP, "10.0.0.0/16", "*", "22")
+	missing.Priority = nil
+	findings := Validate(newSecurityGroup("priority",
+		newRule("TooHigh", 65501, network.SecurityRuleDirectionOutbound, network.SecurityRuleAccessDeny, network.SecurityRuleProtocolTCP, "*", "10.0.0.0/16", "22"),
+		missing))
+	require.Len(t, findings, 2)
+	for _, finding := range findings {
+		assert.Equal(t, SeverityWarning, finding.Severity)
+		assert.Equal(t, FindingCodePriorityOutOfRange, finding.Code)
+		assert.Contains(t, finding.Message, "priority 4096 is applied")
+	}
+
+	// Both rules fall back to priority 4096
+	findings = Validate(newSecurityGroup("priority",
+		newRule("Fallback", 4096, network.SecurityRuleDirectionInbound, network.SecurityRuleAccessDeny, network.SecurityRuleProtocolUDP, "10.0.0.0/16", "*", "53"),
+		missing))
+	assert.Contains(t, findingCodes(findings)["Missing"], FindingCodeDuplicatePriority)
+
+	fake := &fakeService{}
+	c := &NetworkSecurityGroupAgentClient{internal: fake}
+	_, err := c.CreateOrUpdate(context.Background(), "location", "priority", newSecurityGroup("priority", missing))
+	require.NoError(t, err)
+	assert.NotNil(t, fake.created)
+}
+
+func Test_CreateOrUpdate_Validation(t *testing.T) {
+	fake := &fakeService{}
+	c := &NetworkSecurityGroupAgentClient{internal: fake}
+	nsg := newSecurityGroup("web",
+		newRule("AllowWeb", 100, network.SecurityRuleDirectionInbound, network.SecurityRuleAccessAllow, network.SecurityRuleProtocolTCP, "*", "*", "*"),
+		newRule("DenyWeb", 100, network.SecurityRuleDirectionInbound, network.SecurityRuleAccessDeny, network.SecurityRuleProtocolTCP, "*", "*", "80"))
+
+	_, err := c.CreateOrUpdate(context.Background(), "location", "web", nsg)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "DuplicatePriority")
+	assert.Nil(t, fake.created)
+
+	c.SetValidationOptions(ValidationOptions{IgnoredCodes: []FindingCode{FindingCodeDuplicatePriority}})
+	_, err = c.CreateOrUpdate(context.Background(), "location", "web", nsg)
+	require.NoError(t, err)
+
+	warning := SeverityWarning
+	c.SetValidationOptions(ValidationOptions{FailureSeverity: &warning, IgnoredCodes: []FindingCode{FindingCodeDuplicatePriority}})
+	_, err = c.CreateOrUpdate(context.Background(), "location", "web", nsg)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "BroadAllow")
+
+	fake.created = nil
+	c.SetValidationOptions(ValidationOptions{Disabled: true})
+	_, err = c.CreateOrUpdate(context.Background(), "location", "web", nsg)
+	require.NoError(t, err)
+	assert.Equal(t, nsg, fake.created)
+}
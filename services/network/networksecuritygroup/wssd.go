@@ -66,7 +66,7 @@ func (c *client) CreateOrUpdate(ctx context.Context, location, name string, inpu
 			if alreadyExists {
 				return nil, errors.Wrapf(errors.InvalidConfiguration, "Network Security Group Rules cannot have duplicate names")
 			}
-			nameMap[name] = true
+			nameMap[*item.Name] = true
 		}
 	}
 
@@ -77,7 +77,7 @@ func (c *client) CreateOrUpdate(ctx context.Context, location, name string, inpu
 			if alreadyExists {
 				return nil, errors.Wrapf(errors.InvalidConfiguration, "Network Security Group Default Rules cannot have duplicate names")
 			}
-			nameMap[name] = true
+			nameMap[*item.Name] = true
 		}
 	}
 
@@ -327,17 +327,17 @@ func getWssdNetworkSecurityGroupRules(securityRules *[]network.SecurityRule, isD
 			return nil, errors.Wrapf(errors.InvalidInput, "Unknown Direction %s specified", rule.Access)
 		}
 
-		if rule.Priority != nil && isValidPriority(*rule.Priority) {
-			wssdCloudNSGRule.Priority = uint32(*rule.Priority)
-		} else {
-			wssdCloudNSGRule.Priority = 4096 // Max for Azure, which expects 100 to 4096
-		}
+		wssdCloudNSGRule.Priority = getPriority(&rule)
 
 		wssdNSGRules = append(wssdNSGRules, wssdCloudNSGRule)
 	}
 	return
 }
 
+// defaultRulePriority is applied to rules without a valid priority. It is the maximum for Azure, which
+// expects 100 to 4096.
+const defaultRulePriority = 4096
+
 func isValidPriority(priority uint32) bool {
 	return priority >= 100 && priority <= 65500
 }
@@ -0,0 +1,233 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the Apache v2.0 License.
+
+package publicipaddress
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/microsoft/moc-sdk-for-go/services/network"
+	"github.com/microsoft/moc/pkg/errors"
+)
+
+// ErrInUse is returned by Delete and Associate when a network interface or load balancer references the
+// public IP address
+var ErrInUse = errors.New("InUse")
+
+// AssociationKind is the type of resource referencing a public IP address
+type AssociationKind string
+
+const (
+	// AssociationNetworkInterface - IP configuration of a network interface
+	AssociationNetworkInterface AssociationKind = "NetworkInterface"
+	// AssociationLoadBalancer - Frontend IP configuration of a load balancer
+	AssociationLoadBalancer AssociationKind = "LoadBalancer"
+)
+
+// Association is a network interface IP configuration or load balancer frontend IP configuration that
+// references a public IP address of the same group
+type Association struct {
+	// Kind - Type of the referencing resource
+	Kind AssociationKind
+	// PublicIPAddress - Name of the public IP address
+	PublicIPAddress string
+	// Resource - Name of the network interface or load balancer
+	Resource string
+	// Configuration - Name of the IP configuration or frontend IP configuration
+	Configuration string
+}
+
+// String describes the referencing configuration
+func (a Association) String() string {
+	switch a.Kind {
+	case AssociationLoadBalancer:
+		return fmt.Sprintf("load balancer [%s] frontend IP configuration [%s]", a.Resource, a.Configuration)
+	default:
+		return fmt.Sprintf("network interface [%s] IP configuration [%s]", a.Resource, a.Configuration)
+	}
+}
+
+// ListAssociations returns the network interface IP configurations and load balancer frontend IP
+// configurations of the group that reference the public IP address, or any public IP address when name is
+// empty. Load balancers are read with the API version negotiated by the load balancer client, and their
+// frontend public IP addresses are listed whatever the version, so that Delete sees the frontends written
+// by clients that negotiated Version_2_0.
+func (c *PublicIPAddressAgentClient) ListAssociations(ctx context.Context, group, name string) ([]Association, error) {
+	associations := []Association{}
+
+	interfaces, err := c.getInterfaces()
+	if err != nil {
+		return nil, err
+	}
+	nics, err := interfaces.Get(ctx, group, "")
+	if err != nil && !errors.IsNotFound(err) {
+		return nil, err
+	}
+	if nics != nil {
+		for _, nic := range *nics {
+			if nic.Name == nil || nic.InterfacePropertiesFormat == nil || nic.IPConfigurations == nil {
+				continue
+			}
+			for _, ipConfig := range *nic.IPConfigurations {
+				if ipConfig.Name == nil || ipConfig.InterfaceIPConfigurationPropertiesFormat == nil {
+					continue
+				}
+				if pip := getReference(ipConfig.PublicIPAddress); len(pip) > 0 && (len(name) == 0 || pip == name) {
+					associations = append(associations, Association{
+						Kind:            AssociationNetworkInterface,
+						PublicIPAddress: pip,
+						Resource:        *nic.Name,
+						Configuration:   *ipConfig.Name,
+					})
+				}
+			}
+		}
+	}
+
+	loadBalancers, err := c.getLoadBalancers()
+	if err != nil {
+		return nil, err
+	}
+	apiVersion, err := loadBalancers.NegotiateAPIVersion(ctx)
+	if err != nil {
+		return nil, err
+	}
+	lbs, err := loadBalancers.GetWithVersion(ctx, group, "", apiVersion)
+	if err != nil && !errors.IsNotFound(err) {
+		return nil, err
+	}
+	if lbs != nil {
+		for _, lb := range *lbs {
+			if lb.Name == nil || lb.LoadBalancerPropertiesFormat == nil || lb.FrontendIPConfigurations == nil {
+				continue
+			}
+			for _, frontend := range *lb.FrontendIPConfigurations {
+				if frontend.Name == nil || frontend.FrontendIPConfigurationPropertiesFormat == nil {
+					continue
+				}
+				if pip := getReference(frontend.PublicIPAddress); len(pip) > 0 && (len(name) == 0 || pip == name) {
+					associations = append(associations, Association{
+						Kind:            AssociationLoadBalancer,
+						PublicIPAddress: pip,
+						Resource:        *lb.Name,
+						Configuration:   *frontend.Name,
+					})
+				}
+			}
+		}
+	}
+
+	sort.Slice(associations, func(i, j int) bool {
+		a, b := associations[i], associations[j]
+		if a.PublicIPAddress != b.PublicIPAddress {
+			return a.PublicIPAddress < b.PublicIPAddress
+		}
+		if a.Kind != b.Kind {
+			return a.Kind < b.Kind
+		}
+		if a.Resource != b.Resource {
+			return a.Resource < b.Resource
+		}
+		return a.Configuration < b.Configuration
+	})
+	return associations, nil
+}
+
+// Associate references the public IP address from the IP configuration of the network interface or the
+// frontend IP configuration of the load balancer, replacing the public IP address the configuration held.
+// A public IP address serves a single configuration, so it fails when another configuration already
+// references the address.
+func (c *PublicIPAddressAgentClient) Associate(ctx context.Context, group, name string, kind AssociationKind, resource, configuration string) (*Association, error) {
+	if len(name) == 0 {
+		return nil, errors.Wrapf(errors.InvalidInput, "Missing public IP address name")
+	}
+	if kind != AssociationNetworkInterface && kind != AssociationLoadBalancer {
+		return nil, errors.Wrapf(errors.InvalidInput, "Unknown association kind [%s]", kind)
+	}
+	if len(resource) == 0 || len(configuration) == 0 {
+		return nil, errors.Wrapf(errors.InvalidInput, "Missing %s name or configuration name", kind)
+	}
+	target := Association{Kind: kind, PublicIPAddress: name, Resource: resource, Configuration: configuration}
+
+	pips, err := c.Get(ctx, group, name)
+	if err != nil {
+		return nil, err
+	}
+	if pips == nil || len(*pips) == 0 {
+		return nil, errors.Wrapf(errors.NotFound, "Public IP address [%s] not found", name)
+	}
+
+	associations, err := c.ListAssociations(ctx, group, name)
+	if err != nil {
+		return nil, err
+	}
+	for _, association := range associations {
+		if association != target {
+			return nil, errors.Wrapf(ErrInUse, "Public IP address [%s] is already in use by %s", name, association.String())
+		}
+	}
+	if len(associations) > 0 {
+		return &target, nil
+	}
+
+	switch kind {
+	case AssociationLoadBalancer:
+		loadBalancers, err := c.getLoadBalancers()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := loadBalancers.SetFrontendPublicIPAddress(ctx, group, resource, configuration, name); err != nil {
+			return nil, err
+		}
+	default:
+		interfaces, err := c.getInterfaces()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := interfaces.SetPublicIPAddress(ctx, group, resource, configuration, name); err != nil {
+			return nil, err
+		}
+	}
+	return &target, nil
+}
+
+// Disassociate removes the public IP address from the network interface IP configurations referencing it
+// and returns the removed associations. Load balancer frontend IP configurations cannot be left without a
+// public IP address: associate another address with the frontend, or remove the frontend, instead.
+func (c *PublicIPAddressAgentClient) Disassociate(ctx context.Context, group, name string) ([]Association, error) {
+	if len(name) == 0 {
+		return nil, errors.Wrapf(errors.InvalidInput, "Missing public IP address name")
+	}
+	associations, err := c.ListAssociations(ctx, group, name)
+	if err != nil {
+		return nil, err
+	}
+	for _, association := range associations {
+		if association.Kind == AssociationLoadBalancer {
+			return nil, errors.Wrapf(errors.InvalidInput, "Public IP address [%s] cannot be removed from %s, associate another address with the frontend or remove the frontend", name, association.String())
+		}
+	}
+
+	interfaces, err := c.getInterfaces()
+	if err != nil {
+		return nil, err
+	}
+	removed := []Association{}
+	for _, association := range associations {
+		if _, err := interfaces.SetPublicIPAddress(ctx, group, association.Resource, association.Configuration, ""); err != nil {
+			return removed, err
+		}
+		removed = append(removed, association)
+	}
+	return removed, nil
+}
+
+// getReference returns the name of the referenced public IP address
+func getReference(pip *network.PublicIPAddress) string {
+	if pip == nil || pip.ID == nil {
+		return ""
+	}
+	return *pip.ID
+}
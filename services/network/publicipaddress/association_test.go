@@ -0,0 +1,208 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the Apache v2.0 License.
+
+package publicipaddress
+
+import (
+	"context"
+	"testing"
+
+	"github.com/microsoft/moc-sdk-for-go/services/network"
+	"github.com/microsoft/moc-sdk-for-go/services/network/loadbalancer"
+	"github.com/microsoft/moc/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeService stores public IP addresses by name
+type fakeService struct {
+	Service
+	pips    map[string]network.PublicIPAddress
+	deleted []string
+}
+
+func (s *fakeService) Get(ctx context.Context, group, name string) (*[]network.PublicIPAddress, error) {
+	pips := []network.PublicIPAddress{}
+	for pipName, pip := range s.pips {
+		if len(name) == 0 || pipName == name {
+			pips = append(pips, pip)
+		}
+	}
+	return &pips, nil
+}
+
+func (s *fakeService) CreateOrUpdate(ctx context.Context, group, name string, pip *network.PublicIPAddress) (*network.PublicIPAddress, error) {
+	s.pips[name] = *pip
+	return pip, nil
+}
+
+func (s *fakeService) Delete(ctx context.Context, group, name string) error {
+	delete(s.pips, name)
+	s.deleted = append(s.deleted, name)
+	return nil
+}
+
+// fakeInterfaces stores network interfaces with a single IP configuration named ipconfig1
+type fakeInterfaces struct {
+	pips map[string]string
+}
+
+func (f *fakeInterfaces) Get(ctx context.Context, group, name string) (*[]network.Interface, error) {
+	nics := []network.Interface{}
+	for nicName, pip := range f.pips {
+		ipConfig := network.InterfaceIPConfiguration{
+			Name:                                     strPtr("ipconfig1"),
+			InterfaceIPConfigurationPropertiesFormat: &network.InterfaceIPConfigurationPropertiesFormat{},
+		}
+		if len(pip) > 0 {
+			ipConfig.PublicIPAddress = &network.PublicIPAddress{ID: strPtr(pip)}
+		}
+		nics = append(nics, network.Interface{
+			Name:                      strPtr(nicName),
+			InterfacePropertiesFormat: &network.InterfacePropertiesFormat{IPConfigurations: &[]network.InterfaceIPConfiguration{ipConfig}},
+		})
+	}
+	return &nics, nil
+}
+
+func (f *fakeInterfaces) SetPublicIPAddress(ctx context.Context, group, name, ipConfigName, publicIPAddressName string) (*network.Interface, error) {
+	if _, ok := f.pips[name]; !ok || ipConfigName != "ipconfig1" {
+		return nil, errors.Wrapf(errors.NotFound, "IPConfiguration [%s] not found in network interface [%s]", ipConfigName, name)
+	}
+	f.pips[name] = publicIPAddressName
+	return nil, nil
+}
+
+// fakeLoadBalancers stores load balancers with a single frontend IP configuration named fe1
+type fakeLoadBalancers struct {
+	pips       map[string]string
+	apiVersion string
+	gets       int
+}
+
+func (f *fakeLoadBalancers) NegotiateAPIVersion(ctx context.Context) (string, error) {
+	return f.apiVersion, nil
+}
+
+func (f *fakeLoadBalancers) GetWithVersion(ctx context.Context, group, name, apiVersion string) (*[]network.LoadBalancer, error) {
+	f.gets++
+	lbs := []network.LoadBalancer{}
+	for lbName, pip := range f.pips {
+		lbs = append(lbs, network.LoadBalancer{
+			Name: strPtr(lbName),
+			LoadBalancerPropertiesFormat: &network.LoadBalancerPropertiesFormat{
+				FrontendIPConfigurations: &[]network.FrontendIPConfiguration{{
+					Name: strPtr("fe1"),
+					FrontendIPConfigurationPropertiesFormat: &network.FrontendIPConfigurationPropertiesFormat{
+						PublicIPAddress: &network.PublicIPAddress{ID: strPtr(pip)},
+					},
+				}},
+			},
+		})
+	}
+	return &lbs, nil
+}
+
+func (f *fakeLoadBalancers) SetFrontendPublicIPAddress(ctx context.Context, group, name, frontendName, publicIPAddressName string) (*network.LoadBalancer, error) {
+	if _, ok := f.pips[name]; !ok || frontendName != "fe1" {
+		return nil, errors.Wrapf(errors.NotFound, "FrontendIPConfig [%s] not found in load balancer [%s]", frontendName, name)
+	}
+	f.pips[name] = publicIPAddressName
+	return nil, nil
+}
+
+func strPtr(s string) *string { return &s }
+
+func newAssociationTestClient() (*PublicIPAddressAgentClient, *fakeService, *fakeInterfaces, *fakeLoadBalancers) {
+	service := &fakeService{pips: map[string]network.PublicIPAddress{}}
+	for _, name := range []string{"pip1", "pip2", "pip3"} {
+		service.pips[name] = network.PublicIPAddress{Name: strPtr(name)}
+	}
+	interfaces := &fakeInterfaces{pips: map[string]string{"nic1": "pip1", "nic2": ""}}
+	loadBalancers := &fakeLoadBalancers{pips: map[string]string{"lb1": "pip2"}, apiVersion: loadbalancer.Version_2_0}
+	c := &PublicIPAddressAgentClient{internal: service, interfaces: interfaces, loadBalancers: loadBalancers}
+	return c, service, interfaces, loadBalancers
+}
+
+func Test_ListAssociations(t *testing.T) {
+	c, _, _, _ := newAssociationTestClient()
+
+	associations, err := c.ListAssociations(context.Background(), "group", "")
+	require.NoError(t, err)
+	assert.Equal(t, []Association{
+		{Kind: AssociationNetworkInterface, PublicIPAddress: "pip1", Resource: "nic1", Configuration: "ipconfig1"},
+		{Kind: AssociationLoadBalancer, PublicIPAddress: "pip2", Resource: "lb1", Configuration: "fe1"},
+	}, associations)
+
+	associations, err = c.ListAssociations(context.Background(), "group", "pip3")
+	require.NoError(t, err)
+	assert.Empty(t, associations)
+}
+
+func Test_ListAssociations_Version1(t *testing.T) {
+	c, service, _, loadBalancers := newAssociationTestClient()
+	loadBalancers.apiVersion = loadbalancer.Version_1_0
+
+	// Frontends written by a Version 2.0 client are listed, and protect their public IP address
+	associations, err := c.ListAssociations(context.Background(), "group", "")
+	require.NoError(t, err)
+	assert.Equal(t, []Association{
+		{Kind: AssociationNetworkInterface, PublicIPAddress: "pip1", Resource: "nic1", Configuration: "ipconfig1"},
+		{Kind: AssociationLoadBalancer, PublicIPAddress: "pip2", Resource: "lb1", Configuration: "fe1"},
+	}, associations)
+	assert.Equal(t, 1, loadBalancers.gets)
+
+	err = c.Delete(context.Background(), "group", "pip2")
+	assert.ErrorIs(t, err, ErrInUse)
+	assert.Empty(t, service.deleted)
+}
+
+func Test_AssociateAndDisassociate(t *testing.T) {
+	c, _, interfaces, loadBalancers := newAssociationTestClient()
+
+	association, err := c.Associate(context.Background(), "group", "pip3", AssociationNetworkInterface, "nic2", "ipconfig1")
+	require.NoError(t, err)
+	assert.Equal(t, "network interface [nic2] IP configuration [ipconfig1]", association.String())
+	assert.Equal(t, "pip3", interfaces.pips["nic2"])
+
+	// Associating again is a no-op, another configuration is refused
+	_, err = c.Associate(context.Background(), "group", "pip3", AssociationNetworkInterface, "nic2", "ipconfig1")
+	require.NoError(t, err)
+	_, err = c.Associate(context.Background(), "group", "pip1", AssociationLoadBalancer, "lb1", "fe1")
+	assert.ErrorIs(t, err, ErrInUse)
+	_, err = c.Associate(context.Background(), "group", "missing", AssociationNetworkInterface, "nic2", "ipconfig1")
+	assert.ErrorIs(t, err, errors.NotFound)
+	_, err = c.Associate(context.Background(), "group", "pip3", "Gateway", "gw1", "ipconfig1")
+	assert.ErrorIs(t, err, errors.InvalidInput)
+
+	removed, err := c.Disassociate(context.Background(), "group", "pip1")
+	require.NoError(t, err)
+	require.Len(t, removed, 1)
+	assert.Equal(t, "nic1", removed[0].Resource)
+	assert.Empty(t, interfaces.pips["nic1"])
+
+	// Load balancer frontends keep a public IP address, so pip2 is swapped for pip1
+	_, err = c.Disassociate(context.Background(), "group", "pip2")
+	assert.ErrorIs(t, err, errors.InvalidInput)
+	_, err = c.Associate(context.Background(), "group", "pip1", AssociationLoadBalancer, "lb1", "fe1")
+	require.NoError(t, err)
+	assert.Equal(t, "pip1", loadBalancers.pips["lb1"])
+}
+
+func Test_DeleteInUse(t *testing.T) {
+	c, service, _, _ := newAssociationTestClient()
+
+	err := c.Delete(context.Background(), "group", "pip1")
+	assert.ErrorIs(t, err, ErrInUse)
+	assert.False(t, errors.IsNotFound(err))
+	assert.Empty(t, service.deleted)
+
+	_, err = c.Disassociate(context.Background(), "group", "pip1")
+	require.NoError(t, err)
+	require.NoError(t, c.Delete(context.Background(), "group", "pip1"))
+	assert.Equal(t, []string{"pip1"}, service.deleted)
+
+	// ForceDelete skips the check
+	require.NoError(t, c.ForceDelete(context.Background(), "group", "pip2"))
+	assert.Equal(t, []string{"pip1", "pip2"}, service.deleted)
+}
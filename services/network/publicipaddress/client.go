@@ -5,9 +5,13 @@ package publicipaddress
 
 import (
 	"context"
+	"sync"
 
 	"github.com/microsoft/moc-sdk-for-go/services/network"
+	"github.com/microsoft/moc-sdk-for-go/services/network/loadbalancer"
+	"github.com/microsoft/moc-sdk-for-go/services/network/networkinterface"
 	"github.com/microsoft/moc/pkg/auth"
+	"github.com/microsoft/moc/pkg/errors"
 )
 
 // Service defines the interface for managing Public IP Addresses in the network service.
@@ -23,7 +27,26 @@ type Service interface {
 // It embeds the network.BaseClient and includes an internal Service for additional functionality.
 type PublicIPAddressAgentClient struct {
 	network.BaseClient
-	internal Service
+	internal   Service
+	cloudFQDN  string
+	authorizer auth.Authorizer
+	// interfaces and loadBalancers are created on first use
+	interfaces     interfaceService
+	loadBalancers  loadBalancerService
+	dependencyLock sync.Mutex
+}
+
+// interfaceService is the part of the network interface client used to find and update associations
+type interfaceService interface {
+	Get(context.Context, string, string) (*[]network.Interface, error)
+	SetPublicIPAddress(context.Context, string, string, string, string) (*network.Interface, error)
+}
+
+// loadBalancerService is the part of the load balancer client used to find and update associations
+type loadBalancerService interface {
+	NegotiateAPIVersion(context.Context) (string, error)
+	GetWithVersion(context.Context, string, string, string) (*[]network.LoadBalancer, error)
+	SetFrontendPublicIPAddress(context.Context, string, string, string, string) (*network.LoadBalancer, error)
 }
 
 // NewPublicIPAddressClient creates a new instance of PublicIPAddressAgentClient.
@@ -35,7 +58,35 @@ func NewPublicIPAddressClient(cloudFQDN string, authorizer auth.Authorizer) (*Pu
 		return nil, err
 	}
 
-	return &PublicIPAddressAgentClient{internal: c}, nil
+	return &PublicIPAddressAgentClient{internal: c, cloudFQDN: cloudFQDN, authorizer: authorizer}, nil
+}
+
+// getInterfaces returns the network interface client, which is created on first use
+func (c *PublicIPAddressAgentClient) getInterfaces() (interfaceService, error) {
+	c.dependencyLock.Lock()
+	defer c.dependencyLock.Unlock()
+	if c.interfaces == nil {
+		interfaces, err := networkinterface.NewInterfaceClient(c.cloudFQDN, c.authorizer)
+		if err != nil {
+			return nil, err
+		}
+		c.interfaces = interfaces
+	}
+	return c.interfaces, nil
+}
+
+// getLoadBalancers returns the load balancer client, which is created on first use
+func (c *PublicIPAddressAgentClient) getLoadBalancers() (loadBalancerService, error) {
+	c.dependencyLock.Lock()
+	defer c.dependencyLock.Unlock()
+	if c.loadBalancers == nil {
+		loadBalancers, err := loadbalancer.NewLoadBalancerClient(c.cloudFQDN, c.authorizer)
+		if err != nil {
+			return nil, err
+		}
+		c.loadBalancers = loadBalancers
+	}
+	return c.loadBalancers, nil
 }
 
 // Get retrieves a list of PublicIPAddresses from the specified resource group and name.
@@ -49,7 +100,22 @@ func (c *PublicIPAddressAgentClient) CreateOrUpdate(ctx context.Context, group,
 }
 
 // Delete removes a public IP address resource identified by the specified group and name.
+// It fails with ErrInUse while a configuration returned by ListAssociations references the address, and
+// with the error of the lookup when the associations cannot be listed. Use ForceDelete to skip the check.
 func (c *PublicIPAddressAgentClient) Delete(ctx context.Context, group, name string) error {
+	associations, err := c.ListAssociations(ctx, group, name)
+	if err != nil {
+		return err
+	}
+	if len(associations) > 0 {
+		return errors.Wrapf(ErrInUse, "Public IP address [%s] is in use by %s, disassociate it first", name, associations[0].String())
+	}
+	return c.internal.Delete(ctx, group, name)
+}
+
+// ForceDelete removes a public IP address resource identified by the specified group and name without
+// checking whether it is in use.
+func (c *PublicIPAddressAgentClient) ForceDelete(ctx context.Context, group, name string) error {
 	return c.internal.Delete(ctx, group, name)
 }
 
@@ -68,8 +68,13 @@ func getWssdPublicIPAddress(networkPip *network.PublicIPAddress, group string) (
 		wssdCloudPip.IpVersion = ipVersionSdkToProtobuf(networkPip.PublicIPAddressVersion)
 	}
 
-	if networkPip.Tags != nil {
-		wssdCloudPip.Tags = tags.MapToProto(networkPip.Tags)
+	if err := validateDNSSettings(networkPip.DNSSettings); err != nil {
+		return nil, err
+	}
+
+	// DNS settings are kept in tags
+	if pipTags := getDNSTags(networkPip); pipTags != nil {
+		wssdCloudPip.Tags = tags.MapToProto(pipTags)
 	}
 
 	return wssdCloudPip, nil
@@ -94,6 +99,7 @@ func getPublicIPAddress(wssdPip *wssdcloudnetwork.PublicIPAddress) (networkPip *
 
 	if wssdPip.Tags != nil {
 		networkPip.Tags = tags.ProtoToMap(wssdPip.Tags)
+		networkPip.DNSSettings = getDNSSettings(networkPip.Tags)
 	}
 
 	return networkPip, nil
@@ -0,0 +1,203 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the Apache v2.0 License.
+
+package publicipaddress
+
+import (
+	"context"
+	"net/netip"
+	"regexp"
+	"strings"
+
+	"github.com/microsoft/moc-sdk-for-go/services/network"
+	"github.com/microsoft/moc/pkg/errors"
+)
+
+// The cloud agent does not keep DNS settings, so they are stored in tags of the public IP address
+const (
+	// DNSLabelTag - Tag holding the domain name label of a public IP address
+	DNSLabelTag = "moc-pip-dns-label"
+	// ReverseFqdnTag - Tag holding the reverse FQDN of a public IP address
+	ReverseFqdnTag = "moc-pip-reverse-fqdn"
+
+	// MinIdleTimeoutInMinutes - Shortest TCP idle timeout of a public IP address
+	MinIdleTimeoutInMinutes int32 = 4
+	// MaxIdleTimeoutInMinutes - Longest TCP idle timeout of a public IP address
+	MaxIdleTimeoutInMinutes int32 = 30
+)
+
+var (
+	domainNameLabelRegexp = regexp.MustCompile(`^[a-z][a-z0-9-]{1,61}[a-z0-9]$`)
+	fqdnLabelRegexp       = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?$`)
+)
+
+// Reservation describes a public IP address holding a specific static address
+type Reservation struct {
+	// IPAddress - The IPv4 or IPv6 address to reserve
+	IPAddress string
+	// Location - Resource location
+	Location string
+	// IdleTimeoutInMinutes - The timeout for the TCP idle connection, between 4 and 30 minutes. Defaults to
+	// network.DefaultIdleTimeoutInMinutes.
+	IdleTimeoutInMinutes *int32
+	// DNSSettings - The domain name label and reverse FQDN of the address. Fqdn is read-only and ignored.
+	DNSSettings *network.PublicIPAddressDNSSettings
+	// Tags - Resource tags
+	Tags map[string]*string
+}
+
+// Reserve creates the public IP address with the static address of the reservation. The name, address and
+// domain name label must not be used by another public IP address of the group.
+func (c *PublicIPAddressAgentClient) Reserve(ctx context.Context, group, name string, reservation Reservation) (*network.PublicIPAddress, error) {
+	if len(name) == 0 {
+		return nil, errors.Wrapf(errors.InvalidInput, "Missing public IP address name")
+	}
+	address, err := netip.ParseAddr(strings.TrimSpace(reservation.IPAddress))
+	if err != nil {
+		return nil, errors.Wrapf(errors.InvalidInput, "Invalid public IP address [%s]", reservation.IPAddress)
+	}
+	address = address.Unmap()
+	version := network.IPv4
+	if address.Is6() {
+		version = network.IPv6
+	}
+	idleTimeout := network.DefaultIdleTimeoutInMinutes
+	if reservation.IdleTimeoutInMinutes != nil {
+		idleTimeout = *reservation.IdleTimeoutInMinutes
+	}
+	if idleTimeout < MinIdleTimeoutInMinutes || idleTimeout > MaxIdleTimeoutInMinutes {
+		return nil, errors.Wrapf(errors.InvalidInput, "Idle timeout [%d] must be between %d and %d minutes", idleTimeout, MinIdleTimeoutInMinutes, MaxIdleTimeoutInMinutes)
+	}
+	if err := validateDNSSettings(reservation.DNSSettings); err != nil {
+		return nil, err
+	}
+
+	pips, err := c.Get(ctx, group, "")
+	if err != nil && !errors.IsNotFound(err) {
+		return nil, err
+	}
+	if pips != nil {
+		for _, pip := range *pips {
+			if err := checkReservationConflict(&pip, name, address, reservation.DNSSettings); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	ipAddress := address.String()
+	pip := &network.PublicIPAddress{
+		Name: &name,
+		Tags: reservation.Tags,
+		PublicIPAddressPropertiesFormat: &network.PublicIPAddressPropertiesFormat{
+			PublicIPAllocationMethod: network.Static,
+			PublicIPAddressVersion:   version,
+			IPAddress:                &ipAddress,
+			IdleTimeoutInMinutes:     &idleTimeout,
+		},
+	}
+	if len(reservation.Location) > 0 {
+		pip.Location = &reservation.Location
+	}
+	if reservation.DNSSettings != nil {
+		pip.DNSSettings = &network.PublicIPAddressDNSSettings{
+			DomainNameLabel: reservation.DNSSettings.DomainNameLabel,
+			ReverseFqdn:     reservation.DNSSettings.ReverseFqdn,
+		}
+	}
+	return c.CreateOrUpdate(ctx, group, name, pip)
+}
+
+// checkReservationConflict fails when the existing public IP address has the name, address or domain name
+// label of the reservation
+func checkReservationConflict(pip *network.PublicIPAddress, name string, address netip.Addr, dnsSettings *network.PublicIPAddressDNSSettings) error {
+	if pip.Name != nil && *pip.Name == name {
+		return errors.Wrapf(errors.AlreadyExists, "Public IP address [%s] already exists", name)
+	}
+	if pip.PublicIPAddressPropertiesFormat == nil {
+		return nil
+	}
+	if pip.IPAddress != nil {
+		if existing, err := netip.ParseAddr(strings.TrimSpace(*pip.IPAddress)); err == nil && existing.Unmap() == address {
+			return errors.Wrapf(errors.AlreadyExists, "Address [%s] is already held by public IP address [%s]", address, getName(pip.Name))
+		}
+	}
+	label := getDomainNameLabel(dnsSettings)
+	if len(label) > 0 && strings.EqualFold(label, getDomainNameLabel(pip.DNSSettings)) {
+		return errors.Wrapf(errors.AlreadyExists, "Domain name label [%s] is already used by public IP address [%s]", label, getName(pip.Name))
+	}
+	return nil
+}
+
+// validateDNSSettings checks that the domain name label is a lower case DNS label of 3 to 63 characters
+// starting with a letter and that the reverse FQDN is a valid domain name
+func validateDNSSettings(dnsSettings *network.PublicIPAddressDNSSettings) error {
+	if dnsSettings == nil {
+		return nil
+	}
+	if dnsSettings.DomainNameLabel != nil && len(*dnsSettings.DomainNameLabel) > 0 && !domainNameLabelRegexp.MatchString(*dnsSettings.DomainNameLabel) {
+		return errors.Wrapf(errors.InvalidInput, "Invalid domain name label [%s], it must have 3 to 63 lower case letters, digits or hyphens, start with a letter and end with a letter or digit", *dnsSettings.DomainNameLabel)
+	}
+	if dnsSettings.ReverseFqdn != nil && len(*dnsSettings.ReverseFqdn) > 0 {
+		fqdn := strings.TrimSuffix(strings.ToLower(*dnsSettings.ReverseFqdn), ".")
+		if len(fqdn) == 0 || len(fqdn) > 253 {
+			return errors.Wrapf(errors.InvalidInput, "Invalid reverse FQDN [%s]", *dnsSettings.ReverseFqdn)
+		}
+		for _, label := range strings.Split(fqdn, ".") {
+			if !fqdnLabelRegexp.MatchString(label) {
+				return errors.Wrapf(errors.InvalidInput, "Invalid reverse FQDN [%s]", *dnsSettings.ReverseFqdn)
+			}
+		}
+	}
+	return nil
+}
+
+// getDNSTags returns the tags of the public IP address with its DNS settings added
+func getDNSTags(pip *network.PublicIPAddress) map[string]*string {
+	if pip.PublicIPAddressPropertiesFormat == nil || pip.DNSSettings == nil {
+		return pip.Tags
+	}
+	pipTags := map[string]*string{}
+	for key, value := range pip.Tags {
+		pipTags[key] = value
+	}
+	delete(pipTags, DNSLabelTag)
+	delete(pipTags, ReverseFqdnTag)
+	if pip.DNSSettings.DomainNameLabel != nil && len(*pip.DNSSettings.DomainNameLabel) > 0 {
+		label := *pip.DNSSettings.DomainNameLabel
+		pipTags[DNSLabelTag] = &label
+	}
+	if pip.DNSSettings.ReverseFqdn != nil && len(*pip.DNSSettings.ReverseFqdn) > 0 {
+		fqdn := *pip.DNSSettings.ReverseFqdn
+		pipTags[ReverseFqdnTag] = &fqdn
+	}
+	if len(pipTags) == 0 && pip.Tags == nil {
+		return nil
+	}
+	return pipTags
+}
+
+// getDNSSettings removes the DNS settings from the tags and returns them, or nil when the tags hold none
+func getDNSSettings(pipTags map[string]*string) *network.PublicIPAddressDNSSettings {
+	label, hasLabel := pipTags[DNSLabelTag]
+	fqdn, hasFqdn := pipTags[ReverseFqdnTag]
+	if !hasLabel && !hasFqdn {
+		return nil
+	}
+	delete(pipTags, DNSLabelTag)
+	delete(pipTags, ReverseFqdnTag)
+	return &network.PublicIPAddressDNSSettings{DomainNameLabel: label, ReverseFqdn: fqdn}
+}
+
+func getDomainNameLabel(dnsSettings *network.PublicIPAddressDNSSettings) string {
+	if dnsSettings == nil || dnsSettings.DomainNameLabel == nil {
+		return ""
+	}
+	return *dnsSettings.DomainNameLabel
+}
+
+func getName(name *string) string {
+	if name == nil {
+		return ""
+	}
+	return *name
+}
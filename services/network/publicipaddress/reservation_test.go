@@ -0,0 +1,80 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the Apache v2.0 License.
+
+package publicipaddress
+
+import (
+	"context"
+	"testing"
+
+	"github.com/microsoft/moc-sdk-for-go/services/network"
+	"github.com/microsoft/moc/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func int32Ptr(i int32) *int32 { return &i }
+
+func Test_Reserve(t *testing.T) {
+	c, service, _, _ := newAssociationTestClient()
+
+	pip, err := c.Reserve(context.Background(), "group", "web", Reservation{
+		IPAddress:            "192.168.100.10",
+		Location:             "location",
+		IdleTimeoutInMinutes: int32Ptr(15),
+		DNSSettings:          &network.PublicIPAddressDNSSettings{DomainNameLabel: strPtr("web-frontend"), ReverseFqdn: strPtr("web.contoso.com.")},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, network.Static, pip.PublicIPAllocationMethod)
+	assert.Equal(t, network.IPv4, pip.PublicIPAddressVersion)
+	assert.Equal(t, "192.168.100.10", *pip.IPAddress)
+	assert.Equal(t, int32(15), *pip.IdleTimeoutInMinutes)
+	assert.Equal(t, "web-frontend", *service.pips["web"].DNSSettings.DomainNameLabel)
+
+	pip, err = c.Reserve(context.Background(), "group", "web6", Reservation{IPAddress: "fd00::10"})
+	require.NoError(t, err)
+	assert.Equal(t, network.IPv6, pip.PublicIPAddressVersion)
+	assert.Equal(t, network.DefaultIdleTimeoutInMinutes, *pip.IdleTimeoutInMinutes)
+
+	for name, reservation := range map[string]Reservation{
+		"web":  {IPAddress: "192.168.100.11"},
+		"web2": {IPAddress: "192.168.100.10"},
+		"web3": {IPAddress: "192.168.100.11", DNSSettings: &network.PublicIPAddressDNSSettings{DomainNameLabel: strPtr("web-frontend")}},
+	} {
+		_, err = c.Reserve(context.Background(), "group", name, reservation)
+		assert.ErrorIs(t, err, errors.AlreadyExists, name)
+	}
+	for _, reservation := range []Reservation{
+		{IPAddress: "192.168.100"},
+		{IPAddress: "192.168.100.11", IdleTimeoutInMinutes: int32Ptr(31)},
+		{IPAddress: "192.168.100.11", IdleTimeoutInMinutes: int32Ptr(3)},
+		{IPAddress: "192.168.100.11", DNSSettings: &network.PublicIPAddressDNSSettings{DomainNameLabel: strPtr("Web")}},
+		{IPAddress: "192.168.100.11", DNSSettings: &network.PublicIPAddressDNSSettings{DomainNameLabel: strPtr("1web")}},
+		{IPAddress: "192.168.100.11", DNSSettings: &network.PublicIPAddressDNSSettings{ReverseFqdn: strPtr("web..contoso.com")}},
+	} {
+		_, err = c.Reserve(context.Background(), "group", "web4", reservation)
+		assert.ErrorIs(t, err, errors.InvalidInput, reservation.IPAddress)
+	}
+}
+
+func Test_DNSTags(t *testing.T) {
+	pip := &network.PublicIPAddress{
+		Tags: map[string]*string{"owner": strPtr("web")},
+		PublicIPAddressPropertiesFormat: &network.PublicIPAddressPropertiesFormat{
+			DNSSettings: &network.PublicIPAddressDNSSettings{DomainNameLabel: strPtr("web-frontend")},
+		},
+	}
+	pipTags := getDNSTags(pip)
+	assert.Equal(t, "web-frontend", *pipTags[DNSLabelTag])
+	assert.NotContains(t, pipTags, ReverseFqdnTag)
+	assert.NotContains(t, pip.Tags, DNSLabelTag)
+
+	dnsSettings := getDNSSettings(pipTags)
+	require.NotNil(t, dnsSettings)
+	assert.Equal(t, "web-frontend", *dnsSettings.DomainNameLabel)
+	assert.Nil(t, dnsSettings.ReverseFqdn)
+	assert.Equal(t, map[string]*string{"owner": strPtr("web")}, pipTags)
+	assert.Nil(t, getDNSSettings(pipTags))
+
+	assert.Nil(t, getDNSTags(&network.PublicIPAddress{}))
+}
@@ -0,0 +1,215 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the Apache v2.0 License.
+
+package registeredips
+
+import (
+	"context"
+	"net/netip"
+	"strings"
+
+	"github.com/microsoft/moc/pkg/errors"
+)
+
+// maxReconcileAttempts bounds the updates sent by a reconcile call when
+// concurrent writers keep overwriting its changes.
+const maxReconcileAttempts = 10
+
+// CurrentFunc returns the registered IPs currently stored on each subnet of
+// the target network, keyed by subnet name. The network packages build it
+// from the IP pools returned by Get.
+type CurrentFunc func(ctx context.Context) (map[string][]string, error)
+
+// UpdateFunc is the full-replace UpdateRegisteredIPs call of the target
+// network.
+type UpdateFunc func(ctx context.Context, subnetRegisteredIPs []SubnetRegisteredIPs) ([]SubnetRegisteredIPs, []IPAddressUpdateFailure, error)
+
+// ReconcileResult describes the updates sent by AddRegisteredIPs,
+// RemoveRegisteredIPs or SyncRegisteredIPs and its outcome.
+type ReconcileResult struct {
+	// Added and Removed are the per-subnet differences between the stored
+	// and the desired lists that were sent to MOC. Both are empty when the
+	// network already matched and no update was sent.
+	Added   []SubnetRegisteredIPs `json:"added,omitempty" yaml:"added,omitempty"`
+	Removed []SubnetRegisteredIPs `json:"removed,omitempty" yaml:"removed,omitempty"`
+	// Persisted is what MOC stored for the updated subnets on the last
+	// update that changed them.
+	Persisted []SubnetRegisteredIPs `json:"persisted,omitempty" yaml:"persisted,omitempty"`
+	// Failures are the IP-level rejections of the update (partial success).
+	Failures []IPAddressUpdateFailure `json:"failures,omitempty" yaml:"failures,omitempty"`
+	// Attempts is the number of updates sent, more than one when a
+	// concurrent writer overwrote the changes and they were applied again.
+	Attempts int `json:"attempts" yaml:"attempts"`
+}
+
+// FailuresByCode groups the failures of the result by IPUpdateErrorCode.
+func (r *ReconcileResult) FailuresByCode() map[IPUpdateErrorCode][]IPAddressUpdateFailure {
+	return ClassifyFailures(r.Failures)
+}
+
+// ClassifyFailures groups failures by IPUpdateErrorCode, keeping their order
+// within each code.
+func ClassifyFailures(failures []IPAddressUpdateFailure) map[IPUpdateErrorCode][]IPAddressUpdateFailure {
+	classified := map[IPUpdateErrorCode][]IPAddressUpdateFailure{}
+	for _, f := range failures {
+		classified[f.Code] = append(classified[f.Code], f)
+	}
+	return classified
+}
+
+// IsPermanent reports whether a failure with this code will recur until the
+// request or the subnet configuration changes (bad address, address outside
+// the pools, missing subnet or pools). AlreadyAllocated and Unknown are not
+// permanent: the address may be released, or the failure may be transient.
+func (c IPUpdateErrorCode) IsPermanent() bool {
+	switch c {
+	case IPUpdateInvalidFormat, IPUpdateOutOfRange, IPUpdateSubnetNotFound, IPUpdateNoPoolsInSubnet:
+		return true
+	}
+	return false
+}
+
+// Add registers the supplied IPs on their subnets in addition to the IPs
+// already registered there. IPs already registered are left as they are.
+func Add(ctx context.Context, current CurrentFunc, update UpdateFunc, additions []SubnetRegisteredIPs) (*ReconcileResult, error) {
+	return reconcile(ctx, current, update, additions, func(stored, requested []string) []string {
+		return union(stored, requested)
+	})
+}
+
+// Remove unregisters the supplied IPs from their subnets, keeping every other
+// registered IP. IPs that are not registered are ignored.
+func Remove(ctx context.Context, current CurrentFunc, update UpdateFunc, removals []SubnetRegisteredIPs) (*ReconcileResult, error) {
+	return reconcile(ctx, current, update, removals, func(stored, requested []string) []string {
+		return difference(stored, requested)
+	})
+}
+
+// Sync makes the registered IPs of each supplied subnet equal to its desired
+// list. Subnets that are not supplied are left untouched; supply a subnet
+// with an empty list to clear it.
+func Sync(ctx context.Context, current CurrentFunc, update UpdateFunc, desired []SubnetRegisteredIPs) (*ReconcileResult, error) {
+	return reconcile(ctx, current, update, desired, func(stored, requested []string) []string {
+		return union(nil, requested)
+	})
+}
+
+// reconcile reads the stored lists, computes the desired list of each
+// requested subnet and sends a full-replace update for the subnets that
+// differ. The update carries no resource version, so a concurrent writer
+// may overwrite it with a list read before it. The stored lists are read
+// again after every update, and the changes that were lost are applied
+// again until the read matches. IPs rejected by MOC are not applied again.
+func reconcile(ctx context.Context, current CurrentFunc, update UpdateFunc, requested []SubnetRegisteredIPs, desire func(stored, requested []string) []string) (*ReconcileResult, error) {
+	// Merge repeated subnets, keeping the order of first appearance.
+	subnets := []string{}
+	bySubnet := map[string][]string{}
+	for _, s := range requested {
+		if len(s.SubnetName) == 0 {
+			return nil, errors.Wrapf(errors.InvalidInput, "SubnetName is not specified")
+		}
+		if _, ok := bySubnet[s.SubnetName]; !ok {
+			subnets = append(subnets, s.SubnetName)
+		}
+		bySubnet[s.SubnetName] = append(bySubnet[s.SubnetName], s.RegisteredIPAddresses...)
+	}
+
+	result := &ReconcileResult{}
+	added := map[string][]string{}
+	removed := map[string][]string{}
+	persisted := map[string]SubnetRegisteredIPs{}
+	for {
+		stored, err := current(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		updates := []SubnetRegisteredIPs{}
+		for _, subnet := range subnets {
+			want := desire(stored[subnet], bySubnet[subnet])
+			subnetAdded := difference(want, stored[subnet])
+			subnetRemoved := difference(stored[subnet], want)
+			if len(subnetAdded) == 0 && len(subnetRemoved) == 0 {
+				continue
+			}
+			updates = append(updates, SubnetRegisteredIPs{SubnetName: subnet, RegisteredIPAddresses: want})
+			added[subnet] = union(added[subnet], subnetAdded)
+			removed[subnet] = union(removed[subnet], subnetRemoved)
+		}
+		if len(updates) == 0 {
+			break
+		}
+		if result.Attempts >= maxReconcileAttempts {
+			return nil, errors.Wrapf(errors.Failed, "Registered IPs were overwritten by concurrent updates %d times", result.Attempts)
+		}
+
+		result.Attempts++
+		subnetPersisted, failures, err := update(ctx, updates)
+		if err != nil {
+			return nil, err
+		}
+		for _, s := range subnetPersisted {
+			persisted[s.SubnetName] = s
+		}
+		for _, f := range failures {
+			bySubnet[f.SubnetName] = difference(bySubnet[f.SubnetName], []string{f.IPAddress})
+			added[f.SubnetName] = difference(added[f.SubnetName], []string{f.IPAddress})
+			removed[f.SubnetName] = difference(removed[f.SubnetName], []string{f.IPAddress})
+		}
+		result.Failures = append(result.Failures, failures...)
+	}
+
+	for _, subnet := range subnets {
+		if len(added[subnet]) > 0 {
+			result.Added = append(result.Added, SubnetRegisteredIPs{SubnetName: subnet, RegisteredIPAddresses: added[subnet]})
+		}
+		if len(removed[subnet]) > 0 {
+			result.Removed = append(result.Removed, SubnetRegisteredIPs{SubnetName: subnet, RegisteredIPAddresses: removed[subnet]})
+		}
+		if s, ok := persisted[subnet]; ok {
+			result.Persisted = append(result.Persisted, s)
+		}
+	}
+	return result, nil
+}
+
+// ipKey compares addresses by their canonical form so that, for example,
+// IPv6 addresses written differently are treated as the same IP. Unparsable
+// entries are compared as written and left for MOC to reject.
+func ipKey(ip string) string {
+	ip = strings.TrimSpace(ip)
+	if addr, err := netip.ParseAddr(ip); err == nil {
+		return addr.String()
+	}
+	return ip
+}
+
+// union returns a followed by the entries of b that are not in a, without
+// duplicates. The result is never nil.
+func union(a, b []string) []string {
+	seen := map[string]bool{}
+	out := []string{}
+	for _, ip := range append(append([]string{}, a...), b...) {
+		if key := ipKey(ip); !seen[key] {
+			seen[key] = true
+			out = append(out, ip)
+		}
+	}
+	return out
+}
+
+// difference returns the entries of a that are not in b, without duplicates.
+func difference(a, b []string) []string {
+	exclude := map[string]bool{}
+	for _, ip := range b {
+		exclude[ipKey(ip)] = true
+	}
+	out := []string{}
+	for _, ip := range a {
+		if key := ipKey(ip); !exclude[key] {
+			exclude[key] = true
+			out = append(out, ip)
+		}
+	}
+	return out
+}
@@ -0,0 +1,198 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the Apache v2.0 License.
+
+package registeredips
+
+import (
+	"context"
+	stdErrors "errors"
+	"reflect"
+	"testing"
+
+	"github.com/microsoft/moc/pkg/errors"
+)
+
+// fakeNetwork stores registered IPs per subnet and records the updates sent
+// to it. err, when set, is returned by every update. failures are rejected
+// and not stored. afterUpdate, when set, runs after every stored update to
+// simulate a concurrent writer.
+type fakeNetwork struct {
+	stored      map[string][]string
+	updates     [][]SubnetRegisteredIPs
+	err         error
+	failures    []IPAddressUpdateFailure
+	afterUpdate func(f *fakeNetwork)
+}
+
+func (f *fakeNetwork) current(ctx context.Context) (map[string][]string, error) {
+	out := map[string][]string{}
+	for k, v := range f.stored {
+		out[k] = append([]string(nil), v...)
+	}
+	return out, nil
+}
+
+func (f *fakeNetwork) update(ctx context.Context, in []SubnetRegisteredIPs) ([]SubnetRegisteredIPs, []IPAddressUpdateFailure, error) {
+	f.updates = append(f.updates, in)
+	if f.err != nil {
+		return nil, nil, f.err
+	}
+	persisted := []SubnetRegisteredIPs{}
+	for _, s := range in {
+		rejected := []string{}
+		for _, failure := range f.failures {
+			if failure.SubnetName == s.SubnetName {
+				rejected = append(rejected, failure.IPAddress)
+			}
+		}
+		f.stored[s.SubnetName] = difference(s.RegisteredIPAddresses, rejected)
+		persisted = append(persisted, SubnetRegisteredIPs{SubnetName: s.SubnetName, RegisteredIPAddresses: f.stored[s.SubnetName]})
+	}
+	if f.afterUpdate != nil {
+		f.afterUpdate(f)
+	}
+	return persisted, f.failures, nil
+}
+
+func TestReconcile_Add(t *testing.T) {
+	f := &fakeNetwork{stored: map[string][]string{"a": {"10.0.0.4"}, "b": {"10.0.1.4"}}}
+	result, err := Add(context.Background(), f.current, f.update, []SubnetRegisteredIPs{
+		{SubnetName: "a", RegisteredIPAddresses: []string{"10.0.0.4", "10.0.0.5"}},
+		{SubnetName: "b", RegisteredIPAddresses: []string{"10.0.1.4"}},
+	})
+	if err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	want := []SubnetRegisteredIPs{{SubnetName: "a", RegisteredIPAddresses: []string{"10.0.0.4", "10.0.0.5"}}}
+	if len(f.updates) != 1 || !reflect.DeepEqual(f.updates[0], want) {
+		t.Errorf("updates=%v want [%v]", f.updates, want)
+	}
+	if !reflect.DeepEqual(result.Added, []SubnetRegisteredIPs{{SubnetName: "a", RegisteredIPAddresses: []string{"10.0.0.5"}}}) {
+		t.Errorf("Added=%v", result.Added)
+	}
+	if len(result.Removed) != 0 {
+		t.Errorf("Removed=%v want none", result.Removed)
+	}
+}
+
+func TestReconcile_RemoveNoop(t *testing.T) {
+	f := &fakeNetwork{stored: map[string][]string{"a": {"10.0.0.4"}}}
+	result, err := Remove(context.Background(), f.current, f.update, []SubnetRegisteredIPs{
+		{SubnetName: "a", RegisteredIPAddresses: []string{"10.0.0.9"}},
+	})
+	if err != nil {
+		t.Fatalf("Remove: %v", err)
+	}
+	if len(f.updates) != 0 || len(result.Removed) != 0 {
+		t.Errorf("updates=%v Removed=%v want no update", f.updates, result.Removed)
+	}
+}
+
+func TestReconcile_Sync(t *testing.T) {
+	f := &fakeNetwork{stored: map[string][]string{"a": {"10.0.0.4", "10.0.0.5"}, "b": {"10.0.1.4"}}}
+	result, err := Sync(context.Background(), f.current, f.update, []SubnetRegisteredIPs{
+		{SubnetName: "a", RegisteredIPAddresses: []string{"10.0.0.5", "10.0.0.6"}},
+	})
+	if err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	if len(f.updates) != 1 {
+		t.Errorf("updates=%d want 1", len(f.updates))
+	}
+	if !reflect.DeepEqual(f.stored["a"], []string{"10.0.0.5", "10.0.0.6"}) || !reflect.DeepEqual(f.stored["b"], []string{"10.0.1.4"}) {
+		t.Errorf("stored=%v", f.stored)
+	}
+	if !reflect.DeepEqual(result.Removed, []SubnetRegisteredIPs{{SubnetName: "a", RegisteredIPAddresses: []string{"10.0.0.4"}}}) {
+		t.Errorf("Removed=%v", result.Removed)
+	}
+}
+
+func TestReconcile_ReturnsUpdateError(t *testing.T) {
+	f := &fakeNetwork{stored: map[string][]string{}, err: errors.Wrapf(errors.Failed, "update failed")}
+	_, err := Add(context.Background(), f.current, f.update, []SubnetRegisteredIPs{
+		{SubnetName: "a", RegisteredIPAddresses: []string{"10.0.0.4"}},
+	})
+	if err == nil || len(f.updates) != 1 {
+		t.Errorf("err=%v updates=%d want the update error after a single update", err, len(f.updates))
+	}
+}
+
+func TestReconcile_ReappliesOverwrittenChanges(t *testing.T) {
+	f := &fakeNetwork{stored: map[string][]string{"a": {"10.0.0.4"}}}
+	// A concurrent writer that read the list before the first update
+	// overwrites it with its own addition.
+	f.afterUpdate = func(f *fakeNetwork) {
+		if len(f.updates) == 1 {
+			f.stored["a"] = []string{"10.0.0.4", "10.0.0.6"}
+		}
+	}
+	result, err := Add(context.Background(), f.current, f.update, []SubnetRegisteredIPs{
+		{SubnetName: "a", RegisteredIPAddresses: []string{"10.0.0.5"}},
+	})
+	if err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	if len(f.updates) != 2 || result.Attempts != 2 {
+		t.Errorf("updates=%d Attempts=%d want 2", len(f.updates), result.Attempts)
+	}
+	if !reflect.DeepEqual(f.stored["a"], []string{"10.0.0.4", "10.0.0.6", "10.0.0.5"}) {
+		t.Errorf("stored=%v want both additions", f.stored["a"])
+	}
+	if !reflect.DeepEqual(result.Added, []SubnetRegisteredIPs{{SubnetName: "a", RegisteredIPAddresses: []string{"10.0.0.5"}}}) {
+		t.Errorf("Added=%v", result.Added)
+	}
+}
+
+func TestReconcile_BoundsAttempts(t *testing.T) {
+	f := &fakeNetwork{stored: map[string][]string{"a": {}}}
+	// A concurrent writer that overwrites every update.
+	f.afterUpdate = func(f *fakeNetwork) {
+		f.stored["a"] = []string{}
+	}
+	_, err := Add(context.Background(), f.current, f.update, []SubnetRegisteredIPs{
+		{SubnetName: "a", RegisteredIPAddresses: []string{"10.0.0.5"}},
+	})
+	if !stdErrors.Is(err, errors.Failed) || len(f.updates) != maxReconcileAttempts {
+		t.Errorf("err=%v updates=%d want Failed after %d updates", err, len(f.updates), maxReconcileAttempts)
+	}
+}
+
+func TestReconcile_ClassifiesFailures(t *testing.T) {
+	f := &fakeNetwork{
+		stored: map[string][]string{"a": {}},
+		failures: []IPAddressUpdateFailure{
+			{SubnetName: "a", IPAddress: "10.9.0.4", Code: IPUpdateOutOfRange},
+			{SubnetName: "a", IPAddress: "10.0.0.7", Code: IPUpdateAlreadyAllocated},
+			{SubnetName: "a", IPAddress: "10.9.0.5", Code: IPUpdateOutOfRange},
+		},
+	}
+	result, err := Add(context.Background(), f.current, f.update, []SubnetRegisteredIPs{
+		{SubnetName: "a", RegisteredIPAddresses: []string{"10.9.0.4", "10.0.0.7", "10.9.0.5"}},
+	})
+	if err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	byCode := result.FailuresByCode()
+	if len(byCode[IPUpdateOutOfRange]) != 2 || len(byCode[IPUpdateAlreadyAllocated]) != 1 {
+		t.Errorf("FailuresByCode=%v", byCode)
+	}
+	if len(f.updates) != 1 || len(result.Added) != 0 {
+		t.Errorf("updates=%d Added=%v want a single update and no additions", len(f.updates), result.Added)
+	}
+	if !IPUpdateOutOfRange.IsPermanent() || IPUpdateAlreadyAllocated.IsPermanent() {
+		t.Errorf("unexpected IsPermanent classification")
+	}
+}
+
+func TestReconcile_CanonicalAddresses(t *testing.T) {
+	f := &fakeNetwork{stored: map[string][]string{"a": {"fd00::0:4"}}}
+	_, err := Add(context.Background(), f.current, f.update, []SubnetRegisteredIPs{
+		{SubnetName: "a", RegisteredIPAddresses: []string{"fd00::4"}},
+	})
+	if err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	if len(f.updates) != 0 {
+		t.Errorf("updates=%v want none", f.updates)
+	}
+}
@@ -0,0 +1,345 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the Apache v2.0 License.
+
+package topology
+
+import (
+	"net/netip"
+	"strings"
+
+	"github.com/microsoft/moc-sdk-for-go/services/compute"
+	"github.com/microsoft/moc-sdk-for-go/services/network"
+	"github.com/microsoft/moc-sdk-for-go/services/network/ipam"
+	"github.com/microsoft/moc-sdk-for-go/services/network/networkinterface"
+)
+
+const (
+	// RelationSubnetOf - From a subnet to its network
+	RelationSubnetOf = "subnet of"
+	// RelationSecuredBy - From a subnet or a network interface to its network security group
+	RelationSecuredBy = "secured by"
+	// RelationConnectedTo - From a network interface to the subnet of one of its IP configurations
+	RelationConnectedTo = "connected to"
+	// RelationUsesPublicIP - From a network interface or a load balancer frontend to its public IP address
+	RelationUsesPublicIP = "uses public IP"
+	// RelationBackendOf - From a network interface to the load balancer of its backend address pool
+	RelationBackendOf = "backend of"
+	// RelationFrontendIn - From a load balancer to the network of a frontend
+	RelationFrontendIn = "frontend in"
+	// RelationAllocatedFrom - From a load balancer or a public IP address to the VIP pool holding its address
+	RelationAllocatedFrom = "allocated from"
+	// RelationAttachedTo - From a virtual machine to its network interface
+	RelationAttachedTo = "attached to"
+)
+
+// Inventory is a snapshot of the resources to crawl: the networks and address consumers read by the IPAM
+// helpers, and the network security groups and virtual machines that reference them
+type Inventory struct {
+	ipam.Inventory
+	// SecurityGroups
+	SecurityGroups []network.SecurityGroup
+	// VirtualMachines
+	VirtualMachines []compute.VirtualMachine
+}
+
+// NodeID returns the ID of a resource of the kind. Subnet IDs are built with SubnetID.
+func NodeID(kind NodeKind, name string) string {
+	switch kind {
+	case KindVirtualNetwork:
+		return "/" + networkinterface.VNET_PREFIX + "/" + name
+	case KindLogicalNetwork:
+		return "/" + networkinterface.LNET_PREFIX + "/" + name
+	case KindNetworkInterface:
+		return "/networkinterfaces/" + name
+	case KindLoadBalancer:
+		return "/loadbalancers/" + name
+	case KindPublicIPAddress:
+		return "/publicipaddresses/" + name
+	case KindSecurityGroup:
+		return "/networksecuritygroups/" + name
+	case KindVipPool:
+		return "/vippools/" + name
+	case KindVirtualMachine:
+		return "/virtualmachines/" + name
+	}
+	return "/" + strings.ToLower(string(kind)) + "/" + name
+}
+
+// SubnetID returns the ID of a subnet of a virtual or logical network, in the form network interfaces
+// use to reference it
+func SubnetID(networkKind NodeKind, networkName, subnetName string) string {
+	return NodeID(networkKind, networkName) + "/" + networkinterface.SUBNET_PREFIX + "/" + subnetName
+}
+
+// Build crawls the references between the resources of the inventory. References to resources that are
+// not in the inventory are kept as missing nodes.
+func Build(inv *Inventory) *Graph {
+	b := &builder{graph: NewGraph(), networkKinds: map[string]NodeKind{}}
+	b.addNodes(inv)
+
+	for _, vnet := range inv.VirtualNetworks {
+		if vnet.VirtualNetworkPropertiesFormat == nil || vnet.Subnets == nil {
+			continue
+		}
+		for _, subnet := range *vnet.Subnets {
+			if subnet.SubnetPropertiesFormat != nil {
+				b.addSubnetEdges(SubnetID(KindVirtualNetwork, getName(vnet.Name), getName(subnet.Name)), NodeID(KindVirtualNetwork, getName(vnet.Name)), subnet.NetworkSecurityGroup)
+			}
+		}
+	}
+	for _, lnet := range inv.LogicalNetworks {
+		if lnet.LogicalNetworkPropertiesFormat == nil || lnet.Subnets == nil {
+			continue
+		}
+		for _, subnet := range *lnet.Subnets {
+			if subnet.LogicalSubnetPropertiesFormat != nil {
+				b.addSubnetEdges(SubnetID(KindLogicalNetwork, getName(lnet.Name), getName(subnet.Name)), NodeID(KindLogicalNetwork, getName(lnet.Name)), subnet.NetworkSecurityGroup)
+			}
+		}
+	}
+	for _, nic := range inv.Interfaces {
+		b.addInterfaceEdges(inv, nic)
+	}
+	for _, lb := range inv.LoadBalancers {
+		b.addLoadBalancerEdges(lb)
+	}
+	for _, pip := range inv.PublicIPAddresses {
+		if pip.PublicIPAddressPropertiesFormat != nil && pip.IPAddress != nil {
+			b.addVipPoolEdges(NodeID(KindPublicIPAddress, getName(pip.Name)), *pip.IPAddress)
+		}
+	}
+	for _, vm := range inv.VirtualMachines {
+		if vm.VirtualMachineProperties == nil || vm.NetworkProfile == nil || vm.NetworkProfile.NetworkInterfaces == nil {
+			continue
+		}
+		for _, nicRef := range *vm.NetworkProfile.NetworkInterfaces {
+			if nicRef.ID != nil && len(*nicRef.ID) > 0 {
+				b.addEdge(NodeID(KindVirtualMachine, getName(vm.Name)), b.reference(KindNetworkInterface, *nicRef.ID), RelationAttachedTo)
+			}
+		}
+	}
+	return b.graph
+}
+
+type builder struct {
+	graph *Graph
+	// networkKinds maps network names to their kind, for references that do not say
+	networkKinds map[string]NodeKind
+	vipPools     []vipPoolRange
+}
+
+type vipPoolRange struct {
+	id    string
+	start netip.Addr
+	end   netip.Addr
+}
+
+func (b *builder) addNodes(inv *Inventory) {
+	add := func(kind NodeKind, name *string) {
+		b.graph.AddNode(Node{ID: NodeID(kind, getName(name)), Kind: kind, Name: getName(name)})
+	}
+	for _, vnet := range inv.VirtualNetworks {
+		add(KindVirtualNetwork, vnet.Name)
+		b.networkKinds[getName(vnet.Name)] = KindVirtualNetwork
+		if vnet.VirtualNetworkPropertiesFormat != nil && vnet.Subnets != nil {
+			for _, subnet := range *vnet.Subnets {
+				id := SubnetID(KindVirtualNetwork, getName(vnet.Name), getName(subnet.Name))
+				b.graph.AddNode(Node{ID: id, Kind: KindSubnet, Name: getName(subnet.Name)})
+			}
+		}
+	}
+	for _, lnet := range inv.LogicalNetworks {
+		add(KindLogicalNetwork, lnet.Name)
+		if _, ok := b.networkKinds[getName(lnet.Name)]; !ok {
+			b.networkKinds[getName(lnet.Name)] = KindLogicalNetwork
+		}
+		if lnet.LogicalNetworkPropertiesFormat != nil && lnet.Subnets != nil {
+			for _, subnet := range *lnet.Subnets {
+				id := SubnetID(KindLogicalNetwork, getName(lnet.Name), getName(subnet.Name))
+				b.graph.AddNode(Node{ID: id, Kind: KindSubnet, Name: getName(subnet.Name)})
+			}
+		}
+	}
+	for _, nic := range inv.Interfaces {
+		add(KindNetworkInterface, nic.Name)
+	}
+	for _, lb := range inv.LoadBalancers {
+		add(KindLoadBalancer, lb.Name)
+	}
+	for _, pip := range inv.PublicIPAddresses {
+		add(KindPublicIPAddress, pip.Name)
+	}
+	for _, nsg := range inv.SecurityGroups {
+		add(KindSecurityGroup, nsg.Name)
+	}
+	for _, vp := range inv.VipPools {
+		add(KindVipPool, vp.Name)
+		if r, ok := getVipPoolRange(vp); ok {
+			b.vipPools = append(b.vipPools, r)
+		}
+	}
+	for _, vm := range inv.VirtualMachines {
+		add(KindVirtualMachine, vm.Name)
+	}
+}
+
+func (b *builder) addSubnetEdges(subnetID, networkID string, nsg *network.SubResource) {
+	b.addEdge(subnetID, networkID, RelationSubnetOf)
+	if nsg != nil && nsg.ID != nil && len(*nsg.ID) > 0 {
+		b.addEdge(subnetID, b.reference(KindSecurityGroup, *nsg.ID), RelationSecuredBy)
+	}
+}
+
+func (b *builder) addInterfaceEdges(inv *Inventory, nic network.Interface) {
+	if nic.InterfacePropertiesFormat == nil || nic.IPConfigurations == nil {
+		return
+	}
+	id := NodeID(KindNetworkInterface, getName(nic.Name))
+	for _, ipConfig := range *nic.IPConfigurations {
+		if ipConfig.InterfaceIPConfigurationPropertiesFormat == nil {
+			continue
+		}
+		if ipConfig.Subnet != nil && ipConfig.Subnet.ID != nil && len(*ipConfig.Subnet.ID) > 0 {
+			b.addEdge(id, b.subnetReference(*ipConfig.Subnet.ID), RelationConnectedTo)
+		}
+		if ipConfig.NetworkSecurityGroup != nil && ipConfig.NetworkSecurityGroup.ID != nil && len(*ipConfig.NetworkSecurityGroup.ID) > 0 {
+			b.addEdge(id, b.reference(KindSecurityGroup, *ipConfig.NetworkSecurityGroup.ID), RelationSecuredBy)
+		}
+		if ipConfig.PublicIPAddress != nil && ipConfig.PublicIPAddress.ID != nil && len(*ipConfig.PublicIPAddress.ID) > 0 {
+			b.addEdge(id, b.reference(KindPublicIPAddress, *ipConfig.PublicIPAddress.ID), RelationUsesPublicIP)
+		}
+		if ipConfig.LoadBalancerBackendAddressPools != nil {
+			for _, pool := range *ipConfig.LoadBalancerBackendAddressPools {
+				if lb := findBackendPoolLoadBalancer(inv, pool.Name); len(lb) > 0 {
+					b.addEdge(id, b.reference(KindLoadBalancer, lb), RelationBackendOf)
+				}
+			}
+		}
+	}
+}
+
+func (b *builder) addLoadBalancerEdges(lb network.LoadBalancer) {
+	if lb.LoadBalancerPropertiesFormat == nil || lb.FrontendIPConfigurations == nil {
+		return
+	}
+	id := NodeID(KindLoadBalancer, getName(lb.Name))
+	for _, frontend := range *lb.FrontendIPConfigurations {
+		if frontend.FrontendIPConfigurationPropertiesFormat == nil {
+			continue
+		}
+		// the frontend subnet reference holds the name of the network
+		if frontend.Subnet != nil && frontend.Subnet.ID != nil && len(*frontend.Subnet.ID) > 0 {
+			b.addEdge(id, b.networkReference(*frontend.Subnet.ID), RelationFrontendIn)
+		}
+		if frontend.PublicIPAddress != nil && frontend.PublicIPAddress.ID != nil && len(*frontend.PublicIPAddress.ID) > 0 {
+			b.addEdge(id, b.reference(KindPublicIPAddress, *frontend.PublicIPAddress.ID), RelationUsesPublicIP)
+		}
+		for _, address := range []*string{frontend.IPAddress, frontend.PrivateIPAddress} {
+			if address != nil {
+				b.addVipPoolEdges(id, *address)
+			}
+		}
+	}
+}
+
+func (b *builder) addVipPoolEdges(id, address string) {
+	ip, err := netip.ParseAddr(strings.TrimSpace(address))
+	if err != nil {
+		return
+	}
+	for _, r := range b.vipPools {
+		if ip.BitLen() == r.start.BitLen() && !ip.Less(r.start) && !r.end.Less(ip) {
+			b.addEdge(id, r.id, RelationAllocatedFrom)
+		}
+	}
+}
+
+func (b *builder) addEdge(from, to, relation string) {
+	b.graph.AddEdge(from, to, relation)
+}
+
+// reference returns the ID of the named resource, adding a missing node if it is not in the inventory
+func (b *builder) reference(kind NodeKind, name string) string {
+	id := NodeID(kind, name)
+	b.graph.AddNode(Node{ID: id, Kind: kind, Name: name, Missing: true})
+	return id
+}
+
+// subnetReference returns the ID of a subnet referenced by a network interface as
+// /<networktype>/<network>/subnets/<subnet>
+func (b *builder) subnetReference(reference string) string {
+	networkPrefix, networkName, subnetName, err := networkinterface.ParseSubnetID(reference)
+	if err != nil {
+		return b.reference(KindSubnet, reference)
+	}
+	kind := KindVirtualNetwork
+	if networkPrefix == networkinterface.LNET_PREFIX {
+		kind = KindLogicalNetwork
+	}
+	id := SubnetID(kind, networkName, subnetName)
+	b.graph.AddNode(Node{ID: id, Kind: KindSubnet, Name: subnetName, Missing: true})
+	return id
+}
+
+// networkReference returns the ID of a network referenced by name only
+func (b *builder) networkReference(name string) string {
+	kind, ok := b.networkKinds[name]
+	if !ok {
+		kind = KindVirtualNetwork
+	}
+	return b.reference(kind, name)
+}
+
+// findBackendPoolLoadBalancer returns the name of the load balancer of a backend pool reference, either
+// /loadbalancers/<name>/backendaddresspools/<pool> or the name of a pool of one of the load balancers
+func findBackendPoolLoadBalancer(inv *Inventory, pool *string) string {
+	if pool == nil || len(*pool) == 0 {
+		return ""
+	}
+	components := strings.Split(*pool, "/")
+	if len(components) == 5 && strings.EqualFold(components[1], "loadbalancers") {
+		return components[2]
+	}
+	for _, lb := range inv.LoadBalancers {
+		if lb.LoadBalancerPropertiesFormat == nil || lb.BackendAddressPools == nil {
+			continue
+		}
+		for _, bap := range *lb.BackendAddressPools {
+			if bap.Name != nil && *bap.Name == *pool {
+				return getName(lb.Name)
+			}
+		}
+	}
+	return ""
+}
+
+func getVipPoolRange(vp network.VipPool) (vipPoolRange, bool) {
+	r := vipPoolRange{id: NodeID(KindVipPool, getName(vp.Name))}
+	if vp.VipPoolPropertiesFormat == nil {
+		return r, false
+	}
+	if vp.StartIP != nil && vp.EndIP != nil {
+		start, errStart := netip.ParseAddr(strings.TrimSpace(*vp.StartIP))
+		end, errEnd := netip.ParseAddr(strings.TrimSpace(*vp.EndIP))
+		if errStart == nil && errEnd == nil {
+			r.start, r.end = start, end
+			return r, true
+		}
+	}
+	if vp.IPPrefix != nil {
+		prefix, err := netip.ParsePrefix(strings.TrimSpace(*vp.IPPrefix))
+		if err != nil {
+			return r, false
+		}
+		r.start, r.end = network.GetPrefixRange(prefix)
+		return r, true
+	}
+	return r, false
+}
+
+func getName(name *string) string {
+	if name == nil {
+		return ""
+	}
+	return *name
+}
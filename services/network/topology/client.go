@@ -0,0 +1,71 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the Apache v2.0 License.
+
+package topology
+
+import (
+	"context"
+
+	"github.com/microsoft/moc-sdk-for-go/services/compute/virtualmachine"
+	"github.com/microsoft/moc-sdk-for-go/services/network/ipam"
+	"github.com/microsoft/moc-sdk-for-go/services/network/networksecuritygroup"
+	"github.com/microsoft/moc/pkg/auth"
+)
+
+// Client reads the resources of the topology from the cloud agent
+type Client struct {
+	ipamclient *ipam.Client
+	nsgclient  *networksecuritygroup.NetworkSecurityGroupAgentClient
+	vmclient   *virtualmachine.VirtualMachineClient
+}
+
+// NewClient returns a client for the topology of a cloud
+func NewClient(cloudFQDN string, authorizer auth.Authorizer) (*Client, error) {
+	c := &Client{}
+	var err error
+	if c.ipamclient, err = ipam.NewClient(cloudFQDN, authorizer); err != nil {
+		return nil, err
+	}
+	if c.nsgclient, err = networksecuritygroup.NewSecurityGroupClient(cloudFQDN, authorizer); err != nil {
+		return nil, err
+	}
+	if c.vmclient, err = virtualmachine.NewVirtualMachineClient(cloudFQDN, authorizer); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// GetInventory lists the resources read by the IPAM inventory of the group and location, the virtual
+// machines of the group and the network security groups of the location
+func (c *Client) GetInventory(ctx context.Context, group, location string) (*Inventory, error) {
+	networks, err := c.ipamclient.GetInventory(ctx, group, location)
+	if err != nil {
+		return nil, err
+	}
+
+	inv := &Inventory{Inventory: *networks}
+	nsgs, err := c.nsgclient.Get(ctx, location, "")
+	if err != nil {
+		return nil, err
+	}
+	if nsgs != nil {
+		inv.SecurityGroups = *nsgs
+	}
+	vms, err := c.vmclient.Get(ctx, group, "")
+	if err != nil {
+		return nil, err
+	}
+	if vms != nil {
+		inv.VirtualMachines = *vms
+	}
+	return inv, nil
+}
+
+// GetGraph crawls the resources of the group and location into a dependency graph
+func (c *Client) GetGraph(ctx context.Context, group, location string) (*Graph, error) {
+	inv, err := c.GetInventory(ctx, group, location)
+	if err != nil {
+		return nil, err
+	}
+	return Build(inv), nil
+}
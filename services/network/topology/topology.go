@@ -0,0 +1,301 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the Apache v2.0 License.
+
+package topology
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/microsoft/moc/pkg/errors"
+)
+
+// NodeKind is the resource type of a node
+type NodeKind string
+
+const (
+	// KindVirtualNetwork - Virtual network of a group
+	KindVirtualNetwork NodeKind = "VirtualNetwork"
+	// KindLogicalNetwork - Logical network of a location
+	KindLogicalNetwork NodeKind = "LogicalNetwork"
+	// KindSubnet - Subnet of a virtual or logical network
+	KindSubnet NodeKind = "Subnet"
+	// KindNetworkInterface - Network interface
+	KindNetworkInterface NodeKind = "NetworkInterface"
+	// KindLoadBalancer - Load balancer
+	KindLoadBalancer NodeKind = "LoadBalancer"
+	// KindPublicIPAddress - Public IP address
+	KindPublicIPAddress NodeKind = "PublicIPAddress"
+	// KindSecurityGroup - Network security group
+	KindSecurityGroup NodeKind = "NetworkSecurityGroup"
+	// KindVipPool - VIP pool of a location
+	KindVipPool NodeKind = "VipPool"
+	// KindVirtualMachine - Virtual machine
+	KindVirtualMachine NodeKind = "VirtualMachine"
+)
+
+// Node is a resource of the graph
+type Node struct {
+	// ID - Resource path, such as /virtualnetworks/<name>/subnets/<subnet>
+	ID string `json:"id"`
+	// Kind
+	Kind NodeKind `json:"kind"`
+	// Name
+	Name string `json:"name"`
+	// Missing - The node is referenced but was not found in the inventory
+	Missing bool `json:"missing,omitempty"`
+}
+
+// Edge is a reference from a resource to a resource it depends on
+type Edge struct {
+	// From - ID of the dependent resource
+	From string `json:"from"`
+	// To - ID of the resource it depends on
+	To string `json:"to"`
+	// Relation - How From uses To
+	Relation string `json:"relation"`
+}
+
+// Graph is the dependency graph of the network resources
+type Graph struct {
+	nodes map[string]*Node
+	edges []Edge
+	// dependents and dependencies index the edges by node ID
+	dependents   map[string][]string
+	dependencies map[string][]string
+}
+
+// NewGraph returns an empty graph
+func NewGraph() *Graph {
+	return &Graph{
+		nodes:        map[string]*Node{},
+		dependents:   map[string][]string{},
+		dependencies: map[string][]string{},
+	}
+}
+
+// AddNode adds the node, or marks an existing missing node as found
+func (g *Graph) AddNode(node Node) {
+	if existing, ok := g.nodes[node.ID]; ok {
+		existing.Missing = existing.Missing && node.Missing
+		return
+	}
+	g.nodes[node.ID] = &node
+}
+
+// AddEdge adds a dependency of from on to. Both nodes must have been added.
+func (g *Graph) AddEdge(from, to, relation string) {
+	for _, edge := range g.edges {
+		if edge.From == from && edge.To == to && edge.Relation == relation {
+			return
+		}
+	}
+	g.edges = append(g.edges, Edge{From: from, To: to, Relation: relation})
+	if !contains(g.dependents[to], from) {
+		g.dependents[to] = append(g.dependents[to], from)
+	}
+	if !contains(g.dependencies[from], to) {
+		g.dependencies[from] = append(g.dependencies[from], to)
+	}
+}
+
+// Node returns the node with the ID
+func (g *Graph) Node(id string) (Node, bool) {
+	node, ok := g.nodes[id]
+	if !ok {
+		return Node{}, false
+	}
+	return *node, true
+}
+
+// Nodes returns the nodes, sorted by ID
+func (g *Graph) Nodes() []Node {
+	nodes := []Node{}
+	for _, id := range g.sortedIDs() {
+		nodes = append(nodes, *g.nodes[id])
+	}
+	return nodes
+}
+
+// Edges returns the edges, sorted by From then To
+func (g *Graph) Edges() []Edge {
+	edges := append([]Edge{}, g.edges...)
+	sort.SliceStable(edges, func(i, j int) bool {
+		if edges[i].From != edges[j].From {
+			return edges[i].From < edges[j].From
+		}
+		return edges[i].To < edges[j].To
+	})
+	return edges
+}
+
+// UsedBy returns the resources that reference the node directly, sorted by ID
+func (g *Graph) UsedBy(id string) []Node {
+	return g.getNodes(g.dependents[id])
+}
+
+// AllUsedBy returns the resources that depend on the node directly or through other resources, sorted by ID
+func (g *Graph) AllUsedBy(id string) []Node {
+	return g.getNodes(g.closure([]string{id}, g.dependents, false))
+}
+
+// Uses returns the resources the node references directly, sorted by ID
+func (g *Graph) Uses(id string) []Node {
+	return g.getNodes(g.dependencies[id])
+}
+
+// Missing returns the referenced resources that were not found, sorted by ID
+func (g *Graph) Missing() []Node {
+	missing := []Node{}
+	for _, node := range g.Nodes() {
+		if node.Missing {
+			missing = append(missing, node)
+		}
+	}
+	return missing
+}
+
+// DeletionOrder returns the IDs of the resources to delete, dependents first, so that the given resources
+// can be deleted without leaving dangling references. It includes every resource that depends on them.
+// With no IDs, it orders the whole graph. A subnet in the order is removed by updating its network, or
+// with its network when the network is also in the order. Missing nodes are left out.
+func (g *Graph) DeletionOrder(ids ...string) ([]string, error) {
+	for _, id := range ids {
+		if _, ok := g.nodes[id]; !ok {
+			return nil, errors.Wrapf(errors.NotFound, "Resource [%s] not found in the topology", id)
+		}
+	}
+	if len(ids) == 0 {
+		ids = g.sortedIDs()
+	}
+	targets := map[string]bool{}
+	for _, id := range g.closure(ids, g.dependents, true) {
+		targets[id] = true
+	}
+
+	const (
+		visiting = 1
+		visited  = 2
+	)
+	state := map[string]int{}
+	order := []string{}
+	var visit func(id string, path []string) error
+	visit = func(id string, path []string) error {
+		switch state[id] {
+		case visited:
+			return nil
+		case visiting:
+			return errors.Wrapf(errors.InvalidInput, "Dependency cycle: %s", strings.Join(append(path, id), " -> "))
+		}
+		state[id] = visiting
+		dependents := append([]string{}, g.dependents[id]...)
+		sort.Strings(dependents)
+		for _, dependent := range dependents {
+			if targets[dependent] {
+				if err := visit(dependent, append(append([]string{}, path...), id)); err != nil {
+					return err
+				}
+			}
+		}
+		state[id] = visited
+		if !g.nodes[id].Missing {
+			order = append(order, id)
+		}
+		return nil
+	}
+
+	sorted := []string{}
+	for id := range targets {
+		sorted = append(sorted, id)
+	}
+	sort.Strings(sorted)
+	for _, id := range sorted {
+		if err := visit(id, nil); err != nil {
+			return nil, err
+		}
+	}
+	return order, nil
+}
+
+// DOT returns the graph in Graphviz DOT format. Edges point from the dependent resource to its dependency.
+func (g *Graph) DOT() string {
+	var b strings.Builder
+	b.WriteString("digraph topology {\n")
+	for _, node := range g.Nodes() {
+		style := ""
+		if node.Missing {
+			style = ", style=dashed"
+		}
+		fmt.Fprintf(&b, "\t%q [label=%q%s];\n", node.ID, string(node.Kind)+"\n"+node.Name, style)
+	}
+	for _, edge := range g.Edges() {
+		fmt.Fprintf(&b, "\t%q -> %q [label=%q];\n", edge.From, edge.To, edge.Relation)
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// MarshalJSON returns the nodes and edges of the graph
+func (g *Graph) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Nodes []Node `json:"nodes"`
+		Edges []Edge `json:"edges"`
+	}{Nodes: g.Nodes(), Edges: g.Edges()})
+}
+
+// closure returns the IDs reachable from ids through the index, including ids when inclusive is true
+func (g *Graph) closure(ids []string, index map[string][]string, inclusive bool) []string {
+	seen := map[string]bool{}
+	result := []string{}
+	queue := []string{}
+	for _, id := range ids {
+		seen[id] = true
+		if inclusive {
+			result = append(result, id)
+		}
+		queue = append(queue, id)
+	}
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+		for _, next := range index[id] {
+			if !seen[next] {
+				seen[next] = true
+				result = append(result, next)
+				queue = append(queue, next)
+			}
+		}
+	}
+	return result
+}
+
+func (g *Graph) getNodes(ids []string) []Node {
+	nodes := []Node{}
+	for _, id := range ids {
+		if node, ok := g.nodes[id]; ok {
+			nodes = append(nodes, *node)
+		}
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].ID < nodes[j].ID })
+	return nodes
+}
+
+func (g *Graph) sortedIDs() []string {
+	ids := []string{}
+	for id := range g.nodes {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,159 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the Apache v2.0 License.
+
+package topology
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/microsoft/moc-sdk-for-go/services/compute"
+	"github.com/microsoft/moc-sdk-for-go/services/network"
+	"github.com/microsoft/moc-sdk-for-go/services/network/ipam"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func strPtr(s string) *string {
+	return &s
+}
+
+func ids(nodes []Node) []string {
+	result := []string{}
+	for _, node := range nodes {
+		result = append(result, node.ID)
+	}
+	return result
+}
+
+func newInventory() *Inventory {
+	return &Inventory{
+		Inventory: ipam.Inventory{
+			VirtualNetworks: []network.VirtualNetwork{{
+				Name: strPtr("vnet1"),
+				VirtualNetworkPropertiesFormat: &network.VirtualNetworkPropertiesFormat{
+					Subnets: &[]network.Subnet{{
+						Name: strPtr("web"),
+						SubnetPropertiesFormat: &network.SubnetPropertiesFormat{
+							NetworkSecurityGroup: &network.SubResource{ID: strPtr("nsg1")},
+						},
+					}},
+				},
+			}},
+			Interfaces: []network.Interface{{
+				Name: strPtr("nic1"),
+				InterfacePropertiesFormat: &network.InterfacePropertiesFormat{
+					IPConfigurations: &[]network.InterfaceIPConfiguration{{
+						InterfaceIPConfigurationPropertiesFormat: &network.InterfaceIPConfigurationPropertiesFormat{
+							Subnet:                          &network.APIEntityReference{ID: strPtr("/virtualnetworks/vnet1/subnets/web")},
+							NetworkSecurityGroup:            &network.SubResource{ID: strPtr("nsg1")},
+							LoadBalancerBackendAddressPools: &[]network.BackendAddressPool{{Name: strPtr("/loadbalancers/lb1/backendaddresspools/pool")}},
+						},
+					}},
+				},
+			}},
+			LoadBalancers: []network.LoadBalancer{{
+				Name: strPtr("lb1"),
+				LoadBalancerPropertiesFormat: &network.LoadBalancerPropertiesFormat{
+					FrontendIPConfigurations: &[]network.FrontendIPConfiguration{{
+						FrontendIPConfigurationPropertiesFormat: &network.FrontendIPConfigurationPropertiesFormat{
+							Subnet:    &network.Subnet{ID: strPtr("vnet1")},
+							IPAddress: strPtr("192.168.0.10"),
+						},
+					}},
+				},
+			}},
+			PublicIPAddresses: []network.PublicIPAddress{{
+				Name:                            strPtr("pip1"),
+				PublicIPAddressPropertiesFormat: &network.PublicIPAddressPropertiesFormat{IPAddress: strPtr("192.168.0.20")},
+			}},
+			VipPools: []network.VipPool{{
+				Name:                    strPtr("vips"),
+				VipPoolPropertiesFormat: &network.VipPoolPropertiesFormat{IPPrefix: strPtr("192.168.0.0/24")},
+			}},
+		},
+		SecurityGroups: []network.SecurityGroup{{Name: strPtr("nsg1")}},
+		VirtualMachines: []compute.VirtualMachine{{
+			Name: strPtr("vm1"),
+			VirtualMachineProperties: &compute.VirtualMachineProperties{
+				NetworkProfile: &compute.NetworkProfile{
+					NetworkInterfaces: &[]compute.NetworkInterfaceReference{{ID: strPtr("nic1")}, {ID: strPtr("nic2")}},
+				},
+			},
+		}},
+	}
+}
+
+func Test_UsedBy(t *testing.T) {
+	g := Build(newInventory())
+
+	assert.Equal(t, []string{"/networkinterfaces/nic1", "/virtualnetworks/vnet1/subnets/web"}, ids(g.UsedBy("/networksecuritygroups/nsg1")))
+	assert.Equal(t, []string{"/networkinterfaces/nic1"}, ids(g.UsedBy("/virtualnetworks/vnet1/subnets/web")))
+	assert.Equal(t, []string{"/loadbalancers/lb1", "/publicipaddresses/pip1"}, ids(g.UsedBy("/vippools/vips")))
+	assert.Equal(t, []string{
+		"/loadbalancers/lb1",
+		"/networkinterfaces/nic1",
+		"/virtualmachines/vm1",
+		"/virtualnetworks/vnet1/subnets/web",
+	}, ids(g.AllUsedBy("/virtualnetworks/vnet1")))
+	assert.Equal(t, []string{"/networkinterfaces/nic2"}, ids(g.Missing()))
+}
+
+func Test_DeletionOrder(t *testing.T) {
+	g := Build(newInventory())
+
+	order, err := g.DeletionOrder("/networksecuritygroups/nsg1")
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"/virtualmachines/vm1",
+		"/networkinterfaces/nic1",
+		"/virtualnetworks/vnet1/subnets/web",
+		"/networksecuritygroups/nsg1",
+	}, order)
+
+	order, err = g.DeletionOrder()
+	require.NoError(t, err)
+	position := map[string]int{}
+	for i, id := range order {
+		position[id] = i
+	}
+	assert.Len(t, order, 8)
+	assert.Less(t, position["/virtualmachines/vm1"], position["/networkinterfaces/nic1"])
+	assert.Less(t, position["/networkinterfaces/nic1"], position["/loadbalancers/lb1"])
+	assert.Less(t, position["/loadbalancers/lb1"], position["/virtualnetworks/vnet1"])
+	assert.Less(t, position["/loadbalancers/lb1"], position["/vippools/vips"])
+	assert.Less(t, position["/virtualnetworks/vnet1/subnets/web"], position["/virtualnetworks/vnet1"])
+
+	_, err = g.DeletionOrder("/virtualnetworks/missing")
+	assert.Error(t, err)
+}
+
+func Test_DeletionOrder_Cycle(t *testing.T) {
+	g := NewGraph()
+	g.AddNode(Node{ID: "/a", Kind: KindVipPool, Name: "a"})
+	g.AddNode(Node{ID: "/b", Kind: KindVipPool, Name: "b"})
+	g.AddEdge("/a", "/b", RelationAllocatedFrom)
+	g.AddEdge("/b", "/a", RelationAllocatedFrom)
+	_, err := g.DeletionOrder("/a")
+	assert.Error(t, err)
+}
+
+func Test_Export(t *testing.T) {
+	g := Build(newInventory())
+
+	dot := g.DOT()
+	assert.True(t, strings.HasPrefix(dot, "digraph topology {\n"))
+	assert.Contains(t, dot, `"/networkinterfaces/nic1" -> "/networksecuritygroups/nsg1" [label="secured by"];`)
+	assert.Contains(t, dot, `"/networkinterfaces/nic2" [label="NetworkInterface\nnic2", style=dashed];`)
+
+	data, err := json.Marshal(g)
+	require.NoError(t, err)
+	var exported struct {
+		Nodes []Node `json:"nodes"`
+		Edges []Edge `json:"edges"`
+	}
+	require.NoError(t, json.Unmarshal(data, &exported))
+	assert.Equal(t, g.Nodes(), exported.Nodes)
+	assert.Equal(t, g.Edges(), exported.Edges)
+}
@@ -70,6 +70,90 @@ func (c *client) UpdateRegisteredIPsWithVersion(ctx context.Context, groupName,
 		nil
 }
 
+// ReconcileResult describes the updates sent by AddRegisteredIPs,
+// RemoveRegisteredIPs or SyncRegisteredIPs. It is re-exported from the
+// shared registeredips package.
+type ReconcileResult = registeredips.ReconcileResult
+
+// AddRegisteredIPs registers the supplied IPs on their subnets in addition
+// to the IPs already registered there. It reads the current lists and sends a
+// full-replace update for the subnets that change. The update carries no
+// resource version, so the lists are read again after the update and changes
+// overwritten by a concurrent writer are applied again, up to a bounded number
+// of updates after which a Failed error is returned. IP-level rejections are
+// returned in the result and are not applied again.
+func (c *VirtualNetworkClient) AddRegisteredIPs(ctx context.Context, groupName, name string, subnetRegisteredIPs []SubnetRegisteredIPs) (*ReconcileResult, error) {
+	if err := validateRegisteredIPsTarget(groupName, name); err != nil {
+		return nil, err
+	}
+	return registeredips.Add(ctx, c.getRegisteredIPs(groupName, name), c.updateRegisteredIPs(groupName, name), subnetRegisteredIPs)
+}
+
+// RemoveRegisteredIPs unregisters the supplied IPs from their subnets,
+// keeping every other registered IP. See AddRegisteredIPs for the update
+// behavior.
+func (c *VirtualNetworkClient) RemoveRegisteredIPs(ctx context.Context, groupName, name string, subnetRegisteredIPs []SubnetRegisteredIPs) (*ReconcileResult, error) {
+	if err := validateRegisteredIPsTarget(groupName, name); err != nil {
+		return nil, err
+	}
+	return registeredips.Remove(ctx, c.getRegisteredIPs(groupName, name), c.updateRegisteredIPs(groupName, name), subnetRegisteredIPs)
+}
+
+// SyncRegisteredIPs makes the registered IPs of each supplied subnet equal
+// to its desired list, and only updates the subnets that differ. Subnets
+// that are not supplied are left untouched. See AddRegisteredIPs for the
+// update behavior.
+func (c *VirtualNetworkClient) SyncRegisteredIPs(ctx context.Context, groupName, name string, desired []SubnetRegisteredIPs) (*ReconcileResult, error) {
+	if err := validateRegisteredIPsTarget(groupName, name); err != nil {
+		return nil, err
+	}
+	return registeredips.Sync(ctx, c.getRegisteredIPs(groupName, name), c.updateRegisteredIPs(groupName, name), desired)
+}
+
+// getRegisteredIPs reads the registered IPs of every subnet of the network
+// from its IP pools.
+func (c *VirtualNetworkClient) getRegisteredIPs(groupName, name string) registeredips.CurrentFunc {
+	return func(ctx context.Context) (map[string][]string, error) {
+		networks, err := c.Get(ctx, groupName, name)
+		if err != nil {
+			return nil, err
+		}
+		if networks == nil || len(*networks) == 0 {
+			return nil, errors.Wrapf(errors.NotFound, "Virtual Network [%s] not found", name)
+		}
+		stored := map[string][]string{}
+		n := (*networks)[0]
+		if n.VirtualNetworkPropertiesFormat == nil || n.Subnets == nil {
+			return stored, nil
+		}
+		for _, subnet := range *n.Subnets {
+			if subnet.Name == nil || subnet.SubnetPropertiesFormat == nil {
+				continue
+			}
+			for _, pool := range subnet.IPPools {
+				stored[*subnet.Name] = append(stored[*subnet.Name], pool.RegisteredIPAddresses...)
+			}
+		}
+		return stored, nil
+	}
+}
+
+func (c *VirtualNetworkClient) updateRegisteredIPs(groupName, name string) registeredips.UpdateFunc {
+	return func(ctx context.Context, subnetRegisteredIPs []SubnetRegisteredIPs) ([]SubnetRegisteredIPs, []IPAddressUpdateFailure, error) {
+		return c.UpdateRegisteredIPs(ctx, groupName, name, subnetRegisteredIPs)
+	}
+}
+
+func validateRegisteredIPsTarget(groupName, name string) error {
+	if len(groupName) == 0 {
+		return errors.Wrapf(errors.InvalidInput, "GroupName is not specified")
+	}
+	if len(name) == 0 {
+		return errors.Wrapf(errors.InvalidInput, "Name is not specified")
+	}
+	return nil
+}
+
 func subnetsToProto(in []SubnetRegisteredIPs) []*wssdcloudnetwork.VirtualSubnetIPUpdate {
 	out := make([]*wssdcloudnetwork.VirtualSubnetIPUpdate, 0, len(in))
 	for _, s := range in {
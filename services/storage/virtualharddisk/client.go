@@ -118,3 +118,50 @@ func (c *VirtualHardDiskClient) DownloadVhdFromHttp(ctx context.Context, group,
 	datastring := string(data)
 	return c.internal.CreateOrUpdate(ctx, group, container, name, storage, datastring, common.ImageSource_HTTP_SOURCE)
 }
+
+// Clone creates a new virtual hard disk as a copy of an existing one. If container is empty,
+// the clone is placed in the container of the source disk.
+func (c *VirtualHardDiskClient) Clone(ctx context.Context, group, container, sourceName, name string) (*storage.VirtualHardDisk, error) {
+	if len(sourceName) == 0 || len(name) == 0 {
+		return nil, errors.Wrapf(errors.InvalidInput, "Source and target disk names are required")
+	}
+	vhds, err := c.Get(ctx, group, container, sourceName)
+	if err != nil {
+		return nil, err
+	}
+	if vhds == nil || len(*vhds) == 0 {
+		return nil, errors.Wrapf(errors.NotFound, "%s", sourceName)
+	}
+
+	source := (*vhds)[0]
+	cloneSource := sourceName
+	if source.VirtualHardDiskProperties != nil {
+		if source.Path != nil && len(*source.Path) > 0 {
+			cloneSource = *source.Path
+		}
+		if len(container) == 0 && source.ContainerName != nil {
+			container = *source.ContainerName
+		}
+	}
+
+	// convert cloneImg struct to json string and use it as image-path
+	data, err := json.Marshal(compute.CloneImageProperties{CloneSource: cloneSource})
+	if err != nil {
+		return nil, err
+	}
+
+	// the source is sent in the image-path json, the target copies the settings of the source disk
+	target := &storage.VirtualHardDisk{
+		Name:                      &name,
+		Tags:                      source.Tags,
+		VirtualHardDiskProperties: &storage.VirtualHardDiskProperties{},
+	}
+	if source.VirtualHardDiskProperties != nil {
+		target.DiskSizeBytes = source.DiskSizeBytes
+		target.Dynamic = source.Dynamic
+		target.HyperVGeneration = source.HyperVGeneration
+		target.DiskFileFormat = source.DiskFileFormat
+		target.CloudInitDataSource = source.CloudInitDataSource
+	}
+	return c.internal.CreateOrUpdate(ctx, group, container, name, target, string(data), common.ImageSource_CLONE_SOURCE)
+}